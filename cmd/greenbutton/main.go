@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/levenlabs/go-lflag"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/server"
+	"github.com/raterudder/raterudder/pkg/storage"
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/raterudder/raterudder/pkg/utility"
+)
+
+// greenbutton imports a Green Button (ESPI XML) file into a site's energy
+// history so savings can be computed from the revenue meter.
+func main() {
+	s := storage.Configured()
+	file := lflag.RequiredString("file", "Path to the Green Button XML file to import")
+	siteID := lflag.String("site-id", types.SiteIDNone, "Site ID to import the meter data into")
+	lflag.Configure()
+
+	ctx := context.Background()
+	defer func() {
+		if err := s.Close(); err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to close storage", "error", err)
+		}
+	}()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to open green button file", "error", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	meterHistory, err := utility.ParseGreenButton(f)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to parse green button file", "error", err)
+		os.Exit(1)
+	}
+
+	res, err := server.ImportMeterHistory(ctx, s, *siteID, meterHistory)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to import green button data", "error", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Imported %d of %d hours (hours without ESS history are skipped) from %s to %s\n", res.MatchedESSHours, res.Hours, res.Start, res.End)
+	fmt.Printf("Meter: %.2f kWh imported, %.2f kWh exported\n", res.MeterImportKWH, res.MeterExportKWH)
+	fmt.Printf("ESS:   %.2f kWh imported, %.2f kWh exported\n", res.ESSGridImportKWH, res.ESSGridExportKWH)
+}
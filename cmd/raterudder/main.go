@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/raterudder/raterudder/pkg/common"
 	"github.com/raterudder/raterudder/pkg/ess"
 	"github.com/raterudder/raterudder/pkg/log"
 	"github.com/raterudder/raterudder/pkg/server"
@@ -20,6 +21,7 @@ import (
 
 func main() {
 	// init packages
+	common.Configure()
 	u := utility.Configured()
 	e := ess.Configured()
 	s := storage.Configured()
@@ -9,6 +9,7 @@ require (
 	github.com/levenlabs/go-lflag v1.0.2
 	github.com/levenlabs/go-llog v1.0.0
 	github.com/stretchr/testify v1.11.1
+	golang.org/x/net v0.49.0
 	google.golang.org/api v0.264.0
 	google.golang.org/grpc v1.78.0
 )
@@ -38,7 +39,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.39.0 // indirect
 	go.opentelemetry.io/otel/trace v1.39.0 // indirect
 	golang.org/x/crypto v0.47.0 // indirect
-	golang.org/x/net v0.49.0 // indirect
 	golang.org/x/oauth2 v0.34.0 // indirect
 	golang.org/x/sync v0.19.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrAuth is matched by errors where the upstream rejected our credentials.
+	ErrAuth = errors.New("authentication failed")
+	// ErrRateLimited is matched by errors where the upstream asked us to slow
+	// down.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUpstream is matched by errors where the upstream is unavailable or
+	// failing, including when its circuit breaker is open.
+	ErrUpstream = errors.New("upstream unavailable")
+	// ErrCircuitOpen is returned without making a request when a host has
+	// failed too many times in a row.
+	ErrCircuitOpen = errors.New("circuit open")
+	// ErrBudgetExhausted is returned without making a request when the
+	// request budget in the context has been used up.
+	ErrBudgetExhausted = errors.New("request budget exhausted")
+	// ErrNotPublic is returned when a user-supplied URL points at, or
+	// resolves to, an address that isn't on the public internet.
+	ErrNotPublic = errors.New("address is not public")
+)
+
+// StatusError is an unsuccessful HTTP response. It matches ErrAuth,
+// ErrRateLimited or ErrUpstream with errors.Is depending on the status code.
+type StatusError struct {
+	StatusCode int
+	Host       string
+	// RetryAfter is how long the upstream asked us to wait, if it said
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.Host, e.StatusCode)
+}
+
+// Is implements errors.Is for the error kinds.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrAuth:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUpstream:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// CheckResponse returns a *StatusError if the response wasn't a 2xx.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return NewStatusError(resp)
+}
+
+// NewStatusError returns a *StatusError for an unexpected response.
+func NewStatusError(resp *http.Response) *StatusError {
+	e := &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter(resp),
+	}
+	if resp.Request != nil {
+		e.Host = resp.Request.URL.Host
+	}
+	return e
+}
+
+// retryAfter parses the Retry-After header in either of its forms.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -26,12 +26,39 @@ func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error
 
 // HTTPClient returns a default http client with a default user-agent set
 func HTTPClient(timeout time.Duration) *http.Client {
+	return HTTPClientWithTransport(timeout, http.DefaultTransport)
+}
+
+// HTTPClientWithTransport returns an http client with a default user-agent set
+// that uses the given transport to make requests. Idempotent requests are
+// retried, hosts that keep failing are skipped until they recover, the
+// request budget in the context is enforced, and traffic is recorded if
+// http-record-dir is set. Each client has its own circuit breakers so one
+// site's failures don't block requests for other sites.
+func HTTPClientWithTransport(timeout time.Duration, transport http.RoundTripper) *http.Client {
+	return newHTTPClient(timeout, transport, newBreakerSet(DefaultBreakerPolicy))
+}
+
+// LocalHTTPClient returns an http client like HTTPClient but without circuit
+// breakers. It's used for devices on the LAN and other user-supplied hosts
+// where a failing host says nothing about anyone else's.
+func LocalHTTPClient(timeout time.Duration) *http.Client {
+	return LocalHTTPClientWithTransport(timeout, http.DefaultTransport)
+}
+
+// LocalHTTPClientWithTransport returns an http client like
+// HTTPClientWithTransport but without circuit breakers.
+func LocalHTTPClientWithTransport(timeout time.Duration, transport http.RoundTripper) *http.Client {
+	return newHTTPClient(timeout, transport, nil)
+}
+
+func newHTTPClient(timeout time.Duration, transport http.RoundTripper, breakers *breakerSet) *http.Client {
 	v := strings.TrimSpace(version)
 	userAgent := "RateRudder/" + v
 
 	return &http.Client{
 		Transport: &userAgentTransport{
-			transport: http.DefaultTransport,
+			transport: newResilientTransport(&recordingTransport{transport: transport}, DefaultRetryPolicy, breakers),
 			userAgent: userAgent,
 		},
 		Timeout: timeout,
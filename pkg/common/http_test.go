@@ -1,9 +1,11 @@
 package common
 
 import (
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -39,3 +41,34 @@ func TestHTTPClient(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
+
+func TestPublicHTTPClient(t *testing.T) {
+	t.Run("PublicIP", func(t *testing.T) {
+		for _, ip := range []string{"127.0.0.1", "10.1.2.3", "192.168.1.20", "169.254.169.254", "100.100.100.200", "::1", "fd00:ec2::254", "0.0.0.0"} {
+			assert.False(t, PublicIP(net.ParseIP(ip)), ip)
+		}
+		assert.True(t, PublicIP(net.ParseIP("8.8.8.8")))
+		assert.True(t, PublicIP(net.ParseIP("2606:4700::1111")))
+	})
+
+	t.Run("ValidatePublicURL", func(t *testing.T) {
+		assert.NoError(t, ValidatePublicURL("https://example.com/hook"))
+		assert.Error(t, ValidatePublicURL("http://example.com/hook"))
+		assert.Error(t, ValidatePublicURL("https:///hook"))
+		assert.ErrorIs(t, ValidatePublicURL("https://10.0.0.5/hook"), ErrNotPublic)
+		assert.ErrorIs(t, ValidatePublicURL("https://metadata.google.internal/"), ErrNotPublic)
+		assert.ErrorIs(t, ValidatePublicURL("https://LOCALHOST/"), ErrNotPublic)
+	})
+
+	t.Run("Refuses Private Addresses", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+		}))
+		defer server.Close()
+
+		_, err := PublicHTTPClient(time.Second).Get(server.URL)
+		assert.ErrorIs(t, err, ErrNotPublic)
+		assert.EqualValues(t, 0, calls.Load())
+	})
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, which some clouds also
+// use for their metadata services.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// PublicIP returns whether the IP is on the public internet. Loopback,
+// private, link-local (including cloud metadata services), shared and
+// multicast addresses aren't.
+func PublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	return !sharedAddressSpace.Contains(ip)
+}
+
+// ValidatePublicURL returns an error unless the URL is https and its host
+// isn't obviously private. Hostnames are checked again when they're
+// resolved by the client from PublicHTTPClient.
+func ValidatePublicURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("url must use https: %s", u.Redacted())
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("url is missing a host: %s", u.Redacted())
+	}
+	if ip := net.ParseIP(host); ip != nil && !PublicIP(ip) {
+		return fmt.Errorf("%s: %w", host, ErrNotPublic)
+	}
+	for _, suffix := range []string{"localhost", ".local", ".internal"} {
+		if strings.HasSuffix(strings.ToLower(host), suffix) {
+			return fmt.Errorf("%s: %w", host, ErrNotPublic)
+		}
+	}
+	return nil
+}
+
+// PublicHTTPClient returns an http client for URLs supplied by users. It only
+// connects to public addresses, checked after DNS resolution so a hostname
+// can't be pointed at the LAN, only follows redirects to URLs that pass
+// ValidatePublicURL, and has no circuit breakers.
+func PublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !PublicIP(net.ParseIP(host)) {
+				return fmt.Errorf("%s: %w", host, ErrNotPublic)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the host
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	client := LocalHTTPClientWithTransport(timeout, transport)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return ValidatePublicURL(req.URL.String())
+	}
+	return client
+}
@@ -0,0 +1,556 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/levenlabs/go-lflag"
+	"github.com/raterudder/raterudder/pkg/log"
+)
+
+// redacted replaces sensitive values in recorded traffic.
+const redacted = "REDACTED"
+
+// sensitiveNames are substrings of header, query, form and JSON field names
+// whose values are redacted before being recorded.
+var sensitiveNames = []string{
+	"password", "passwd", "token", "secret", "key", "auth", "cookie",
+	"session", "email", "account", "username",
+}
+
+// identifierNames are substrings of field names whose values identify a
+// device. Their values are redacted, along with anywhere else they appear
+// such as URL paths.
+var identifierNames = []string{
+	"gatewayid", "gateway_id", "serial", "deviceid", "device_id", "macaddr",
+	"mac_addr",
+}
+
+// identifierFields are field names that identify a device or a host on the
+// LAN but are too short to match as substrings.
+var identifierFields = []string{"sn", "din", "ip", "host", "mac"}
+
+// lanHosts matches private IPv4 addresses and mDNS or home network
+// hostnames.
+var lanHosts = regexp.MustCompile(`\b(?:10\.\d{1,3}\.\d{1,3}\.\d{1,3}|192\.168\.\d{1,3}\.\d{1,3}|172\.(?:1[6-9]|2\d|3[01])\.\d{1,3}\.\d{1,3}|169\.254\.\d{1,3}\.\d{1,3}|[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*\.(?:local|lan|home\.arpa))\b`)
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return isIdentifier(name)
+}
+
+func isIdentifier(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range identifierNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return slices.Contains(identifierFields, name)
+}
+
+// sanitizer redacts identifiers it has seen in field values wherever else
+// they appear, along with LAN hosts. A nil sanitizer only redacts LAN hosts.
+type sanitizer struct {
+	mu          sync.Mutex
+	identifiers map[string]bool
+}
+
+func newSanitizer() *sanitizer {
+	return &sanitizer{identifiers: make(map[string]bool)}
+}
+
+// remember saves the value of a field so it's redacted elsewhere. Short
+// values would redact too much.
+func (s *sanitizer) remember(name string, values ...string) {
+	if s == nil || !isIdentifier(name) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range values {
+		if len(v) >= 4 {
+			s.identifiers[v] = true
+		}
+	}
+}
+
+// text redacts remembered identifiers and LAN hosts in str.
+func (s *sanitizer) text(str string) string {
+	str = lanHosts.ReplaceAllString(str, redacted)
+	if s == nil {
+		return str
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.identifiers {
+		str = strings.ReplaceAll(str, id, redacted)
+	}
+	return str
+}
+
+// Fixture is a list of recorded HTTP interactions.
+type Fixture struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a sanitized request and the response it received.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a sanitized HTTP request.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	RecordedBody
+}
+
+// RecordedResponse is a sanitized HTTP response. If the request failed
+// without a response, Error is set instead.
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	RecordedBody
+}
+
+// RecordedBody holds a body as JSON when it's valid JSON so fixtures are
+// readable and easy to edit, otherwise as text.
+type RecordedBody struct {
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyText string          `json:"bodyText,omitempty"`
+}
+
+func (s *sanitizer) body(b []byte, contentType string) RecordedBody {
+	if len(b) == 0 {
+		return RecordedBody{}
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if values, err := url.ParseQuery(string(b)); err == nil {
+			return RecordedBody{BodyText: s.values(values).Encode()}
+		}
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// keep numbers as they were sent rather than rounding them to floats
+	dec.UseNumber()
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		s.rememberJSON(v)
+		if sanitized, err := json.Marshal(s.json(v)); err == nil {
+			return RecordedBody{Body: sanitized}
+		}
+	}
+	return RecordedBody{BodyText: s.text(string(b))}
+}
+
+func (b RecordedBody) bytes() []byte {
+	if len(b.Body) > 0 {
+		return b.Body
+	}
+	return []byte(b.BodyText)
+}
+
+func (s *sanitizer) header(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	res := make(http.Header, len(h))
+	for k, vs := range h {
+		if isSensitive(k) {
+			s.remember(k, vs...)
+			res[k] = []string{redacted}
+			continue
+		}
+		res[k] = make([]string, len(vs))
+		for i, v := range vs {
+			res[k][i] = s.text(v)
+		}
+	}
+	return res
+}
+
+func (s *sanitizer) values(values url.Values) url.Values {
+	res := make(url.Values, len(values))
+	for k, vs := range values {
+		if isSensitive(k) {
+			s.remember(k, vs...)
+			res[k] = []string{redacted}
+			continue
+		}
+		res[k] = make([]string, len(vs))
+		for i, v := range vs {
+			res[k][i] = s.text(v)
+		}
+	}
+	return res
+}
+
+// url redacts the query first so identifiers in it are also redacted from
+// the host and path.
+func (s *sanitizer) url(u *url.URL) string {
+	c := *u
+	c.User = nil
+	if c.RawQuery != "" {
+		c.RawQuery = s.values(c.Query()).Encode()
+	}
+	if host := s.text(c.Hostname()); host != c.Hostname() {
+		c.Host = host
+		if port := u.Port(); port != "" {
+			c.Host += ":" + port
+		}
+	}
+	c.Path = s.text(c.Path)
+	c.RawPath = ""
+	return c.String()
+}
+
+func (s *sanitizer) rememberJSON(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			switch child := child.(type) {
+			case string:
+				s.remember(k, child)
+			case json.Number:
+				s.remember(k, child.String())
+			default:
+				s.rememberJSON(child)
+			}
+		}
+	case []any:
+		for _, child := range v {
+			s.rememberJSON(child)
+		}
+	}
+}
+
+func (s *sanitizer) json(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if isSensitive(k) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = s.json(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = s.json(child)
+		}
+	case string:
+		return s.text(v)
+	}
+	return v
+}
+
+// readBody reads the body and replaces it so it can still be read.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(*body)
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(b))
+	return b, err
+}
+
+func (s *sanitizer) request(req *http.Request) (RecordedRequest, error) {
+	var b []byte
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return RecordedRequest{}, err
+		}
+		defer body.Close()
+		if b, err = io.ReadAll(body); err != nil {
+			return RecordedRequest{}, err
+		}
+	} else if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if b, err = readBody(&req.Body); err != nil {
+			return RecordedRequest{}, err
+		}
+	}
+	// the body and headers go first so identifiers in them are also
+	// redacted from the url
+	body := s.body(b, req.Header.Get("Content-Type"))
+	header := s.header(req.Header)
+	return RecordedRequest{
+		Method:       req.Method,
+		URL:          s.url(req.URL),
+		Header:       header,
+		RecordedBody: body,
+	}, nil
+}
+
+// Recorder writes sanitized HTTP traffic to a fixture file per host in a
+// directory.
+type Recorder struct {
+	dir       string
+	session   string
+	sanitizer *sanitizer
+
+	mu       sync.Mutex
+	fixtures map[string]*Fixture
+}
+
+// NewRecorder returns a Recorder writing into dir. Each host gets its own
+// file for the life of the Recorder.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create record dir: %w", err)
+	}
+	return &Recorder{
+		dir:       dir,
+		session:   time.Now().UTC().Format("20060102T150405Z"),
+		sanitizer: newSanitizer(),
+		fixtures:  make(map[string]*Fixture),
+	}, nil
+}
+
+// Path returns the fixture file that traffic to host is recorded into.
+func (r *Recorder) Path(host string) string {
+	name := strings.NewReplacer(":", "_", "/", "_").Replace(r.sanitizer.text(host))
+	return filepath.Join(r.dir, name+"-"+r.session+".json")
+}
+
+func (r *Recorder) record(host string, i Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.fixtures[host]
+	if f == nil {
+		f = &Fixture{}
+		r.fixtures[host] = f
+	}
+	f.Interactions = append(f.Interactions, i)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	return os.WriteFile(r.Path(host), buf.Bytes(), 0o600)
+}
+
+// activeRecorder is set by the http-record-dir flag and used by every client
+// returned from HTTPClient.
+var activeRecorder atomic.Pointer[Recorder]
+
+// SetRecorder records the traffic of every client returned from HTTPClient
+// with r. A nil Recorder stops recording.
+func SetRecorder(r *Recorder) {
+	activeRecorder.Store(r)
+}
+
+// Configure registers the flags for the shared HTTP clients.
+func Configure() {
+	recordDir := lflag.String("http-record-dir", "", "Directory to record sanitized ESS and utility HTTP traffic into as test fixtures")
+
+	lflag.Do(func() {
+		if *recordDir == "" {
+			return
+		}
+		r, err := NewRecorder(*recordDir)
+		if err != nil {
+			log.Ctx(context.Background()).Error("failed to start http recorder", slog.Any("error", err))
+			os.Exit(1)
+		}
+		log.Ctx(context.Background()).Warn("recording http traffic", slog.String("dir", *recordDir))
+		SetRecorder(r)
+	})
+}
+
+// recordingTransport records traffic with the active Recorder, if there is
+// one.
+type recordingTransport struct {
+	transport http.RoundTripper
+	recorder  *Recorder
+}
+
+// NewRecordingTransport returns a RoundTripper that records the traffic sent
+// through transport with r.
+func NewRecordingTransport(transport http.RoundTripper, r *Recorder) http.RoundTripper {
+	return &recordingTransport{transport: transport, recorder: r}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := t.recorder
+	if r == nil {
+		r = activeRecorder.Load()
+	}
+	if r == nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	recReq, err := r.sanitizer.request(req)
+	if err != nil {
+		return nil, err
+	}
+	i := Interaction{Request: recReq}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		i.Response.Error = err.Error()
+	} else {
+		b, readErr := readBody(&resp.Body)
+		if readErr != nil {
+			return nil, readErr
+		}
+		i.Response.StatusCode = resp.StatusCode
+		i.Response.Header = r.sanitizer.header(resp.Header)
+		// the sanitized body won't be the same length
+		i.Response.Header.Del("Content-Length")
+		i.Response.RecordedBody = r.sanitizer.body(b, resp.Header.Get("Content-Type"))
+	}
+	if recErr := r.record(req.URL.Host, i); recErr != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to record http interaction", slog.Any("error", recErr))
+	}
+	return resp, err
+}
+
+// ReplayTransport is a RoundTripper that responds with the interactions in a
+// fixture instead of sending requests. Each interaction is used once, in
+// order, for the first request with the same method and path, preferring
+// one with the same query. Redacted path segments match any segment.
+type ReplayTransport struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayTransport returns a ReplayTransport for the fixture file at path.
+func NewReplayTransport(path string) (*ReplayTransport, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	return NewReplayTransportFromFixture(f), nil
+}
+
+// NewReplayTransportFromFixture returns a ReplayTransport for f.
+func NewReplayTransportFromFixture(f Fixture) *ReplayTransport {
+	return &ReplayTransport{
+		interactions: f.Interactions,
+		used:         make([]bool, len(f.Interactions)),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		// the body isn't matched on but it should be consumed like it would be
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	// fields are redacted by name the same as when they were recorded
+	var s *sanitizer
+	query := s.values(req.URL.Query()).Encode()
+
+	t.mu.Lock()
+	match := -1
+	for i, in := range t.interactions {
+		if t.used[i] || in.Request.Method != req.Method {
+			continue
+		}
+		u, err := url.Parse(in.Request.URL)
+		if err != nil || !replayPathMatches(u.Path, req.URL.Path) {
+			continue
+		}
+		if u.Query().Encode() == query {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match >= 0 {
+		t.used[match] = true
+	}
+	t.mu.Unlock()
+
+	if match < 0 {
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL.Path)
+	}
+	rec := t.interactions[match].Response
+	if rec.Error != "" {
+		return nil, errors.New(rec.Error)
+	}
+	header := rec.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	body := rec.bytes()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.StatusCode, http.StatusText(rec.StatusCode)),
+		StatusCode:    rec.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func replayPathMatches(recorded, path string) bool {
+	if recorded == path {
+		return true
+	}
+	rs, ps := strings.Split(recorded, "/"), strings.Split(path, "/")
+	if len(rs) != len(ps) {
+		return false
+	}
+	for i := range rs {
+		if rs[i] != ps[i] && rs[i] != redacted {
+			return false
+		}
+	}
+	return true
+}
+
+// Unused returns the interactions that haven't been replayed.
+func (t *ReplayTransport) Unused() []Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var res []Interaction
+	for i, in := range t.interactions {
+		if !t.used[i] {
+			res = append(res, in)
+		}
+	}
+	return res
+}
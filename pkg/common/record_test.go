@@ -0,0 +1,137 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "hunter2", r.Form.Get("password"), "the real request isn't sanitized")
+			w.Header().Set("Set-Cookie", "session=abc")
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"result": {"token": "tok-123", "expires": 1700000000000000001}}`))
+		case "/status":
+			_, _ = w.Write([]byte(`{"soc": ` + r.URL.Query().Get("n") + `}`))
+		case "/gateways":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"list": [{"serialNumber": "SN-98765", "ip": "192.168.1.20", "url": "http://envoy.local/api"}]}`))
+		case "/gateways/SN-98765/status":
+			_, _ = w.Write([]byte(`gateway SN-98765 at 10.0.0.7 is up`))
+		default:
+			http.Error(w, "offline", http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	r, err := NewRecorder(dir)
+	require.NoError(t, err)
+	client := &http.Client{Transport: NewRecordingTransport(http.DefaultTransport, r)}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", server.URL+"/login?apiKey=k1", strings.NewReader("account=me@example.com&password=hunter2"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer abc")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	// the caller still gets the real response
+	assert.Contains(t, string(body), "tok-123")
+
+	for _, n := range []string{"1", "2"} {
+		resp, err = client.Get(server.URL + "/status?n=" + n)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	resp, err = client.Get(server.URL + "/down")
+	require.NoError(t, err)
+	resp.Body.Close()
+	for _, path := range []string{"/gateways", "/gateways/SN-98765/status?gatewayId=GW-4242&host=192.168.1.20"} {
+		resp, err = client.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// secrets never make it into the fixture
+	b, err := os.ReadFile(r.Path(strings.TrimPrefix(server.URL, "http://")))
+	require.NoError(t, err)
+	for _, secret := range []string{"hunter2", "me@example.com", "Bearer", "tok-123", "session=abc", "k1", "SN-98765", "GW-4242", "192.168.1.20", "10.0.0.7", "envoy.local"} {
+		assert.NotContains(t, string(b), secret)
+	}
+	var f Fixture
+	require.NoError(t, json.Unmarshal(b, &f))
+	require.Len(t, f.Interactions, 6)
+	assert.Equal(t, "account=REDACTED&password=REDACTED", f.Interactions[0].Request.BodyText)
+	assert.JSONEq(t, `{"result": {"token": "REDACTED", "expires": 1700000000000000001}}`, string(f.Interactions[0].Response.Body))
+	assert.Equal(t, "offline\n", f.Interactions[3].Response.BodyText)
+	// identifiers seen in one response are redacted from later urls
+	assert.Contains(t, f.Interactions[5].Request.URL, "/gateways/REDACTED/status?gatewayId=REDACTED&host=REDACTED")
+	assert.Equal(t, "gateway REDACTED at REDACTED is up", f.Interactions[5].Response.BodyText)
+
+	t.Run("Replay", func(t *testing.T) {
+		replay := NewReplayTransportFromFixture(f)
+		client := &http.Client{Transport: replay}
+
+		// requests are matched by query first so the order doesn't matter
+		for _, n := range []string{"2", "1"} {
+			resp, err := client.Get("https://example.com/status?n=" + n)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.JSONEq(t, `{"soc": `+n+`}`, string(body))
+		}
+
+		resp, err := client.Get("https://example.com/down")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.ErrorIs(t, CheckResponse(resp), ErrUpstream)
+
+		// each interaction is only used once
+		_, err = client.Get("https://example.com/down")
+		assert.ErrorContains(t, err, "no recorded response for GET /down")
+
+		// redacted path segments match the real ones
+		resp, err = client.Get("https://example.com/gateways/SN-1/status?gatewayId=GW-1&host=10.1.1.1")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "gateway REDACTED at REDACTED is up", string(body))
+
+		unused := replay.Unused()
+		require.Len(t, unused, 2)
+		assert.Equal(t, "POST", unused[0].Request.Method)
+	})
+}
+
+func TestReplayTransportFile(t *testing.T) {
+	_, err := NewReplayTransport("testdata/missing.json")
+	assert.Error(t, err)
+
+	path := t.TempDir() + "/fixture.json"
+	require.NoError(t, os.WriteFile(path, []byte(`{"interactions": [
+		{"request": {"method": "GET", "url": "https://example.com/api"}, "response": {"error": "connection reset"}}
+	]}`), 0o600))
+	replay, err := NewReplayTransport(path)
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: replay}).Get("https://example.com/api")
+	assert.ErrorContains(t, err, "connection reset")
+}
@@ -0,0 +1,283 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+)
+
+// RetryPolicy controls how failed idempotent requests are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the first
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubling after that
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts. If the upstream asks us to
+	// wait longer than this, the response is returned instead.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used by the clients returned from HTTPClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// BreakerPolicy controls when a host's circuit breaker opens.
+type BreakerPolicy struct {
+	// Threshold is how many failures in a row open the breaker
+	Threshold int
+	// Cooldown is how long the breaker stays open before a single request
+	// is let through to probe the host
+	Cooldown time.Duration
+}
+
+// DefaultBreakerPolicy is used by the clients returned from HTTPClient.
+var DefaultBreakerPolicy = BreakerPolicy{
+	Threshold: 5,
+	Cooldown:  30 * time.Second,
+}
+
+type hostBreaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// breakerSet tracks failures per host. A nil breakerSet never opens.
+type breakerSet struct {
+	mu     sync.Mutex
+	policy BreakerPolicy
+	hosts  map[string]*hostBreaker
+	now    func() time.Time
+}
+
+func newBreakerSet(policy BreakerPolicy) *breakerSet {
+	return &breakerSet{
+		policy: policy,
+		hosts:  make(map[string]*hostBreaker),
+		now:    time.Now,
+	}
+}
+
+// allow returns whether a request to the host can be sent.
+func (b *breakerSet) allow(host string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hb := b.hosts[host]
+	if hb == nil || hb.failures < b.policy.Threshold {
+		return true
+	}
+	if b.now().Before(hb.openUntil) || hb.probing {
+		return false
+	}
+	// half-open, let one request through to see if the host is back
+	hb.probing = true
+	return true
+}
+
+// record records the outcome of a request to the host.
+func (b *breakerSet) record(host string, ok bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		delete(b.hosts, host)
+		return
+	}
+	hb := b.hosts[host]
+	if hb == nil {
+		hb = &hostBreaker{}
+		b.hosts[host] = hb
+	}
+	hb.failures++
+	hb.probing = false
+	if hb.failures >= b.policy.Threshold {
+		hb.openUntil = b.now().Add(b.policy.Cooldown)
+	}
+}
+
+// open returns whether the host has failed enough to open its breaker.
+func (b *breakerSet) open(host string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hb := b.hosts[host]
+	return hb != nil && hb.failures >= b.policy.Threshold
+}
+
+// abandon is called when a request was canceled before we learned anything
+// about the host.
+func (b *breakerSet) abandon(host string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if hb := b.hosts[host]; hb != nil {
+		hb.probing = false
+	}
+}
+
+type requestBudgetKey struct{}
+
+type requestBudget struct {
+	remaining atomic.Int64
+}
+
+// WithRequestBudget limits the number of HTTP requests, including retries,
+// that can be sent with the returned context. Requests past the budget fail
+// with ErrBudgetExhausted.
+func WithRequestBudget(ctx context.Context, n int) context.Context {
+	b := &requestBudget{}
+	b.remaining.Store(int64(n))
+	return context.WithValue(ctx, requestBudgetKey{}, b)
+}
+
+// RequestBudgetRemaining returns how many requests are left in the context's
+// budget and false if it doesn't have one.
+func RequestBudgetRemaining(ctx context.Context) (int, bool) {
+	b, ok := ctx.Value(requestBudgetKey{}).(*requestBudget)
+	if !ok {
+		return 0, false
+	}
+	return max(int(b.remaining.Load()), 0), true
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent marks requests sent with the returned context as safe to
+// retry even if their method isn't idempotent.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// we can't send the body again
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+// resilientTransport retries idempotent requests that fail because of the
+// network or the upstream, stops sending requests to hosts that keep
+// failing, and enforces the request budget in the context.
+type resilientTransport struct {
+	transport http.RoundTripper
+	retry     RetryPolicy
+	breakers  *breakerSet
+	sleep     func(ctx context.Context, d time.Duration) error
+}
+
+func newResilientTransport(transport http.RoundTripper, retry RetryPolicy, breakers *breakerSet) *resilientTransport {
+	return &resilientTransport{
+		transport: transport,
+		retry:     retry,
+		breakers:  breakers,
+		sleep:     sleepContext,
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := req.URL.Host
+	budget, _ := ctx.Value(requestBudgetKey{}).(*requestBudget)
+	retryable := isIdempotent(req)
+
+	for attempt := 1; ; attempt++ {
+		if budget != nil && budget.remaining.Add(-1) < 0 {
+			return nil, fmt.Errorf("%s: %w", host, ErrBudgetExhausted)
+		}
+		if !t.breakers.allow(host) {
+			return nil, fmt.Errorf("%s: %w: %w", host, ErrCircuitOpen, ErrUpstream)
+		}
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		resp, err := t.transport.RoundTrip(req)
+		if err != nil && ctx.Err() != nil {
+			t.breakers.abandon(host)
+			return nil, err
+		}
+		outage := err != nil || resp.StatusCode >= 500
+		t.breakers.record(host, !outage)
+		rateLimited := err == nil && resp.StatusCode == http.StatusTooManyRequests
+		if (!outage && !rateLimited) || !retryable || attempt >= t.retry.MaxAttempts || t.breakers.open(host) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if ra := retryAfter(resp); ra > 0 {
+				if ra > t.retry.MaxDelay {
+					// not worth waiting for within this request
+					return resp, nil
+				}
+				delay = ra
+			}
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		l := log.Ctx(ctx).With(slog.String("host", host), slog.Int("attempt", attempt), slog.Duration("delay", delay))
+		if err != nil {
+			l.WarnContext(ctx, "http request failed, retrying", slog.Any("error", err))
+		} else {
+			l.WarnContext(ctx, "http request failed, retrying", slog.Int("status", resp.StatusCode))
+		}
+		if err := t.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt, doubling each attempt
+// with jitter so clients don't retry in lockstep.
+func (t *resilientTransport) backoff(attempt int) time.Duration {
+	d := t.retry.BaseDelay << (attempt - 1)
+	if d > t.retry.MaxDelay || d <= 0 {
+		d = t.retry.MaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
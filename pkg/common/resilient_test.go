@@ -0,0 +1,268 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient returns a client using a resilient transport that records its
+// sleeps instead of waiting.
+func testClient(breakers *breakerSet) (*http.Client, *[]time.Duration) {
+	var sleeps []time.Duration
+	t := newResilientTransport(http.DefaultTransport, DefaultRetryPolicy, breakers)
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return ctx.Err()
+	}
+	return &http.Client{Transport: t}, &sleeps
+}
+
+// statusServer returns a server that responds with each of the statuses in
+// turn and then 200s, along with the number of requests it received.
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if r.Body != nil {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Body", string(body))
+		}
+		if n <= len(statuses) {
+			if statuses[n-1] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "2")
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestResilientTransport(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Retries Idempotent Requests", func(t *testing.T) {
+		server, calls := statusServer(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+		client, sleeps := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.EqualValues(t, 3, calls.Load())
+
+		// the backoff doubles with jitter
+		require.Len(t, *sleeps, 2)
+		assert.GreaterOrEqual(t, (*sleeps)[0], 125*time.Millisecond)
+		assert.LessOrEqual(t, (*sleeps)[0], 250*time.Millisecond)
+		assert.GreaterOrEqual(t, (*sleeps)[1], 250*time.Millisecond)
+		assert.LessOrEqual(t, (*sleeps)[1], 500*time.Millisecond)
+	})
+
+	t.Run("Gives Up After Max Attempts", func(t *testing.T) {
+		server, calls := statusServer(t, 500, 500, 500, 500)
+		client, _ := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.EqualValues(t, 3, calls.Load())
+	})
+
+	t.Run("Does Not Retry Posts", func(t *testing.T) {
+		server, calls := statusServer(t, http.StatusInternalServerError)
+		client, _ := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		resp, err := client.Post(server.URL, "text/plain", strings.NewReader("mode=1"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("Retries Posts Marked Idempotent", func(t *testing.T) {
+		server, calls := statusServer(t, http.StatusInternalServerError)
+		client, _ := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		req, err := http.NewRequestWithContext(WithIdempotent(ctx), "POST", server.URL, strings.NewReader("mode=1"))
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.EqualValues(t, 2, calls.Load())
+		// the body was sent again
+		assert.Equal(t, "mode=1", resp.Header.Get("X-Body"))
+	})
+
+	t.Run("Does Not Retry Client Errors", func(t *testing.T) {
+		server, calls := statusServer(t, http.StatusUnauthorized)
+		client, _ := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.EqualValues(t, 1, calls.Load())
+		assert.ErrorIs(t, CheckResponse(resp), ErrAuth)
+	})
+
+	t.Run("Honors Retry-After", func(t *testing.T) {
+		server, calls := statusServer(t, http.StatusTooManyRequests)
+		client, sleeps := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.EqualValues(t, 2, calls.Load())
+		assert.Equal(t, []time.Duration{2 * time.Second}, *sleeps)
+	})
+
+	t.Run("Circuit Breaker", func(t *testing.T) {
+		var failing atomic.Bool
+		failing.Store(true)
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		now := time.Unix(1000, 0)
+		breakers := newBreakerSet(BreakerPolicy{Threshold: 3, Cooldown: time.Minute})
+		breakers.now = func() time.Time { return now }
+		client, _ := testClient(breakers)
+
+		// all 3 attempts fail and open the breaker
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.EqualValues(t, 3, calls.Load())
+
+		_, err = client.Get(server.URL)
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.ErrorIs(t, err, ErrUpstream)
+		assert.EqualValues(t, 3, calls.Load())
+
+		// after the cooldown a single probe is let through and it still fails
+		now = now.Add(time.Minute)
+		resp, err = client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.EqualValues(t, 4, calls.Load())
+
+		// the host recovers and the probe closes the breaker
+		now = now.Add(time.Minute)
+		failing.Store(false)
+		resp, err = client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, err = client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.EqualValues(t, 6, calls.Load())
+	})
+
+	t.Run("No Circuit Breaker", func(t *testing.T) {
+		server, calls := statusServer(t, 503, 503, 503, 503, 503, 503)
+		client, _ := testClient(nil)
+
+		for range 2 {
+			resp, err := client.Get(server.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		// every attempt reached the host
+		assert.EqualValues(t, 6, calls.Load())
+	})
+
+	t.Run("Breakers Per Client", func(t *testing.T) {
+		breakers := func(c *http.Client) *breakerSet {
+			return c.Transport.(*userAgentTransport).transport.(*resilientTransport).breakers
+		}
+		a := HTTPClient(time.Minute)
+		b := HTTPClient(time.Minute)
+		assert.NotNil(t, breakers(a))
+		assert.NotSame(t, breakers(a), breakers(b))
+		assert.Nil(t, breakers(LocalHTTPClient(time.Minute)))
+	})
+
+	t.Run("Request Budget", func(t *testing.T) {
+		server, calls := statusServer(t, 500, 500)
+		client, _ := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		ctx := WithRequestBudget(ctx, 2)
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		require.NoError(t, err)
+		// the retries use up the budget
+		_, err = client.Do(req)
+		assert.ErrorIs(t, err, ErrBudgetExhausted)
+		assert.EqualValues(t, 2, calls.Load())
+
+		remaining, ok := RequestBudgetRemaining(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, 0, remaining)
+		_, ok = RequestBudgetRemaining(context.Background())
+		assert.False(t, ok)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		server, calls := statusServer(t, 500, 500)
+		client, _ := testClient(newBreakerSet(DefaultBreakerPolicy))
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.EqualValues(t, 0, calls.Load())
+	})
+}
+
+func TestStatusError(t *testing.T) {
+	for status, kind := range map[int]error{
+		http.StatusUnauthorized:        ErrAuth,
+		http.StatusForbidden:           ErrAuth,
+		http.StatusTooManyRequests:     ErrRateLimited,
+		http.StatusInternalServerError: ErrUpstream,
+		http.StatusGatewayTimeout:      ErrUpstream,
+	} {
+		err := &StatusError{StatusCode: status, Host: "example.com"}
+		for _, other := range []error{ErrAuth, ErrRateLimited, ErrUpstream} {
+			assert.Equal(t, other == kind, errors.Is(err, other), "%d is %v", status, other)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/api", nil)
+	resp := &http.Response{StatusCode: http.StatusOK, Request: req, Header: http.Header{}}
+	assert.NoError(t, CheckResponse(resp))
+
+	resp.StatusCode = http.StatusTooManyRequests
+	resp.Header.Set("Retry-After", "30")
+	err := CheckResponse(resp)
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, "example.com", statusErr.Host)
+	assert.Equal(t, 30*time.Second, statusErr.RetryAfter)
+	assert.EqualError(t, err, "example.com returned status 429")
+}
@@ -4,19 +4,62 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"math"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/raterudder/raterudder/pkg/log"
 	"github.com/raterudder/raterudder/pkg/types"
 )
 
+// minCurrentPriceConfidence is the confidence below which a projected current
+// price isn't trusted to be lower than the prices observed so far this hour.
+const minCurrentPriceConfidence = 0.5
+
 // Decision represents the result of the decision logic.
 type Decision struct {
 	Action      types.Action
 	Explanation string
 }
 
+// batteryModeNames are used to explain battery mode substitutions.
+var batteryModeNames = map[types.BatteryMode]string{
+	types.BatteryModeChargeAny:   "Charging",
+	types.BatteryModeChargeSolar: "Solar-only charging",
+	types.BatteryModeStandby:     "Standby",
+	types.BatteryModeLoad:        "Using the battery",
+	types.BatteryModeExport:      "Exporting the battery",
+}
+
+// batteryModeFallbacks are the modes to try, in order, when the ESS doesn't
+// support a battery mode.
+var batteryModeFallbacks = map[types.BatteryMode][]types.BatteryMode{
+	// charging from solar is the closest to charging without the grid and
+	// standby still lets excess solar charge the battery
+	types.BatteryModeChargeAny:   {types.BatteryModeChargeSolar, types.BatteryModeStandby},
+	types.BatteryModeChargeSolar: {types.BatteryModeStandby},
+	// charging from solar also stops the battery from discharging
+	types.BatteryModeStandby: {types.BatteryModeChargeSolar},
+	// using the battery for the home is the closest to exporting it
+	types.BatteryModeExport: {types.BatteryModeLoad},
+}
+
+// supportedBatteryMode returns the battery mode to use instead of mode if
+// the ESS doesn't support it along with an explanation of the substitution.
+func supportedBatteryMode(caps *types.ESSCapabilities, mode types.BatteryMode) (types.BatteryMode, string) {
+	if caps.SupportsBatteryMode(mode) {
+		return mode, ""
+	}
+	for _, fallback := range batteryModeFallbacks[mode] {
+		if caps.SupportsBatteryMode(fallback) {
+			return fallback, fmt.Sprintf("%s not supported by ESS, %s instead.", batteryModeNames[mode], strings.ToLower(batteryModeNames[fallback]))
+		}
+	}
+	return types.BatteryModeNoChange, fmt.Sprintf("%s not supported by ESS, leaving battery unchanged.", batteryModeNames[mode])
+}
+
 // Controller handles the decision-making logic for the ESS.
 type Controller struct {
 }
@@ -54,6 +97,19 @@ func (c *Controller) Decide(
 		solarMode = types.SolarModeNoExport
 	}
 
+	// don't plan on anything the ESS can't do
+	caps := currentStatus.Capabilities
+	if caps != nil {
+		if !caps.GridCharging && settings.GridChargeBatteries {
+			log.Ctx(ctx).DebugContext(ctx, "ess cannot charge from the grid, disabling grid charging")
+			settings.GridChargeBatteries = false
+		}
+		if !caps.BatteryExport && settings.GridExportBatteries {
+			log.Ctx(ctx).DebugContext(ctx, "ess cannot export the battery, disabling battery export")
+			settings.GridExportBatteries = false
+		}
+	}
+
 	// Rule 1: If the price is negative, then don't export anything to the grid.
 	if currentPrice.DollarsPerKWH < 0 {
 		solarMode = types.SolarModeNoExport
@@ -63,6 +119,18 @@ func (c *Controller) Decide(
 
 	// Helper to determine final action with "No Change" optimizations
 	finalizeAction := func(batteryMode types.BatteryMode, reason types.ActionReason, modeReason string, futurePrice *types.Price, hitDeficitAt time.Time, hitCapacityAt time.Time) Decision {
+		var explanations []string
+		if supported, explanation := supportedBatteryMode(caps, batteryMode); supported != batteryMode {
+			log.Ctx(ctx).DebugContext(
+				ctx,
+				"battery mode not supported by ess, substituting",
+				slog.Int("batteryMode", int(batteryMode)),
+				slog.Int("substitute", int(supported)),
+			)
+			batteryMode = supported
+			explanations = append(explanations, explanation)
+		}
+
 		finalBatMode := batteryMode
 		switch batteryMode {
 		case types.BatteryModeChargeAny:
@@ -149,8 +217,19 @@ func (c *Controller) Decide(
 			if !currentStatus.ElevatedMinBatterySOC && (!settings.GridChargeBatteries || currentStatus.CanImportBattery) {
 				finalBatMode = types.BatteryModeNoChange
 			}
+		case types.BatteryModeExport:
+			// if the battery is discharging while we're exporting and the ESS is
+			// allowed to export the battery then we're already exporting
+			if currentStatus.BatteryKW > 0 && currentStatus.GridKW < 0 && currentStatus.CanExportBattery && !currentStatus.ElevatedMinBatterySOC {
+				finalBatMode = types.BatteryModeNoChange
+			}
 		default:
 		}
+		// the ESS only puts back the export limit it lifted when it's sent
+		// another mode
+		if finalBatMode == types.BatteryModeNoChange && currentStatus.ExportLimitLifted && batteryMode != types.BatteryModeExport && batteryMode != types.BatteryModeNoChange {
+			finalBatMode = batteryMode
+		}
 
 		// Check Solar Mode
 		finalSolarMode := solarMode
@@ -166,8 +245,19 @@ func (c *Controller) Decide(
 		case types.SolarModeNoChange:
 			// nothing to do
 		}
+		if !caps.SupportsSolarMode(finalSolarMode) {
+			log.Ctx(ctx).DebugContext(ctx, "solar mode not supported by ess, ignoring", slog.Int("solarMode", int(finalSolarMode)))
+			finalSolarMode = types.SolarModeNoChange
+			explanations = append(explanations, "Solar export control not supported by ESS.")
+		}
+
+		explanation := strings.Join(explanations, " ")
+		if explanation != "" {
+			modeReason += " " + explanation
+		}
 
 		return Decision{
+			Explanation: explanation,
 			Action: types.Action{
 				Timestamp:         now.UTC(),
 				BatteryMode:       finalBatMode,
@@ -190,7 +280,59 @@ func (c *Controller) Decide(
 		return finalizeAction(types.BatteryModeStandby, types.ActionReasonMissingBattery, "Battery Config Missing or Capacity 0. Standby.", nil, time.Time{}, time.Time{}), nil
 	}
 
+	// Outage Rule: The grid is down so the home runs on either the battery or
+	// the standby generator. The battery's energy is worth what it'll cost to
+	// replace once the grid is back so save it if the generator is cheaper.
+	if currentStatus.GridOutage && settings.GeneratorCostDollarsPerKWH > 0 {
+		simData := c.SimulateState(ctx, now, currentStatus, currentPrice, futurePrices, history, settings)
+		replaceCost, replacePrice, replaceTime := batteryReplacementCost(simData)
+		log.Ctx(ctx).DebugContext(
+			ctx,
+			"grid outage, choosing between battery and generator",
+			slog.Float64("generatorCost", settings.GeneratorCostDollarsPerKWH),
+			slog.Float64("replaceCost", replaceCost),
+			slog.Time("replaceTime", replaceTime),
+		)
+		var d Decision
+		if settings.GeneratorCostDollarsPerKWH < replaceCost {
+			desc := fmt.Sprintf(
+				"Grid Outage. Generator ($%.3f) < Replacing Battery at %s ($%.3f). Saving Battery.",
+				settings.GeneratorCostDollarsPerKWH,
+				replaceTime.Format(time.Kitchen),
+				replaceCost,
+			)
+			d = finalizeAction(types.BatteryModeStandby, types.ActionReasonOutageUseGenerator, desc, &replacePrice, time.Time{}, time.Time{})
+		} else {
+			desc := fmt.Sprintf(
+				"Grid Outage. Replacing Battery ($%.3f) <= Generator ($%.3f). Using Battery.",
+				replaceCost,
+				settings.GeneratorCostDollarsPerKWH,
+			)
+			d = finalizeAction(types.BatteryModeLoad, types.ActionReasonOutageUseBattery, desc, &replacePrice, time.Time{}, time.Time{})
+		}
+		// storm hedge and backup modes don't accept changes
+		if currentStatus.EmergencyMode {
+			d.Action.BatteryMode = types.BatteryModeNoChange
+			d.Action.SolarMode = types.SolarModeNoChange
+			d.Action.Description += " ESS is in emergency mode so modes weren't changed."
+		}
+		return d, nil
+	}
+
 	gridChargeNowCost := currentPrice.DollarsPerKWH + currentPrice.GridUseDollarsPerKWH
+	// Early in the hour the projected price is mostly a guess so if the prices
+	// observed so far are higher, assume they'll continue so we don't charge
+	// during a spike.
+	if currentPrice.PriceConfidence() < minCurrentPriceConfidence && currentPrice.ObservedDollarsPerKWH > currentPrice.DollarsPerKWH {
+		log.Ctx(ctx).DebugContext(
+			ctx,
+			"current price projection has low confidence, using observed price for charging",
+			slog.Float64("projectedPrice", currentPrice.DollarsPerKWH),
+			slog.Float64("observedPrice", currentPrice.ObservedDollarsPerKWH),
+			slog.Float64("confidence", currentPrice.Confidence),
+		)
+		gridChargeNowCost = currentPrice.ObservedDollarsPerKWH + currentPrice.GridUseDollarsPerKWH
+	}
 	// Rule 2: If the price is below the Always Charge Threshold, then charge the
 	// battery.
 	if !currentStatus.BatteryChargingDisabled && gridChargeNowCost <= settings.AlwaysChargeUnderDollarsPerKWH {
@@ -215,6 +357,10 @@ func (c *Controller) Decide(
 		// conservatively assume it takes 3 hours to charge the battery from 0->100
 		chargeKW = capacityKWH / 3.0
 	}
+	// charging from the grid can't be faster than the grid import limit
+	if currentStatus.MaxGridImportKW > 0 && currentStatus.MaxGridImportKW < chargeKW {
+		chargeKW = currentStatus.MaxGridImportKW
+	}
 
 	simData := c.SimulateState(ctx, now, currentStatus, currentPrice, futurePrices, history, settings)
 
@@ -234,6 +380,48 @@ func (c *Controller) Decide(
 		}
 	}
 
+	// Balancing Rule: If the battery modules have drifted apart then fully
+	// charge the battery in the cheapest window long enough to fill it so the
+	// modules can balance.
+	if imbalance := currentStatus.BatteryImbalance(); settings.BatteryBalanceThresholdSOC > 0 && imbalance > settings.BatteryBalanceThresholdSOC &&
+		settings.GridChargeBatteries && !currentStatus.BatteryChargingDisabled && len(simData) > 1 {
+		// the emptiest module has the furthest to go
+		needKWH := capacityKWH * (100.0 - slices.Min(currentStatus.EachBatterySOC)) / 100.0
+		chargeHours := max(1, int(math.Ceil(needKWH/chargeKW)))
+		futureCosts := make([]float64, 0, len(simData)-1)
+		for _, slot := range simData[1:] {
+			if slot.ChargingDisabled {
+				continue
+			}
+			futureCosts = append(futureCosts, slot.GridChargeDollarsPerKWH)
+		}
+		sort.Float64s(futureCosts)
+		// if it's too cold to charge later then now is the only chance
+		windowCost := math.Inf(1)
+		if len(futureCosts) > 0 {
+			windowCost = futureCosts[min(chargeHours, len(futureCosts))-1]
+		}
+		log.Ctx(ctx).DebugContext(
+			ctx,
+			"battery modules imbalanced",
+			slog.Float64("imbalance", imbalance),
+			slog.Float64("threshold", settings.BatteryBalanceThresholdSOC),
+			slog.Int("chargeHours", chargeHours),
+			slog.Float64("windowCost", windowCost),
+			slog.Float64("nowCost", gridChargeNowCost),
+		)
+		if gridChargeNowCost <= windowCost {
+			desc := fmt.Sprintf(
+				"Battery Modules Imbalanced (%.0f%% > %.0f%%). Charging to Full Now ($%.3f <= $%.3f) to Balance.",
+				imbalance,
+				settings.BatteryBalanceThresholdSOC,
+				gridChargeNowCost,
+				windowCost,
+			)
+			return finalizeAction(types.BatteryModeChargeAny, types.ActionReasonBalanceCharge, desc, nil, time.Time{}, time.Time{}), nil
+		}
+	}
+
 	// track simulated energy
 	continuousPeakLoadKWH := 0.0
 	var hitDeficitAt time.Time
@@ -248,17 +436,24 @@ func (c *Controller) Decide(
 	for i, slot := range simData {
 		simInFuture := false
 
-		// these costs ignore the "now" hour so it can be compared against gridChargeNowCost
+		// these costs ignore the "now" hour so it can be compared against
+		// gridChargeNowCost and skip the hours it's too cold to charge
 		var simPrevChargeCosts []float64
 		var simPrevCheapestCost float64
 		if i > 0 {
 			simInFuture = true
-			simPrevChargeCosts = make([]float64, i)
+			simPrevChargeCosts = make([]float64, 0, i)
 			for j := 1; j <= i; j++ {
-				simPrevChargeCosts[j-1] = simData[j].GridChargeDollarsPerKWH
+				if simData[j].ChargingDisabled {
+					continue
+				}
+				simPrevChargeCosts = append(simPrevChargeCosts, simData[j].GridChargeDollarsPerKWH)
 			}
 			sort.Float64s(simPrevChargeCosts)
-			simPrevCheapestCost = simPrevChargeCosts[0]
+			simPrevCheapestCost = math.Inf(1)
+			if len(simPrevChargeCosts) > 0 {
+				simPrevCheapestCost = simPrevChargeCosts[0]
+			}
 		}
 
 		isAboveMinDeficitPriceDifference := simInFuture && slot.GridChargeDollarsPerKWH > simPrevCheapestCost+settings.MinDeficitPriceDifferenceDollarsPerKWH
@@ -337,8 +532,40 @@ func (c *Controller) Decide(
 				// round up the hours we need to charge except for a little buffer
 				chargeDurationHours := max(1, int((float64(deficitAmount)/chargeKW + 0.84)))
 
-				if simInFuture {
-					simInFuture = true
+				// it'll be too cold to charge until after the deficit so now is
+				// the only chance, but only if it's cheaper than importing then
+				coldUntilDeficit := simInFuture && len(simPrevChargeCosts) == 0
+				if coldUntilDeficit && gridChargeNowCost+settings.MinDeficitPriceDifferenceDollarsPerKWH <= slot.GridChargeDollarsPerKWH {
+					shouldCharge = true
+					chargeDescription = fmt.Sprintf(
+						"Projected Deficit at %s. Battery Too Cold to Charge Before Then. Charging Now ($%.3f) <= Then ($%.3f) - Delta ($%.3f).",
+						slot.TS.Format(time.Kitchen),
+						gridChargeNowCost,
+						slot.GridChargeDollarsPerKWH,
+						settings.MinDeficitPriceDifferenceDollarsPerKWH,
+					)
+					chargeActionReason = types.ActionReasonColdChargeNow
+					log.Ctx(ctx).DebugContext(
+						ctx,
+						"deficit predicted while too cold to charge, charging now",
+						slog.Float64("deficit", deficitAmount),
+						slog.Time("deficitAt", hitDeficitAt),
+						slog.Float64("chargeCost", gridChargeNowCost),
+						slog.Float64("deficitCost", slot.GridChargeDollarsPerKWH),
+					)
+					break
+				}
+				if coldUntilDeficit {
+					log.Ctx(ctx).DebugContext(
+						ctx,
+						"deficit predicted while too cold to charge, importing then is cheaper",
+						slog.Float64("deficit", deficitAmount),
+						slog.Time("deficitAt", hitDeficitAt),
+						slog.Float64("chargeCost", gridChargeNowCost),
+						slog.Float64("deficitCost", slot.GridChargeDollarsPerKWH),
+						slog.Float64("minDeficitPriceDifference", settings.MinDeficitPriceDifferenceDollarsPerKWH),
+					)
+				} else if simInFuture {
 					if chargeDurationHours > len(simPrevChargeCosts) {
 						cheapestFutureChargeCost = simPrevChargeCosts[len(simPrevChargeCosts)-1]
 					} else {
@@ -347,7 +574,7 @@ func (c *Controller) Decide(
 
 					// Find the price that matches the cheapest future cost
 					for j := 1; j <= i; j++ {
-						if simData[j].GridChargeDollarsPerKWH == cheapestFutureChargeCost {
+						if !simData[j].ChargingDisabled && simData[j].GridChargeDollarsPerKWH == cheapestFutureChargeCost {
 							cheapestFutureChargePrice = simData[j].Price
 							cheapestFutureChargeTime = simData[j].TS
 							break
@@ -357,7 +584,7 @@ func (c *Controller) Decide(
 
 				// if we have determined we'll run out of energy and it's cheaper to
 				// charge now than later, charge now
-				if simInFuture && gridChargeNowCost+settings.MinDeficitPriceDifferenceDollarsPerKWH <= cheapestFutureChargeCost {
+				if !coldUntilDeficit && simInFuture && gridChargeNowCost+settings.MinDeficitPriceDifferenceDollarsPerKWH <= cheapestFutureChargeCost {
 					shouldCharge = true
 					chargeDescription = fmt.Sprintf(
 						"Projected Deficit at %s. Charge Now ($%.3f) <= Later ($%.3f) - Delta ($%.3f).",
@@ -378,7 +605,7 @@ func (c *Controller) Decide(
 						slog.Float64("minDeficitPriceDifference", settings.MinDeficitPriceDifferenceDollarsPerKWH),
 					)
 					break
-				} else {
+				} else if !coldUntilDeficit {
 					if plannedChargeTime.IsZero() || cheapestFutureChargeCost < plannedChargeCost {
 						plannedChargeTime = cheapestFutureChargeTime
 						plannedChargePrice = cheapestFutureChargePrice
@@ -443,7 +670,7 @@ func (c *Controller) Decide(
 
 		// make sure we can charge the batteries, we can export solar, and we have
 		// enough headroom to charge
-		if settings.GridChargeBatteries && settings.GridExportSolar && simEnergyAfterCharge < capacityKWH && hitCapacityAt.IsZero() && !currentStatus.BatteryChargingDisabled {
+		if settings.GridChargeBatteries && settings.GridExportSolar && simEnergyAfterCharge < slot.BatteryCapacityKWH && hitCapacityAt.IsZero() && !currentStatus.BatteryChargingDisabled {
 			var value float64
 			// if we are importing, we avoid the import cost
 			// if we are exporting, we get the export value
@@ -483,6 +710,87 @@ func (c *Controller) Decide(
 		return finalizeAction(types.BatteryModeChargeAny, chargeActionReason, desc, futurePrice, hitDeficitAt, hitCapacityAt), nil
 	}
 
+	// Rule 3b: Sell stored energy if we're at the export price peak and the
+	// energy can be put back later for less than we get for it now.
+	if settings.GridExportBatteries && caps.SupportsBatteryMode(types.BatteryModeExport) && currentStatus.BatterySOC > settings.MinBatterySOC && len(simData) > 1 {
+		exportNowValue := simData[0].BatteryExportDollarsPerKWH
+
+		// exporting drains the battery on top of what the home uses so it runs
+		// out sooner than simulated
+		exportKW := currentStatus.MaxBatteryDischargeKW
+		if exportKW <= 0 {
+			exportKW = capacityKWH / 3.0
+		}
+		if currentStatus.MaxGridExportKW > 0 {
+			exportKW = min(exportKW, currentStatus.MaxGridExportKW)
+		}
+		drainKWH := min(exportKW, max(simData[0].BatteryKWH-simData[0].BatteryReserveKWH, 0))
+		exportDeficitAt := hitDeficitAt
+		for _, slot := range simData[1:] {
+			if slot.BatteryKWH-drainKWH < slot.BatteryReserveKWH {
+				exportDeficitAt = slot.TS
+				break
+			}
+		}
+
+		// the export value has to be higher now than later, net metering
+		// credits are worth the same every hour so there's never a peak
+		isExportPeak := drainKWH > 0
+		rechargeCost := -1.0
+		var rechargePrice types.Price
+		var rechargeAt time.Time
+		for _, slot := range simData[1:] {
+			if slot.BatteryExportDollarsPerKWH >= exportNowValue {
+				isExportPeak = false
+				break
+			}
+			// the energy has to be put back by the time the battery would run
+			// out, after that the home buys it at the import price anyways
+			if !exportDeficitAt.IsZero() && slot.TS.After(exportDeficitAt) {
+				continue
+			}
+			if settings.GridChargeBatteries && !currentStatus.BatteryChargingDisabled && !slot.ChargingDisabled && (rechargeCost < 0 || slot.GridChargeDollarsPerKWH < rechargeCost) {
+				rechargeCost = slot.GridChargeDollarsPerKWH
+				rechargePrice = slot.Price
+				rechargeAt = slot.TS
+			}
+		}
+		// if solar will fill the battery up before we run out then the energy we
+		// sell now is replaced by solar we would've otherwise exported
+		if !hitCapacityAt.IsZero() && (exportDeficitAt.IsZero() || hitCapacityAt.Before(exportDeficitAt)) {
+			for _, slot := range simData {
+				if slot.TS.Equal(hitCapacityAt) {
+					if rechargeCost < 0 || slot.SolarOppDollarsPerKWH < rechargeCost {
+						rechargeCost = slot.SolarOppDollarsPerKWH
+						rechargePrice = slot.Price
+						rechargeAt = slot.TS
+					}
+					break
+				}
+			}
+		}
+
+		log.Ctx(ctx).DebugContext(
+			ctx,
+			"checked battery export opportunity",
+			slog.Float64("exportNowValue", exportNowValue),
+			slog.Bool("isExportPeak", isExportPeak),
+			slog.Float64("drainKWH", drainKWH),
+			slog.Time("exportDeficitAt", exportDeficitAt),
+			slog.Float64("rechargeCost", rechargeCost),
+			slog.Float64("minArbitrageDifference", settings.MinArbitrageDifferenceDollarsPerKWH),
+		)
+		if isExportPeak && rechargeCost >= 0 && exportNowValue-rechargeCost > settings.MinArbitrageDifferenceDollarsPerKWH {
+			desc := fmt.Sprintf(
+				"Export Opportunity. Sell@%.3f -> Recharge@%.3f at %s.",
+				exportNowValue,
+				rechargeCost,
+				rechargeAt.Format(time.Kitchen),
+			)
+			return finalizeAction(types.BatteryModeExport, types.ActionReasonArbitrageExportNow, desc, &rechargePrice, hitDeficitAt, hitCapacityAt), nil
+		}
+	}
+
 	// Rule 4: Logic for Battery Usage vs Standby
 	// If we have plenty of battery (no deficit), Use it (Load).
 	// If we have a deficit, but we are at the Highest Price, Use it (Load).
@@ -562,3 +870,149 @@ func (c *Controller) Decide(
 	)
 	return finalizeAction(types.BatteryModeLoad, types.ActionReasonSufficientBattery, "Sufficient battery.", nil, hitDeficitAt, hitCapacityAt), nil
 }
+
+// PlanSchedule builds a schedule for the rest of the simulated day by
+// deciding what to do at the start of each hour using the simulated battery
+// level. The first block is the current action so the ESS keeps doing what
+// was just decided. Consecutive hours with the same modes are merged.
+func (c *Controller) PlanSchedule(
+	ctx context.Context,
+	currentStatus types.SystemStatus,
+	currentPrice types.Price,
+	futurePrices []types.Price,
+	history []types.EnergyStats,
+	settings types.Settings,
+	current types.Action,
+) []types.ScheduleBlock {
+	now := currentStatus.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	hourStart := now.Truncate(time.Hour)
+
+	blocks := []types.ScheduleBlock{{
+		Start:       hourStart,
+		End:         hourStart.Add(time.Hour),
+		BatteryMode: current.TargetBatteryMode,
+		SolarMode:   current.TargetSolarMode,
+	}}
+	if currentStatus.BatteryCapacityKWH <= 0 {
+		return blocks
+	}
+
+	simData := c.SimulateState(ctx, now, currentStatus, currentPrice, futurePrices, history, settings)
+	// once a balancing charge is planned the modules are assumed to balance
+	balanced := false
+	for i := 1; i < len(simData); i++ {
+		slot := simData[i]
+		// without a price there's nothing to plan with
+		if slot.Price.TSStart.IsZero() {
+			break
+		}
+
+		// the battery is wherever the simulation left it at the end of the
+		// previous hour and nothing is flowing yet
+		status := currentStatus
+		status.Timestamp = slot.TS
+		status.BatterySOC = simData[i-1].BatteryKWH / currentStatus.BatteryCapacityKWH * 100.0
+		status.BatteryKW = 0
+		status.GridKW = 0
+		status.BatteryAboveMinSOC = status.BatterySOC >= settings.MinBatterySOC
+		status.BatteryChargingDisabled = slot.ChargingDisabled
+		status.EachBatteryKW = nil
+		if len(currentStatus.EachBatterySOC) > 1 {
+			if balanced {
+				status.EachBatterySOC = nil
+			} else {
+				// the simulated level is the emptiest module's so every module
+				// moves with it and keeps the same spread
+				shift := status.BatterySOC - slices.Min(currentStatus.EachBatterySOC)
+				status.EachBatterySOC = make([]float64, len(currentStatus.EachBatterySOC))
+				var total float64
+				for j, soc := range currentStatus.EachBatterySOC {
+					status.EachBatterySOC[j] = min(max(soc+shift, 0), 100)
+					total += status.EachBatterySOC[j]
+				}
+				status.BatterySOC = total / float64(len(status.EachBatterySOC))
+			}
+		}
+
+		var prices []types.Price
+		for _, fp := range futurePrices {
+			if fp.TSStart.After(slot.TS) {
+				prices = append(prices, fp)
+			}
+		}
+
+		planCtx := log.With(ctx, log.Ctx(ctx).With(slog.Time("planHour", slot.TS)))
+		decision, err := c.Decide(planCtx, status, slot.Price, prices, history, settings)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to plan hour, ending schedule", slog.Time("hour", slot.TS), slog.Any("error", err))
+			break
+		}
+		if decision.Action.Reason == types.ActionReasonBalanceCharge {
+			balanced = true
+		}
+
+		start := hourStart.Add(time.Duration(i) * time.Hour)
+		last := &blocks[len(blocks)-1]
+		if last.BatteryMode == decision.Action.TargetBatteryMode && last.SolarMode == decision.Action.TargetSolarMode {
+			last.End = start.Add(time.Hour)
+			continue
+		}
+		blocks = append(blocks, types.ScheduleBlock{
+			Start:       start,
+			End:         start.Add(time.Hour),
+			BatteryMode: decision.Action.TargetBatteryMode,
+			SolarMode:   decision.Action.TargetSolarMode,
+		})
+	}
+	return blocks
+}
+
+// ModeMismatches compares the status reported after modes were sent to the
+// battery and solar modes and returns how the ESS differs, if at all. Only the
+// flags the ESS sets when switching modes are compared since the power flows
+// take longer to follow. NoChange modes aren't checked.
+func ModeMismatches(status types.SystemStatus, settings types.Settings, bat types.BatteryMode, sol types.SolarMode) []string {
+	var mismatches []string
+	// these mirror the checks Decide uses to skip modes we're already in
+	gridChargeOK := !settings.GridChargeBatteries || status.CanImportBattery
+	switch bat {
+	case types.BatteryModeChargeAny:
+		if !status.ElevatedMinBatterySOC || !gridChargeOK {
+			mismatches = append(mismatches, "not charging from the grid")
+		}
+	case types.BatteryModeChargeSolar:
+		if !status.ElevatedMinBatterySOC || status.CanImportBattery {
+			mismatches = append(mismatches, "not charging from solar only")
+		}
+	case types.BatteryModeStandby:
+		// the reserve can't be elevated if we're already at the minimum
+		if !status.ElevatedMinBatterySOC && status.BatterySOC > settings.MinBatterySOC+1 {
+			mismatches = append(mismatches, "battery reserve not elevated")
+		}
+	case types.BatteryModeLoad:
+		if status.ElevatedMinBatterySOC {
+			mismatches = append(mismatches, "battery reserve still elevated")
+		}
+		if !gridChargeOK {
+			mismatches = append(mismatches, "grid charging not enabled")
+		}
+	case types.BatteryModeExport:
+		if status.ElevatedMinBatterySOC || !status.CanExportBattery {
+			mismatches = append(mismatches, "battery export not enabled")
+		}
+	}
+	switch sol {
+	case types.SolarModeNoExport:
+		if status.CanExportSolar {
+			mismatches = append(mismatches, "solar export still enabled")
+		}
+	case types.SolarModeAny:
+		if !status.CanExportSolar {
+			mismatches = append(mismatches, "solar export not enabled")
+		}
+	}
+	return mismatches
+}
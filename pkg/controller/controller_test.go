@@ -95,6 +95,30 @@ func TestDecide(t *testing.T) {
 		assert.Equal(t, baseStatus.BatterySOC, decision.Action.SystemStatus.BatterySOC)
 	})
 
+	t.Run("Low Projected Price With Low Confidence -> No Charge", func(t *testing.T) {
+		// the first samples of the hour spiked but the day-ahead price pulled the
+		// projection down below the always charge threshold
+		currentPrice := types.Price{
+			TSStart:               now,
+			DollarsPerKWH:         0.00,
+			GridUseDollarsPerKWH:  -0.01,
+			Projected:             true,
+			ObservedDollarsPerKWH: 0.20,
+			Confidence:            0.3,
+		}
+		decision, err := c.Decide(ctx, baseStatus, currentPrice, nil, history, baseSettings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.ActionReasonAlwaysChargeBelowThreshold, decision.Action.Reason)
+		assert.NotEqual(t, types.BatteryModeChargeAny, decision.Action.TargetBatteryMode)
+
+		// once we're confident in the projection we charge
+		currentPrice.Confidence = 0.8
+		decision, err = c.Decide(ctx, baseStatus, currentPrice, nil, history, baseSettings)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonAlwaysChargeBelowThreshold, decision.Action.Reason)
+		assert.Equal(t, types.BatteryModeChargeAny, decision.Action.TargetBatteryMode)
+	})
+
 	t.Run("High Price Now -> Load (Discharge)", func(t *testing.T) {
 		currentPrice := types.Price{TSStart: now, DollarsPerKWH: 0.20, GridUseDollarsPerKWH: 0.20}
 		// Provide cheap power for next 24 hours to ensure we definitely wait
@@ -723,6 +747,17 @@ func TestDecide(t *testing.T) {
 			assert.Equal(t, types.BatteryModeLoad, decision.Action.TargetBatteryMode)
 		})
 
+		t.Run("Export Limit Lifted -> Load", func(t *testing.T) {
+			status := baseStatus
+			status.BatteryKW = 0.0
+			status.ExportLimitLifted = true
+
+			decision, err := c.Decide(ctx, status, currentPrice, nil, history, baseSettings)
+			require.NoError(t, err)
+			// the ESS has to be sent the mode to put back its export limit
+			assert.Equal(t, types.BatteryModeLoad, decision.Action.BatteryMode)
+		})
+
 		t.Run("Standby Logic: Idle -> NoChange", func(t *testing.T) {
 			status := baseStatus
 			status.BatteryKW = 0.0
@@ -1066,3 +1101,579 @@ func TestDecide(t *testing.T) {
 		})
 	})
 }
+
+func TestDecideCapabilities(t *testing.T) {
+	c := NewController()
+	ctx := context.Background()
+	now := time.Now()
+
+	settings := types.Settings{
+		MinBatterySOC:                       20.0,
+		AlwaysChargeUnderDollarsPerKWH:      -0.01,
+		GridChargeBatteries:                 true,
+		GridExportSolar:                     true,
+		MinArbitrageDifferenceDollarsPerKWH: 0.01,
+		SolarTrendRatioMax:                  3.0,
+		SolarBellCurveMultiplier:            1.0,
+	}
+
+	status := types.SystemStatus{
+		Timestamp:          now,
+		BatterySOC:         50.0,
+		BatteryKW:          1.0,
+		BatteryCapacityKWH: 10.0,
+		MaxBatteryChargeKW: 5.0,
+		HomeKW:             1.0,
+		CanImportBattery:   true,
+		CanExportSolar:     true,
+	}
+
+	history := []types.EnergyStats{}
+	for i := -48; i < 0; i++ {
+		history = append(history, types.EnergyStats{
+			TSHourStart:   now.Add(time.Duration(i) * time.Hour),
+			GridImportKWH: 1.0,
+			HomeKWH:       1.0,
+		})
+	}
+
+	// a cheap hour now and an expensive one soon is an arbitrage opportunity
+	currentPrice := types.Price{TSStart: now, DollarsPerKWH: 0.10, GridUseDollarsPerKWH: 0.10}
+	futurePrices := []types.Price{}
+	for i := 1; i <= 24; i++ {
+		price := 0.10
+		if i == 2 {
+			price = 0.50
+		}
+		futurePrices = append(futurePrices, types.Price{
+			TSStart:       now.Add(time.Duration(i) * time.Hour),
+			DollarsPerKWH: price, GridUseDollarsPerKWH: price,
+		})
+	}
+	negativePrice := types.Price{TSStart: now, DollarsPerKWH: -0.05}
+
+	t.Run("All Capabilities", func(t *testing.T) {
+		s := status
+		caps := types.AllESSCapabilities()
+		s.Capabilities = &caps
+
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.BatteryModeChargeAny, decision.Action.BatteryMode)
+		assert.Equal(t, types.ActionReasonArbitrageChargeNow, decision.Action.Reason)
+		assert.Empty(t, decision.Explanation)
+	})
+
+	t.Run("No Grid Charging -> No Arbitrage", func(t *testing.T) {
+		s := status
+		caps := types.AllESSCapabilities()
+		caps.GridCharging = false
+		s.Capabilities = &caps
+
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeChargeAny, decision.Action.TargetBatteryMode)
+		assert.NotEqual(t, types.ActionReasonArbitrageChargeNow, decision.Action.Reason)
+	})
+
+	t.Run("Charge Unsupported -> Standby", func(t *testing.T) {
+		s := status
+		s.Capabilities = &types.ESSCapabilities{
+			BatteryModes: []types.BatteryMode{types.BatteryModeStandby, types.BatteryModeLoad},
+			SolarModes:   []types.SolarMode{types.SolarModeNoExport, types.SolarModeAny},
+		}
+
+		decision, err := c.Decide(ctx, s, negativePrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.BatteryModeStandby, decision.Action.BatteryMode)
+		assert.Equal(t, types.BatteryModeStandby, decision.Action.TargetBatteryMode)
+		assert.Equal(t, types.ActionReasonAlwaysChargeBelowThreshold, decision.Action.Reason)
+		assert.Equal(t, "Charging not supported by ESS, standby instead.", decision.Explanation)
+		assert.Contains(t, decision.Action.Description, decision.Explanation)
+	})
+
+	t.Run("Charge Unsupported -> Solar Charge", func(t *testing.T) {
+		s := status
+		s.Capabilities = &types.ESSCapabilities{
+			BatteryModes: []types.BatteryMode{types.BatteryModeChargeSolar, types.BatteryModeStandby, types.BatteryModeLoad},
+			SolarModes:   []types.SolarMode{types.SolarModeNoExport, types.SolarModeAny},
+		}
+
+		decision, err := c.Decide(ctx, s, negativePrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.BatteryModeChargeSolar, decision.Action.BatteryMode)
+		assert.Equal(t, "Charging not supported by ESS, solar-only charging instead.", decision.Explanation)
+	})
+
+	t.Run("Only Load -> NoChange", func(t *testing.T) {
+		s := status
+		s.Capabilities = &types.ESSCapabilities{
+			BatteryModes: []types.BatteryMode{types.BatteryModeLoad},
+			SolarModes:   []types.SolarMode{types.SolarModeNoExport, types.SolarModeAny},
+		}
+
+		decision, err := c.Decide(ctx, s, negativePrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.BatteryModeNoChange, decision.Action.BatteryMode)
+		assert.Equal(t, "Charging not supported by ESS, leaving battery unchanged.", decision.Explanation)
+	})
+
+	t.Run("No Solar Modes", func(t *testing.T) {
+		s := status
+		s.Capabilities = &types.ESSCapabilities{
+			BatteryModes: types.AllESSCapabilities().BatteryModes,
+			GridCharging: true,
+		}
+
+		decision, err := c.Decide(ctx, s, negativePrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.BatteryModeChargeAny, decision.Action.BatteryMode)
+		assert.Equal(t, types.SolarModeNoChange, decision.Action.SolarMode)
+		assert.Equal(t, types.SolarModeNoExport, decision.Action.TargetSolarMode)
+		assert.Equal(t, "Solar export control not supported by ESS.", decision.Explanation)
+	})
+}
+
+func TestDecideExport(t *testing.T) {
+	c := NewController()
+	ctx := context.Background()
+	now := time.Now()
+
+	settings := types.Settings{
+		MinBatterySOC:                       20.0,
+		AlwaysChargeUnderDollarsPerKWH:      -0.01,
+		GridChargeBatteries:                 true,
+		GridExportSolar:                     true,
+		GridExportBatteries:                 true,
+		MinArbitrageDifferenceDollarsPerKWH: 0.05,
+		SolarTrendRatioMax:                  3.0,
+		SolarBellCurveMultiplier:            1.0,
+	}
+
+	status := types.SystemStatus{
+		Timestamp:             now,
+		BatterySOC:            80.0,
+		BatteryKW:             1.0,
+		BatteryCapacityKWH:    10.0,
+		MaxBatteryChargeKW:    5.0,
+		MaxBatteryDischargeKW: 5.0,
+		HomeKW:                1.0,
+		CanImportBattery:      true,
+		CanExportSolar:        true,
+	}
+
+	history := []types.EnergyStats{}
+	for i := -48; i < 0; i++ {
+		history = append(history, types.EnergyStats{
+			TSHourStart:   now.Add(time.Duration(i) * time.Hour),
+			GridImportKWH: 1.0,
+			HomeKWH:       1.0,
+		})
+	}
+
+	// prices are at their peak now and cheap for the rest of the day
+	currentPrice := types.Price{TSStart: now, DollarsPerKWH: 0.50, GridUseDollarsPerKWH: 0.05}
+	futurePrices := func(price float64) []types.Price {
+		var prices []types.Price
+		for i := 1; i <= 24; i++ {
+			prices = append(prices, types.Price{
+				TSStart:              now.Add(time.Duration(i) * time.Hour),
+				DollarsPerKWH:        price,
+				GridUseDollarsPerKWH: 0.05,
+			})
+		}
+		return prices
+	}
+
+	t.Run("Export At Peak", func(t *testing.T) {
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.05), history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.BatteryModeExport, decision.Action.BatteryMode)
+		assert.Equal(t, types.ActionReasonArbitrageExportNow, decision.Action.Reason)
+		assert.Contains(t, decision.Action.Description, "Sell@0.500 -> Recharge@0.100")
+		require.NotNil(t, decision.Action.FuturePrice)
+		assert.Equal(t, 0.05, decision.Action.FuturePrice.DollarsPerKWH)
+	})
+
+	t.Run("Already Exporting", func(t *testing.T) {
+		s := status
+		s.BatteryKW = 5.0
+		s.GridKW = -4.0
+		s.CanExportBattery = true
+
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices(0.05), history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.BatteryModeNoChange, decision.Action.BatteryMode)
+		assert.Equal(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Higher Price Later", func(t *testing.T) {
+		prices := futurePrices(0.05)
+		prices[3].DollarsPerKWH = 0.60
+
+		decision, err := c.Decide(ctx, status, currentPrice, prices, history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Recharge Too Expensive", func(t *testing.T) {
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.46), history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("No Grid Charging", func(t *testing.T) {
+		s := settings
+		s.GridChargeBatteries = false
+
+		// there's no solar to refill the battery either
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.05), history, s)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Battery Export Disabled", func(t *testing.T) {
+		s := settings
+		s.GridExportBatteries = false
+
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.05), history, s)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Export Unsupported", func(t *testing.T) {
+		s := status
+		caps := types.AllESSCapabilities()
+		caps.BatteryModes = []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeStandby, types.BatteryModeLoad}
+		s.Capabilities = &caps
+
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices(0.05), history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("At Min SOC", func(t *testing.T) {
+		s := status
+		s.BatterySOC = 20.0
+
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices(0.05), history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Net Metering", func(t *testing.T) {
+		// credits are worth the same every hour so now is never the peak
+		s := settings
+		s.UtilityRateOptions.NetMeteringCredits = true
+		s.SolarNetMeteringCreditsValue = "highest"
+
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.05), history, s)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Deficit Before Cheap Recharge", func(t *testing.T) {
+		// exporting empties the battery by the next hour which is still
+		// expensive so the home would buy the energy back at nearly the
+		// price it was sold for
+		prices := futurePrices(0.05)
+		for i := 0; i < 3; i++ {
+			prices[i].DollarsPerKWH = 0.45
+		}
+
+		decision, err := c.Decide(ctx, status, currentPrice, prices, history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+
+		t.Run("Export Limit", func(t *testing.T) {
+			// only 1kWh can be exported in the hour so the battery lasts
+			// until the cheap hours
+			s := status
+			s.MaxGridExportKW = 1.0
+			decision, err := c.Decide(ctx, s, currentPrice, prices, history, settings)
+			require.NoError(t, err)
+			assert.Equal(t, types.BatteryModeExport, decision.Action.TargetBatteryMode)
+		})
+	})
+}
+
+func TestPlanSchedule(t *testing.T) {
+	c := NewController()
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 14, 20, 0, 0, time.UTC)
+
+	settings := types.Settings{
+		MinBatterySOC:                       20.0,
+		AlwaysChargeUnderDollarsPerKWH:      0.0,
+		GridChargeBatteries:                 true,
+		GridExportSolar:                     true,
+		MinArbitrageDifferenceDollarsPerKWH: 0.05,
+		SolarTrendRatioMax:                  3.0,
+		SolarBellCurveMultiplier:            1.0,
+	}
+
+	status := types.SystemStatus{
+		Timestamp:          now,
+		BatterySOC:         80.0,
+		BatteryCapacityKWH: 10.0,
+		MaxBatteryChargeKW: 5.0,
+		CanExportSolar:     true,
+	}
+
+	history := []types.EnergyStats{}
+	for i := -48; i < 0; i++ {
+		history = append(history, types.EnergyStats{
+			TSHourStart:   now.Truncate(time.Hour).Add(time.Duration(i) * time.Hour),
+			GridImportKWH: 0.5,
+			HomeKWH:       0.5,
+		})
+	}
+
+	currentPrice := types.Price{TSStart: now.Truncate(time.Hour), DollarsPerKWH: 0.10}
+	var futurePrices []types.Price
+	for i := 1; i < 24; i++ {
+		price := 0.10
+		// negative prices are always worth charging at
+		if i == 5 || i == 6 {
+			price = -0.02
+		}
+		futurePrices = append(futurePrices, types.Price{
+			TSStart:       now.Truncate(time.Hour).Add(time.Duration(i) * time.Hour),
+			DollarsPerKWH: price,
+		})
+	}
+
+	decision, err := c.Decide(ctx, status, currentPrice, futurePrices, history, settings)
+	require.NoError(t, err)
+	require.Equal(t, types.BatteryModeLoad, decision.Action.TargetBatteryMode)
+
+	blocks := c.PlanSchedule(ctx, status, currentPrice, futurePrices, history, settings, decision.Action)
+	require.NotEmpty(t, blocks)
+
+	// the schedule starts with the current action at the start of the hour
+	assert.Equal(t, now.Truncate(time.Hour), blocks[0].Start)
+	assert.Equal(t, decision.Action.TargetBatteryMode, blocks[0].BatteryMode)
+
+	// blocks are contiguous and merged
+	for i := 1; i < len(blocks); i++ {
+		assert.Equal(t, blocks[i-1].End, blocks[i].Start)
+		assert.False(t, blocks[i-1].BatteryMode == blocks[i].BatteryMode && blocks[i-1].SolarMode == blocks[i].SolarMode, "consecutive blocks should differ")
+	}
+	assert.Equal(t, now.Truncate(time.Hour).Add(24*time.Hour), blocks[len(blocks)-1].End)
+
+	// the negative price hours are planned as charging
+	var charge *types.ScheduleBlock
+	for i := range blocks {
+		if blocks[i].BatteryMode == types.BatteryModeChargeAny {
+			charge = &blocks[i]
+			break
+		}
+	}
+	require.NotNil(t, charge)
+	assert.Equal(t, now.Truncate(time.Hour).Add(5*time.Hour), charge.Start)
+	assert.Equal(t, now.Truncate(time.Hour).Add(7*time.Hour), charge.End)
+	assert.Equal(t, types.SolarModeNoExport, charge.SolarMode)
+
+	t.Run("Missing Battery", func(t *testing.T) {
+		s := status
+		s.BatteryCapacityKWH = 0
+		blocks := c.PlanSchedule(ctx, s, currentPrice, futurePrices, history, settings, decision.Action)
+		assert.Len(t, blocks, 1)
+	})
+}
+
+func TestModeMismatches(t *testing.T) {
+	settings := types.Settings{
+		MinBatterySOC:       20,
+		GridChargeBatteries: true,
+	}
+	tests := []struct {
+		name     string
+		status   types.SystemStatus
+		bat      types.BatteryMode
+		sol      types.SolarMode
+		expected []string
+	}{
+		{
+			name:   "ChargeAny Applied",
+			status: types.SystemStatus{ElevatedMinBatterySOC: true, CanImportBattery: true, CanExportSolar: true},
+			bat:    types.BatteryModeChargeAny,
+			sol:    types.SolarModeAny,
+		},
+		{
+			name:     "ChargeAny Without Grid",
+			status:   types.SystemStatus{ElevatedMinBatterySOC: true},
+			bat:      types.BatteryModeChargeAny,
+			expected: []string{"not charging from the grid"},
+		},
+		{
+			name:   "ChargeSolar Applied",
+			status: types.SystemStatus{ElevatedMinBatterySOC: true},
+			bat:    types.BatteryModeChargeSolar,
+		},
+		{
+			name:     "ChargeSolar From Grid",
+			status:   types.SystemStatus{ElevatedMinBatterySOC: true, CanImportBattery: true},
+			bat:      types.BatteryModeChargeSolar,
+			expected: []string{"not charging from solar only"},
+		},
+		{
+			name:   "Standby Applied",
+			status: types.SystemStatus{BatterySOC: 60, ElevatedMinBatterySOC: true},
+			bat:    types.BatteryModeStandby,
+		},
+		{
+			name:   "Standby At Minimum",
+			status: types.SystemStatus{BatterySOC: 20.5},
+			bat:    types.BatteryModeStandby,
+		},
+		{
+			name:     "Standby Not Applied",
+			status:   types.SystemStatus{BatterySOC: 60},
+			bat:      types.BatteryModeStandby,
+			expected: []string{"battery reserve not elevated"},
+		},
+		{
+			name:   "Load Applied",
+			status: types.SystemStatus{CanImportBattery: true},
+			bat:    types.BatteryModeLoad,
+		},
+		{
+			name:     "Load Not Applied",
+			status:   types.SystemStatus{ElevatedMinBatterySOC: true},
+			bat:      types.BatteryModeLoad,
+			expected: []string{"battery reserve still elevated", "grid charging not enabled"},
+		},
+		{
+			name:   "Export Applied",
+			status: types.SystemStatus{CanExportBattery: true},
+			bat:    types.BatteryModeExport,
+		},
+		{
+			name:     "Export Not Applied",
+			status:   types.SystemStatus{CanImportBattery: true},
+			bat:      types.BatteryModeExport,
+			expected: []string{"battery export not enabled"},
+		},
+		{
+			name:     "Solar Still Exporting",
+			status:   types.SystemStatus{CanExportSolar: true},
+			sol:      types.SolarModeNoExport,
+			expected: []string{"solar export still enabled"},
+		},
+		{
+			name:     "Solar Not Exporting",
+			status:   types.SystemStatus{},
+			sol:      types.SolarModeAny,
+			expected: []string{"solar export not enabled"},
+		},
+		{
+			name:   "NoChange",
+			status: types.SystemStatus{ElevatedMinBatterySOC: true},
+			bat:    types.BatteryModeNoChange,
+			sol:    types.SolarModeNoChange,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ModeMismatches(tt.status, settings, tt.bat, tt.sol))
+		})
+	}
+}
+
+func TestDecideBalancing(t *testing.T) {
+	c := NewController()
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 1, 0, 0, 0, time.UTC)
+
+	settings := types.Settings{
+		MinBatterySOC:              20.0,
+		GridChargeBatteries:        true,
+		SolarTrendRatioMax:         3.0,
+		SolarBellCurveMultiplier:   1.0,
+		BatteryBalanceThresholdSOC: 10.0,
+	}
+	status := types.SystemStatus{
+		Timestamp:          now,
+		BatterySOC:         60.0,
+		EachBatterySOC:     []float64{70.0, 50.0},
+		BatteryCapacityKWH: 10.0,
+		MaxBatteryChargeKW: 5.0,
+		CanImportBattery:   true,
+	}
+	// the battery needs 5kWh so a 1 hour window at 5kW
+	prices := func(current float64, future map[int]float64) (types.Price, []types.Price) {
+		var fps []types.Price
+		for i := 1; i < 24; i++ {
+			p := 0.10
+			if fp, ok := future[i]; ok {
+				p = fp
+			}
+			fps = append(fps, types.Price{TSStart: now.Add(time.Duration(i) * time.Hour), DollarsPerKWH: p})
+		}
+		return types.Price{TSStart: now, DollarsPerKWH: current}, fps
+	}
+
+	t.Run("Cheapest Window Now", func(t *testing.T) {
+		currentPrice, futurePrices := prices(0.03, map[int]float64{5: 0.04})
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices, nil, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonBalanceCharge, decision.Action.Reason)
+		assert.Equal(t, types.BatteryModeChargeAny, decision.Action.BatteryMode)
+		assert.Contains(t, decision.Action.Description, "Imbalanced (20% > 10%)")
+	})
+
+	t.Run("Cheaper Window Later", func(t *testing.T) {
+		currentPrice, futurePrices := prices(0.05, map[int]float64{5: 0.02})
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices, nil, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.ActionReasonBalanceCharge, decision.Action.Reason)
+	})
+
+	t.Run("Longer Window", func(t *testing.T) {
+		// 15kWh needs 3 hours so being the second cheapest hour is enough
+		s := status
+		s.BatteryCapacityKWH = 30.0
+		currentPrice, futurePrices := prices(0.04, map[int]float64{5: 0.02, 6: 0.05, 7: 0.06})
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices, nil, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonBalanceCharge, decision.Action.Reason)
+	})
+
+	t.Run("Within Threshold", func(t *testing.T) {
+		s := status
+		s.EachBatterySOC = []float64{62.0, 58.0}
+		currentPrice, futurePrices := prices(0.03, nil)
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices, nil, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.ActionReasonBalanceCharge, decision.Action.Reason)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		s := settings
+		s.BatteryBalanceThresholdSOC = 0
+		currentPrice, futurePrices := prices(0.03, nil)
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices, nil, s)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.ActionReasonBalanceCharge, decision.Action.Reason)
+	})
+
+	t.Run("Plan Schedule", func(t *testing.T) {
+		// the battery balances in the cheapest hour and not again after
+		currentPrice, futurePrices := prices(0.10, map[int]float64{3: 0.02})
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices, nil, settings)
+		require.NoError(t, err)
+		require.NotEqual(t, types.ActionReasonBalanceCharge, decision.Action.Reason)
+
+		blocks := c.PlanSchedule(ctx, status, currentPrice, futurePrices, nil, settings, decision.Action)
+		var charging []types.ScheduleBlock
+		for _, b := range blocks {
+			if b.BatteryMode == types.BatteryModeChargeAny {
+				charging = append(charging, b)
+			}
+		}
+		require.Len(t, charging, 1)
+		assert.Equal(t, now.Add(3*time.Hour), charging[0].Start)
+		assert.Equal(t, now.Add(4*time.Hour), charging[0].End)
+	})
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"sort"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// evPlanHours is how far ahead the EV charging plan looks.
+const evPlanHours = 24
+
+// PlanEVCharging plans when an EV charger should charge over the next day.
+// The EVChargeHours cheapest hours are allowed to charge at EVMaxChargeKW
+// along with any hour under the always charge price. Hours where the battery
+// plan is powering the home or exporting are only used if there aren't enough
+// other hours so the battery isn't drained into the car. Every other hour is
+// limited to 0. Consecutive hours with the same limit are merged. No blocks
+// are returned if EV charging isn't configured.
+func PlanEVCharging(
+	now time.Time,
+	currentPrice types.Price,
+	futurePrices []types.Price,
+	schedule []types.ScheduleBlock,
+	settings types.Settings,
+) []types.EVChargeBlock {
+	if settings.EVMaxChargeKW <= 0 {
+		return nil
+	}
+
+	type slot struct {
+		start time.Time
+		cost  float64
+		// batteryBusy is true if the battery is planned to be discharging
+		batteryBusy bool
+		charge      bool
+	}
+	hourStart := now.Truncate(time.Hour)
+	var slots []*slot
+	for i := range evPlanHours {
+		start := hourStart.Add(time.Duration(i) * time.Hour)
+		end := start.Add(time.Hour)
+
+		var sum float64
+		var n int
+		if i == 0 {
+			sum = currentPrice.DollarsPerKWH + currentPrice.GridUseDollarsPerKWH
+			n = 1
+		} else {
+			for _, p := range futurePrices {
+				if !p.TSStart.Before(start) && p.TSStart.Before(end) {
+					sum += p.DollarsPerKWH + p.GridUseDollarsPerKWH
+					n++
+				}
+			}
+		}
+		// without a price there's nothing to plan with
+		if n == 0 {
+			break
+		}
+
+		s := &slot{start: start, cost: sum / float64(n)}
+		for _, b := range schedule {
+			if !start.Before(b.Start) && start.Before(b.End) {
+				s.batteryBusy = b.BatteryMode == types.BatteryModeLoad || b.BatteryMode == types.BatteryModeExport
+				break
+			}
+		}
+		s.charge = s.cost <= settings.AlwaysChargeUnderDollarsPerKWH
+		slots = append(slots, s)
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+
+	ranked := make([]*slot, len(slots))
+	copy(ranked, slots)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].batteryBusy != ranked[j].batteryBusy {
+			return !ranked[i].batteryBusy
+		}
+		return ranked[i].cost < ranked[j].cost
+	})
+	for i := 0; i < settings.EVChargeHours && i < len(ranked); i++ {
+		ranked[i].charge = true
+	}
+
+	var blocks []types.EVChargeBlock
+	for _, s := range slots {
+		limit := 0.0
+		if s.charge {
+			limit = settings.EVMaxChargeKW
+		}
+		end := s.start.Add(time.Hour)
+		if len(blocks) > 0 && blocks[len(blocks)-1].LimitKW == limit {
+			blocks[len(blocks)-1].End = end
+			continue
+		}
+		blocks = append(blocks, types.EVChargeBlock{Start: s.start, End: end, LimitKW: limit})
+	}
+	return blocks
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanEVCharging(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 20, 0, 0, time.UTC)
+	hourStart := now.Truncate(time.Hour)
+
+	prices := func(cost map[int]float64) (types.Price, []types.Price) {
+		price := func(i int) types.Price {
+			p := types.Price{
+				TSStart:       hourStart.Add(time.Duration(i) * time.Hour),
+				TSEnd:         hourStart.Add(time.Duration(i+1) * time.Hour),
+				DollarsPerKWH: 0.10,
+			}
+			if c, ok := cost[i]; ok {
+				p.DollarsPerKWH = c
+			}
+			return p
+		}
+		var future []types.Price
+		for i := 1; i < 24; i++ {
+			future = append(future, price(i))
+		}
+		return price(0), future
+	}
+
+	settings := types.Settings{
+		EVMaxChargeKW: 7.2,
+		EVChargeHours: 2,
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		current, future := prices(nil)
+		s := settings
+		s.EVMaxChargeKW = 0
+		assert.Empty(t, PlanEVCharging(now, current, future, nil, s))
+	})
+
+	t.Run("Cheapest Hours", func(t *testing.T) {
+		current, future := prices(map[int]float64{3: 0.02, 4: 0.03, 10: 0.04})
+		blocks := PlanEVCharging(now, current, future, nil, settings)
+		require.Equal(t, []types.EVChargeBlock{
+			{Start: hourStart, End: hourStart.Add(3 * time.Hour), LimitKW: 0},
+			{Start: hourStart.Add(3 * time.Hour), End: hourStart.Add(5 * time.Hour), LimitKW: 7.2},
+			{Start: hourStart.Add(5 * time.Hour), End: hourStart.Add(24 * time.Hour), LimitKW: 0},
+		}, blocks)
+	})
+
+	t.Run("Avoids Battery Discharge", func(t *testing.T) {
+		current, future := prices(map[int]float64{3: 0.02, 4: 0.03, 10: 0.04})
+		schedule := []types.ScheduleBlock{
+			{Start: hourStart, End: hourStart.Add(4 * time.Hour), BatteryMode: types.BatteryModeStandby},
+			{Start: hourStart.Add(4 * time.Hour), End: hourStart.Add(5 * time.Hour), BatteryMode: types.BatteryModeLoad},
+			{Start: hourStart.Add(5 * time.Hour), End: hourStart.Add(24 * time.Hour), BatteryMode: types.BatteryModeStandby},
+		}
+		blocks := PlanEVCharging(now, current, future, schedule, settings)
+		var charging []time.Time
+		for _, b := range blocks {
+			if b.LimitKW > 0 {
+				charging = append(charging, b.Start)
+			}
+		}
+		assert.Equal(t, []time.Time{hourStart.Add(3 * time.Hour), hourStart.Add(10 * time.Hour)}, charging)
+	})
+
+	t.Run("Always Charge Price", func(t *testing.T) {
+		current, future := prices(map[int]float64{0: -0.01, 3: 0.02, 4: 0.03})
+		s := settings
+		s.EVChargeHours = 0
+		s.AlwaysChargeUnderDollarsPerKWH = 0.0
+		blocks := PlanEVCharging(now, current, future, nil, s)
+		require.NotEmpty(t, blocks)
+		assert.Equal(t, types.EVChargeBlock{Start: hourStart, End: hourStart.Add(time.Hour), LimitKW: 7.2}, blocks[0])
+		assert.Len(t, blocks, 2)
+	})
+
+	t.Run("Stops At Missing Prices", func(t *testing.T) {
+		current, future := prices(nil)
+		blocks := PlanEVCharging(now, current, future[:5], nil, settings)
+		require.Len(t, blocks, 2)
+		assert.Equal(t, hourStart.Add(6*time.Hour), blocks[len(blocks)-1].End)
+	})
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// PlanLoads schedules the site's flexible loads in the cheapest simulated
+// hours before each load's next deadline. Loads are expected to have the
+// energy they still need for the current deadline in EnergyKWH.
+func (c *Controller) PlanLoads(
+	ctx context.Context,
+	now time.Time,
+	currentStatus types.SystemStatus,
+	currentPrice types.Price,
+	futurePrices []types.Price,
+	history []types.EnergyStats,
+	settings types.Settings,
+) []types.LoadSchedule {
+	if len(settings.FlexibleLoads) == 0 {
+		return nil
+	}
+	simData := c.simulate(ctx, now, currentStatus, currentPrice, futurePrices, history, settings, nil)
+	schedules, _ := planLoads(now, simData, settings.FlexibleLoads)
+	return schedules
+}
+
+// planLoads schedules each load in turn and returns the schedules along with
+// the energy the loads use in each simulated hour. An hour's cost is the grid
+// price except for any solar surplus, which costs what the solar would have
+// been worth otherwise. Loads scheduled earlier use up the surplus first.
+func planLoads(now time.Time, simData []SimHour, loads []types.FlexibleLoad) ([]types.LoadSchedule, []float64) {
+	loadKWH := make([]float64, len(simData))
+	schedules := make([]types.LoadSchedule, 0, len(loads))
+	for _, l := range loads {
+		deadline := l.NextDeadline(now)
+		schedule := types.LoadSchedule{
+			LoadID:   l.ID,
+			Name:     l.Name,
+			Deadline: deadline,
+			Runs:     []types.LoadRun{},
+		}
+		if l.EnergyKWH <= 0 || l.PowerKW <= 0 {
+			schedules = append(schedules, schedule)
+			continue
+		}
+
+		type candidate struct {
+			i    int
+			kwh  float64
+			cost float64
+		}
+		var candidates []candidate
+		for i, h := range simData {
+			// without a price there's nothing to plan with
+			if !h.TS.Before(deadline) || h.Price.TSStart.IsZero() {
+				break
+			}
+			hours := math.Min(1, deadline.Sub(h.TS).Hours())
+			kwh := l.PowerKW * hours
+			surplus := math.Max(0, -h.NetLoadSolarKWH-loadKWH[i])
+			solar := math.Min(surplus, kwh)
+			candidates = append(candidates, candidate{
+				i:    i,
+				kwh:  kwh,
+				cost: (solar*h.SolarOppDollarsPerKWH + (kwh-solar)*h.GridChargeDollarsPerKWH) / kwh,
+			})
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].cost < candidates[j].cost
+		})
+
+		remaining := l.EnergyKWH
+		chosen := make(map[int]float64)
+		for _, c := range candidates {
+			if remaining <= 0 {
+				break
+			}
+			kwh := math.Min(remaining, c.kwh)
+			chosen[c.i] = kwh
+			loadKWH[c.i] += kwh
+			remaining -= kwh
+		}
+		// ignore rounding errors
+		if remaining > 0.001 {
+			schedule.UnmetKWH = remaining
+		}
+
+		for i, h := range simData {
+			kwh, ok := chosen[i]
+			if !ok {
+				continue
+			}
+			end := h.TS.Add(time.Duration(kwh / l.PowerKW * float64(time.Hour)))
+			if n := len(schedule.Runs); n > 0 && schedule.Runs[n-1].End.Equal(h.TS) {
+				schedule.Runs[n-1].End = end
+				schedule.Runs[n-1].EnergyKWH += kwh
+				continue
+			}
+			schedule.Runs = append(schedule.Runs, types.LoadRun{Start: h.TS, End: end, EnergyKWH: kwh})
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, loadKWH
+}
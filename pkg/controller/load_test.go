@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanLoads(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+
+	// prices are 0.10 except where overridden and hours with negative net
+	// load have a solar surplus
+	simHours := func(prices map[int]float64, netLoad map[int]float64) []SimHour {
+		var sim []SimHour
+		for i := 0; i < 24; i++ {
+			ts := now.Add(time.Duration(i) * time.Hour)
+			price := 0.10
+			if p, ok := prices[i]; ok {
+				price = p
+			}
+			sim = append(sim, SimHour{
+				TS:                      ts,
+				Hour:                    ts.Hour(),
+				NetLoadSolarKWH:         netLoad[i],
+				GridChargeDollarsPerKWH: price,
+				SolarOppDollarsPerKWH:   0.03,
+				Price:                   types.Price{TSStart: ts, DollarsPerKWH: price},
+			})
+		}
+		return sim
+	}
+
+	waterHeater := types.FlexibleLoad{
+		ID:           "water",
+		Name:         "Water Heater",
+		EnergyKWH:    6,
+		PowerKW:      4,
+		DeadlineHour: 6,
+	}
+
+	t.Run("Cheapest Hours Before Deadline", func(t *testing.T) {
+		// hour 18 is the cheapest but it's after the 6am deadline
+		sim := simHours(map[int]float64{3: 0.02, 10: 0.04, 12: 0.05, 18: 0.01}, nil)
+		schedules, loadKWH := planLoads(now, sim, []types.FlexibleLoad{waterHeater})
+		require.Len(t, schedules, 1)
+		s := schedules[0]
+		assert.Equal(t, time.Date(2026, 3, 11, 6, 0, 0, 0, time.UTC), s.Deadline)
+		assert.Zero(t, s.UnmetKWH)
+		assert.Equal(t, []types.LoadRun{
+			{Start: now.Add(3 * time.Hour), End: now.Add(4 * time.Hour), EnergyKWH: 4},
+			{Start: now.Add(10 * time.Hour), End: now.Add(10*time.Hour + 30*time.Minute), EnergyKWH: 2},
+		}, s.Runs)
+		assert.Equal(t, 4.0, loadKWH[3])
+		assert.Equal(t, 2.0, loadKWH[10])
+		assert.True(t, s.RunningAt(now.Add(3*time.Hour+10*time.Minute)))
+		assert.False(t, s.RunningAt(now.Add(10*time.Hour+45*time.Minute)))
+	})
+
+	t.Run("Solar Surplus", func(t *testing.T) {
+		l := waterHeater
+		l.DeadlineHour = 20
+		sim := simHours(nil, map[int]float64{2: -5, 3: -2})
+		schedules, _ := planLoads(now, sim, []types.FlexibleLoad{l})
+		// the full surplus hour is cheapest and the partial surplus hour is
+		// next
+		assert.Equal(t, []types.LoadRun{
+			{Start: now.Add(2 * time.Hour), End: now.Add(3*time.Hour + 30*time.Minute), EnergyKWH: 6},
+		}, schedules[0].Runs)
+	})
+
+	t.Run("Loads Share Surplus", func(t *testing.T) {
+		l := waterHeater
+		l.DeadlineHour = 20
+		l.EnergyKWH = 4
+		pool := types.FlexibleLoad{ID: "pool", EnergyKWH: 4, PowerKW: 4, DeadlineHour: 20}
+		sim := simHours(map[int]float64{5: 0.08}, map[int]float64{2: -4})
+		schedules, loadKWH := planLoads(now, sim, []types.FlexibleLoad{l, pool})
+		require.Len(t, schedules, 2)
+		assert.Equal(t, now.Add(2*time.Hour), schedules[0].Runs[0].Start)
+		// the surplus is used up so the pool runs in the next cheapest hour
+		assert.Equal(t, now.Add(5*time.Hour), schedules[1].Runs[0].Start)
+		assert.Equal(t, 4.0, loadKWH[2])
+		assert.Equal(t, 4.0, loadKWH[5])
+	})
+
+	t.Run("Unmet", func(t *testing.T) {
+		l := waterHeater
+		l.DeadlineHour = 15
+		sim := simHours(nil, nil)
+		schedules, _ := planLoads(now, sim, []types.FlexibleLoad{l})
+		assert.InDelta(t, 2.0, schedules[0].UnmetKWH, 0.001)
+		assert.Len(t, schedules[0].Runs, 1)
+	})
+
+	t.Run("Already Delivered", func(t *testing.T) {
+		l := waterHeater
+		l.EnergyKWH = 0
+		schedules, loadKWH := planLoads(now, simHours(nil, nil), []types.FlexibleLoad{l})
+		assert.Empty(t, schedules[0].Runs)
+		assert.Zero(t, schedules[0].UnmetKWH)
+		for _, kwh := range loadKWH {
+			assert.Zero(t, kwh)
+		}
+	})
+}
+
+func TestSimulateStateFlexibleLoads(t *testing.T) {
+	c := NewController()
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+	status := types.SystemStatus{
+		Timestamp:          now,
+		BatterySOC:         50,
+		BatteryCapacityKWH: 10,
+	}
+	currentPrice := types.Price{TSStart: now, DollarsPerKWH: 0.10}
+	var futurePrices []types.Price
+	for i := 1; i < 24; i++ {
+		price := 0.10
+		if i == 4 {
+			price = 0.02
+		}
+		futurePrices = append(futurePrices, types.Price{TSStart: now.Add(time.Duration(i) * time.Hour), DollarsPerKWH: price})
+	}
+	settings := types.Settings{
+		IgnoreHourUsageOverMultiple: 2,
+		SolarTrendRatioMax:          3,
+		FlexibleLoads: []types.FlexibleLoad{
+			{ID: "water", EnergyKWH: 3, PowerKW: 3, DeadlineHour: 6},
+		},
+	}
+
+	sim := c.SimulateState(ctx, now, status, currentPrice, futurePrices, nil, settings)
+	require.Greater(t, len(sim), 4)
+	assert.Equal(t, 3.0, sim[4].FlexibleLoadKWH)
+	assert.Equal(t, 3.0, sim[4].NetLoadSolarKWH)
+	assert.InDelta(t, sim[3].BatteryKWH-3, sim[4].BatteryKWH, 0.001)
+
+	schedules := c.PlanLoads(ctx, now, status, currentPrice, futurePrices, nil, settings)
+	require.Len(t, schedules, 1)
+	assert.Equal(t, []types.LoadRun{{Start: now.Add(4 * time.Hour), End: now.Add(5 * time.Hour), EnergyKWH: 3}}, schedules[0].Runs)
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// batteryReplacementCost returns the cheapest cost (in $/kWh) to put energy
+// back in the battery once the grid is back, along with the price and time of
+// that hour. Hours with a solar surplus are valued at the solar opportunity
+// cost. The current hour is skipped since the grid is down and so are hours
+// without a price.
+func batteryReplacementCost(simData []SimHour) (float64, types.Price, time.Time) {
+	cost := -1.0
+	var price types.Price
+	var ts time.Time
+	for _, h := range simData[min(1, len(simData)):] {
+		if h.Price.TSStart.IsZero() {
+			continue
+		}
+		c := h.GridChargeDollarsPerKWH
+		if h.NetLoadSolarKWH < 0 {
+			c = h.SolarOppDollarsPerKWH
+		}
+		if cost < 0 || c < cost {
+			cost = c
+			price = h.Price
+			ts = h.TS
+		}
+	}
+	return max(cost, 0), price, ts
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecideOutage(t *testing.T) {
+	c := NewController()
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 20, 0, 0, 0, time.UTC)
+
+	settings := types.Settings{
+		MinBatterySOC:              20.0,
+		GridChargeBatteries:        true,
+		SolarTrendRatioMax:         3.0,
+		SolarBellCurveMultiplier:   1.0,
+		GeneratorCostDollarsPerKWH: 0.30,
+	}
+	status := types.SystemStatus{
+		Timestamp:          now,
+		BatterySOC:         80.0,
+		BatteryCapacityKWH: 10.0,
+		BatteryKW:          2.0,
+		HomeKW:             2.0,
+		GridOutage:         true,
+	}
+	// the price is low now but there's no grid to charge from
+	currentPrice := types.Price{TSStart: now, DollarsPerKWH: -0.05}
+	futurePrices := func(dollarsPerKWH float64) []types.Price {
+		var prices []types.Price
+		for i := 1; i < 24; i++ {
+			prices = append(prices, types.Price{TSStart: now.Add(time.Duration(i) * time.Hour), DollarsPerKWH: dollarsPerKWH})
+		}
+		return prices
+	}
+
+	t.Run("Generator Cheaper", func(t *testing.T) {
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.50), nil, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonOutageUseGenerator, decision.Action.Reason)
+		assert.Equal(t, types.BatteryModeStandby, decision.Action.BatteryMode)
+		require.NotNil(t, decision.Action.FuturePrice)
+		assert.Equal(t, 0.50, decision.Action.FuturePrice.DollarsPerKWH)
+		assert.Contains(t, decision.Action.Description, "Saving Battery")
+	})
+
+	t.Run("Battery Cheaper", func(t *testing.T) {
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.10), nil, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonOutageUseBattery, decision.Action.Reason)
+		assert.Equal(t, types.BatteryModeLoad, decision.Action.TargetBatteryMode)
+		assert.Contains(t, decision.Action.Description, "Using Battery")
+	})
+
+	t.Run("Emergency Mode", func(t *testing.T) {
+		s := status
+		s.EmergencyMode = true
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices(0.50), nil, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonOutageUseGenerator, decision.Action.Reason)
+		assert.Equal(t, types.BatteryModeStandby, decision.Action.TargetBatteryMode)
+		assert.Equal(t, types.BatteryModeNoChange, decision.Action.BatteryMode)
+		assert.Equal(t, types.SolarModeNoChange, decision.Action.SolarMode)
+	})
+
+	t.Run("No Generator", func(t *testing.T) {
+		s := settings
+		s.GeneratorCostDollarsPerKWH = 0
+		decision, err := c.Decide(ctx, status, currentPrice, futurePrices(0.50), nil, s)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.ActionReasonOutageUseGenerator, decision.Action.Reason)
+		assert.NotEqual(t, types.ActionReasonOutageUseBattery, decision.Action.Reason)
+	})
+}
+
+func TestBatteryReplacementCost(t *testing.T) {
+	now := time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC)
+	hour := func(i int, gridCharge float64) SimHour {
+		ts := now.Add(time.Duration(i) * time.Hour)
+		return SimHour{TS: ts, GridChargeDollarsPerKWH: gridCharge, Price: types.Price{TSStart: ts, DollarsPerKWH: gridCharge}}
+	}
+	surplus := hour(2, 0.15)
+	surplus.NetLoadSolarKWH = -2
+	surplus.SolarOppDollarsPerKWH = 0.04
+	sim := []SimHour{
+		// the current hour is skipped
+		hour(0, 0.01),
+		hour(1, 0.12),
+		surplus,
+		hour(3, 0.08),
+		// no price
+		{TS: now.Add(4 * time.Hour)},
+	}
+	cost, _, ts := batteryReplacementCost(sim)
+	assert.Equal(t, 0.04, cost)
+	assert.Equal(t, now.Add(2*time.Hour), ts)
+
+	cost, _, ts = batteryReplacementCost(nil)
+	assert.Zero(t, cost)
+	assert.True(t, ts.IsZero())
+}
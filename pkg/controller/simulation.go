@@ -12,28 +12,33 @@ import (
 
 // SimHour represents one hour of simulated energy state.
 type SimHour struct {
-	TS                      time.Time   `json:"ts"`
-	Hour                    int         `json:"hour"`
-	NetLoadSolarKWH         float64     `json:"netLoadSolarKWH"`
-	ClampedNetLoadSolarKWH  float64     `json:"clampedNetLoadSolarKWH"`
-	GridChargeDollarsPerKWH float64     `json:"gridChargeDollarsPerKWH"`
-	SolarOppDollarsPerKWH   float64     `json:"solarOppDollarsPerKWH"`
-	AvgHomeLoadKWH          float64     `json:"avgHomeLoadKWH"`
-	PredictedSolarKWH       float64     `json:"predictedSolarKWH"`
-	BatteryKWH              float64     `json:"batteryKWH"`
-	BatteryKWHIfStandby     float64     `json:"batteryKWHIfStandby"`
-	BatteryCapacityKWH      float64     `json:"batteryCapacityKWH"`
-	BatteryReserveKWH       float64     `json:"batteryReserveKWH"`
-	TotalBatteryDeficitKWH  float64     `json:"totalBatteryDeficitKWH"`
-	TodaySolarTrend         float64     `json:"todaySolarTrend"`
-	HitCapacity             bool        `json:"hitCapacity"`
-	HitSolarCapacity        bool        `json:"hitSolarCapacity"`
-	HitDeficit              bool        `json:"hitDeficit"`
-	Price                   types.Price `json:"price"`
+	TS                         time.Time   `json:"ts"`
+	Hour                       int         `json:"hour"`
+	NetLoadSolarKWH            float64     `json:"netLoadSolarKWH"`
+	ClampedNetLoadSolarKWH     float64     `json:"clampedNetLoadSolarKWH"`
+	GridChargeDollarsPerKWH    float64     `json:"gridChargeDollarsPerKWH"`
+	SolarOppDollarsPerKWH      float64     `json:"solarOppDollarsPerKWH"`
+	BatteryExportDollarsPerKWH float64     `json:"batteryExportDollarsPerKWH"`
+	AvgHomeLoadKWH             float64     `json:"avgHomeLoadKWH"`
+	FlexibleLoadKWH            float64     `json:"flexibleLoadKWH,omitempty"`
+	PredictedSolarKWH          float64     `json:"predictedSolarKWH"`
+	BatteryKWH                 float64     `json:"batteryKWH"`
+	BatteryKWHIfStandby        float64     `json:"batteryKWHIfStandby"`
+	BatteryCapacityKWH         float64     `json:"batteryCapacityKWH"`
+	BatteryReserveKWH          float64     `json:"batteryReserveKWH"`
+	TotalBatteryDeficitKWH     float64     `json:"totalBatteryDeficitKWH"`
+	TodaySolarTrend            float64     `json:"todaySolarTrend"`
+	HitCapacity                bool        `json:"hitCapacity"`
+	HitSolarCapacity           bool        `json:"hitSolarCapacity"`
+	HitDeficit                 bool        `json:"hitDeficit"`
+	ChargingDisabled           bool        `json:"chargingDisabled,omitempty"`
+	Price                      types.Price `json:"price"`
 }
 
 // SimulateState builds a 24-hour simulation of energy state and prices.
 // It returns the simulated hours and the current available battery energy (kWh).
+// The energy of any flexible loads is added to the home load in the hours
+// they're planned to run.
 func (c *Controller) SimulateState(
 	ctx context.Context,
 	now time.Time,
@@ -43,16 +48,35 @@ func (c *Controller) SimulateState(
 	history []types.EnergyStats,
 	settings types.Settings,
 ) []SimHour {
-	capacityKWH := currentStatus.BatteryCapacityKWH
-	currentSOC := currentStatus.BatterySOC
-	// simulate battery energy over the 24 hours
-	simEnergy := capacityKWH * (currentSOC / 100.0)
+	simData := c.simulate(ctx, now, currentStatus, currentPrice, futurePrices, history, settings, nil)
+	if len(settings.FlexibleLoads) == 0 {
+		return simData
+	}
+	_, loadKWH := planLoads(now, simData, settings.FlexibleLoads)
+	return c.simulate(ctx, now, currentStatus, currentPrice, futurePrices, history, settings, loadKWH)
+}
+
+// simulate runs the simulation with loadKWH added to the home load of each
+// simulated hour.
+func (c *Controller) simulate(
+	ctx context.Context,
+	now time.Time,
+	currentStatus types.SystemStatus,
+	currentPrice types.Price,
+	futurePrices []types.Price,
+	history []types.EnergyStats,
+	settings types.Settings,
+	loadKWH []float64,
+) []SimHour {
+	// simulate battery energy over the 24 hours, the weakest modules limit
+	// how much of the battery can be used
+	capacityKWH, simEnergy := currentStatus.UsableBattery()
 	simStandbyEnergy := simEnergy
 	var deficitKWH float64
 
 	// Build Energy Model
 	model := c.buildHourlyEnergyModel(ctx, now, history, settings)
-	minKWH := capacityKWH * (settings.MinBatterySOC / 100.0)
+	minKWH := currentStatus.BatteryCapacityKWH * (settings.MinBatterySOC / 100.0)
 
 	// simulate our energy state and prices for the next 24 hours
 	simData := make([]SimHour, 0, 24)
@@ -121,22 +145,29 @@ func (c *Controller) SimulateState(
 		}
 
 		gridChargeCost := price.DollarsPerKWH + price.GridUseDollarsPerKWH
-		solarOppCost := price.DollarsPerKWH
-
-		if !settings.GridExportSolar {
-			solarOppCost = 0
-		} else if settings.UtilityRateOptions.NetMeteringCredits {
+		// exportValue is what we'd get for sending a kWh to the grid this hour
+		exportValue := price.DollarsPerKWH
+		if settings.UtilityRateOptions.NetMeteringCredits {
 			switch settings.SolarNetMeteringCreditsValue {
 			case "highest":
-				solarOppCost = maxFutureGridChargeCost
+				exportValue = maxFutureGridChargeCost
 			case "none":
-				solarOppCost = 0
+				exportValue = 0
 			default:
 				// Default to conservative value ("lowest")
-				solarOppCost = minFutureGridChargeCost
+				exportValue = minFutureGridChargeCost
 			}
 		}
 
+		solarOppCost := exportValue
+		if !settings.GridExportSolar {
+			solarOppCost = 0
+		}
+		batteryExportValue := exportValue
+		if !settings.GridExportBatteries {
+			batteryExportValue = 0
+		}
+
 		profile := model[h]
 
 		// Determine solar trend for this hour
@@ -149,7 +180,18 @@ func (c *Controller) SimulateState(
 
 		predictedAvgSolar := profile.avgSolarKWH * currentSolarTrend
 
-		netLoadSolar := profile.avgHomeLoadKWH - predictedAvgSolar
+		var flexibleLoad float64
+		if i < len(loadKWH) {
+			flexibleLoad = loadKWH[i]
+		}
+		netLoadSolar := profile.avgHomeLoadKWH + flexibleLoad - predictedAvgSolar
+
+		// the battery can't charge if it's too cold, right now the ESS knows
+		// best and later on we go by the predicted temperature
+		chargingDisabled := currentStatus.BatteryChargingDisabled
+		if i > 0 {
+			chargingDisabled = tooColdToCharge(currentStatus.BatteryTempForecast, simTime, settings.BatteryMinChargeTempC)
+		}
 
 		clampedNet := netLoadSolar
 		// update simulated energy state
@@ -165,6 +207,10 @@ func (c *Controller) SimulateState(
 				simEnergy = minKWH
 				hitDeficit = true
 			}
+		} else if chargingDisabled {
+			// Solar > Load: the surplus is exported or curtailed since the
+			// battery can't take it
+			clampedNet = 0
 		} else {
 			// make sure we don't simulate charging more than we can
 			if currentStatus.MaxBatteryChargeKW > 0 && clampedNet < -currentStatus.MaxBatteryChargeKW {
@@ -196,24 +242,27 @@ func (c *Controller) SimulateState(
 		}
 
 		simData = append(simData, SimHour{
-			TS:                      simTime,
-			Hour:                    h,
-			NetLoadSolarKWH:         netLoadSolar,
-			ClampedNetLoadSolarKWH:  clampedNet,
-			GridChargeDollarsPerKWH: gridChargeCost,
-			SolarOppDollarsPerKWH:   solarOppCost,
-			AvgHomeLoadKWH:          profile.avgHomeLoadKWH,
-			PredictedSolarKWH:       predictedAvgSolar,
-			BatteryKWH:              simEnergy,
-			BatteryKWHIfStandby:     simStandbyEnergy,
-			BatteryCapacityKWH:      capacityKWH,
-			BatteryReserveKWH:       minKWH,
-			TotalBatteryDeficitKWH:  deficitKWH,
-			TodaySolarTrend:         currentSolarTrend,
-			HitCapacity:             hitCapacity,
-			HitSolarCapacity:        hitSolarCapacity,
-			HitDeficit:              hitDeficit,
-			Price:                   price,
+			TS:                         simTime,
+			Hour:                       h,
+			NetLoadSolarKWH:            netLoadSolar,
+			ClampedNetLoadSolarKWH:     clampedNet,
+			GridChargeDollarsPerKWH:    gridChargeCost,
+			SolarOppDollarsPerKWH:      solarOppCost,
+			BatteryExportDollarsPerKWH: batteryExportValue,
+			AvgHomeLoadKWH:             profile.avgHomeLoadKWH,
+			FlexibleLoadKWH:            flexibleLoad,
+			PredictedSolarKWH:          predictedAvgSolar,
+			BatteryKWH:                 simEnergy,
+			BatteryKWHIfStandby:        simStandbyEnergy,
+			BatteryCapacityKWH:         capacityKWH,
+			BatteryReserveKWH:          minKWH,
+			TotalBatteryDeficitKWH:     deficitKWH,
+			TodaySolarTrend:            currentSolarTrend,
+			HitCapacity:                hitCapacity,
+			HitSolarCapacity:           hitSolarCapacity,
+			HitDeficit:                 hitDeficit,
+			ChargingDisabled:           chargingDisabled,
+			Price:                      price,
 		})
 		simTime = simTime.Add(1 * time.Hour)
 	}
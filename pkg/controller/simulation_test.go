@@ -678,5 +678,83 @@ func TestSimulateState(t *testing.T) {
 				assert.InDelta(t, tt.expectedOppCost, simData[0].SolarOppDollarsPerKWH, 0.001)
 			})
 		}
+
+		t.Run("Battery Export", func(t *testing.T) {
+			settings := types.Settings{
+				GridExportBatteries: true,
+				UtilityRateOptions: types.UtilityRateOptions{
+					NetMeteringCredits: true,
+				},
+				SolarNetMeteringCreditsValue: "highest",
+			}
+
+			// battery export is valued the same way as solar export but only
+			// depends on the battery export setting
+			simData := c.SimulateState(ctx, now, currentStatus, currentPrice, []types.Price{futurePrice}, nil, settings)
+			assert.NotEmpty(t, simData)
+			assert.InDelta(t, 0.50, simData[0].BatteryExportDollarsPerKWH, 0.001)
+			assert.InDelta(t, 0.0, simData[0].SolarOppDollarsPerKWH, 0.001)
+
+			settings.GridExportBatteries = false
+			simData = c.SimulateState(ctx, now, currentStatus, currentPrice, []types.Price{futurePrice}, nil, settings)
+			assert.InDelta(t, 0.0, simData[0].BatteryExportDollarsPerKWH, 0.001)
+		})
+	})
+
+	t.Run("Imbalanced Modules", func(t *testing.T) {
+		now := time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)
+		status := types.SystemStatus{
+			Timestamp:          now,
+			BatteryCapacityKWH: 10.0,
+			BatterySOC:         50.0,
+			EachBatterySOC:     []float64{60.0, 40.0},
+		}
+		settings := types.Settings{MinBatterySOC: 10.0, SolarTrendRatioMax: 3.0}
+		simData := c.SimulateState(ctx, now, status, types.Price{DollarsPerKWH: 0.10, TSStart: now}, nil, nil, settings)
+		assert.NotEmpty(t, simData)
+		// discharging stops when the emptiest module hits the reserve and
+		// charging stops when the fullest module is full
+		assert.InDelta(t, 4.0, simData[0].BatteryKWH, 0.01)
+		assert.InDelta(t, 8.0, simData[0].BatteryCapacityKWH, 0.01)
+		assert.InDelta(t, 1.0, simData[0].BatteryReserveKWH, 0.01)
+	})
+
+	t.Run("Too Cold To Charge", func(t *testing.T) {
+		now := time.Date(2025, 6, 15, 10, 0, 0, 0, time.UTC)
+		// 2kWh of solar surplus every hour
+		var history []types.EnergyStats
+		for i := 1; i <= 48; i++ {
+			history = append(history, types.EnergyStats{
+				TSHourStart: now.Add(-time.Duration(i) * time.Hour),
+				SolarKWH:    3.0,
+				HomeKWH:     1.0,
+			})
+		}
+		// the battery is too cold to charge for the next 2 hours
+		var forecast []types.TempForecast
+		for i := 0; i < 24; i++ {
+			temp := 10.0
+			if i == 1 || i == 2 {
+				temp = -5.0
+			}
+			forecast = append(forecast, types.TempForecast{TSStart: now.Add(time.Duration(i) * time.Hour), TempC: temp})
+		}
+		status := types.SystemStatus{
+			Timestamp:           now,
+			BatteryCapacityKWH:  10.0,
+			BatterySOC:          20.0,
+			BatteryTempForecast: forecast,
+		}
+		settings := types.Settings{MinBatterySOC: 10.0, SolarTrendRatioMax: 3.0}
+		simData := c.SimulateState(ctx, now, status, types.Price{DollarsPerKWH: 0.10, TSStart: now}, nil, history, settings)
+		assert.GreaterOrEqual(t, len(simData), 4)
+		assert.False(t, simData[0].ChargingDisabled)
+		assert.InDelta(t, 4.0, simData[0].BatteryKWH, 0.01)
+		assert.True(t, simData[1].ChargingDisabled)
+		assert.InDelta(t, 4.0, simData[1].BatteryKWH, 0.01)
+		assert.True(t, simData[2].ChargingDisabled)
+		assert.InDelta(t, 4.0, simData[2].BatteryKWH, 0.01)
+		assert.False(t, simData[3].ChargingDisabled)
+		assert.InDelta(t, 6.0, simData[3].BatteryKWH, 0.01)
 	})
 }
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+const (
+	// batteryTempForecastHours is how far ahead the battery temperature is
+	// predicted
+	batteryTempForecastHours = 24
+	// maxLearnedChargeTempRiseC is how far above the configured minimum the
+	// charging temperature limit can be raised by what was observed so a stuck
+	// alarm doesn't make every hour look too cold
+	maxLearnedChargeTempRiseC = 10.0
+)
+
+// PredictBatteryTemps predicts the battery temperature for each hour of the
+// next day starting with the current hour. The history gives how the battery
+// temperature usually moves through the day, shifted by how much colder or
+// warmer than usual the battery is now. With a weather forecast the battery is
+// also assumed to stay as far from the outdoor temperature as it is now and the
+// colder of the two predictions is used since charging early costs less than
+// not being able to charge at all.
+func PredictBatteryTemps(
+	ctx context.Context,
+	now time.Time,
+	currentTempC float64,
+	history []types.Action,
+	weather []types.TempForecast,
+) []types.TempForecast {
+	var sums, counts [24]float64
+	for _, a := range history {
+		if a.SystemStatus.BatteryTempC == nil {
+			continue
+		}
+		ts := a.SystemStatus.Timestamp
+		if ts.IsZero() {
+			ts = a.Timestamp
+		}
+		h := ts.In(now.Location()).Hour()
+		sums[h] += *a.SystemStatus.BatteryTempC
+		counts[h]++
+	}
+	usualTemp := func(h int) (float64, bool) {
+		if counts[h] == 0 {
+			return 0, false
+		}
+		return sums[h] / counts[h], true
+	}
+	outdoorTemp := func(ts time.Time) (float64, bool) {
+		for _, w := range weather {
+			if w.TSStart.Truncate(time.Hour).Equal(ts.Truncate(time.Hour)) {
+				return w.TempC, true
+			}
+		}
+		return 0, false
+	}
+
+	// how much colder or warmer than usual the battery is right now
+	var offset float64
+	if usual, ok := usualTemp(now.Hour()); ok {
+		offset = currentTempC - usual
+	}
+	// how much warmer than outside the battery is right now
+	var gap float64
+	outdoorNow, hasWeather := outdoorTemp(now)
+	if hasWeather {
+		gap = currentTempC - outdoorNow
+	}
+
+	start := now.Truncate(time.Hour)
+	forecast := make([]types.TempForecast, 0, batteryTempForecastHours)
+	minTempC := currentTempC
+	for i := 0; i < batteryTempForecastHours; i++ {
+		ts := start.Add(time.Duration(i) * time.Hour)
+		temp := currentTempC
+		if usual, ok := usualTemp(ts.In(now.Location()).Hour()); ok {
+			temp = usual + offset
+		}
+		if hasWeather {
+			if outdoor, ok := outdoorTemp(ts); ok {
+				temp = math.Min(temp, outdoor+gap)
+			}
+		}
+		minTempC = math.Min(minTempC, temp)
+		forecast = append(forecast, types.TempForecast{
+			TSStart: ts,
+			TempC:   temp,
+		})
+	}
+
+	log.Ctx(ctx).DebugContext(
+		ctx,
+		"predicted battery temperature",
+		slog.Float64("currentTempC", currentTempC),
+		slog.Float64("offsetC", offset),
+		slog.Bool("hasWeather", hasWeather),
+		slog.Float64("outdoorGapC", gap),
+		slog.Float64("minTempC", minTempC),
+	)
+	return forecast
+}
+
+// MinChargeTemp returns the temperature (in °C) at or below which the battery
+// can't be charged. It's the configured minimum unless the battery recently
+// stopped charging while it was warmer than that.
+func MinChargeTemp(minTempC float64, history []types.Action) float64 {
+	limit := minTempC
+	for _, a := range history {
+		t := a.SystemStatus.BatteryTempC
+		if !a.SystemStatus.BatteryChargingDisabled || t == nil {
+			continue
+		}
+		if *t > limit && *t <= minTempC+maxLearnedChargeTempRiseC {
+			limit = *t
+		}
+	}
+	return limit
+}
+
+// tooColdToCharge returns true if the battery is predicted to be too cold to
+// charge during the hour starting at ts.
+func tooColdToCharge(forecast []types.TempForecast, ts time.Time, minTempC float64) bool {
+	for _, f := range forecast {
+		if f.TSStart.Truncate(time.Hour).Equal(ts.Truncate(time.Hour)) {
+			return f.TempC <= minTempC
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempAction(ts time.Time, tempC float64, chargingDisabled bool) types.Action {
+	return types.Action{
+		Timestamp: ts,
+		SystemStatus: types.SystemStatus{
+			Timestamp:               ts,
+			BatteryTempC:            &tempC,
+			BatteryChargingDisabled: chargingDisabled,
+		},
+	}
+}
+
+func TestPredictBatteryTemps(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 10, 15, 20, 0, 0, time.UTC)
+
+	// the battery is usually 10C in the afternoon and 2C overnight
+	var history []types.Action
+	for d := 1; d <= 2; d++ {
+		day := now.Truncate(24*time.Hour).AddDate(0, 0, -d)
+		for h := 0; h < 24; h++ {
+			temp := 10.0
+			if h < 8 {
+				temp = 2.0
+			}
+			history = append(history, tempAction(day.Add(time.Duration(h)*time.Hour), temp, false))
+		}
+	}
+
+	t.Run("History", func(t *testing.T) {
+		// it's 3C colder than usual today
+		forecast := PredictBatteryTemps(ctx, now, 7.0, history, nil)
+		require.Len(t, forecast, 24)
+		assert.Equal(t, now.Truncate(time.Hour), forecast[0].TSStart)
+		assert.InDelta(t, 7.0, forecast[0].TempC, 0.001)
+		// 3am tomorrow
+		assert.Equal(t, 3, forecast[12].TSStart.Hour())
+		assert.InDelta(t, -1.0, forecast[12].TempC, 0.001)
+	})
+
+	t.Run("No History", func(t *testing.T) {
+		forecast := PredictBatteryTemps(ctx, now, 7.0, nil, nil)
+		require.Len(t, forecast, 24)
+		for _, f := range forecast {
+			assert.InDelta(t, 7.0, f.TempC, 0.001)
+		}
+	})
+
+	t.Run("Weather Colder", func(t *testing.T) {
+		// the battery is 5C warmer than outside and a cold front comes in
+		var weather []types.TempForecast
+		for i := 0; i < 24; i++ {
+			temp := 5.0
+			if i >= 6 {
+				temp = -15.0
+			}
+			weather = append(weather, types.TempForecast{TSStart: now.Truncate(time.Hour).Add(time.Duration(i) * time.Hour), TempC: temp})
+		}
+		forecast := PredictBatteryTemps(ctx, now, 10.0, history, weather)
+		require.Len(t, forecast, 24)
+		assert.InDelta(t, 10.0, forecast[0].TempC, 0.001)
+		assert.InDelta(t, 10.0, forecast[5].TempC, 0.001)
+		assert.InDelta(t, -10.0, forecast[6].TempC, 0.001)
+	})
+
+	t.Run("Weather Warmer", func(t *testing.T) {
+		// a warm night doesn't override the battery usually getting cold
+		var weather []types.TempForecast
+		for i := 0; i < 24; i++ {
+			weather = append(weather, types.TempForecast{TSStart: now.Truncate(time.Hour).Add(time.Duration(i) * time.Hour), TempC: 5.0})
+		}
+		forecast := PredictBatteryTemps(ctx, now, 10.0, history, weather)
+		require.Len(t, forecast, 24)
+		assert.InDelta(t, 2.0, forecast[12].TempC, 0.001)
+	})
+}
+
+func TestMinChargeTemp(t *testing.T) {
+	now := time.Date(2026, 1, 10, 15, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 0.0, MinChargeTemp(0, nil))
+	assert.Equal(t, 0.0, MinChargeTemp(0, []types.Action{
+		tempAction(now, 3.0, false),
+		{Timestamp: now, SystemStatus: types.SystemStatus{BatteryChargingDisabled: true}},
+	}))
+	assert.Equal(t, 2.5, MinChargeTemp(0, []types.Action{
+		tempAction(now, 1.0, true),
+		tempAction(now.Add(time.Hour), 2.5, true),
+		tempAction(now.Add(2*time.Hour), 4.0, false),
+	}))
+	// a stuck alarm isn't trusted
+	assert.Equal(t, 0.0, MinChargeTemp(0, []types.Action{
+		tempAction(now, 25.0, true),
+	}))
+}
+
+func TestDecideCold(t *testing.T) {
+	c := NewController()
+	ctx := context.Background()
+	now := time.Date(2026, 1, 10, 22, 0, 0, 0, time.UTC)
+
+	settings := types.Settings{
+		MinBatterySOC:            20.0,
+		GridChargeBatteries:      true,
+		SolarTrendRatioMax:       3.0,
+		SolarBellCurveMultiplier: 1.0,
+	}
+	// 1kWh above the reserve with a 1kW load
+	status := types.SystemStatus{
+		Timestamp:          now,
+		BatterySOC:         30.0,
+		BatteryCapacityKWH: 10.0,
+		MaxBatteryChargeKW: 5.0,
+		HomeKW:             1.0,
+		CanImportBattery:   true,
+	}
+	var history []types.EnergyStats
+	for i := 1; i <= 48; i++ {
+		history = append(history, types.EnergyStats{
+			TSHourStart:   now.Add(-time.Duration(i) * time.Hour),
+			GridImportKWH: 1.0,
+			HomeKWH:       1.0,
+		})
+	}
+	// it's cheaper to charge overnight
+	currentPrice := types.Price{TSStart: now, DollarsPerKWH: 0.10}
+	var futurePrices []types.Price
+	for i := 1; i < 24; i++ {
+		futurePrices = append(futurePrices, types.Price{TSStart: now.Add(time.Duration(i) * time.Hour), DollarsPerKWH: 0.05})
+	}
+	// or it's more expensive to import overnight
+	var peakPrices []types.Price
+	for i := 1; i < 24; i++ {
+		peakPrices = append(peakPrices, types.Price{TSStart: now.Add(time.Duration(i) * time.Hour), DollarsPerKWH: 0.20})
+	}
+	// the battery is too cold to charge for the next coldHours hours
+	tempForecast := func(coldHours int) []types.TempForecast {
+		var forecast []types.TempForecast
+		for i := 0; i < 24; i++ {
+			temp := 5.0
+			if i > 0 && i <= coldHours {
+				temp = -2.0
+			}
+			forecast = append(forecast, types.TempForecast{TSStart: now.Add(time.Duration(i) * time.Hour), TempC: temp})
+		}
+		return forecast
+	}
+
+	t.Run("Warm", func(t *testing.T) {
+		s := status
+		s.BatteryTempForecast = tempForecast(0)
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeChargeAny, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Cold Overnight", func(t *testing.T) {
+		s := status
+		s.BatteryTempForecast = tempForecast(10)
+		decision, err := c.Decide(ctx, s, currentPrice, peakPrices, history, settings)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonColdChargeNow, decision.Action.Reason)
+		assert.Equal(t, types.BatteryModeChargeAny, decision.Action.TargetBatteryMode)
+		assert.Contains(t, decision.Action.Description, "Too Cold to Charge")
+	})
+
+	t.Run("Cheaper To Import Then", func(t *testing.T) {
+		s := status
+		s.BatteryTempForecast = tempForecast(10)
+		decision, err := c.Decide(ctx, s, currentPrice, futurePrices, history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.ActionReasonColdChargeNow, decision.Action.Reason)
+		assert.NotEqual(t, types.BatteryModeChargeAny, decision.Action.TargetBatteryMode)
+	})
+
+	t.Run("Not Enough Cheaper", func(t *testing.T) {
+		sets := settings
+		sets.MinDeficitPriceDifferenceDollarsPerKWH = 0.15
+		s := status
+		s.BatteryTempForecast = tempForecast(10)
+		decision, err := c.Decide(ctx, s, currentPrice, peakPrices, history, sets)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.ActionReasonColdChargeNow, decision.Action.Reason)
+	})
+
+	t.Run("Higher Limit", func(t *testing.T) {
+		sets := settings
+		sets.BatteryMinChargeTempC = 6.0
+		s := status
+		s.BatteryTempForecast = tempForecast(0)
+		decision, err := c.Decide(ctx, s, currentPrice, peakPrices, history, sets)
+		require.NoError(t, err)
+		assert.Equal(t, types.ActionReasonColdChargeNow, decision.Action.Reason)
+	})
+
+	t.Run("Charging Disabled Now", func(t *testing.T) {
+		s := status
+		s.BatteryTempForecast = tempForecast(10)
+		s.BatteryChargingDisabled = true
+		decision, err := c.Decide(ctx, s, currentPrice, peakPrices, history, settings)
+		require.NoError(t, err)
+		assert.NotEqual(t, types.BatteryModeChargeAny, decision.Action.TargetBatteryMode)
+	})
+}
@@ -0,0 +1,153 @@
+package ess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/common"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// Actuator switches a flexible load on and off.
+type Actuator interface {
+	SetLoad(ctx context.Context, on bool) error
+}
+
+// loadCommand is the payload sent by webhook and MQTT actuators.
+type loadCommand struct {
+	ID        string    `json:"id"`
+	On        bool      `json:"on"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewActuator returns the actuator for a flexible load. MQTT and Home
+// Assistant actuators use the site's credentials for those systems.
+func NewActuator(siteID string, load types.FlexibleLoad, creds types.Credentials) (Actuator, error) {
+	switch load.Actuator.Type {
+	case types.LoadActuatorWebhook:
+		if load.Actuator.URL == "" {
+			return nil, errors.New("missing webhook url")
+		}
+		if err := common.ValidatePublicURL(load.Actuator.URL); err != nil {
+			return nil, fmt.Errorf("invalid webhook url: %w", err)
+		}
+		return &webhookActuator{
+			client: common.PublicHTTPClient(30 * time.Second),
+			id:     load.ID,
+			url:    load.Actuator.URL,
+		}, nil
+	case types.LoadActuatorMQTT:
+		if creds.MQTT == nil || creds.MQTT.Broker == "" {
+			return nil, errors.New("mqtt actuator requires mqtt credentials")
+		}
+		if load.Actuator.Topic == "" {
+			return nil, errors.New("missing mqtt topic")
+		}
+		return &mqttActuator{
+			creds: *creds.MQTT,
+			// the ESS bridge may already be connected as raterudder-<siteID>
+			clientID: "raterudder-" + siteID + "-" + load.ID,
+			id:       load.ID,
+			topic:    load.Actuator.Topic,
+		}, nil
+	case types.LoadActuatorHomeAssistant:
+		if creds.HomeAssistant == nil || creds.HomeAssistant.Token == "" {
+			return nil, errors.New("home assistant actuator requires home assistant credentials")
+		}
+		if load.Actuator.EntityID == "" {
+			return nil, errors.New("missing home assistant entity")
+		}
+		baseURL, err := parseHABaseURL(creds.HomeAssistant.URL)
+		if err != nil {
+			return nil, err
+		}
+		return &haActuator{
+			ha: &HomeAssistant{
+				client: common.LocalHTTPClient(30 * time.Second),
+				cfg:    haConfig{baseURL: baseURL, token: creds.HomeAssistant.Token},
+			},
+			entityID: load.Actuator.EntityID,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown actuator type: %q", load.Actuator.Type)
+}
+
+// webhookActuator POSTs a loadCommand to a public https URL. Failed commands
+// aren't retried since the next update sends the load's state again.
+type webhookActuator struct {
+	client *http.Client
+	id     string
+	url    string
+}
+
+func (a *webhookActuator) SetLoad(ctx context.Context, on bool) error {
+	body, err := json.Marshal(loadCommand{ID: a.id, On: on, Timestamp: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := common.CheckResponse(resp); err != nil {
+		return fmt.Errorf("webhook error: %w", err)
+	}
+	return nil
+}
+
+// mqttActuator publishes a retained loadCommand to a topic. Loads are only
+// switched during updates so it connects for each command.
+type mqttActuator struct {
+	creds    types.MQTTCredentials
+	clientID string
+	id       string
+	topic    string
+}
+
+func (a *mqttActuator) SetLoad(ctx context.Context, on bool) error {
+	payload, err := json.Marshal(loadCommand{ID: a.id, On: on, Timestamp: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	client, err := dialMQTT(ctx, a.creds.Broker, a.creds.Username, a.creds.Password, a.clientID, func(string, []byte) {})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.publish(ctx, a.topic, payload, true); err != nil {
+		return fmt.Errorf("failed to publish load command: %w", err)
+	}
+	return nil
+}
+
+// haActuator turns a Home Assistant entity on and off.
+type haActuator struct {
+	ha       *HomeAssistant
+	entityID string
+}
+
+func (a *haActuator) SetLoad(ctx context.Context, on bool) error {
+	service := "turn_off"
+	if on {
+		service = "turn_on"
+	}
+	log.Ctx(ctx).InfoContext(ctx, "calling home assistant service", slog.String("service", "homeassistant."+service), slog.String("entityID", a.entityID))
+	data := map[string]interface{}{"entity_id": a.entityID}
+	if err := a.ha.doRequest(ctx, "POST", "/api/services/homeassistant/"+service, data, nil); err != nil {
+		return fmt.Errorf("failed to call homeassistant.%s: %w", service, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActuators(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Webhook", func(t *testing.T) {
+		var got []loadCommand
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			var cmd loadCommand
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&cmd))
+			got = append(got, cmd)
+		}))
+		defer srv.Close()
+
+		a := &webhookActuator{client: srv.Client(), id: "water", url: srv.URL}
+		require.NoError(t, a.SetLoad(ctx, true))
+		require.NoError(t, a.SetLoad(ctx, false))
+		require.Len(t, got, 2)
+		assert.Equal(t, "water", got[0].ID)
+		assert.True(t, got[0].On)
+		assert.False(t, got[1].On)
+	})
+
+	t.Run("Webhook Error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", http.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		a := &webhookActuator{client: srv.Client(), id: "water", url: srv.URL}
+		assert.ErrorContains(t, a.SetLoad(ctx, true), "webhook error")
+	})
+
+	t.Run("Webhook URL", func(t *testing.T) {
+		webhook := func(url string) types.FlexibleLoad {
+			return types.FlexibleLoad{ID: "water", Actuator: types.LoadActuator{Type: types.LoadActuatorWebhook, URL: url}}
+		}
+		_, err := NewActuator("site1", webhook("https://example.com/water"), types.Credentials{})
+		require.NoError(t, err)
+		for _, url := range []string{
+			"http://example.com/water",
+			"https://127.0.0.1/water",
+			"https://192.168.1.20/water",
+			"https://169.254.169.254/latest/meta-data",
+			"https://[::1]/water",
+			"https://heater.local/water",
+		} {
+			_, err := NewActuator("site1", webhook(url), types.Credentials{})
+			assert.Error(t, err, url)
+		}
+
+	})
+
+	t.Run("MQTT", func(t *testing.T) {
+		broker := newFakeMQTTBroker(t)
+		a, err := NewActuator("site1", types.FlexibleLoad{
+			ID:       "pool",
+			Actuator: types.LoadActuator{Type: types.LoadActuatorMQTT, Topic: "home/pool/set"},
+		}, types.Credentials{MQTT: &types.MQTTCredentials{Broker: broker.URL()}})
+		require.NoError(t, err)
+		require.NoError(t, a.SetLoad(ctx, true))
+
+		var cmd loadCommand
+		require.NoError(t, json.Unmarshal(broker.retainedMessage("home/pool/set"), &cmd))
+		assert.Equal(t, "pool", cmd.ID)
+		assert.True(t, cmd.On)
+	})
+
+	t.Run("Home Assistant", func(t *testing.T) {
+		f, srv := newFakeHomeAssistant(t)
+		a, err := NewActuator("site1", types.FlexibleLoad{
+			ID:       "water",
+			Actuator: types.LoadActuator{Type: types.LoadActuatorHomeAssistant, EntityID: "switch.water_heater"},
+		}, types.Credentials{HomeAssistant: &types.HomeAssistantCredentials{URL: srv.URL + "/", Token: "ha-token"}})
+		require.NoError(t, err)
+		require.NoError(t, a.SetLoad(ctx, true))
+		require.NoError(t, a.SetLoad(ctx, false))
+		assert.Equal(t, []string{
+			`homeassistant/turn_on {"entity_id":"switch.water_heater"}`,
+			`homeassistant/turn_off {"entity_id":"switch.water_heater"}`,
+		}, f.serviceCalls())
+	})
+
+	t.Run("Missing Credentials", func(t *testing.T) {
+		_, err := NewActuator("site1", types.FlexibleLoad{
+			ID:       "pool",
+			Actuator: types.LoadActuator{Type: types.LoadActuatorMQTT, Topic: "home/pool/set"},
+		}, types.Credentials{})
+		assert.ErrorContains(t, err, "requires mqtt credentials")
+
+		_, err = NewActuator("site1", types.FlexibleLoad{
+			ID:       "water",
+			Actuator: types.LoadActuator{Type: types.LoadActuatorHomeAssistant, EntityID: "switch.water_heater"},
+		}, types.Credentials{})
+		assert.ErrorContains(t, err, "requires home assistant credentials")
+
+		_, err = NewActuator("site1", types.FlexibleLoad{ID: "x"}, types.Credentials{})
+		assert.ErrorContains(t, err, "unknown actuator type")
+	})
+}
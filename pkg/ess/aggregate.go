@@ -0,0 +1,398 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// Aggregate controls several systems as if they were one, like a large home
+// with two Franklin gateways or a mix of batteries. The status and energy
+// history of the units are combined and modes are sent to every unit.
+type Aggregate struct {
+	mu       sync.Mutex
+	siteID   string
+	settings types.Settings
+	units    []aggregateUnit
+	results  []types.UnitResult
+}
+
+type aggregateUnit struct {
+	name   string
+	ess    string
+	system System
+	// capacityKWH is from the last status and weights the unit's SOC
+	capacityKWH float64
+}
+
+func newAggregate(siteID string) *Aggregate {
+	return &Aggregate{
+		siteID: siteID,
+	}
+}
+
+func aggregateInfo() types.ESSProviderInfo {
+	return types.ESSProviderInfo{
+		ID:   "aggregate",
+		Name: "Multiple Systems",
+		// limited to what every unit supports once they're known
+		Capabilities: types.AllESSCapabilities(),
+		Credentials: []types.ESSCredential{
+			{
+				Field:       "units",
+				Name:        "Units",
+				Type:        "password",
+				Required:    true,
+				Description: `JSON list of {"name", "ess", "credentials"} for each system, e.g. [{"name": "Garage", "ess": "franklin", "credentials": {"franklin": {...}}}].`,
+			},
+		},
+	}
+}
+
+// ApplySettings applies the settings to every unit.
+func (a *Aggregate) ApplySettings(ctx context.Context, settings types.Settings) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.settings = settings
+	for _, u := range a.units {
+		if err := u.system.ApplySettings(ctx, a.unitSettings(u.ess)); err != nil {
+			return fmt.Errorf("%s: %w", u.name, err)
+		}
+	}
+	return nil
+}
+
+func (a *Aggregate) unitSettings(ess string) types.Settings {
+	settings := a.settings
+	settings.ESS = ess
+	return settings
+}
+
+// Authenticate creates the units from the credentials and authenticates each
+// of them. Units that haven't changed systems are reused so they keep their
+// sessions.
+func (a *Aggregate) Authenticate(ctx context.Context, creds types.Credentials) (types.Credentials, bool, error) {
+	if creds.Aggregate == nil {
+		return creds, false, errors.New("missing aggregate credentials")
+	}
+	var defs []types.AggregateUnit
+	if err := json.Unmarshal([]byte(creds.Aggregate.Units), &defs); err != nil {
+		return creds, false, fmt.Errorf("invalid units: %w", err)
+	}
+	if len(defs) == 0 {
+		return creds, false, errors.New("no units configured")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var updated bool
+	units := make([]aggregateUnit, len(defs))
+	for i, def := range defs {
+		if !aggregateUnitSupported(def.ESS) {
+			return creds, false, fmt.Errorf("unit %d has unsupported ess: %q", i+1, def.ESS)
+		}
+		if def.Name == "" {
+			def.Name = fmt.Sprintf("Unit %d", i+1)
+			defs[i].Name = def.Name
+			updated = true
+		}
+
+		var u aggregateUnit
+		if i < len(a.units) && a.units[i].ess == def.ESS {
+			u = a.units[i]
+		} else {
+			// units get their own site ID so their state doesn't collide
+			u.system = newSystem(def.ESS, fmt.Sprintf("%s-%d", a.siteID, i))
+			if err := u.system.ApplySettings(ctx, a.unitSettings(def.ESS)); err != nil {
+				return creds, false, fmt.Errorf("%s: %w", def.Name, err)
+			}
+		}
+		u.name = def.Name
+		u.ess = def.ESS
+
+		unitCreds, unitUpdated, err := u.system.Authenticate(ctx, def.Credentials)
+		if err != nil {
+			return creds, false, fmt.Errorf("%s: %w", def.Name, err)
+		}
+		if unitUpdated {
+			defs[i].Credentials = unitCreds
+			updated = true
+		}
+		units[i] = u
+	}
+	a.units = units
+
+	if updated {
+		b, err := json.Marshal(defs)
+		if err != nil {
+			return creds, false, err
+		}
+		creds.Aggregate = &types.AggregateCredentials{Units: string(b)}
+	}
+	return creds, updated, nil
+}
+
+func aggregateUnitSupported(id string) bool {
+	if id == "aggregate" {
+		return false
+	}
+	for _, info := range NewMap().ListSystems() {
+		if info.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities returns what every unit supports.
+func (a *Aggregate) Capabilities() types.ESSCapabilities {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.capabilities()
+}
+
+func (a *Aggregate) capabilities() types.ESSCapabilities {
+	if len(a.units) == 0 {
+		return types.AllESSCapabilities()
+	}
+	caps := a.units[0].system.Capabilities()
+	caps.BatteryModes = slices.Clone(caps.BatteryModes)
+	caps.SolarModes = slices.Clone(caps.SolarModes)
+	for _, u := range a.units[1:] {
+		c := u.system.Capabilities()
+		caps.BatteryModes = slices.DeleteFunc(caps.BatteryModes, func(m types.BatteryMode) bool {
+			return !c.SupportsBatteryMode(m)
+		})
+		caps.SolarModes = slices.DeleteFunc(caps.SolarModes, func(m types.SolarMode) bool {
+			return !c.SupportsSolarMode(m)
+		})
+		caps.GridCharging = caps.GridCharging && c.GridCharging
+		caps.BatteryExport = caps.BatteryExport && c.BatteryExport
+		caps.MinCommandIntervalSeconds = max(caps.MinCommandIntervalSeconds, c.MinCommandIntervalSeconds)
+	}
+	// schedules aren't pushed to the units
+	caps.Schedule = false
+	return caps
+}
+
+// GetStatus combines the status of every unit. Power and capacity are summed
+// and the SOC is weighted by each unit's capacity.
+func (a *Aggregate) GetStatus(ctx context.Context) (types.SystemStatus, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.units) == 0 {
+		return types.SystemStatus{}, errors.New("no units configured")
+	}
+
+	statuses := make([]types.SystemStatus, len(a.units))
+	for i := range a.units {
+		status, err := a.units[i].system.GetStatus(ctx)
+		if err != nil {
+			return types.SystemStatus{}, fmt.Errorf("%s: %w", a.units[i].name, err)
+		}
+		a.units[i].capacityKWH = status.BatteryCapacityKWH
+		statuses[i] = status
+	}
+
+	status := combineStatuses(a.units, statuses)
+	caps := a.capabilities()
+	status.Capabilities = &caps
+	return status, nil
+}
+
+func combineStatuses(units []aggregateUnit, statuses []types.SystemStatus) types.SystemStatus {
+	combined := types.SystemStatus{
+		CanExportSolar:          true,
+		CanExportBattery:        true,
+		CanImportBattery:        true,
+		BatteryChargingDisabled: true,
+	}
+	var gridImportUnlimited, gridExportUnlimited bool
+	var socSum float64
+	for i, s := range statuses {
+		if s.Timestamp.After(combined.Timestamp) {
+			combined.Timestamp = s.Timestamp
+		}
+		socSum += s.BatterySOC * s.BatteryCapacityKWH
+		// modules in separate units can be different sizes and don't charge
+		// and discharge together so their spread isn't an imbalance
+		if len(statuses) == 1 {
+			combined.EachBatterySOC = s.EachBatterySOC
+			combined.EachBatteryKW = s.EachBatteryKW
+		}
+		combined.BatteryKW += s.BatteryKW
+		combined.BatteryCapacityKWH += s.BatteryCapacityKWH
+		combined.MaxBatteryChargeKW += s.MaxBatteryChargeKW
+		combined.MaxBatteryDischargeKW += s.MaxBatteryDischargeKW
+		combined.MaxGridImportKW += s.MaxGridImportKW
+		combined.MaxGridExportKW += s.MaxGridExportKW
+		gridImportUnlimited = gridImportUnlimited || s.MaxGridImportKW == 0
+		gridExportUnlimited = gridExportUnlimited || s.MaxGridExportKW == 0
+		combined.SolarKW += s.SolarKW
+		combined.GridKW += s.GridKW
+		combined.HomeKW += s.HomeKW
+		combined.GeneratorKW += s.GeneratorKW
+
+		// the modes only apply if every unit is in them
+		combined.CanExportSolar = combined.CanExportSolar && s.CanExportSolar
+		combined.CanExportBattery = combined.CanExportBattery && s.CanExportBattery
+		combined.CanImportBattery = combined.CanImportBattery && s.CanImportBattery
+		combined.BatteryChargingDisabled = combined.BatteryChargingDisabled && s.BatteryChargingDisabled
+		combined.ExportLimitLifted = combined.ExportLimitLifted || s.ExportLimitLifted
+		// the coldest unit is the first to stop charging
+		if s.BatteryTempC != nil && (combined.BatteryTempC == nil || *s.BatteryTempC < *combined.BatteryTempC) {
+			combined.BatteryTempC = s.BatteryTempC
+		}
+		// but a single unit is enough to hold energy or stop us
+		combined.ElevatedMinBatterySOC = combined.ElevatedMinBatterySOC || s.ElevatedMinBatterySOC
+		combined.BatteryAboveMinSOC = combined.BatteryAboveMinSOC || s.BatteryAboveMinSOC
+		combined.EmergencyMode = combined.EmergencyMode || s.EmergencyMode
+		combined.GridOutage = combined.GridOutage || s.GridOutage
+
+		for _, alarm := range s.Alarms {
+			alarm.Name = units[i].name + ": " + alarm.Name
+			combined.Alarms = append(combined.Alarms, alarm)
+		}
+		for _, storm := range s.Storms {
+			// gateways in the same home report the same storms
+			if !slices.Contains(combined.Storms, storm) {
+				combined.Storms = append(combined.Storms, storm)
+			}
+		}
+	}
+	if gridImportUnlimited {
+		combined.MaxGridImportKW = 0
+	}
+	if gridExportUnlimited {
+		combined.MaxGridExportKW = 0
+	}
+	if combined.BatteryCapacityKWH > 0 {
+		combined.BatterySOC = socSum / combined.BatteryCapacityKWH
+	} else {
+		for _, s := range statuses {
+			combined.BatterySOC += s.BatterySOC / float64(len(statuses))
+		}
+	}
+	return combined
+}
+
+// SetModes sends the modes to every unit even if some of them fail. The result
+// of each unit is available from UnitResults.
+func (a *Aggregate) SetModes(ctx context.Context, bat types.BatteryMode, sol types.SolarMode) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.units) == 0 {
+		return errors.New("no units configured")
+	}
+
+	var errs []error
+	a.results = make([]types.UnitResult, len(a.units))
+	for i, u := range a.units {
+		a.results[i] = types.UnitResult{
+			Name:        u.name,
+			BatteryMode: bat,
+			SolarMode:   sol,
+		}
+		if err := u.system.SetModes(ctx, bat, sol); err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to set unit modes", slog.String("unit", u.name), slog.Any("error", err))
+			a.results[i].Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", u.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UnitResults returns the result of each unit from the last SetModes.
+func (a *Aggregate) UnitResults() []types.UnitResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.results)
+}
+
+// GetEnergyHistory sums the energy history of every unit by hour. The SOC
+// ranges are weighted by each unit's capacity.
+func (a *Aggregate) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.units) == 0 {
+		return nil, errors.New("no units configured")
+	}
+
+	type hour struct {
+		stats  types.EnergyStats
+		weight float64
+	}
+	hours := make(map[int64]*hour)
+	for _, u := range a.units {
+		history, err := u.system.GetEnergyHistory(ctx, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", u.name, err)
+		}
+		// the history is fetched before the first status so fall back to
+		// weighting every unit the same
+		weight := u.capacityKWH
+		if weight <= 0 {
+			weight = 1
+		}
+		for _, s := range history {
+			key := s.TSHourStart.Unix()
+			h, ok := hours[key]
+			if !ok {
+				h = &hour{stats: types.EnergyStats{TSHourStart: s.TSHourStart}}
+				hours[key] = h
+			}
+			addEnergyStats(&h.stats, s, weight)
+			h.weight += weight
+		}
+	}
+
+	history := make([]types.EnergyStats, 0, len(hours))
+	for _, h := range hours {
+		h.stats.MinBatterySOC /= h.weight
+		h.stats.MaxBatterySOC /= h.weight
+		history = append(history, h.stats)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].TSHourStart.Before(history[j].TSHourStart)
+	})
+	return history, nil
+}
+
+// addEnergyStats adds the energy in src to dst and adds the SOCs multiplied by
+// weight so they can be averaged afterwards.
+func addEnergyStats(dst *types.EnergyStats, src types.EnergyStats, weight float64) {
+	dst.MinBatterySOC += src.MinBatterySOC * weight
+	dst.MaxBatterySOC += src.MaxBatterySOC * weight
+	dst.BatteryChargedKWH += src.BatteryChargedKWH
+	dst.BatteryUsedKWH += src.BatteryUsedKWH
+	dst.SolarKWH += src.SolarKWH
+	dst.HomeKWH += src.HomeKWH
+	dst.GridExportKWH += src.GridExportKWH
+	dst.GridImportKWH += src.GridImportKWH
+	dst.BatteryToHomeKWH += src.BatteryToHomeKWH
+	dst.SolarToHomeKWH += src.SolarToHomeKWH
+	dst.SolarToBatteryKWH += src.SolarToBatteryKWH
+	dst.SolarToGridKWH += src.SolarToGridKWH
+	dst.BatteryToGridKWH += src.BatteryToGridKWH
+	dst.GeneratorKWH += src.GeneratorKWH
+	dst.GeneratorToHomeKWH += src.GeneratorToHomeKWH
+	dst.GeneratorToBatteryKWH += src.GeneratorToBatteryKWH
+	dst.GeneratorHours += src.GeneratorHours
+	dst.MeterGridImportKWH += src.MeterGridImportKWH
+	dst.MeterGridExportKWH += src.MeterGridExportKWH
+	dst.HasMeterData = dst.HasMeterData || src.HasMeterData
+	dst.Alarms = append(dst.Alarms, src.Alarms...)
+}
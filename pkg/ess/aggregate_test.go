@@ -0,0 +1,283 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUnit is a System with fixed responses for testing the aggregate.
+type fakeUnit struct {
+	status     types.SystemStatus
+	history    []types.EnergyStats
+	caps       types.ESSCapabilities
+	setModes   error
+	batMode    types.BatteryMode
+	solMode    types.SolarMode
+	statusErr  error
+	historyErr error
+}
+
+func (f *fakeUnit) GetStatus(ctx context.Context) (types.SystemStatus, error) {
+	return f.status, f.statusErr
+}
+
+func (f *fakeUnit) SetModes(ctx context.Context, bat types.BatteryMode, sol types.SolarMode) error {
+	f.batMode = bat
+	f.solMode = sol
+	return f.setModes
+}
+
+func (f *fakeUnit) ApplySettings(ctx context.Context, settings types.Settings) error {
+	return nil
+}
+
+func (f *fakeUnit) Authenticate(ctx context.Context, creds types.Credentials) (types.Credentials, bool, error) {
+	return creds, false, nil
+}
+
+func (f *fakeUnit) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
+	return f.history, f.historyErr
+}
+
+func (f *fakeUnit) Capabilities() types.ESSCapabilities {
+	return f.caps
+}
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Authenticate", func(t *testing.T) {
+		ConfigureMock(nil)
+
+		a := newAggregate("site")
+		require.NoError(t, a.ApplySettings(ctx, types.Settings{MinBatterySOC: 20}))
+
+		creds, updated, err := a.Authenticate(ctx, types.Credentials{
+			Aggregate: &types.AggregateCredentials{
+				Units: `[{"ess": "mock"}, {"name": "Garage", "ess": "mock", "credentials": {"mock": {"strategy": "simple", "location": "America/New_York"}}}]`,
+			},
+		})
+		require.NoError(t, err)
+		// the first unit gets a name and the mock's default credentials
+		assert.True(t, updated)
+		require.Len(t, a.units, 2)
+		assert.Equal(t, "Unit 1", a.units[0].name)
+		assert.Equal(t, "Garage", a.units[1].name)
+
+		var units []types.AggregateUnit
+		require.NoError(t, json.Unmarshal([]byte(creds.Aggregate.Units), &units))
+		require.Len(t, units, 2)
+		assert.Equal(t, "Unit 1", units[0].Name)
+		require.NotNil(t, units[0].Credentials.Mock)
+		assert.Equal(t, "simple", units[0].Credentials.Mock.Strategy)
+		assert.Equal(t, "America/New_York", units[1].Credentials.Mock.Location)
+
+		// authenticating again keeps the same systems
+		first := a.units[0].system
+		_, updated, err = a.Authenticate(ctx, creds)
+		require.NoError(t, err)
+		assert.False(t, updated)
+		assert.Same(t, first, a.units[0].system)
+
+		// each unit has its own state
+		assert.Equal(t, "site-0", a.units[0].system.(*MockESS).siteID)
+		assert.Equal(t, "site-1", a.units[1].system.(*MockESS).siteID)
+	})
+
+	t.Run("Invalid Units", func(t *testing.T) {
+		for name, units := range map[string]string{
+			"json":      `{`,
+			"empty":     `[]`,
+			"nested":    `[{"ess": "aggregate"}]`,
+			"unknown":   `[{"ess": "battery9000"}]`,
+			"bad creds": `[{"ess": "mock", "credentials": {"mock": {"strategy": "complex"}}}]`,
+		} {
+			t.Run(name, func(t *testing.T) {
+				a := newAggregate("site")
+				_, _, err := a.Authenticate(ctx, types.Credentials{
+					Aggregate: &types.AggregateCredentials{Units: units},
+				})
+				assert.Error(t, err)
+				assert.Empty(t, a.units)
+			})
+		}
+
+		_, _, err := newAggregate("site").Authenticate(ctx, types.Credentials{})
+		assert.Error(t, err)
+	})
+
+	t.Run("GetStatus", func(t *testing.T) {
+		storm := types.Storm{Description: "Thunderstorm", TSStart: time.Unix(1000, 0)}
+		houseTempC, garageTempC := 3.0, -2.0
+		house := &fakeUnit{
+			status: types.SystemStatus{
+				Timestamp:             time.Unix(100, 0),
+				BatterySOC:            80,
+				EachBatterySOC:        []float64{80, 80},
+				BatteryKW:             2,
+				BatteryCapacityKWH:    30,
+				MaxBatteryChargeKW:    10,
+				MaxBatteryDischargeKW: 10,
+				MaxGridImportKW:       8,
+				SolarKW:               1,
+				GridKW:                0.5,
+				HomeKW:                3.5,
+				CanExportSolar:        true,
+				CanExportBattery:      true,
+				BatteryAboveMinSOC:    true,
+				BatteryTempC:          &houseTempC,
+				Storms:                []types.Storm{storm},
+			},
+			caps: types.AllESSCapabilities(),
+		}
+		garage := &fakeUnit{
+			status: types.SystemStatus{
+				Timestamp:             time.Unix(200, 0),
+				BatterySOC:            40,
+				EachBatterySOC:        []float64{40},
+				BatteryKW:             -1,
+				BatteryCapacityKWH:    10,
+				MaxBatteryChargeKW:    5,
+				MaxBatteryDischargeKW: 5,
+				MaxGridImportKW:       4,
+				SolarKW:               2,
+				GridKW:                0,
+				HomeKW:                1,
+				GeneratorKW:           6,
+				GridOutage:            true,
+				CanExportSolar:        true,
+				ElevatedMinBatterySOC: true,
+				BatteryTempC:          &garageTempC,
+				Alarms:                []types.SystemAlarm{{Name: "Overheat"}},
+				Storms:                []types.Storm{storm},
+			},
+			caps: types.ESSCapabilities{
+				BatteryModes:              []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeStandby, types.BatteryModeLoad},
+				SolarModes:                []types.SolarMode{types.SolarModeAny},
+				GridCharging:              true,
+				MinCommandIntervalSeconds: 300,
+			},
+		}
+		a := newAggregate("site")
+		a.units = []aggregateUnit{
+			{name: "House", system: house},
+			{name: "Garage", system: garage},
+		}
+
+		status, err := a.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, time.Unix(200, 0), status.Timestamp)
+		// weighted by capacity
+		assert.InDelta(t, 70.0, status.BatterySOC, 0.001)
+		assert.Empty(t, status.EachBatterySOC, "modules in separate units aren't imbalanced")
+		assert.Equal(t, 1.0, status.BatteryKW)
+		assert.Equal(t, 40.0, status.BatteryCapacityKWH)
+		assert.Equal(t, 15.0, status.MaxBatteryChargeKW)
+		assert.Equal(t, 15.0, status.MaxBatteryDischargeKW)
+		assert.Equal(t, 12.0, status.MaxGridImportKW)
+		assert.Equal(t, 0.0, status.MaxGridExportKW, "unlimited if any unit is unlimited")
+		assert.Equal(t, 3.0, status.SolarKW)
+		assert.Equal(t, 0.5, status.GridKW)
+		assert.Equal(t, 4.5, status.HomeKW)
+		assert.Equal(t, 6.0, status.GeneratorKW)
+		assert.True(t, status.GridOutage, "an outage at any unit is an outage")
+		assert.True(t, status.CanExportSolar)
+		assert.False(t, status.CanExportBattery)
+		assert.True(t, status.ElevatedMinBatterySOC)
+		assert.True(t, status.BatteryAboveMinSOC)
+		require.NotNil(t, status.BatteryTempC)
+		assert.Equal(t, -2.0, *status.BatteryTempC, "the coldest unit is the first to stop charging")
+		assert.Equal(t, []types.SystemAlarm{{Name: "Garage: Overheat"}}, status.Alarms)
+		assert.Equal(t, []types.Storm{storm}, status.Storms)
+
+		// only the modes both units support
+		require.NotNil(t, status.Capabilities)
+		assert.Equal(t, []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeStandby, types.BatteryModeLoad}, status.Capabilities.BatteryModes)
+		assert.Equal(t, []types.SolarMode{types.SolarModeAny}, status.Capabilities.SolarModes)
+		assert.True(t, status.Capabilities.GridCharging)
+		assert.False(t, status.Capabilities.BatteryExport)
+		assert.Equal(t, 300, status.Capabilities.MinCommandIntervalSeconds)
+		// the unit's capabilities weren't modified
+		assert.Len(t, house.caps.BatteryModes, 5)
+
+		garage.statusErr = errors.New("offline")
+		_, err = a.GetStatus(ctx)
+		assert.ErrorContains(t, err, "Garage: offline")
+	})
+
+	t.Run("SetModes", func(t *testing.T) {
+		house := &fakeUnit{}
+		garage := &fakeUnit{setModes: errors.New("offline")}
+		a := newAggregate("site")
+		a.units = []aggregateUnit{
+			{name: "House", system: house},
+			{name: "Garage", system: garage},
+		}
+
+		err := a.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoExport)
+		assert.ErrorContains(t, err, "Garage: offline")
+		// every unit is still sent the modes
+		assert.Equal(t, types.BatteryModeChargeAny, house.batMode)
+		assert.Equal(t, types.SolarModeNoExport, house.solMode)
+		assert.Equal(t, types.BatteryModeChargeAny, garage.batMode)
+		assert.Equal(t, []types.UnitResult{
+			{Name: "House", BatteryMode: types.BatteryModeChargeAny, SolarMode: types.SolarModeNoExport},
+			{Name: "Garage", BatteryMode: types.BatteryModeChargeAny, SolarMode: types.SolarModeNoExport, Error: "offline"},
+		}, a.UnitResults())
+
+		garage.setModes = nil
+		require.NoError(t, a.SetModes(ctx, types.BatteryModeLoad, types.SolarModeAny))
+		for _, r := range a.UnitResults() {
+			assert.Empty(t, r.Error)
+		}
+	})
+
+	t.Run("GetEnergyHistory", func(t *testing.T) {
+		h1 := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+		h2 := h1.Add(time.Hour)
+		house := &fakeUnit{history: []types.EnergyStats{
+			{TSHourStart: h1, MinBatterySOC: 60, MaxBatterySOC: 80, HomeKWH: 2, SolarKWH: 1, BatteryUsedKWH: 1, BatteryToHomeKWH: 1, GeneratorKWH: 1, GeneratorToHomeKWH: 1, GeneratorHours: 0.25},
+			{TSHourStart: h2, MinBatterySOC: 50, MaxBatterySOC: 60, HomeKWH: 3, GridImportKWH: 1},
+		}}
+		garage := &fakeUnit{history: []types.EnergyStats{
+			// the same hour in another timezone
+			{TSHourStart: h1.In(time.FixedZone("CST", -6*3600)), MinBatterySOC: 20, MaxBatterySOC: 40, HomeKWH: 1, GridImportKWH: 1, GeneratorKWH: 2, GeneratorToHomeKWH: 1.5, GeneratorToBatteryKWH: 0.5, GeneratorHours: 0.5},
+		}}
+		a := newAggregate("site")
+		a.units = []aggregateUnit{
+			{name: "House", system: house, capacityKWH: 30},
+			{name: "Garage", system: garage, capacityKWH: 10},
+		}
+
+		history, err := a.GetEnergyHistory(ctx, h1, h2.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.True(t, h1.Equal(history[0].TSHourStart))
+		assert.InDelta(t, 50.0, history[0].MinBatterySOC, 0.001)
+		assert.InDelta(t, 70.0, history[0].MaxBatterySOC, 0.001)
+		assert.Equal(t, 3.0, history[0].HomeKWH)
+		assert.Equal(t, 1.0, history[0].SolarKWH)
+		assert.Equal(t, 1.0, history[0].GridImportKWH)
+		assert.Equal(t, 1.0, history[0].BatteryToHomeKWH)
+		assert.Equal(t, 3.0, history[0].GeneratorKWH)
+		assert.Equal(t, 2.5, history[0].GeneratorToHomeKWH)
+		assert.Equal(t, 0.5, history[0].GeneratorToBatteryKWH)
+		assert.Equal(t, 0.75, history[0].GeneratorHours)
+
+		// only the house has the second hour
+		assert.True(t, h2.Equal(history[1].TSHourStart))
+		assert.InDelta(t, 50.0, history[1].MinBatterySOC, 0.001)
+		assert.Equal(t, 3.0, history[1].HomeKWH)
+
+		garage.historyErr = errors.New("offline")
+		_, err = a.GetEnergyHistory(ctx, h1, h2.Add(time.Hour))
+		assert.ErrorContains(t, err, "Garage: offline")
+	})
+}
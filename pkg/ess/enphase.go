@@ -0,0 +1,635 @@
+package ess
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/common"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+const (
+	enphaseModeSelfConsumption = "self-consumption"
+	enphaseModeBackup          = "backup"
+
+	enphaseMainsClosed = "closed"
+
+	enphaseMeterProduction     = "production"
+	enphaseMeterNetConsumption = "net-consumption"
+	enphaseMeterStorage        = "storage"
+	enphaseMeterTotal          = "total-consumption"
+
+	// IQ Battery 5P units have a 5kWh capacity and 3.84kW continuous power,
+	// the older 3T/10T units are 1.28kW per 3.36kWh base unit
+	enphase5PCapacityWH = 4000
+	enphase5PKW         = 3.84
+	enphaseBaseUnitWH   = 3360
+	enphaseBaseUnitKW   = 1.28
+)
+
+// enphaseOKStatuses are the device statuses that aren't reported as alarms.
+var enphaseOKStatuses = map[string]bool{
+	"envoy.global.ok": true,
+	"prop.done":       true,
+}
+
+// Enphase implements the System interface for Enphase IQ Batteries using the
+// IQ Gateway's (Envoy) local HTTPS API.
+type Enphase struct {
+	client   *http.Client
+	baseURL  string
+	tokenStr string
+	mu       sync.Mutex
+	settings types.Settings
+	location *time.Location
+
+	// the Envoy only reports lifetime meter readings
+	history *meterHistory
+}
+
+func newEnphase() *Enphase {
+	// the gateway only serves a self-signed certificate
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402
+	return &Enphase{
+		client:  common.LocalHTTPClientWithTransport(time.Minute, transport),
+		history: newMeterHistory(),
+	}
+}
+
+func enphaseInfo() types.ESSProviderInfo {
+	return types.ESSProviderInfo{
+		ID:           "enphase",
+		Name:         "Enphase IQ Battery",
+		Capabilities: enphaseCapabilities(),
+		Credentials: []types.ESSCredential{
+			{
+				Field:       "host",
+				Name:        "Gateway Address",
+				Type:        "string",
+				Required:    true,
+				Description: "IP address or hostname of the IQ Gateway (Envoy) on your local network.",
+			},
+			{
+				Field:       "token",
+				Name:        "Owner Token",
+				Type:        "password",
+				Required:    true,
+				Description: "The owner token for the gateway from entrez.enphaseenergy.com.",
+			},
+		},
+	}
+}
+
+// ApplySettings applies the given settings to the Enphase struct.
+func (e *Enphase) ApplySettings(ctx context.Context, settings types.Settings) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings = settings
+	return nil
+}
+
+// Capabilities returns the modes that can be set through the storage
+// settings.
+func (e *Enphase) Capabilities() types.ESSCapabilities {
+	return enphaseCapabilities()
+}
+
+// enphaseCapabilities are the capabilities of the local API which can only
+// change the storage settings. Every change is pushed to the batteries as a
+// new tariff which can take several minutes to apply so changing it more
+// often just queues up conflicting updates.
+func enphaseCapabilities() types.ESSCapabilities {
+	return types.ESSCapabilities{
+		BatteryModes:              []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeChargeSolar, types.BatteryModeStandby, types.BatteryModeLoad},
+		GridCharging:              true,
+		MinCommandIntervalSeconds: 15 * 60,
+	}
+}
+
+// Authenticate validates the token against the gateway. The gateway only
+// accepts tokens issued by Enphase so the credentials are never changed.
+func (e *Enphase) Authenticate(ctx context.Context, creds types.Credentials) (types.Credentials, bool, error) {
+	if creds.Enphase == nil {
+		return creds, false, errors.New("missing enphase credentials")
+	}
+	if creds.Enphase.Host == "" {
+		return creds, false, errors.New("missing enphase gateway host")
+	}
+	if creds.Enphase.Token == "" {
+		return creds, false, errors.New("missing enphase token")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	baseURL, err := enphaseBaseURL(creds.Enphase.Host)
+	if err != nil {
+		return creds, false, err
+	}
+	if baseURL != e.baseURL {
+		// a different gateway has a different timezone
+		e.location = nil
+	}
+	e.baseURL = baseURL
+	e.tokenStr = creds.Enphase.Token
+
+	// validate the token and cache the gateway's timezone
+	if _, err := e.getLocation(ctx); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "enphase credential validation failed", slog.Any("error", err))
+		return creds, false, fmt.Errorf("credential validation failed: %w", err)
+	}
+	return creds, false, nil
+}
+
+// enphaseBaseURL returns the base URL of the gateway for a host which may or
+// may not include the scheme.
+func enphaseBaseURL(host string) (string, error) {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid enphase gateway host: %w", err)
+	}
+	if u.Host == "" {
+		return "", errors.New("invalid enphase gateway host")
+	}
+	return u.String(), nil
+}
+
+func (e *Enphase) doRequest(ctx context.Context, method, path string, data interface{}, dest interface{}) error {
+	if e.baseURL == "" {
+		return errors.New("enphase not authenticated")
+	}
+
+	var body io.Reader
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, e.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+e.tokenStr)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		// we can't get a new token ourselves so the user needs to update it
+		log.Ctx(ctx).ErrorContext(ctx, "enphase token rejected", slog.Int("status", resp.StatusCode), slog.String("path", path))
+		return fmt.Errorf("enphase token rejected: %w", common.NewStatusError(resp))
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Ctx(ctx).ErrorContext(ctx, "enphase api error", slog.Int("status", resp.StatusCode), slog.String("path", path), slog.String("body", string(respBody)))
+		return fmt.Errorf("enphase api error: %w", common.NewStatusError(resp))
+	}
+
+	if dest != nil {
+		if err := json.Unmarshal(respBody, dest); err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to decode enphase response", slog.Any("error", err), slog.String("body", string(respBody)))
+			return fmt.Errorf("failed to decode enphase response: %w", err)
+		}
+	}
+	return nil
+}
+
+// getLocation returns the gateway's timezone, fetching it if it isn't cached.
+// Must be called with e.mu held.
+func (e *Enphase) getLocation(ctx context.Context) (*time.Location, error) {
+	if e.location != nil {
+		return e.location, nil
+	}
+	var res enphaseDateTimeSettings
+	if err := e.doRequest(ctx, "GET", "/admin/lib/date_time_settings", nil, &res); err != nil {
+		return nil, fmt.Errorf("date_time_settings failed: %w", err)
+	}
+	loc, err := time.LoadLocation(res.TZ)
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to load location, defaulting to UTC", slog.String("tz", res.TZ), slog.Any("error", err))
+		loc = time.UTC
+	}
+	e.location = loc
+	return loc, nil
+}
+
+// getTariff returns the gateway's tariff which holds the storage settings.
+// Must be called with e.mu held.
+func (e *Enphase) getTariff(ctx context.Context) (enphaseTariff, enphaseStorageSettings, error) {
+	var res enphaseTariff
+	if err := e.doRequest(ctx, "GET", "/admin/lib/tariff", nil, &res); err != nil {
+		return nil, enphaseStorageSettings{}, fmt.Errorf("tariff failed: %w", err)
+	}
+	var storage enphaseStorageSettings
+	if raw, ok := res.tariff()["storage_settings"]; ok {
+		if err := json.Unmarshal(raw, &storage); err != nil {
+			return nil, enphaseStorageSettings{}, fmt.Errorf("failed to decode storage settings: %w", err)
+		}
+	}
+	return res, storage, nil
+}
+
+// enphaseBatteryKW returns the continuous power of an IQ Battery device with
+// the given capacity. The inventory lists each 3.36kWh base unit of a 3T/10T
+// separately.
+func enphaseBatteryKW(capacityWH float64) float64 {
+	if capacityWH >= enphase5PCapacityWH {
+		return enphase5PKW
+	}
+	return enphaseBaseUnitKW * capacityWH / enphaseBaseUnitWH
+}
+
+// GetStatus returns the status of the Enphase system
+func (e *Enphase) GetStatus(ctx context.Context) (types.SystemStatus, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting enphase system status")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	loc, err := e.getLocation(ctx)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	live, err := e.recordSnapshot(ctx)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	var inventory []enphaseInventory
+	if err := e.doRequest(ctx, "GET", "/ivp/ensemble/inventory", nil, &inventory); err != nil {
+		return types.SystemStatus{}, fmt.Errorf("ensemble inventory failed: %w", err)
+	}
+	var capacityWH, maxKW float64
+	var alarms []types.SystemAlarm
+	// the coldest battery is the first to stop charging
+	var tempC *float64
+	// without a system controller the home can't be islanded so we're always
+	// on the grid
+	onGrid := true
+	for _, inv := range inventory {
+		if inv.Type == "ENPOWER" {
+			for _, d := range inv.Devices {
+				if d.MainsOperState != enphaseMainsClosed {
+					onGrid = false
+				}
+			}
+			continue
+		}
+		if inv.Type != "ENCHARGE" {
+			continue
+		}
+		for _, d := range inv.Devices {
+			capacityWH += d.EnchargeCapacity
+			maxKW += enphaseBatteryKW(d.EnchargeCapacity)
+			if d.Temperature != nil && (tempC == nil || *d.Temperature < *tempC) {
+				tempC = d.Temperature
+			}
+			for _, s := range d.DeviceStatus {
+				if enphaseOKStatuses[s] {
+					continue
+				}
+				alarms = append(alarms, types.SystemAlarm{
+					Name: d.SerialNum + ": " + s,
+					Time: time.Now(),
+				})
+			}
+		}
+	}
+	if capacityWH == 0 {
+		return types.SystemStatus{}, errors.New("no enphase batteries found")
+	}
+
+	_, storage, err := e.getTariff(ctx)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	soc := live.Meters.SOC
+	log.Ctx(ctx).DebugContext(
+		ctx,
+		"enphase status",
+		slog.Float64("soc", soc),
+		slog.Float64("reserveSOC", storage.ReservedSOC),
+		slog.String("mode", storage.Mode),
+		slog.Bool("chargeFromGrid", storage.ChargeFromGrid),
+		slog.Bool("onGrid", onGrid),
+		slog.Float64("storageMW", live.Meters.Storage.AggPMW),
+		slog.Float64("pvMW", live.Meters.PV.AggPMW),
+		slog.Float64("gridMW", live.Meters.Grid.AggPMW),
+		slog.Float64("loadMW", live.Meters.Load.AggPMW),
+	)
+
+	return types.SystemStatus{
+		Timestamp:             time.Now().In(loc),
+		BatterySOC:            soc,
+		BatteryKW:             live.Meters.Storage.AggPMW / 1e6,
+		SolarKW:               math.Max(live.Meters.PV.AggPMW/1e6, 0),
+		GridKW:                live.Meters.Grid.AggPMW / 1e6,
+		HomeKW:                live.Meters.Load.AggPMW / 1e6,
+		BatteryCapacityKWH:    capacityWH / 1000,
+		MaxBatteryChargeKW:    maxKW,
+		MaxBatteryDischargeKW: maxKW,
+		EmergencyMode:         storage.Mode == enphaseModeBackup || !onGrid,
+		// export is configured during commissioning and isn't available from
+		// the local API so we assume it matches the settings
+		CanExportSolar:        e.settings.GridExportSolar,
+		CanExportBattery:      false,
+		CanImportBattery:      e.settings.GridChargeBatteries,
+		ElevatedMinBatterySOC: storage.ReservedSOC > 0 && storage.ReservedSOC > e.settings.MinBatterySOC,
+		BatteryAboveMinSOC:    soc >= storage.ReservedSOC,
+		BatteryTempC:          tempC,
+		Alarms:                alarms,
+	}, nil
+}
+
+// SetModes sets the battery mode by changing the reserve and charge from grid
+// storage settings while in self-consumption mode. The local API can't change
+// the export settings so the solar mode is ignored.
+func (e *Enphase) SetModes(ctx context.Context, bat types.BatteryMode, sol types.SolarMode) error {
+	log.Ctx(ctx).DebugContext(ctx, "SetModes called", slog.Any("batteryMode", bat), slog.Any("solarMode", sol))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if sol != types.SolarModeNoChange {
+		log.Ctx(ctx).WarnContext(ctx, "enphase local api cannot change solar export, ignoring", slog.Any("solarMode", sol))
+	}
+	if bat == types.BatteryModeNoChange {
+		return nil
+	}
+
+	tariff, storage, err := e.getTariff(ctx)
+	if err != nil {
+		return err
+	}
+	if storage.Mode == enphaseModeBackup {
+		log.Ctx(ctx).InfoContext(ctx, "device is in backup mode, skipping set modes")
+		return errors.New("device is in backup mode")
+	}
+
+	var live enphaseLiveData
+	if err := e.doRequest(ctx, "GET", "/ivp/livedata/status", nil, &live); err != nil {
+		return fmt.Errorf("livedata failed: %w", err)
+	}
+	minBatterySOC := e.settings.MinBatterySOC
+
+	var reserve float64
+	var chargeFromGrid bool
+	switch bat {
+	case types.BatteryModeChargeAny:
+		// the gateway only charges from the grid to reach the reserve when charge
+		// from grid is enabled, otherwise this only charges from solar
+		reserve = 100
+		chargeFromGrid = e.settings.GridChargeBatteries
+	case types.BatteryModeChargeSolar:
+		reserve = 100
+	case types.BatteryModeStandby:
+		// holding the reserve at the current SOC stops the battery from
+		// discharging while excess solar still charges it
+		reserve = math.Max(math.Floor(live.Meters.SOC), minBatterySOC)
+	case types.BatteryModeLoad:
+		reserve = minBatterySOC
+	default:
+		return fmt.Errorf("unknown battery mode: %v", bat)
+	}
+
+	log.Ctx(ctx).InfoContext(
+		ctx,
+		"setting enphase storage settings",
+		slog.String("mode", enphaseModeSelfConsumption),
+		slog.Float64("reserveSOC", reserve),
+		slog.Bool("chargeFromGrid", chargeFromGrid),
+	)
+	if err := tariff.setStorageSettings(map[string]interface{}{
+		"mode":             enphaseModeSelfConsumption,
+		"reserved_soc":     reserve,
+		"charge_from_grid": chargeFromGrid,
+	}); err != nil {
+		return err
+	}
+	// only the tariff is written back, the schedule is managed by the gateway
+	data := map[string]json.RawMessage{"tariff": tariff["tariff"]}
+	if err := e.doRequest(ctx, "PUT", "/admin/lib/tariff", data, nil); err != nil {
+		return fmt.Errorf("failed to set tariff: %w", err)
+	}
+	return nil
+}
+
+// recordSnapshot fetches the live data and meter readings and records them
+// for the energy history. Must be called with e.mu held.
+func (e *Enphase) recordSnapshot(ctx context.Context) (enphaseLiveData, error) {
+	var live enphaseLiveData
+	if err := e.doRequest(ctx, "GET", "/ivp/livedata/status", nil, &live); err != nil {
+		return enphaseLiveData{}, fmt.Errorf("livedata failed: %w", err)
+	}
+
+	var meters []enphaseMeter
+	if err := e.doRequest(ctx, "GET", "/ivp/meters", nil, &meters); err != nil {
+		return enphaseLiveData{}, fmt.Errorf("meters failed: %w", err)
+	}
+	var readings []enphaseMeterReading
+	if err := e.doRequest(ctx, "GET", "/ivp/meters/readings", nil, &readings); err != nil {
+		return enphaseLiveData{}, fmt.Errorf("meter readings failed: %w", err)
+	}
+	byType := make(map[string]enphaseMeterReading)
+	for _, m := range meters {
+		if m.State != "enabled" {
+			continue
+		}
+		for _, r := range readings {
+			if r.EID == m.EID {
+				byType[m.MeasurementType] = r
+			}
+		}
+	}
+	production, okProduction := byType[enphaseMeterProduction]
+	net, okNet := byType[enphaseMeterNetConsumption]
+	if !okProduction || !okNet {
+		// without the CTs there are no lifetime counters to build history from
+		log.Ctx(ctx).WarnContext(ctx, "enphase production and net consumption meters not found, skipping history snapshot")
+		return live, nil
+	}
+
+	c := meterCounters{
+		SolarWH:      production.ActEnergyDlvd,
+		GridImportWH: net.ActEnergyDlvd,
+		GridExportWH: net.ActEnergyRcvd,
+	}
+	storage, okStorage := byType[enphaseMeterStorage]
+	if okStorage {
+		c.BatteryUsedWH = storage.ActEnergyDlvd
+		c.BatteryChargedWH = storage.ActEnergyRcvd
+	}
+	if total, ok := byType[enphaseMeterTotal]; ok {
+		c.HomeWH = total.ActEnergyDlvd
+	} else {
+		// the gateway calculates total consumption from the other meters
+		var prod enphaseProduction
+		if err := e.doRequest(ctx, "GET", "/production.json?details=1", nil, &prod); err != nil {
+			return enphaseLiveData{}, fmt.Errorf("production failed: %w", err)
+		}
+		for _, m := range prod.Consumption {
+			if m.MeasurementType == enphaseMeterTotal {
+				c.HomeWH = m.WhLifetime
+			}
+		}
+	}
+	// older systems don't have a storage CT so the battery's energy is whatever
+	// is left over after the other meters
+	e.history.deriveBattery = !okStorage
+	e.history.record(time.Now(), c, live.Meters.SOC)
+	return live, nil
+}
+
+// GetEnergyHistory returns the hourly energy history from the Envoy's
+// production and consumption meters.
+func (e *Enphase) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting enphase energy history", slog.Time("start", start), slog.Time("end", end))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	loc, err := e.getLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.recordSnapshot(ctx); err != nil {
+		return nil, err
+	}
+	return e.history.stats(start, end, loc), nil
+}
+
+// Internal Structs
+
+type enphaseDateTimeSettings struct {
+	TZ string `json:"tz"`
+}
+
+type enphaseLivePower struct {
+	AggPMW float64 `json:"agg_p_mw"` // milliwatts
+}
+
+type enphaseLiveData struct {
+	Meters struct {
+		SOC          float64          `json:"soc"`
+		EncAggEnergy float64          `json:"enc_agg_energy"` // Wh remaining
+		PV           enphaseLivePower `json:"pv"`
+		// storage is positive when discharging
+		Storage enphaseLivePower `json:"storage"`
+		// grid is positive when importing
+		Grid enphaseLivePower `json:"grid"`
+		Load enphaseLivePower `json:"load"`
+	} `json:"meters"`
+}
+
+type enphaseInventoryDevice struct {
+	SerialNum        string   `json:"serial_num"`
+	PercentFull      float64  `json:"percentFull"`
+	EnchargeCapacity float64  `json:"encharge_capacity"` // Wh
+	Temperature      *float64 `json:"temperature"`       // °C
+	DeviceStatus     []string `json:"device_status"`
+	// set on the system controller (ENPOWER)
+	MainsOperState string `json:"mains_oper_state"`
+}
+
+type enphaseInventory struct {
+	Type    string                   `json:"type"`
+	Devices []enphaseInventoryDevice `json:"devices"`
+}
+
+type enphaseMeter struct {
+	EID             int64  `json:"eid"`
+	State           string `json:"state"`
+	MeasurementType string `json:"measurementType"`
+}
+
+type enphaseMeterReading struct {
+	EID           int64   `json:"eid"`
+	ActEnergyDlvd float64 `json:"actEnergyDlvd"` // Wh lifetime
+	ActEnergyRcvd float64 `json:"actEnergyRcvd"` // Wh lifetime
+	ActivePower   float64 `json:"activePower"`   // W
+}
+
+type enphaseProductionMeter struct {
+	Type            string  `json:"type"`
+	MeasurementType string  `json:"measurementType"`
+	WNow            float64 `json:"wNow"`
+	WhLifetime      float64 `json:"whLifetime"`
+}
+
+type enphaseProduction struct {
+	Production  []enphaseProductionMeter `json:"production"`
+	Consumption []enphaseProductionMeter `json:"consumption"`
+}
+
+type enphaseStorageSettings struct {
+	Mode           string  `json:"mode"`
+	ReservedSOC    float64 `json:"reserved_soc"`
+	ChargeFromGrid bool    `json:"charge_from_grid"`
+}
+
+// enphaseTariff is the gateway's tariff configuration. It's kept as raw JSON
+// so that the rate schedules we don't touch are written back unchanged.
+type enphaseTariff map[string]json.RawMessage
+
+func (t enphaseTariff) tariff() map[string]json.RawMessage {
+	var res map[string]json.RawMessage
+	_ = json.Unmarshal(t["tariff"], &res)
+	return res
+}
+
+// setStorageSettings overwrites the given storage settings keys, keeping any
+// other storage settings.
+func (t enphaseTariff) setStorageSettings(updates map[string]interface{}) error {
+	tariff := t.tariff()
+	if tariff == nil {
+		return errors.New("enphase tariff missing")
+	}
+	storage := make(map[string]interface{})
+	if raw, ok := tariff["storage_settings"]; ok {
+		if err := json.Unmarshal(raw, &storage); err != nil {
+			return fmt.Errorf("failed to decode storage settings: %w", err)
+		}
+	}
+	for k, v := range updates {
+		storage[k] = v
+	}
+	b, err := json.Marshal(storage)
+	if err != nil {
+		return err
+	}
+	tariff["storage_settings"] = b
+	b, err = json.Marshal(tariff)
+	if err != nil {
+		return err
+	}
+	t["tariff"] = b
+	return nil
+}
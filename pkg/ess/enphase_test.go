@@ -0,0 +1,369 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnphaseGateway serves the recorded gateway responses in
+// testdata/enphase and keeps the tariff so it can be updated.
+type fakeEnphaseGateway struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	token     string
+	fixtures  map[string]json.RawMessage
+	tariff    map[string]interface{}
+	tariffPut int
+}
+
+func newFakeEnphaseGateway(t *testing.T) *fakeEnphaseGateway {
+	g := &fakeEnphaseGateway{
+		t:        t,
+		token:    "owner-token",
+		fixtures: make(map[string]json.RawMessage),
+	}
+	for path, name := range map[string]string{
+		"/admin/lib/date_time_settings": "date_time_settings.json",
+		"/ivp/livedata/status":          "livedata_status.json",
+		"/ivp/ensemble/inventory":       "ensemble_inventory.json",
+		"/ivp/meters":                   "meters.json",
+		"/ivp/meters/readings":          "meters_readings.json",
+		"/production.json":              "production.json",
+		"/admin/lib/tariff":             "tariff.json",
+	} {
+		b, err := os.ReadFile(filepath.Join("testdata", "enphase", name))
+		require.NoError(t, err)
+		g.fixtures[path] = b
+	}
+	require.NoError(t, json.Unmarshal(g.fixtures["/admin/lib/tariff"], &g.tariff))
+	return g
+}
+
+// setFixture replaces the response for path after applying fn to the decoded
+// fixture.
+func (g *fakeEnphaseGateway) setFixture(path string, fn func(v interface{}) interface{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var v interface{}
+	require.NoError(g.t, json.Unmarshal(g.fixtures[path], &v))
+	b, err := json.Marshal(fn(v))
+	require.NoError(g.t, err)
+	g.fixtures[path] = b
+}
+
+func (g *fakeEnphaseGateway) storageSettings() map[string]interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.tariff["tariff"].(map[string]interface{})["storage_settings"].(map[string]interface{})
+}
+
+func (g *fakeEnphaseGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+g.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /admin/lib/tariff":
+		_ = json.NewEncoder(w).Encode(g.tariff)
+		return
+	case "PUT /admin/lib/tariff":
+		var req map[string]interface{}
+		require.NoError(g.t, json.NewDecoder(r.Body).Decode(&req))
+		// only the tariff is accepted
+		assert.Len(g.t, req, 1)
+		g.tariff["tariff"] = req["tariff"]
+		g.tariffPut++
+		return
+	}
+	if r.Method == "GET" {
+		if b, ok := g.fixtures[r.URL.Path]; ok {
+			_, _ = w.Write(b)
+			return
+		}
+	}
+	http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+}
+
+func newTestEnphase(t *testing.T) (*Enphase, *fakeEnphaseGateway) {
+	gw := newFakeEnphaseGateway(t)
+	ts := httptest.NewTLSServer(gw)
+	t.Cleanup(ts.Close)
+
+	e := newEnphase()
+	e.client = ts.Client()
+	require.NoError(t, e.ApplySettings(context.Background(), types.Settings{
+		MinBatterySOC:       20,
+		GridChargeBatteries: true,
+		GridExportSolar:     true,
+	}))
+
+	_, changed, err := e.Authenticate(context.Background(), types.Credentials{
+		Enphase: &types.EnphaseCredentials{Host: ts.URL, Token: "owner-token"},
+	})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	return e, gw
+}
+
+func TestEnphase(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Authenticate", func(t *testing.T) {
+		gw := newFakeEnphaseGateway(t)
+		ts := httptest.NewTLSServer(gw)
+		defer ts.Close()
+
+		e := newEnphase()
+		e.client = ts.Client()
+
+		_, _, err := e.Authenticate(ctx, types.Credentials{})
+		assert.Error(t, err)
+
+		_, _, err = e.Authenticate(ctx, types.Credentials{
+			Enphase: &types.EnphaseCredentials{Host: ts.URL},
+		})
+		assert.Error(t, err)
+
+		_, _, err = e.Authenticate(ctx, types.Credentials{
+			Enphase: &types.EnphaseCredentials{Host: ts.URL, Token: "wrong"},
+		})
+		assert.ErrorContains(t, err, "token rejected")
+
+		creds, changed, err := e.Authenticate(ctx, types.Credentials{
+			Enphase: &types.EnphaseCredentials{Host: ts.URL, Token: "owner-token"},
+		})
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "owner-token", creds.Enphase.Token)
+		assert.Equal(t, "America/Los_Angeles", e.location.String())
+	})
+
+	t.Run("GetStatus", func(t *testing.T) {
+		e, _ := newTestEnphase(t)
+
+		status, err := e.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.InDelta(t, 52.0, status.BatterySOC, 0.001)
+		assert.InDelta(t, -1.5, status.BatteryKW, 0.001)
+		assert.InDelta(t, 3.25, status.SolarKW, 0.001)
+		assert.InDelta(t, -0.25, status.GridKW, 0.001)
+		assert.InDelta(t, 1.5, status.HomeKW, 0.001)
+		assert.InDelta(t, 10.08, status.BatteryCapacityKWH, 0.001)
+		assert.InDelta(t, 3.84, status.MaxBatteryChargeKW, 0.001)
+		assert.InDelta(t, 3.84, status.MaxBatteryDischargeKW, 0.001)
+		assert.False(t, status.EmergencyMode)
+		assert.True(t, status.CanExportSolar)
+		assert.True(t, status.CanImportBattery)
+		assert.False(t, status.CanExportBattery)
+		assert.False(t, status.ElevatedMinBatterySOC)
+		assert.True(t, status.BatteryAboveMinSOC)
+		require.NotNil(t, status.BatteryTempC)
+		assert.Equal(t, 24.0, *status.BatteryTempC)
+		assert.Empty(t, status.Alarms)
+		assert.Equal(t, "America/Los_Angeles", status.Timestamp.Location().String())
+	})
+
+	t.Run("GetStatus Off Grid", func(t *testing.T) {
+		e, gw := newTestEnphase(t)
+		gw.setFixture("/ivp/ensemble/inventory", func(v interface{}) interface{} {
+			for _, inv := range v.([]interface{}) {
+				inv := inv.(map[string]interface{})
+				if inv["type"] != "ENPOWER" {
+					continue
+				}
+				for _, d := range inv["devices"].([]interface{}) {
+					d.(map[string]interface{})["mains_oper_state"] = "open"
+				}
+			}
+			return v
+		})
+
+		status, err := e.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.True(t, status.EmergencyMode)
+	})
+
+	t.Run("GetStatus No Batteries", func(t *testing.T) {
+		e, gw := newTestEnphase(t)
+		gw.setFixture("/ivp/ensemble/inventory", func(v interface{}) interface{} {
+			return []interface{}{}
+		})
+
+		_, err := e.GetStatus(ctx)
+		assert.ErrorContains(t, err, "no enphase batteries")
+	})
+
+	t.Run("SetModes", func(t *testing.T) {
+		e, gw := newTestEnphase(t)
+
+		require.NoError(t, e.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoChange))
+		storage := gw.storageSettings()
+		assert.Equal(t, enphaseModeSelfConsumption, storage["mode"])
+		assert.Equal(t, 100.0, storage["reserved_soc"])
+		assert.Equal(t, true, storage["charge_from_grid"])
+		// settings we don't manage are preserved
+		assert.Equal(t, 5.0, storage["very_low_soc"])
+		assert.Equal(t, "1760800000", storage["date"])
+		assert.Equal(t, 1, gw.tariffPut)
+		gw.mu.Lock()
+		assert.Equal(t, "USD", gw.tariff["tariff"].(map[string]interface{})["currency"].(map[string]interface{})["code"])
+		gw.mu.Unlock()
+
+		status, err := e.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.True(t, status.ElevatedMinBatterySOC)
+		assert.False(t, status.BatteryAboveMinSOC)
+
+		require.NoError(t, e.SetModes(ctx, types.BatteryModeChargeSolar, types.SolarModeNoChange))
+		storage = gw.storageSettings()
+		assert.Equal(t, 100.0, storage["reserved_soc"])
+		assert.Equal(t, false, storage["charge_from_grid"])
+
+		// standby holds the current SOC
+		require.NoError(t, e.SetModes(ctx, types.BatteryModeStandby, types.SolarModeNoChange))
+		storage = gw.storageSettings()
+		assert.Equal(t, 52.0, storage["reserved_soc"])
+		assert.Equal(t, false, storage["charge_from_grid"])
+
+		// load drops the reserve to the minimum SOC
+		require.NoError(t, e.SetModes(ctx, types.BatteryModeLoad, types.SolarModeNoChange))
+		storage = gw.storageSettings()
+		assert.Equal(t, 20.0, storage["reserved_soc"])
+		assert.Equal(t, false, storage["charge_from_grid"])
+		assert.Equal(t, 4, gw.tariffPut)
+
+		// solar only changes are ignored
+		require.NoError(t, e.SetModes(ctx, types.BatteryModeNoChange, types.SolarModeNoExport))
+		assert.Equal(t, 4, gw.tariffPut)
+	})
+
+	t.Run("SetModes No Grid Charging", func(t *testing.T) {
+		e, gw := newTestEnphase(t)
+		require.NoError(t, e.ApplySettings(ctx, types.Settings{MinBatterySOC: 20}))
+
+		require.NoError(t, e.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoChange))
+		storage := gw.storageSettings()
+		assert.Equal(t, 100.0, storage["reserved_soc"])
+		assert.Equal(t, false, storage["charge_from_grid"])
+	})
+
+	t.Run("SetModes Backup Mode", func(t *testing.T) {
+		e, gw := newTestEnphase(t)
+		gw.storageSettings()["mode"] = enphaseModeBackup
+
+		err := e.SetModes(ctx, types.BatteryModeLoad, types.SolarModeNoChange)
+		assert.ErrorContains(t, err, "backup mode")
+		assert.Equal(t, 0, gw.tariffPut)
+
+		status, err := e.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.True(t, status.EmergencyMode)
+	})
+
+	t.Run("Token Rejected", func(t *testing.T) {
+		e, gw := newTestEnphase(t)
+		gw.mu.Lock()
+		gw.token = "new-token"
+		gw.mu.Unlock()
+
+		_, err := e.GetStatus(ctx)
+		assert.ErrorContains(t, err, "token rejected")
+	})
+
+	t.Run("GetEnergyHistory", func(t *testing.T) {
+		e, _ := newTestEnphase(t)
+
+		stats, err := e.GetEnergyHistory(ctx, time.Now().Add(-time.Hour), time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, stats)
+
+		// the snapshot comes from the CT readings and the gateway's calculated
+		// total consumption
+		cur, ok := e.history.hours[time.Now().Truncate(time.Hour).Unix()]
+		require.True(t, ok)
+		assert.Equal(t, 12000000.0, cur.first.SolarWH)
+		assert.Equal(t, 5000000.0, cur.first.GridImportWH)
+		assert.Equal(t, 7000000.0, cur.first.GridExportWH)
+		assert.Equal(t, 9000000.0, cur.first.HomeWH)
+		assert.Equal(t, 52.0, cur.minSOC)
+		// there's no storage CT so the battery is derived
+		assert.True(t, e.history.deriveBattery)
+
+		// the battery charged 1kWh from solar and then discharged 0.5kWh to the
+		// home over the previous hour
+		hour := time.Now().Truncate(time.Hour).Add(-time.Hour)
+		e.history.record(hour, meterCounters{
+			HomeWH:       8998500,
+			SolarWH:      11998000,
+			GridImportWH: 5000000,
+			GridExportWH: 7000000,
+		}, 48)
+
+		stats, err = e.GetEnergyHistory(ctx, hour, time.Now())
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+		assert.True(t, stats[0].TSHourStart.Equal(hour))
+		assert.InDelta(t, 1.5, stats[0].HomeKWH, 0.001)
+		assert.InDelta(t, 2.0, stats[0].SolarKWH, 0.001)
+		assert.InDelta(t, 0.5, stats[0].BatteryChargedKWH, 0.001)
+		assert.InDelta(t, 0.0, stats[0].BatteryUsedKWH, 0.001)
+		assert.Equal(t, 48.0, stats[0].MinBatterySOC)
+	})
+
+	t.Run("GetEnergyHistory Storage Meter", func(t *testing.T) {
+		e, gw := newTestEnphase(t)
+		gw.setFixture("/ivp/meters", func(v interface{}) interface{} {
+			return append(v.([]interface{}),
+				map[string]interface{}{"eid": 704643840, "state": "enabled", "measurementType": "storage"},
+			)
+		})
+		gw.setFixture("/ivp/meters/readings", func(v interface{}) interface{} {
+			return append(v.([]interface{}),
+				map[string]interface{}{"eid": 704643840, "actEnergyDlvd": 3000000.0, "actEnergyRcvd": 3500000.0},
+			)
+		})
+
+		_, err := e.GetEnergyHistory(ctx, time.Now().Add(-time.Hour), time.Now())
+		require.NoError(t, err)
+		assert.False(t, e.history.deriveBattery)
+
+		cur := e.history.hours[time.Now().Truncate(time.Hour).Unix()]
+		assert.Equal(t, 3000000.0, cur.first.BatteryUsedWH)
+		assert.Equal(t, 3500000.0, cur.first.BatteryChargedWH)
+		assert.Equal(t, 9000000.0, cur.first.HomeWH)
+	})
+}
+
+func TestEnphaseBaseURL(t *testing.T) {
+	u, err := enphaseBaseURL("envoy.local")
+	require.NoError(t, err)
+	assert.Equal(t, "https://envoy.local", u)
+
+	u, err = enphaseBaseURL(" https://192.168.1.60/ ")
+	require.NoError(t, err)
+	assert.Equal(t, "https://192.168.1.60", u)
+
+	_, err = enphaseBaseURL("https://[::1")
+	assert.Error(t, err)
+}
+
+func TestEnphaseBatteryKW(t *testing.T) {
+	assert.InDelta(t, 3.84, enphaseBatteryKW(5000), 0.001)
+	assert.InDelta(t, 1.28, enphaseBatteryKW(3360), 0.001)
+}
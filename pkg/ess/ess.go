@@ -27,6 +27,23 @@ type System interface {
 
 	// GetEnergyHistory returns the energy history for the specified period.
 	GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error)
+
+	// Capabilities returns the commands the system supports. Systems that
+	// discover their limitations from the device update them in GetStatus.
+	Capabilities() types.ESSCapabilities
+}
+
+// Scheduler is implemented by systems that can follow a schedule on their own.
+type Scheduler interface {
+	// SetSchedule replaces the system's schedule with the given blocks and has
+	// the system follow it. The first block starts at the current hour.
+	SetSchedule(ctx context.Context, blocks []types.ScheduleBlock) error
+}
+
+// MultiUnit is implemented by systems made up of several units.
+type MultiUnit interface {
+	// UnitResults returns the result of each unit from the last SetModes.
+	UnitResults() []types.UnitResult
 }
 
 // Configured sets up the ESS system provider Map
@@ -51,6 +68,12 @@ func NewMap() *Map {
 func (m *Map) ListSystems() []types.ESSProviderInfo {
 	return []types.ESSProviderInfo{
 		franklinInfo(),
+		powerwallInfo(),
+		enphaseInfo(),
+		sunspecInfo(),
+		mqttInfo(),
+		homeAssistantInfo(),
+		aggregateInfo(),
 		mockInfo(),
 	}
 }
@@ -72,23 +95,38 @@ func (m *Map) Site(ctx context.Context, siteID string, settings types.Settings)
 		return sys, nil
 	}
 
-	var sys System
-	switch settings.ESS {
+	sys := newSystem(settings.ESS, siteID)
+	if err := sys.ApplySettings(ctx, settings); err != nil {
+		return nil, err
+	}
+	m.systems[siteID] = sys
+	return sys, nil
+}
+
+// newSystem creates the system with the given ID for the site.
+func newSystem(id, siteID string) System {
+	switch id {
 	case "franklin":
-		sys = newFranklin()
+		return newFranklin()
+	case "powerwall":
+		return newPowerwall()
+	case "enphase":
+		return newEnphase()
+	case "sunspec":
+		return newSunSpec()
+	case "mqtt":
+		return newMQTTBridge(siteID)
+	case "homeassistant":
+		return newHomeAssistant()
 	case "mock":
-		sys = newMock(siteID)
+		return newMock(siteID)
+	case "aggregate":
+		return newAggregate(siteID)
 	default:
 		// Default to franklin for backwards compatibility if not specified
 		// or if an unknown system is provided.
-		sys = newFranklin()
+		return newFranklin()
 	}
-
-	if err := sys.ApplySettings(ctx, settings); err != nil {
-		return nil, err
-	}
-	m.systems[siteID] = sys
-	return sys, nil
 }
 
 // SetSystem sets the system for a specific site. This is primarily used for testing.
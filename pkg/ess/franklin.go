@@ -39,6 +39,20 @@ type Franklin struct {
 	settings         types.Settings
 	deviceInfoCache  deviceInfoV2Result
 	deviceInfoExpiry time.Time
+	// powerCapCache is the gateway's power capability config which only
+	// changes when batteries are added or removed
+	powerCapCache  []powerCapConfig
+	powerCapErr    error
+	powerCapExpiry time.Time
+	// schedule is the last schedule pushed to the gateway
+	schedule []touScheduleDetail
+	// reserveSOCLocked is set when the self consumption mode doesn't allow
+	// editing the reserve SOC which leaves only the mode itself
+	reserveSOCLocked bool
+	// exportGridFeedMax is the user's grid feed limit from before we lifted it
+	// to export the battery, nil when we haven't lifted it. It's kept with the
+	// credentials so it can be put back after a restart.
+	exportGridFeedMax *float64
 }
 
 type franklinMode struct {
@@ -60,8 +74,9 @@ func newFranklin() *Franklin {
 
 func franklinInfo() types.ESSProviderInfo {
 	return types.ESSProviderInfo{
-		ID:   "franklin",
-		Name: "FranklinWH",
+		ID:           "franklin",
+		Name:         "FranklinWH",
+		Capabilities: franklinCapabilities(),
 		Credentials: []types.ESSCredential{
 			{
 				Field:    "username",
@@ -94,6 +109,25 @@ func (f *Franklin) ApplySettings(ctx context.Context, settings types.Settings) e
 	return nil
 }
 
+// Capabilities returns everything unless the reserve SOC can't be edited in
+// which case only self consumption is supported.
+func (f *Franklin) Capabilities() types.ESSCapabilities {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	caps := franklinCapabilities()
+	if f.reserveSOCLocked {
+		caps.BatteryModes = []types.BatteryMode{types.BatteryModeLoad}
+	}
+	return caps
+}
+
+// franklinCapabilities supports every mode and following a schedule.
+func franklinCapabilities() types.ESSCapabilities {
+	caps := types.AllESSCapabilities()
+	caps.Schedule = true
+	return caps
+}
+
 // Authenticate logs into franklin and fetches the default gateway if its not
 // filled in. If a valid token is already stored in creds, it is restored to
 // avoid an unnecessary login round-trip. A fresh login is only performed when
@@ -109,6 +143,7 @@ func (f *Franklin) Authenticate(ctx context.Context, creds types.Credentials) (t
 	defer f.mu.Unlock()
 
 	var changed bool
+	firstAuth := f.username == ""
 
 	// If raw password is provided, hash it inside backend to handle it securely
 	// and avoid frontend dependencies.
@@ -170,6 +205,21 @@ func (f *Franklin) Authenticate(ctx context.Context, creds types.Credentials) (t
 		return creds, false, fmt.Errorf("credential validation failed: %w", err)
 	}
 
+	// pick up the grid feed limit we lifted before a restart and save it once
+	// we lift it or put it back
+	if firstAuth && f.exportGridFeedMax == nil {
+		f.exportGridFeedMax = creds.Franklin.ExportGridFeedMax
+	}
+	if saved := creds.Franklin.ExportGridFeedMax; (saved == nil) != (f.exportGridFeedMax == nil) || (saved != nil && *saved != *f.exportGridFeedMax) {
+		var limit *float64
+		if f.exportGridFeedMax != nil {
+			v := *f.exportGridFeedMax
+			limit = &v
+		}
+		creds.Franklin.ExportGridFeedMax = limit
+		changed = true
+	}
+
 	return creds, changed, nil
 }
 
@@ -331,7 +381,7 @@ func (f *Franklin) doRequest(req *http.Request, dest interface{}) error {
 				}
 				continue
 			}
-			return fmt.Errorf("status %d", resp.StatusCode)
+			return fmt.Errorf("franklin api error: %w", common.NewStatusError(resp))
 		}
 
 		body, err := io.ReadAll(resp.Body)
@@ -404,6 +454,8 @@ func (f *Franklin) getRuntimeData(ctx context.Context) (deviceCompositeInfoResul
 		slog.Float64("gridKW", res.RuntimeData.PowerGrid),
 		slog.Float64("loadKW", res.RuntimeData.PowerLoad),
 		slog.Float64("batteryKW", res.RuntimeData.PowerBattery),
+		slog.Float64("generatorKW", res.RuntimeData.PowerGenerator),
+		slog.Int("runStatus", res.RuntimeData.RunStatus),
 		slog.Int("alarms", len(res.CurrentAlarmList)),
 		slog.Int("mode", res.RuntimeData.TOUID),
 	)
@@ -468,6 +520,60 @@ func (f *Franklin) getDeviceInfoWithCache(ctx context.Context) (deviceInfoV2Resu
 	return di, nil
 }
 
+// getPowerCapConfigList returns the charge and discharge limits of the
+// gateway for each number of aPowers.
+func (f *Franklin) getPowerCapConfigList(ctx context.Context) ([]powerCapConfig, error) {
+	params := url.Values{}
+	params.Set("gatewayId", f.gatewayID)
+
+	req, err := f.newGetRequest(ctx, "hes-gateway/common/getPowerCapConfigList", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []powerCapConfig
+	if err := f.doRequest(req, &res); err != nil {
+		return nil, fmt.Errorf("getPowerCapConfigList failed: %w", err)
+	}
+	return res, nil
+}
+
+// getPowerCapConfigListWithCache returns the cached power capability config
+// if still fresh, otherwise fetches it from the API and updates the cache.
+// Failures are cached for a few minutes so a gateway without the endpoint
+// isn't asked on every status. Must be called with f.mu held.
+func (f *Franklin) getPowerCapConfigListWithCache(ctx context.Context) ([]powerCapConfig, error) {
+	if time.Now().Before(f.powerCapExpiry) {
+		return f.powerCapCache, f.powerCapErr
+	}
+	f.powerCapCache, f.powerCapErr = f.getPowerCapConfigList(ctx)
+	if f.powerCapErr != nil {
+		f.powerCapExpiry = time.Now().Add(5 * time.Minute)
+	} else {
+		f.powerCapExpiry = time.Now().Add(time.Hour)
+	}
+	return f.powerCapCache, f.powerCapErr
+}
+
+// batteryPowerLimits returns the max charge and discharge power of the
+// batteries. If the gateway's config can't be fetched or doesn't include the
+// number of batteries then it's estimated from the number of batteries.
+func (f *Franklin) batteryPowerLimits(ctx context.Context, batteries int) (chargeKW, dischargeKW float64) {
+	configs, err := f.getPowerCapConfigListWithCache(ctx)
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to get franklin power cap config, estimating", slog.Any("error", err))
+	}
+	for _, c := range configs {
+		if c.BatteryCount == batteries && c.MaxChargeKW > 0 && c.MaxDischargeKW > 0 {
+			return c.MaxChargeKW, c.MaxDischargeKW
+		}
+	}
+	if err == nil {
+		log.Ctx(ctx).WarnContext(ctx, "franklin power cap config missing battery count, estimating", slog.Int("batteries", batteries), slog.Int("configs", len(configs)))
+	}
+	return 8 * float64(batteries), 10 * float64(batteries)
+}
+
 // GetStatus returns the status of the franklin system
 func (f *Franklin) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 	log.Ctx(ctx).DebugContext(ctx, "getting franklin system status")
@@ -497,6 +603,12 @@ func (f *Franklin) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 	if err != nil {
 		return types.SystemStatus{}, err
 	}
+	f.reserveSOCLocked = modes.selfConsumption != (franklinMode{}) && !modes.selfConsumption.CanEditReserveSOC
+
+	maxChargeKW, maxDischargeKW := f.batteryPowerLimits(ctx, len(rd.RuntimeData.EachSOC))
+
+	// run statuses 5-7 are off-grid
+	gridOutage := rd.RuntimeData.RunStatus >= 5 && rd.RuntimeData.RunStatus <= 7
 
 	var alarms []types.SystemAlarm
 	for _, alarm := range rd.CurrentAlarmList {
@@ -515,6 +627,11 @@ func (f *Franklin) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 		if alarm.Name == "SIM card not inserted" {
 			continue
 		}
+		// an outage isn't a fault with the system itself
+		if isGridOutageAlarm(alarm.Name) {
+			gridOutage = true
+			continue
+		}
 
 		alarms = append(alarms, types.SystemAlarm{
 			Name:        alarm.Name,
@@ -532,6 +649,9 @@ func (f *Franklin) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 	}
 
 	stormHedge := rd.RuntimeData.TOUID == 6
+	if gridOutage {
+		log.Ctx(ctx).InfoContext(ctx, "franklin is off-grid", slog.Int("runStatus", rd.RuntimeData.RunStatus), slog.Float64("generatorKW", rd.RuntimeData.PowerGenerator))
+	}
 
 	var storms []types.Storm
 	if stormHedge {
@@ -573,6 +693,8 @@ func (f *Franklin) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 		SolarKW:                 rd.RuntimeData.PowerSolar,
 		GridKW:                  rd.RuntimeData.PowerGrid,
 		HomeKW:                  rd.RuntimeData.PowerLoad,
+		GeneratorKW:             rd.RuntimeData.PowerGenerator,
+		GridOutage:              gridOutage,
 		BatteryCapacityKWH:      di.TotalBatteryCapacityKWH,
 		EmergencyMode:           stormHedge || modes.currentMode.WorkMode == 3,
 		CanExportSolar:          pc.GridFeedMaxFlag == GridFeedMaxFlagSolarOnly || pc.GridFeedMaxFlag == GridFeedMaxFlagBatteryAndSolar,
@@ -581,16 +703,31 @@ func (f *Franklin) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 		ElevatedMinBatterySOC:   modes.currentMode.ReserveSOC > 0 && modes.currentMode.ReserveSOC > f.settings.MinBatterySOC,
 		BatteryAboveMinSOC:      rd.RuntimeData.SOC >= modes.currentMode.ReserveSOC,
 		BatteryChargingDisabled: batteryChargingDisabled,
+		BatteryTempC:            rd.RuntimeData.AmbientTemp,
+		ExportLimitLifted:       f.exportGridFeedMax != nil && pc.GridFeedMaxFlag != GridFeedMaxFlagNoExport,
 
-		// TODO: get this from hes-gateway/common/getPowerCapConfigList
-		MaxBatteryChargeKW:    8 * float64(len(rd.RuntimeData.EachSOC)),
-		MaxBatteryDischargeKW: 10 * float64(len(rd.RuntimeData.EachSOC)),
+		MaxBatteryChargeKW:    maxChargeKW,
+		MaxBatteryDischargeKW: maxDischargeKW,
+		MaxGridImportKW:       pc.gridImportLimitKW(),
+		MaxGridExportKW:       pc.gridExportLimitKW(),
 
 		Alarms: alarms,
 		Storms: storms,
 	}, nil
 }
 
+// isGridOutageAlarm returns true if the alarm is raised because the grid is
+// down.
+func isGridOutageAlarm(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"grid outage", "grid loss", "off-grid", "off grid"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *Franklin) getPowerControl(ctx context.Context) (getPowerControlSettingResult, error) {
 	params := url.Values{}
 	params.Set("gatewayId", f.gatewayID)
@@ -654,6 +791,7 @@ func (f *Franklin) setPowerControl(ctx context.Context, pc getPowerControlSettin
 type availableModes struct {
 	list              []franklinMode
 	selfConsumption   franklinMode
+	timeOfUse         franklinMode
 	currentMode       franklinMode
 	stormHedgeEnabled int
 }
@@ -674,6 +812,7 @@ func (f *Franklin) getAvailableModes(ctx context.Context) (availableModes, error
 	}
 
 	var sc franklinMode
+	var tou franklinMode
 	var current franklinMode
 	foundIDs := make([]string, len(res.List))
 	modes := make([]franklinMode, len(res.List))
@@ -690,6 +829,9 @@ func (f *Franklin) getAvailableModes(ctx context.Context) (availableModes, error
 		switch item.WorkMode {
 		case 1: // time-of-use
 			modes[i] = m
+			if tou == (franklinMode{}) {
+				tou = modes[i]
+			}
 		case 2: // self consumption
 			modes[i] = m
 			sc = modes[i]
@@ -730,6 +872,7 @@ func (f *Franklin) getAvailableModes(ctx context.Context) (availableModes, error
 	return availableModes{
 		list:              modes,
 		selfConsumption:   sc,
+		timeOfUse:         tou,
 		stormHedgeEnabled: res.StormHedgeEnabled,
 		currentMode:       current,
 	}, nil
@@ -856,6 +999,19 @@ func (f *Franklin) SetModes(ctx context.Context, bat types.BatteryMode, sol type
 				updatedPC = true
 			}
 		}
+	case types.BatteryModeExport:
+		// exporting works like load except the battery is also allowed to feed
+		// the grid, which is done below after the solar mode is applied so the
+		// solar mode can't turn it back off
+		if !f.settings.GridExportBatteries {
+			return errors.New("exporting the battery is disabled in settings")
+		}
+		soc = minBatterySOC
+		updatedModeOrSOC = true
+		if pc.GridMaxFlag != GridMaxFlagNoChargeFromGrid {
+			pc.GridMaxFlag = GridMaxFlagNoChargeFromGrid
+			updatedPC = true
+		}
 	case types.BatteryModeStandby:
 		rd, err := f.getRuntimeData(ctx)
 		if err != nil {
@@ -916,6 +1072,25 @@ func (f *Franklin) SetModes(ctx context.Context, bat types.BatteryMode, sol type
 		return fmt.Errorf("unknown solar mode: %v", sol)
 	}
 
+	switch bat {
+	case types.BatteryModeExport:
+		// let the battery and solar feed the grid without any limit
+		if pc.GridFeedMaxFlag != GridFeedMaxFlagBatteryAndSolar || pc.GridFeedMax >= 0 {
+			if f.exportGridFeedMax == nil {
+				prev := pc.GridFeedMax
+				f.exportGridFeedMax = &prev
+			}
+			pc.GridFeedMaxFlag = GridFeedMaxFlagBatteryAndSolar
+			pc.GridFeedMax = -1
+			updatedPC = true
+		}
+	case types.BatteryModeNoChange:
+	default:
+		if f.restoreGridFeedMax(&pc) {
+			updatedPC = true
+		}
+	}
+
 	if updatedModeOrSOC {
 		if f.settings.DryRun {
 			if alreadySC {
@@ -996,6 +1171,250 @@ func (f *Franklin) SetModes(ctx context.Context, bat types.BatteryMode, sol type
 	return nil
 }
 
+// restoreGridFeedMax puts back the user's grid feed limit once we're done
+// exporting the battery and returns whether pc changed. The limit isn't sent
+// while export is off so it has to wait until it's turned back on.
+func (f *Franklin) restoreGridFeedMax(pc *getPowerControlSettingResult) bool {
+	if f.exportGridFeedMax == nil || pc.GridFeedMaxFlag == GridFeedMaxFlagNoExport {
+		return false
+	}
+	changed := pc.GridFeedMax != *f.exportGridFeedMax
+	pc.GridFeedMax = *f.exportGridFeedMax
+	f.exportGridFeedMax = nil
+	return changed
+}
+
+// SetSchedule saves the blocks as the gateway's time-of-use schedule and
+// switches to the time-of-use mode so the gateway keeps following the plan
+// if we stop sending updates. The schedule repeats daily so only the first 24
+// hours are used and anything not covered uses self consumption.
+func (f *Franklin) SetSchedule(ctx context.Context, blocks []types.ScheduleBlock) error {
+	log.Ctx(ctx).DebugContext(ctx, "SetSchedule called", slog.Int("blocks", len(blocks)))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(blocks) == 0 {
+		return errors.New("empty schedule")
+	}
+
+	if err := f.ensureLogin(ctx); err != nil {
+		return err
+	}
+
+	rd, err := f.getRuntimeData(ctx)
+	if err != nil {
+		return err
+	}
+	// 6 means storm hedge
+	if rd.RuntimeData.TOUID == 6 {
+		log.Ctx(ctx).InfoContext(ctx, "device is in storm hedge mode, skipping set schedule")
+		return errors.New("device is in storm hedge mode")
+	}
+
+	di, err := f.getDeviceInfoWithCache(ctx)
+	if err != nil {
+		return err
+	}
+
+	modes, err := f.getAvailableModes(ctx)
+	if err != nil {
+		return err
+	}
+	if modes.currentMode.WorkMode == 3 {
+		log.Ctx(ctx).InfoContext(ctx, "device is in backup mode, skipping set schedule")
+		return errors.New("device is in backup mode")
+	}
+	if modes.timeOfUse == (franklinMode{}) {
+		log.Ctx(ctx).ErrorContext(ctx, "time of use mode not available", slog.Any("modes", modes))
+		return errors.New("time of use mode not available")
+	}
+	tou := modes.timeOfUse
+	alreadyTOU := tou.ID == modes.currentMode.ID
+
+	details := franklinScheduleDetails(blocks, di.location)
+	if alreadyTOU && equalTOUScheduleDetails(details, f.schedule) {
+		log.Ctx(ctx).DebugContext(ctx, "franklin schedule unchanged")
+		return nil
+	}
+
+	pc, err := f.getPowerControl(ctx)
+	if err != nil {
+		return err
+	}
+	var updatedPC bool
+	wantGridMaxFlag := GridMaxFlagNoChargeFromGrid
+	if f.settings.GridChargeBatteries {
+		wantGridMaxFlag = GridMaxFlagChargeFromGrid
+	}
+	wantGridFeedMaxFlag := GridFeedMaxFlagNoExport
+	if f.settings.GridExportSolar {
+		wantGridFeedMaxFlag = GridFeedMaxFlagSolarOnly
+	}
+	if f.settings.GridExportBatteries {
+		for _, d := range details {
+			if d.DispatchID == franklinDispatchExport {
+				wantGridFeedMaxFlag = GridFeedMaxFlagBatteryAndSolar
+				break
+			}
+		}
+	}
+	if pc.GridMaxFlag != wantGridMaxFlag || pc.GridFeedMaxFlag != wantGridFeedMaxFlag {
+		pc.GridMaxFlag = wantGridMaxFlag
+		pc.GridFeedMaxFlag = wantGridFeedMaxFlag
+		updatedPC = true
+	}
+	// the schedule exports within the user's limit
+	if f.restoreGridFeedMax(&pc) {
+		updatedPC = true
+	}
+
+	minBatterySOC := f.settings.MinBatterySOC
+	if minBatterySOC < 5 {
+		minBatterySOC = 5
+	}
+
+	if f.settings.DryRun {
+		log.Ctx(ctx).DebugContext(
+			ctx,
+			"dry run: would've set franklin schedule",
+			slog.Any("schedule", details),
+			slog.Bool("alreadyTOU", alreadyTOU),
+			slog.Bool("updatedPC", updatedPC),
+		)
+		return nil
+	}
+
+	log.Ctx(ctx).InfoContext(ctx, "updating franklin schedule", slog.Any("schedule", details))
+	req, err := f.newPostJSONRequest(ctx, "hes-gateway/terminal/tou/saveTouDispatchV2", touScheduleRequest{
+		GatewayID:  f.gatewayID,
+		TouID:      tou.ID,
+		DetailList: details,
+	})
+	if err != nil {
+		return err
+	}
+	if err := f.doRequest(req, &struct{}{}); err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to save schedule", slog.Any("error", err))
+		return err
+	}
+
+	if !alreadyTOU {
+		log.Ctx(ctx).InfoContext(ctx, "switching franklin to time of use mode", slog.Int("id", tou.ID))
+		data := url.Values{}
+		data.Set("gatewayId", f.gatewayID)
+		data.Set("currendId", fmt.Sprint(tou.ID)) // yes, this is misspelled
+		data.Set("workMode", fmt.Sprint(tou.WorkMode))
+		data.Set("electricityType", fmt.Sprint(tou.ElectricityType))
+		data.Set("oldIndex", fmt.Sprint(tou.OldIndex))
+		data.Set("stromEn", fmt.Sprint(modes.stormHedgeEnabled))
+		data.Set("soc", strconv.Itoa(int(math.Round(minBatterySOC))))
+		req, err := f.newPostQueryRequest(ctx, "hes-gateway/terminal/tou/updateTouModeV2", data)
+		if err != nil {
+			return err
+		}
+		if err := f.doRequest(req, &struct{}{}); err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to update tou mode", slog.Any("error", err))
+			return err
+		}
+	}
+
+	if updatedPC {
+		if err := f.setPowerControl(ctx, pc); err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to set power control", slog.Any("error", err))
+			return err
+		}
+	}
+
+	f.schedule = details
+	return nil
+}
+
+// franklinDispatchFor returns what the gateway should do for a battery mode.
+// Solar export is controlled by the power control flags instead.
+func franklinDispatchFor(mode types.BatteryMode) franklinDispatch {
+	switch mode {
+	case types.BatteryModeChargeAny:
+		return franklinDispatchGridCharge
+	case types.BatteryModeChargeSolar:
+		return franklinDispatchSolarCharge
+	case types.BatteryModeStandby:
+		return franklinDispatchStandby
+	case types.BatteryModeExport:
+		return franklinDispatchExport
+	default:
+		return franklinDispatchSelfConsumption
+	}
+}
+
+// franklinScheduleDetails converts the blocks into a daily schedule in the
+// gateway's timezone sorted by time of day. Blocks that cross midnight are
+// split and any time not covered in the day after the first block uses self
+// consumption.
+func franklinScheduleDetails(blocks []types.ScheduleBlock, loc *time.Location) []touScheduleDetail {
+	dayStart := blocks[0].Start.In(loc)
+	dayEnd := dayStart.Add(24 * time.Hour)
+
+	type segment struct {
+		start, end time.Time
+		dispatch   franklinDispatch
+	}
+	var segments []segment
+	covered := dayStart
+	for _, b := range blocks {
+		start := b.Start.In(loc)
+		end := b.End.In(loc)
+		if start.Before(covered) {
+			start = covered
+		}
+		if end.After(dayEnd) {
+			end = dayEnd
+		}
+		if !end.After(start) {
+			continue
+		}
+		segments = append(segments, segment{start: start, end: end, dispatch: franklinDispatchFor(b.BatteryMode)})
+		covered = end
+	}
+	if covered.Before(dayEnd) {
+		segments = append(segments, segment{start: covered, end: dayEnd, dispatch: franklinDispatchSelfConsumption})
+	}
+
+	var details []touScheduleDetail
+	for _, seg := range segments {
+		for start := seg.start; start.Before(seg.end); {
+			midnight := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, loc)
+			end := seg.end
+			endStr := end.Format("15:04")
+			if !end.Before(midnight) {
+				end = midnight
+				endStr = "24:00"
+			}
+			details = append(details, touScheduleDetail{
+				StartHourTime: start.Format("15:04"),
+				EndHourTime:   endStr,
+				DispatchID:    seg.dispatch,
+			})
+			start = end
+		}
+	}
+	sort.Slice(details, func(i, j int) bool {
+		return details[i].StartHourTime < details[j].StartHourTime
+	})
+	return details
+}
+
+func equalTOUScheduleDetails(a, b []touScheduleDetail) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // GetEnergyHistory retrieves energy history for the specified period.
 // It aggregates 5-minute intervals into hourly EnergyStats.
 func (f *Franklin) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
@@ -1136,6 +1555,15 @@ func (f *Franklin) getEnergyStatsForDay(ctx context.Context, day time.Time, loc
 		log.Ctx(ctx).WarnContext(ctx, "socArray length unexpected", slog.Int("expected", expLen), slog.Int("length", len(res.SOCArray)))
 		return nil, errors.New("unexpected array length in response")
 	}
+	// the generator arrays are left out entirely without a generator
+	if len(res.GeneratorToHomeKWHRates) > 0 && len(res.GeneratorToHomeKWHRates) != expLen {
+		log.Ctx(ctx).WarnContext(ctx, "powerGenHomeArray length unexpected", slog.Int("expected", expLen), slog.Int("length", len(res.GeneratorToHomeKWHRates)))
+		return nil, errors.New("unexpected array length in response")
+	}
+	if len(res.GeneratorToBatteryKWHRates) > 0 && len(res.GeneratorToBatteryKWHRates) != expLen {
+		log.Ctx(ctx).WarnContext(ctx, "powerGenFhpArray length unexpected", slog.Int("expected", expLen), slog.Int("length", len(res.GeneratorToBatteryKWHRates)))
+		return nil, errors.New("unexpected array length in response")
+	}
 
 	for i, timeStr := range res.DeviceTimeArray {
 		t, err := time.ParseInLocation("2006-01-02 15:04:05", timeStr, loc)
@@ -1180,13 +1608,26 @@ func (f *Franklin) getEnergyStatsForDay(ctx context.Context, day time.Time, loc
 		gridToHome := res.GridToHomeKWHRates[i] * (duration.Hours())
 		batToGrid := res.BatteryToGridKWHRates[i] * (duration.Hours())
 		batToHome := res.BatteryToHomeKWHRates[i] * (duration.Hours())
+		var genToHome, genToBat float64
+		if len(res.GeneratorToHomeKWHRates) > 0 {
+			genToHome = res.GeneratorToHomeKWHRates[i] * (duration.Hours())
+		}
+		if len(res.GeneratorToBatteryKWHRates) > 0 {
+			genToBat = res.GeneratorToBatteryKWHRates[i] * (duration.Hours())
+		}
 
 		s.SolarKWH += (solarToHome + solarToGrid + solarToBat)
-		s.BatteryChargedKWH += (solarToBat + gridToBat)
+		s.BatteryChargedKWH += (solarToBat + gridToBat + genToBat)
 		s.BatteryUsedKWH += (batToHome + batToGrid)
 		s.GridExportKWH += (solarToGrid + batToGrid)
 		s.GridImportKWH += (gridToHome + gridToBat)
-		s.HomeKWH += (solarToHome + gridToHome + batToHome)
+		s.HomeKWH += (solarToHome + gridToHome + batToHome + genToHome)
+		s.GeneratorKWH += (genToHome + genToBat)
+		s.GeneratorToHomeKWH += genToHome
+		s.GeneratorToBatteryKWH += genToBat
+		if genToHome+genToBat > 0 {
+			s.GeneratorHours += duration.Hours()
+		}
 		s.BatteryToHomeKWH += batToHome
 		s.BatteryToGridKWH += batToGrid
 		s.SolarToHomeKWH += solarToHome
@@ -1267,12 +1708,15 @@ type runtimeData struct {
 	TotalGenerator        float64 `json:"kwh_gen"`
 	TotalLoad             float64 `json:"kwh_load"`
 
+	// AmbientTemp is the ambient temperature (in °C) at the aGate which is
+	// the closest the runtime data has to the battery temperature
+	AmbientTemp *float64 `json:"t_amb"`
+
 	GridChargedBattery  float64 `json:"gridChBat"`
 	BatteryOutGrid      float64 `json:"batOutGrid"`
 	SolarOutGrid        float64 `json:"soOutGrid"`
 	SolarChargedBattery float64 `json:"soChBat"`
 
-	// TODO: t_amb? temperature?
 	// TODO: kwhSolarLoad, kwhGridLoad, kwhFhpLoad, kwhGenLoad
 	// TODO: solarPower (seems to be 10x p_sun?)
 }
@@ -1326,6 +1770,54 @@ type getPowerControlSettingResult struct {
 	// TODO: isNem3, isCalifornia
 }
 
+// gridImportLimitKW returns the user's grid import limit, 0 if unlimited.
+func (pc getPowerControlSettingResult) gridImportLimitKW() float64 {
+	if pc.GridMaxFlag != GridMaxFlagChargeFromGrid || pc.GridMax <= 0 {
+		return 0
+	}
+	return pc.GridMax
+}
+
+// gridExportLimitKW returns the user's grid export limit, 0 if unlimited.
+func (pc getPowerControlSettingResult) gridExportLimitKW() float64 {
+	if pc.GridFeedMaxFlag == GridFeedMaxFlagNoExport || pc.GridFeedMax <= 0 {
+		return 0
+	}
+	return pc.GridFeedMax
+}
+
+// powerCapConfig is the power capability of a gateway with a given number of
+// aPowers.
+type powerCapConfig struct {
+	BatteryCount   int     `json:"apowerNum"`
+	MaxChargeKW    float64 `json:"chargePowerMax"`
+	MaxDischargeKW float64 `json:"dischargePowerMax"`
+}
+
+// franklinDispatch is what the gateway does during a time-of-use period.
+// TODO: validate these against more gateways
+type franklinDispatch int
+
+const (
+	franklinDispatchExport          franklinDispatch = 2
+	franklinDispatchSolarCharge     franklinDispatch = 3
+	franklinDispatchSelfConsumption franklinDispatch = 6
+	franklinDispatchStandby         franklinDispatch = 7
+	franklinDispatchGridCharge      franklinDispatch = 8
+)
+
+type touScheduleRequest struct {
+	GatewayID  string              `json:"gatewayId"`
+	TouID      int                 `json:"touId"`
+	DetailList []touScheduleDetail `json:"detailVoList"`
+}
+
+type touScheduleDetail struct {
+	StartHourTime string           `json:"startHourTime"`
+	EndHourTime   string           `json:"endHourTime"`
+	DispatchID    franklinDispatch `json:"dispatchId"`
+}
+
 type gatewayTouListV2Result struct {
 	CurrentID int       `json:"currendId"` // yes, it's misspelled
 	List      []touItem `json:"list"`
@@ -1369,7 +1861,11 @@ type fhpPowerByDayResult struct {
 	BatteryToGridKWHRates []float64 `json:"powerFhpGirdArray"` // misspelled
 	BatteryToHomeKWHRates []float64 `json:"powerFhpHomeArray"`
 
-	// Generators and V2L ignored for now
+	// only present when a generator is connected to the aGate
+	GeneratorToHomeKWHRates    []float64 `json:"powerGenHomeArray"`
+	GeneratorToBatteryKWHRates []float64 `json:"powerGenFhpArray"`
+
+	// V2L ignored for now
 }
 
 type homeGateway struct {
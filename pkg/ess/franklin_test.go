@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/raterudder/raterudder/pkg/common"
 	"github.com/raterudder/raterudder/pkg/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -158,9 +159,13 @@ func TestFranklin(t *testing.T) {
 			settings:    types.Settings{MinBatterySOC: 10},
 		}
 
+		assert.Equal(t, franklinCapabilities(), f.Capabilities(), "Capabilities should default to everything")
+
 		status, err := f.GetStatus(context.Background())
 		require.NoError(t, err, "GetStatus should succeed")
 
+		// the self consumption mode doesn't allow editing the reserve
+		assert.Equal(t, []types.BatteryMode{types.BatteryModeLoad}, f.Capabilities().BatteryModes, "Only Load should be supported")
 		assert.Equal(t, 88.5, status.BatterySOC, "BatterySOC should match")
 		assert.Equal(t, 30.0, status.BatteryCapacityKWH, "BatteryCapacityKWH should match")
 		assert.True(t, status.CanExportSolar, "CanExportSolar should be true")
@@ -170,6 +175,102 @@ func TestFranklin(t *testing.T) {
 		assert.True(t, status.BatteryAboveMinSOC, "BatteryAboveMinSOC should be true")
 	})
 
+	t.Run("GetStatus Replay", func(t *testing.T) {
+		// hand-written in the format -http-record-dir records
+		replay, err := common.NewReplayTransport("testdata/franklin/status.json")
+		require.NoError(t, err)
+
+		f := &Franklin{
+			client:      &http.Client{Transport: replay},
+			baseURL:     "https://energy.franklinwh.com",
+			username:    "u",
+			md5Password: "p",
+			gatewayID:   "g",
+			settings:    types.Settings{MinBatterySOC: 10},
+		}
+
+		status, err := f.GetStatus(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 88.5, status.BatterySOC)
+		assert.Equal(t, 30.0, status.BatteryCapacityKWH)
+		assert.True(t, status.ElevatedMinBatterySOC)
+		assert.Empty(t, replay.Unused(), "every recorded request should be made")
+	})
+
+	t.Run("GetStatus Power Limits", func(t *testing.T) {
+		capCalls := 0
+		capFails := false
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/hes-gateway/terminal/getDeviceInfoV2":
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"totalCap": 27.2}})
+			case "/hes-gateway/terminal/tou/getPowerControlSetting":
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"code":    200,
+					"success": true,
+					"result":  map[string]interface{}{"gridMaxFlag": 2, "gridMax": 7.5, "gridFeedMaxFlag": 2, "gridFeedMax": 4.0},
+				})
+			case "/hes-gateway/terminal/tou/getGatewayTouListV2":
+				list := []map[string]interface{}{{"id": 1.0, "workMode": 2, "editSocFlag": true}}
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"list": list, "currendId": 1.0}})
+			case "/hes-gateway/terminal/getDeviceCompositeInfo":
+				runtimeData := map[string]interface{}{"soc": 50.0, "fhpSoc": []float64{50.0, 50.0}}
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"runtimeData": runtimeData}})
+			case "/hes-gateway/common/getPowerCapConfigList":
+				capCalls++
+				if capFails {
+					json.NewEncoder(w).Encode(map[string]interface{}{"code": 500, "success": false, "message": "server error"})
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"code":    200,
+					"success": true,
+					"result": []map[string]interface{}{
+						{"apowerNum": 1, "chargePowerMax": 5.0, "dischargePowerMax": 5.0},
+						{"apowerNum": 2, "chargePowerMax": 10.0, "dischargePowerMax": 10.0},
+					},
+				})
+			default:
+				http.Error(w, "not found: "+r.URL.Path, 404)
+			}
+		}))
+		defer ts.Close()
+
+		f := &Franklin{
+			client:    ts.Client(),
+			baseURL:   ts.URL,
+			tokenStr:  "tok",
+			gatewayID: "g",
+		}
+
+		status, err := f.GetStatus(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 10.0, status.MaxBatteryChargeKW)
+		assert.Equal(t, 10.0, status.MaxBatteryDischargeKW)
+		assert.Equal(t, 7.5, status.MaxGridImportKW)
+		assert.Equal(t, 4.0, status.MaxGridExportKW)
+
+		// the config is cached
+		_, err = f.GetStatus(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, capCalls)
+
+		// fall back to the estimate if the endpoint fails
+		f.powerCapExpiry = time.Time{}
+		capFails = true
+		status, err = f.GetStatus(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 2, capCalls)
+		assert.Equal(t, 16.0, status.MaxBatteryChargeKW)
+		assert.Equal(t, 20.0, status.MaxBatteryDischargeKW)
+
+		// the failure is cached too
+		status, err = f.GetStatus(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 2, capCalls)
+		assert.Equal(t, 16.0, status.MaxBatteryChargeKW)
+	})
+
 	t.Run("GetStatus Alarms", func(t *testing.T) {
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path == "/hes-gateway/terminal/initialize/appUserOrInstallerLogin" {
@@ -933,6 +1034,70 @@ func TestFranklin(t *testing.T) {
 		assert.Equal(t, 50.0, s.MaxBatterySOC, "MaxBatterySOC mismatch")
 	})
 
+	t.Run("GetEnergyHistory Generator", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/hes-gateway/terminal/initialize/appUserOrInstallerLogin" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"token": "tok"}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/getDeviceInfoV2" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"zoneInfo": "UTC"}})
+				return
+			}
+			if r.URL.Path == "/api-energy/power/getFhpPowerByDay" {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"code":    200,
+					"success": true,
+					"result": map[string]interface{}{
+						"deviceTimeArray": []string{
+							"2026-02-01 12:00:00",
+							"2026-02-01 12:30:00",
+							"2026-02-01 13:00:00",
+						},
+						"socArray":            []float64{20.0, 30.0, 30.0},
+						"powerSolarHomeArray": []float64{0.0, 0.0, 0.0},
+						"powerFhpHomeArray":   []float64{0.0, 2.0, 0.0},
+						"powerSolarGirdArray": []float64{0.0, 0.0, 0.0},
+						"powerSolarFhpArray":  []float64{0.0, 0.0, 0.0},
+						"powerGirdFhpArray":   []float64{0.0, 0.0, 0.0},
+						"powerGirdHomeArray":  []float64{0.0, 0.0, 0.0},
+						"powerFhpGirdArray":   []float64{0.0, 0.0, 0.0},
+						// the generator only ran for the first half hour
+						"powerGenHomeArray": []float64{3.0, 0.0, 0.0},
+						"powerGenFhpArray":  []float64{4.0, 0.0, 0.0},
+					},
+				})
+				return
+			}
+			http.Error(w, "not found: "+r.URL.Path, 404)
+		}))
+		defer ts.Close()
+
+		f := &Franklin{
+			client:      ts.Client(),
+			baseURL:     ts.URL,
+			username:    "u",
+			md5Password: "p",
+			gatewayID:   "g",
+		}
+
+		start, _ := time.Parse(time.RFC3339, "2026-02-01T12:00:00Z")
+		end, _ := time.Parse(time.RFC3339, "2026-02-01T13:00:00Z")
+		stats, err := f.GetEnergyHistory(context.Background(), start, end)
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+
+		s := stats[0]
+		assert.InDelta(t, 3.5, s.GeneratorKWH, 0.01)
+		assert.InDelta(t, 1.5, s.GeneratorToHomeKWH, 0.01)
+		assert.InDelta(t, 2.0, s.GeneratorToBatteryKWH, 0.01)
+		assert.InDelta(t, 0.5, s.GeneratorHours, 0.01)
+		// generator and battery to home
+		assert.InDelta(t, 2.5, s.HomeKWH, 0.01)
+		assert.InDelta(t, 2.0, s.BatteryChargedKWH, 0.01)
+		assert.Zero(t, s.GridImportKWH)
+	})
+
 	t.Run("Authenticate", func(t *testing.T) {
 		t.Run("MD5HashRawPassword", func(t *testing.T) {
 			randomStr := "temp-token-md5"
@@ -1251,6 +1416,52 @@ func TestFranklin(t *testing.T) {
 			assert.Equal(t, "fresh-token-xyz", newCreds.Franklin.Token, "new token should be written back into credentials")
 			assert.Equal(t, "fresh-token-xyz", f.tokenStr)
 		})
+
+		t.Run("ExportGridFeedMax", func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/hes-gateway/terminal/getDeviceInfoV2" {
+					json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+					return
+				}
+				http.Error(w, "not found: "+r.URL.Path, 404)
+			}))
+			defer ts.Close()
+
+			f := &Franklin{client: ts.Client(), baseURL: ts.URL}
+			limit := 5.0
+			creds := types.Credentials{
+				Franklin: &types.FranklinCredentials{
+					Username:          "user@example.com",
+					MD5Password:       "pass",
+					GatewayID:         "gw1",
+					Token:             "tok",
+					ExportGridFeedMax: &limit,
+				},
+			}
+
+			// the limit lifted before a restart is picked up
+			creds, changed, err := f.Authenticate(context.Background(), creds)
+			require.NoError(t, err)
+			assert.False(t, changed)
+			require.NotNil(t, f.exportGridFeedMax)
+			assert.Equal(t, 5.0, *f.exportGridFeedMax)
+
+			// and cleared once it's put back
+			f.exportGridFeedMax = nil
+			creds, changed, err = f.Authenticate(context.Background(), creds)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Nil(t, creds.Franklin.ExportGridFeedMax)
+
+			// a newly lifted limit is saved
+			lifted := 7.0
+			f.exportGridFeedMax = &lifted
+			creds, changed, err = f.Authenticate(context.Background(), creds)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			require.NotNil(t, creds.Franklin.ExportGridFeedMax)
+			assert.Equal(t, 7.0, *creds.Franklin.ExportGridFeedMax)
+		})
 	})
 
 	t.Run("Token Retry", func(t *testing.T) {
@@ -1436,6 +1647,61 @@ func TestFranklin(t *testing.T) {
 		assert.Equal(t, expectedEnd.UTC(), status.Storms[0].TSEnd.UTC())
 	})
 
+	t.Run("GetStatus Grid Outage", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/hes-gateway/terminal/initialize/appUserOrInstallerLogin":
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"token": "tok"}})
+			case "/hes-gateway/terminal/getDeviceInfoV2":
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"totalCap": 30.0, "timeZone": "UTC"}})
+			case "/hes-gateway/terminal/tou/getPowerControlSetting":
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"gridMaxFlag": 0, "gridFeedMaxFlag": 0}})
+			case "/hes-gateway/terminal/tou/getGatewayTouListV2":
+				list := []map[string]interface{}{{"id": 1.0, "workMode": 1}}
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"list": list, "currendId": 1.0}})
+			case "/hes-gateway/terminal/getDeviceCompositeInfo":
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"code":    200,
+					"success": true,
+					"result": map[string]interface{}{
+						"runtimeData": map[string]interface{}{
+							"soc":        50.0,
+							"mode":       1,
+							"run_status": 7, // off-grid discharging
+							"p_gen":      4.5,
+							"t_amb":      -3.5,
+						},
+						"currentAlarmVOList": []map[string]interface{}{
+							{"logName": "Grid Outage", "alarmCode": "G01", "time": "2026-02-18 10:00:00"},
+						},
+					},
+				})
+			default:
+				http.Error(w, "not found: "+r.URL.Path, 404)
+			}
+		}))
+		defer ts.Close()
+
+		f := &Franklin{
+			client:      ts.Client(),
+			baseURL:     ts.URL,
+			username:    "u",
+			md5Password: "p",
+			gatewayID:   "g",
+			settings:    types.Settings{MinBatterySOC: 10},
+		}
+
+		status, err := f.GetStatus(context.Background())
+		require.NoError(t, err)
+		assert.True(t, status.GridOutage)
+		assert.False(t, status.EmergencyMode)
+		assert.Equal(t, 4.5, status.GeneratorKW)
+		require.NotNil(t, status.BatteryTempC)
+		assert.Equal(t, -3.5, *status.BatteryTempC)
+		// the outage isn't a fault
+		assert.Empty(t, status.Alarms)
+	})
+
 	t.Run("SetModes Both Solar and Battery Export", func(t *testing.T) {
 		var callOrder []string
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1493,4 +1759,200 @@ func TestFranklin(t *testing.T) {
 		require.Len(t, callOrder, 1, "setPowerControlV2 should be called")
 		assert.Equal(t, "setPowerControlV2", callOrder[0])
 	})
+
+	t.Run("SetModes Export", func(t *testing.T) {
+		var callOrder []string
+		powerControl := map[string]interface{}{"gridMaxFlag": 2, "gridFeedMaxFlag": 1, "gridFeedMax": 5.0}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/hes-gateway/terminal/getDeviceCompositeInfo" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/getGatewayTouListV2" {
+				list := []map[string]interface{}{
+					{"id": 20.0, "workMode": 2, "electricityType": 1, "editSocFlag": true, "soc": 50.0},
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"list": list, "currendId": 20.0}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/getPowerControlSetting" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": powerControl})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/updateSocV2" {
+				callOrder = append(callOrder, "updateSocV2")
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/setPowerControlV2" {
+				callOrder = append(callOrder, "setPowerControlV2")
+				powerControl = map[string]interface{}{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&powerControl))
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+				return
+			}
+			http.Error(w, "not found "+r.URL.Path, 404)
+		}))
+		defer ts.Close()
+
+		f := &Franklin{
+			client:    ts.Client(),
+			baseURL:   ts.URL,
+			tokenStr:  "tok",
+			gatewayID: "g",
+		}
+
+		require.NoError(t, f.ApplySettings(context.Background(), types.Settings{
+			MinBatterySOC:       20,
+			GridChargeBatteries: true,
+		}))
+		err := f.SetModes(context.Background(), types.BatteryModeExport, types.SolarModeNoExport)
+		assert.ErrorContains(t, err, "disabled in settings")
+		assert.Empty(t, callOrder)
+
+		require.NoError(t, f.ApplySettings(context.Background(), types.Settings{
+			MinBatterySOC:       20,
+			GridChargeBatteries: true,
+			GridExportBatteries: true,
+		}))
+		require.NoError(t, f.SetModes(context.Background(), types.BatteryModeExport, types.SolarModeNoExport))
+		assert.Equal(t, []string{"updateSocV2", "setPowerControlV2"}, callOrder)
+		assert.EqualValues(t, 1, powerControl["gridMaxFlag"], "grid charging should be disabled")
+		// the battery is allowed to export even though solar export is off
+		assert.EqualValues(t, 2, powerControl["gridFeedMaxFlag"])
+		assert.EqualValues(t, -1, powerControl["gridFeedMax"], "export should be unlimited")
+
+		// the user's export limit isn't sent while export is off
+		require.NoError(t, f.SetModes(context.Background(), types.BatteryModeStandby, types.SolarModeNoExport))
+		assert.EqualValues(t, 3, powerControl["gridFeedMaxFlag"])
+		assert.NotNil(t, f.exportGridFeedMax)
+
+		// and comes back once solar can export again
+		require.NoError(t, f.ApplySettings(context.Background(), types.Settings{
+			MinBatterySOC:       20,
+			GridChargeBatteries: true,
+			GridExportSolar:     true,
+		}))
+		require.NoError(t, f.SetModes(context.Background(), types.BatteryModeStandby, types.SolarModeAny))
+		assert.EqualValues(t, 1, powerControl["gridFeedMaxFlag"])
+		assert.EqualValues(t, 5, powerControl["gridFeedMax"])
+		assert.Nil(t, f.exportGridFeedMax)
+	})
+
+	t.Run("SetSchedule", func(t *testing.T) {
+		var callOrder []string
+		var saved touScheduleRequest
+		currentID := 20.0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/hes-gateway/terminal/getDeviceCompositeInfo" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/getDeviceInfoV2" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"zoneInfo": "America/Chicago"}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/getGatewayTouListV2" {
+				list := []map[string]interface{}{
+					{"id": 10.0, "workMode": 1, "electricityType": 1, "oldIndex": 1},
+					{"id": 20.0, "workMode": 2, "electricityType": 1, "editSocFlag": true, "soc": 50.0},
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"list": list, "currendId": currentID}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/getPowerControlSetting" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{"gridMaxFlag": 2, "gridFeedMaxFlag": 2, "gridFeedMax": 5.0}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/saveTouDispatchV2" {
+				callOrder = append(callOrder, "saveTouDispatchV2")
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&saved))
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/updateTouModeV2" {
+				callOrder = append(callOrder, "updateTouModeV2")
+				assert.Equal(t, "10", r.URL.Query().Get("currendId"))
+				assert.Equal(t, "1", r.URL.Query().Get("workMode"))
+				assert.Equal(t, "20", r.URL.Query().Get("soc"))
+				currentID = 10.0
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+				return
+			}
+			if r.URL.Path == "/hes-gateway/terminal/tou/setPowerControlV2" {
+				callOrder = append(callOrder, "setPowerControlV2")
+				var data map[string]interface{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&data))
+				assert.EqualValues(t, 2, data["gridMaxFlag"])
+				assert.EqualValues(t, 1, data["gridFeedMaxFlag"], "solar export only")
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "success": true, "result": map[string]interface{}{}})
+				return
+			}
+			http.Error(w, "not found "+r.URL.Path, 404)
+		}))
+		defer ts.Close()
+
+		f := &Franklin{
+			client:    ts.Client(),
+			baseURL:   ts.URL,
+			tokenStr:  "tok",
+			gatewayID: "g",
+		}
+		require.NoError(t, f.ApplySettings(context.Background(), types.Settings{
+			MinBatterySOC:       20,
+			GridChargeBatteries: true,
+			GridExportSolar:     true,
+		}))
+
+		loc, err := time.LoadLocation("America/Chicago")
+		require.NoError(t, err)
+		start := time.Date(2026, 3, 10, 14, 0, 0, 0, loc)
+		blocks := []types.ScheduleBlock{
+			{Start: start, End: start.Add(3 * time.Hour), BatteryMode: types.BatteryModeLoad, SolarMode: types.SolarModeAny},
+			{Start: start.Add(3 * time.Hour), End: start.Add(15 * time.Hour), BatteryMode: types.BatteryModeChargeAny, SolarMode: types.SolarModeNoExport},
+		}
+		require.NoError(t, f.SetSchedule(context.Background(), blocks))
+		assert.Equal(t, []string{"saveTouDispatchV2", "updateTouModeV2", "setPowerControlV2"}, callOrder)
+		assert.Equal(t, "g", saved.GatewayID)
+		assert.Equal(t, 10, saved.TouID)
+		assert.Equal(t, []touScheduleDetail{
+			{StartHourTime: "00:00", EndHourTime: "05:00", DispatchID: franklinDispatchGridCharge},
+			{StartHourTime: "05:00", EndHourTime: "14:00", DispatchID: franklinDispatchSelfConsumption},
+			{StartHourTime: "14:00", EndHourTime: "17:00", DispatchID: franklinDispatchSelfConsumption},
+			{StartHourTime: "17:00", EndHourTime: "24:00", DispatchID: franklinDispatchGridCharge},
+		}, saved.DetailList)
+
+		// the same schedule isn't saved again
+		callOrder = nil
+		require.NoError(t, f.SetSchedule(context.Background(), blocks))
+		assert.Empty(t, callOrder)
+	})
+}
+
+func TestFranklinScheduleDetails(t *testing.T) {
+	loc, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+	start := time.Date(2026, 3, 10, 22, 0, 0, 0, time.UTC) // 17:00 local
+
+	details := franklinScheduleDetails([]types.ScheduleBlock{
+		{Start: start, End: start.Add(2 * time.Hour), BatteryMode: types.BatteryModeExport},
+		{Start: start.Add(2 * time.Hour), End: start.Add(9 * time.Hour), BatteryMode: types.BatteryModeStandby},
+		{Start: start.Add(9 * time.Hour), End: start.Add(30 * time.Hour), BatteryMode: types.BatteryModeChargeSolar},
+	}, loc)
+	assert.Equal(t, []touScheduleDetail{
+		{StartHourTime: "00:00", EndHourTime: "02:00", DispatchID: franklinDispatchStandby},
+		{StartHourTime: "02:00", EndHourTime: "17:00", DispatchID: franklinDispatchSolarCharge},
+		{StartHourTime: "17:00", EndHourTime: "19:00", DispatchID: franklinDispatchExport},
+		{StartHourTime: "19:00", EndHourTime: "24:00", DispatchID: franklinDispatchStandby},
+	}, details)
+
+	// a single short block is padded with self consumption
+	details = franklinScheduleDetails([]types.ScheduleBlock{
+		{Start: start, End: start.Add(time.Hour), BatteryMode: types.BatteryModeChargeAny},
+	}, loc)
+	assert.Equal(t, []touScheduleDetail{
+		{StartHourTime: "00:00", EndHourTime: "17:00", DispatchID: franklinDispatchSelfConsumption},
+		{StartHourTime: "17:00", EndHourTime: "18:00", DispatchID: franklinDispatchGridCharge},
+		{StartHourTime: "18:00", EndHourTime: "24:00", DispatchID: franklinDispatchSelfConsumption},
+	}, details)
 }
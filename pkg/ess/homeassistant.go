@@ -0,0 +1,612 @@
+package ess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/common"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// haServiceCall is a Home Assistant service call from the credentials. Every
+// key other than service is sent as the service data.
+type haServiceCall struct {
+	Domain  string
+	Service string
+	Data    map[string]interface{}
+}
+
+// parseHAServiceCalls parses a service call or list of service calls, e.g.
+// {"service": "select.select_option", "entity_id": "select.mode", "option": "Backup"}.
+// An empty string returns no calls.
+func parseHAServiceCalls(s string) ([]haServiceCall, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var raw []map[string]interface{}
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &raw); err != nil {
+			return nil, fmt.Errorf("invalid service calls: %w", err)
+		}
+	} else {
+		var one map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &one); err != nil {
+			return nil, fmt.Errorf("invalid service call: %w", err)
+		}
+		raw = append(raw, one)
+	}
+
+	var calls []haServiceCall
+	for _, r := range raw {
+		name, _ := r["service"].(string)
+		domain, service, ok := strings.Cut(name, ".")
+		if !ok || domain == "" || service == "" {
+			return nil, fmt.Errorf("invalid service %q, expected domain.service", name)
+		}
+		delete(r, "service")
+		calls = append(calls, haServiceCall{Domain: domain, Service: service, Data: r})
+	}
+	return calls, nil
+}
+
+// haEntity is a configured entity. Entities prefixed with "-" in the
+// credentials have their values negated to match our sign conventions.
+type haEntity struct {
+	ID     string
+	Negate bool
+}
+
+func parseHAEntity(s string) (haEntity, error) {
+	s = strings.TrimSpace(s)
+	e := haEntity{ID: strings.TrimPrefix(s, "-"), Negate: strings.HasPrefix(s, "-")}
+	if e.ID == "" {
+		return e, nil
+	}
+	if domain, obj, ok := strings.Cut(e.ID, "."); !ok || domain == "" || obj == "" {
+		return e, fmt.Errorf("invalid entity id: %s", s)
+	}
+	return e, nil
+}
+
+// haUnitMultipliers convert entity units to kW, kWh or %.
+var haUnitMultipliers = map[string]float64{
+	"":    1,
+	"%":   1,
+	"W":   0.001,
+	"kW":  1,
+	"MW":  1000,
+	"Wh":  0.001,
+	"kWh": 1,
+	"MWh": 1000,
+}
+
+// haConfig is the parsed configuration from the credentials.
+type haConfig struct {
+	baseURL     string
+	token       string
+	capacityKWH float64
+	maxPowerKW  float64
+
+	soc, batteryPower, solarPower, gridPower, homePower haEntity
+
+	homeEnergy, solarEnergy, gridImportEnergy, gridExportEnergy haEntity
+	batteryChargedEnergy, batteryDischargedEnergy               haEntity
+
+	batteryModes map[types.BatteryMode][]haServiceCall
+	solarModes   map[types.SolarMode][]haServiceCall
+}
+
+// parseHABaseURL returns the Home Assistant URL without a trailing slash.
+func parseHABaseURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(strings.TrimSpace(raw), "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid home assistant url: %s", raw)
+	}
+	return u.String(), nil
+}
+
+func parseHAConfig(c *types.HomeAssistantCredentials) (haConfig, error) {
+	var cfg haConfig
+	var err error
+	cfg.baseURL, err = parseHABaseURL(c.URL)
+	if err != nil {
+		return cfg, err
+	}
+	if c.Token == "" {
+		return cfg, errors.New("missing home assistant token")
+	}
+	cfg.token = c.Token
+
+	cfg.capacityKWH, err = strconv.ParseFloat(strings.TrimSpace(c.CapacityKWH), 64)
+	if err != nil || cfg.capacityKWH <= 0 {
+		return cfg, fmt.Errorf("invalid battery capacity: %q", c.CapacityKWH)
+	}
+	if c.MaxPowerKW != "" {
+		cfg.maxPowerKW, err = strconv.ParseFloat(strings.TrimSpace(c.MaxPowerKW), 64)
+		if err != nil || cfg.maxPowerKW < 0 {
+			return cfg, fmt.Errorf("invalid max power: %q", c.MaxPowerKW)
+		}
+	}
+
+	for _, e := range []struct {
+		dest     *haEntity
+		value    string
+		name     string
+		required bool
+	}{
+		{&cfg.soc, c.SOCEntity, "battery SOC", true},
+		{&cfg.batteryPower, c.BatteryPowerEntity, "battery power", true},
+		{&cfg.gridPower, c.GridPowerEntity, "grid power", true},
+		{&cfg.solarPower, c.SolarPowerEntity, "solar power", false},
+		{&cfg.homePower, c.HomePowerEntity, "home power", false},
+		{&cfg.homeEnergy, c.HomeEnergyEntity, "home energy", false},
+		{&cfg.solarEnergy, c.SolarEnergyEntity, "solar energy", false},
+		{&cfg.gridImportEnergy, c.GridImportEnergyEntity, "grid import energy", false},
+		{&cfg.gridExportEnergy, c.GridExportEnergyEntity, "grid export energy", false},
+		{&cfg.batteryChargedEnergy, c.BatteryChargedEnergyEntity, "battery charged energy", false},
+		{&cfg.batteryDischargedEnergy, c.BatteryDischargedEnergyEntity, "battery discharged energy", false},
+	} {
+		*e.dest, err = parseHAEntity(e.value)
+		if err != nil {
+			return cfg, err
+		}
+		if e.required && e.dest.ID == "" {
+			return cfg, fmt.Errorf("missing %s entity", e.name)
+		}
+	}
+
+	cfg.batteryModes = make(map[types.BatteryMode][]haServiceCall)
+	cfg.solarModes = make(map[types.SolarMode][]haServiceCall)
+	for _, m := range []struct {
+		bat   types.BatteryMode
+		sol   types.SolarMode
+		value string
+	}{
+		{bat: types.BatteryModeChargeAny, value: c.ChargeAnyService},
+		{bat: types.BatteryModeChargeSolar, value: c.ChargeSolarService},
+		{bat: types.BatteryModeStandby, value: c.StandbyService},
+		{bat: types.BatteryModeLoad, value: c.LoadService},
+		{sol: types.SolarModeAny, value: c.SolarExportService},
+		{sol: types.SolarModeNoExport, value: c.SolarNoExportService},
+	} {
+		calls, err := parseHAServiceCalls(m.value)
+		if err != nil {
+			return cfg, err
+		}
+		if len(calls) == 0 {
+			continue
+		}
+		if m.bat != types.BatteryModeNoChange {
+			cfg.batteryModes[m.bat] = calls
+		} else {
+			cfg.solarModes[m.sol] = calls
+		}
+	}
+	for _, bat := range []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeStandby, types.BatteryModeLoad} {
+		if len(cfg.batteryModes[bat]) == 0 {
+			return cfg, errors.New("the charge, standby and load services are required")
+		}
+	}
+	return cfg, nil
+}
+
+// HomeAssistant implements the System interface for batteries that are
+// integrated in Home Assistant. The status comes from sensor entities and each
+// mode is set by calling the configured services using the REST API.
+type HomeAssistant struct {
+	client   *http.Client
+	mu       sync.Mutex
+	settings types.Settings
+	cfg      haConfig
+	location *time.Location
+
+	// we build the history from snapshots of the energy sensors rather than
+	// requiring the recorder's history API
+	history *meterHistory
+}
+
+func newHomeAssistant() *HomeAssistant {
+	return &HomeAssistant{
+		client:  common.LocalHTTPClient(time.Minute),
+		history: newMeterHistory(),
+	}
+}
+
+func homeAssistantInfo() types.ESSProviderInfo {
+	service := func(field, name string, required bool) types.ESSCredential {
+		return types.ESSCredential{
+			Field:       field,
+			Name:        name,
+			Type:        "string",
+			Required:    required,
+			Description: `Service call JSON, e.g. {"service": "select.select_option", "entity_id": "select.battery_mode", "option": "Backup"}, or a list of them.`,
+		}
+	}
+	entity := func(field, name string, required bool, description string) types.ESSCredential {
+		return types.ESSCredential{
+			Field:       field,
+			Name:        name,
+			Type:        "string",
+			Required:    required,
+			Description: description,
+		}
+	}
+	return types.ESSProviderInfo{
+		ID:   "homeassistant",
+		Name: "Home Assistant",
+		// the optional services add the charge solar and solar modes
+		Capabilities: types.ESSCapabilities{
+			BatteryModes: []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeChargeSolar, types.BatteryModeStandby, types.BatteryModeLoad},
+			SolarModes:   []types.SolarMode{types.SolarModeNoExport, types.SolarModeAny},
+			GridCharging: true,
+		},
+		Credentials: []types.ESSCredential{
+			{
+				Field:       "url",
+				Name:        "Home Assistant URL",
+				Type:        "string",
+				Required:    true,
+				Description: "The URL of your Home Assistant instance. It must be reachable from the internet.",
+			},
+			{
+				Field:       "token",
+				Name:        "Long-Lived Access Token",
+				Type:        "password",
+				Required:    true,
+				Description: "Created from your Home Assistant profile page.",
+			},
+			entity("capacityKWH", "Battery Capacity kWh", true, "The usable capacity of the battery."),
+			entity("maxPowerKW", "Battery Max Power kW (Optional)", false, "The maximum charge and discharge power of the battery."),
+			entity("socEntity", "Battery SOC Entity", true, "Sensor with the battery's state of charge in %."),
+			entity("batteryPowerEntity", "Battery Power Entity", true, "Sensor that is positive when discharging. Prefix with - if it's positive when charging."),
+			entity("gridPowerEntity", "Grid Power Entity", true, "Sensor that is positive when importing. Prefix with - if it's positive when exporting."),
+			entity("solarPowerEntity", "Solar Power Entity (Optional)", false, ""),
+			entity("homePowerEntity", "Home Power Entity (Optional)", false, "Calculated from the other sensors if not set."),
+			entity("homeEnergyEntity", "Home Energy Entity (Optional)", false, "Lifetime energy sensors are used to build the hourly history."),
+			entity("solarEnergyEntity", "Solar Energy Entity (Optional)", false, ""),
+			entity("gridImportEnergyEntity", "Grid Import Energy Entity (Optional)", false, ""),
+			entity("gridExportEnergyEntity", "Grid Export Energy Entity (Optional)", false, ""),
+			entity("batteryChargedEnergyEntity", "Battery Charged Energy Entity (Optional)", false, ""),
+			entity("batteryDischargedEnergyEntity", "Battery Discharged Energy Entity (Optional)", false, ""),
+			service("chargeAnyService", "Charge From Grid Service", true),
+			service("chargeSolarService", "Charge From Solar Service (Optional)", false),
+			service("standbyService", "Standby Service", true),
+			service("loadService", "Self-Consumption Service", true),
+			service("solarExportService", "Allow Solar Export Service (Optional)", false),
+			service("solarNoExportService", "Block Solar Export Service (Optional)", false),
+		},
+	}
+}
+
+// ApplySettings applies the given settings to the HomeAssistant struct.
+func (h *HomeAssistant) ApplySettings(ctx context.Context, settings types.Settings) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.settings = settings
+	return nil
+}
+
+// Capabilities returns the modes that have services configured.
+func (h *HomeAssistant) Capabilities() types.ESSCapabilities {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	caps := types.ESSCapabilities{GridCharging: true}
+	for _, bat := range []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeChargeSolar, types.BatteryModeStandby, types.BatteryModeLoad} {
+		if len(h.cfg.batteryModes[bat]) > 0 {
+			caps.BatteryModes = append(caps.BatteryModes, bat)
+		}
+	}
+	for _, sol := range []types.SolarMode{types.SolarModeNoExport, types.SolarModeAny} {
+		if len(h.cfg.solarModes[sol]) > 0 {
+			caps.SolarModes = append(caps.SolarModes, sol)
+		}
+	}
+	return caps
+}
+
+// Authenticate validates the configuration and token and caches Home
+// Assistant's timezone.
+func (h *HomeAssistant) Authenticate(ctx context.Context, creds types.Credentials) (types.Credentials, bool, error) {
+	if creds.HomeAssistant == nil {
+		return creds, false, errors.New("missing home assistant credentials")
+	}
+	cfg, err := parseHAConfig(creds.HomeAssistant)
+	if err != nil {
+		return creds, false, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cfg.baseURL != h.cfg.baseURL {
+		h.location = nil
+	}
+	h.cfg = cfg
+
+	// validate the token and the entities
+	if _, err := h.getLocation(ctx); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "home assistant credential validation failed", slog.Any("error", err))
+		return creds, false, fmt.Errorf("credential validation failed: %w", err)
+	}
+	if _, err := h.getState(ctx, h.cfg.soc); err != nil {
+		return creds, false, fmt.Errorf("credential validation failed: %w", err)
+	}
+	return creds, false, nil
+}
+
+func (h *HomeAssistant) doRequest(ctx context.Context, method, path string, data interface{}, dest interface{}) error {
+	if h.cfg.baseURL == "" {
+		return errors.New("home assistant not authenticated")
+	}
+	var body io.Reader
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.cfg.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.cfg.token)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		log.Ctx(ctx).ErrorContext(ctx, "home assistant token rejected", slog.Int("status", resp.StatusCode), slog.String("path", path))
+		return fmt.Errorf("home assistant token rejected: %w", common.NewStatusError(resp))
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Ctx(ctx).ErrorContext(ctx, "home assistant api error", slog.Int("status", resp.StatusCode), slog.String("path", path), slog.String("body", string(respBody)))
+		return fmt.Errorf("home assistant api error for %s: %w", path, common.NewStatusError(resp))
+	}
+	if dest != nil {
+		if err := json.Unmarshal(respBody, dest); err != nil {
+			return fmt.Errorf("failed to decode home assistant response: %w", err)
+		}
+	}
+	return nil
+}
+
+// getLocation returns Home Assistant's timezone, fetching it if it isn't
+// cached. Must be called with h.mu held.
+func (h *HomeAssistant) getLocation(ctx context.Context) (*time.Location, error) {
+	if h.location != nil {
+		return h.location, nil
+	}
+	var res haConfigResponse
+	if err := h.doRequest(ctx, "GET", "/api/config", nil, &res); err != nil {
+		return nil, fmt.Errorf("config failed: %w", err)
+	}
+	loc, err := time.LoadLocation(res.TimeZone)
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to load location, defaulting to UTC", slog.String("tz", res.TimeZone), slog.Any("error", err))
+		loc = time.UTC
+	}
+	h.location = loc
+	return loc, nil
+}
+
+// getState returns the numeric state of the entity converted to kW, kWh or %.
+// Must be called with h.mu held.
+func (h *HomeAssistant) getState(ctx context.Context, e haEntity) (float64, error) {
+	var res haState
+	if err := h.doRequest(ctx, "GET", "/api/states/"+url.PathEscape(e.ID), nil, &res); err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", e.ID, err)
+	}
+	v, err := strconv.ParseFloat(res.State, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is %s", e.ID, res.State)
+	}
+	unit, _ := res.Attributes["unit_of_measurement"].(string)
+	mult, ok := haUnitMultipliers[unit]
+	if !ok {
+		return 0, fmt.Errorf("%s has unsupported unit %s", e.ID, unit)
+	}
+	v *= mult
+	if e.Negate {
+		v = -v
+	}
+	return v, nil
+}
+
+// optionalState returns the state of the entity or def if it isn't
+// configured. Must be called with h.mu held.
+func (h *HomeAssistant) optionalState(ctx context.Context, e haEntity, def float64) (float64, error) {
+	if e.ID == "" {
+		return def, nil
+	}
+	return h.getState(ctx, e)
+}
+
+// recordSnapshot records the energy sensors if they're configured. Must be
+// called with h.mu held.
+func (h *HomeAssistant) recordSnapshot(ctx context.Context, soc float64) {
+	cfg := h.cfg
+	if cfg.homeEnergy.ID == "" || cfg.solarEnergy.ID == "" || cfg.gridImportEnergy.ID == "" || cfg.gridExportEnergy.ID == "" {
+		return
+	}
+	var c meterCounters
+	for _, v := range []struct {
+		e    haEntity
+		dest *float64
+	}{
+		{cfg.homeEnergy, &c.HomeWH},
+		{cfg.solarEnergy, &c.SolarWH},
+		{cfg.gridImportEnergy, &c.GridImportWH},
+		{cfg.gridExportEnergy, &c.GridExportWH},
+		{cfg.batteryChargedEnergy, &c.BatteryChargedWH},
+		{cfg.batteryDischargedEnergy, &c.BatteryUsedWH},
+	} {
+		if v.e.ID == "" {
+			continue
+		}
+		kwh, err := h.getState(ctx, v.e)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to read home assistant energy sensor, skipping history snapshot", slog.Any("error", err))
+			return
+		}
+		*v.dest = math.Abs(kwh) * 1000
+	}
+	// without the battery sensors the battery's energy is whatever is left
+	// over after the others
+	h.history.deriveBattery = cfg.batteryChargedEnergy.ID == "" || cfg.batteryDischargedEnergy.ID == ""
+	h.history.record(time.Now(), c, soc)
+}
+
+// GetStatus returns the status built from the configured sensors.
+func (h *HomeAssistant) GetStatus(ctx context.Context) (types.SystemStatus, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting home assistant system status")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	loc, err := h.getLocation(ctx)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	soc, err := h.getState(ctx, h.cfg.soc)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	batteryKW, err := h.getState(ctx, h.cfg.batteryPower)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	gridKW, err := h.getState(ctx, h.cfg.gridPower)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	solarKW, err := h.optionalState(ctx, h.cfg.solarPower, 0)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	// the home uses whatever isn't going to or coming from somewhere else
+	homeKW, err := h.optionalState(ctx, h.cfg.homePower, solarKW+gridKW+batteryKW)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	h.recordSnapshot(ctx, soc)
+
+	log.Ctx(ctx).DebugContext(
+		ctx,
+		"home assistant status",
+		slog.Float64("soc", soc),
+		slog.Float64("batteryKW", batteryKW),
+		slog.Float64("solarKW", solarKW),
+		slog.Float64("gridKW", gridKW),
+		slog.Float64("homeKW", homeKW),
+	)
+
+	return types.SystemStatus{
+		Timestamp:             time.Now().In(loc),
+		BatterySOC:            soc,
+		BatteryKW:             batteryKW,
+		SolarKW:               math.Max(solarKW, 0),
+		GridKW:                gridKW,
+		HomeKW:                homeKW,
+		BatteryCapacityKWH:    h.cfg.capacityKWH,
+		MaxBatteryChargeKW:    h.cfg.maxPowerKW,
+		MaxBatteryDischargeKW: h.cfg.maxPowerKW,
+		// the services are expected to follow the settings
+		CanExportSolar:     h.settings.GridExportSolar,
+		CanExportBattery:   false,
+		CanImportBattery:   h.settings.GridChargeBatteries,
+		BatteryAboveMinSOC: soc >= h.settings.MinBatterySOC,
+	}, nil
+}
+
+// SetModes calls the services configured for the modes. Charging from solar
+// falls back to standby and solar modes without services are ignored.
+func (h *HomeAssistant) SetModes(ctx context.Context, bat types.BatteryMode, sol types.SolarMode) error {
+	log.Ctx(ctx).DebugContext(ctx, "SetModes called", slog.Any("batteryMode", bat), slog.Any("solarMode", sol))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var calls []haServiceCall
+	if bat != types.BatteryModeNoChange {
+		batCalls, ok := h.cfg.batteryModes[bat]
+		if !ok && bat == types.BatteryModeChargeSolar {
+			// standby still lets excess solar charge the battery
+			batCalls, ok = h.cfg.batteryModes[types.BatteryModeStandby]
+		}
+		if !ok {
+			return fmt.Errorf("no home assistant service configured for battery mode %v", bat)
+		}
+		calls = append(calls, batCalls...)
+	}
+	if sol != types.SolarModeNoChange {
+		solCalls, ok := h.cfg.solarModes[sol]
+		if ok {
+			calls = append(calls, solCalls...)
+		} else {
+			log.Ctx(ctx).WarnContext(ctx, "no home assistant service configured for solar mode, ignoring", slog.Any("solarMode", sol))
+		}
+	}
+
+	for _, c := range calls {
+		log.Ctx(ctx).InfoContext(ctx, "calling home assistant service", slog.String("domain", c.Domain), slog.String("service", c.Service), slog.Any("data", c.Data))
+		path := "/api/services/" + url.PathEscape(c.Domain) + "/" + url.PathEscape(c.Service)
+		if err := h.doRequest(ctx, "POST", path, c.Data, nil); err != nil {
+			return fmt.Errorf("failed to call %s.%s: %w", c.Domain, c.Service, err)
+		}
+	}
+	return nil
+}
+
+// GetEnergyHistory returns the hourly energy history from the energy
+// sensors, or nothing if they aren't configured.
+func (h *HomeAssistant) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting home assistant energy history", slog.Time("start", start), slog.Time("end", end))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	loc, err := h.getLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	soc, err := h.getState(ctx, h.cfg.soc)
+	if err != nil {
+		return nil, err
+	}
+	h.recordSnapshot(ctx, soc)
+	return h.history.stats(start, end, loc), nil
+}
+
+// Internal Structs
+
+type haConfigResponse struct {
+	TimeZone string `json:"time_zone"`
+	Version  string `json:"version"`
+}
+
+type haState struct {
+	EntityID   string                 `json:"entity_id"`
+	State      string                 `json:"state"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
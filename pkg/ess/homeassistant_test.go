@@ -0,0 +1,284 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHomeAssistant serves entity states and records service calls.
+type fakeHomeAssistant struct {
+	t *testing.T
+
+	mu     sync.Mutex
+	token  string
+	states map[string]haState
+	calls  []string
+}
+
+func newFakeHomeAssistant(t *testing.T) (*fakeHomeAssistant, *httptest.Server) {
+	f := &fakeHomeAssistant{
+		t:      t,
+		token:  "ha-token",
+		states: make(map[string]haState),
+	}
+	f.setState("sensor.battery_soc", "55", "%")
+	f.setState("sensor.battery_power", "-1500", "W")
+	f.setState("sensor.grid_power", "0.5", "kW")
+	f.setState("sensor.solar_power", "3000", "W")
+	f.setState("sensor.home_energy", "9000", "kWh")
+	f.setState("sensor.solar_energy", "12", "MWh")
+	f.setState("sensor.grid_import_energy", "5000000", "Wh")
+	f.setState("sensor.grid_export_energy", "7000", "kWh")
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeHomeAssistant) setState(entity, state, unit string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[entity] = haState{
+		EntityID:   entity,
+		State:      state,
+		Attributes: map[string]interface{}{"unit_of_measurement": unit},
+	}
+}
+
+func (f *fakeHomeAssistant) serviceCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeHomeAssistant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/config":
+		_ = json.NewEncoder(w).Encode(haConfigResponse{TimeZone: "America/Denver"})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/states/"):
+		state, ok := f.states[strings.TrimPrefix(r.URL.Path, "/api/states/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(state)
+	case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/services/"):
+		var data map[string]interface{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&data))
+		b, err := json.Marshal(data)
+		require.NoError(f.t, err)
+		f.calls = append(f.calls, strings.TrimPrefix(r.URL.Path, "/api/services/")+" "+string(b))
+		_, _ = w.Write([]byte("[]"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testHomeAssistantCredentials(url string) *types.HomeAssistantCredentials {
+	return &types.HomeAssistantCredentials{
+		URL:                    url,
+		Token:                  "ha-token",
+		CapacityKWH:            "13.5",
+		MaxPowerKW:             "5",
+		SOCEntity:              "sensor.battery_soc",
+		BatteryPowerEntity:     "-sensor.battery_power",
+		GridPowerEntity:        "sensor.grid_power",
+		SolarPowerEntity:       "sensor.solar_power",
+		HomeEnergyEntity:       "sensor.home_energy",
+		SolarEnergyEntity:      "sensor.solar_energy",
+		GridImportEnergyEntity: "sensor.grid_import_energy",
+		GridExportEnergyEntity: "sensor.grid_export_energy",
+		ChargeAnyService:       `{"service": "select.select_option", "entity_id": "select.battery_mode", "option": "Force Charge"}`,
+		StandbyService:         `{"service": "select.select_option", "entity_id": "select.battery_mode", "option": "Backup"}`,
+		LoadService: `[
+			{"service": "select.select_option", "entity_id": "select.battery_mode", "option": "Self Use"},
+			{"service": "number.set_value", "entity_id": "number.battery_reserve", "value": 20}
+		]`,
+		SolarNoExportService: `{"service": "number.set_value", "entity_id": "number.export_limit", "value": 0}`,
+	}
+}
+
+func newTestHomeAssistant(t *testing.T) (*HomeAssistant, *fakeHomeAssistant) {
+	f, srv := newFakeHomeAssistant(t)
+	h := newHomeAssistant()
+	require.NoError(t, h.ApplySettings(context.Background(), types.Settings{
+		MinBatterySOC:       20,
+		GridChargeBatteries: true,
+	}))
+	_, _, err := h.Authenticate(context.Background(), types.Credentials{HomeAssistant: testHomeAssistantCredentials(srv.URL)})
+	require.NoError(t, err)
+	return h, f
+}
+
+func TestHomeAssistant(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Authenticate", func(t *testing.T) {
+		_, srv := newFakeHomeAssistant(t)
+		h := newHomeAssistant()
+
+		creds := types.Credentials{HomeAssistant: testHomeAssistantCredentials(srv.URL)}
+		_, changed, err := h.Authenticate(ctx, creds)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "America/Denver", h.location.String())
+
+		creds.HomeAssistant.Token = "wrong"
+		_, _, err = h.Authenticate(ctx, creds)
+		assert.ErrorContains(t, err, "token rejected")
+
+		creds = types.Credentials{HomeAssistant: testHomeAssistantCredentials(srv.URL)}
+		creds.HomeAssistant.SOCEntity = "sensor.missing"
+		_, _, err = h.Authenticate(ctx, creds)
+		assert.ErrorContains(t, err, "sensor.missing")
+
+		creds = types.Credentials{HomeAssistant: testHomeAssistantCredentials(srv.URL)}
+		creds.HomeAssistant.LoadService = ""
+		_, _, err = h.Authenticate(ctx, creds)
+		assert.ErrorContains(t, err, "services are required")
+
+		_, _, err = h.Authenticate(ctx, types.Credentials{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Capabilities", func(t *testing.T) {
+		h, _ := newTestHomeAssistant(t)
+
+		caps := h.Capabilities()
+		assert.Equal(t, []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeStandby, types.BatteryModeLoad}, caps.BatteryModes)
+		assert.Equal(t, []types.SolarMode{types.SolarModeNoExport}, caps.SolarModes)
+		assert.True(t, caps.GridCharging)
+		assert.False(t, caps.BatteryExport)
+	})
+
+	t.Run("GetStatus", func(t *testing.T) {
+		h, _ := newTestHomeAssistant(t)
+
+		status, err := h.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 55.0, status.BatterySOC)
+		// the battery sensor is positive when charging so it's negated
+		assert.InDelta(t, 1.5, status.BatteryKW, 0.001)
+		assert.InDelta(t, 0.5, status.GridKW, 0.001)
+		assert.InDelta(t, 3.0, status.SolarKW, 0.001)
+		assert.InDelta(t, 5.0, status.HomeKW, 0.001)
+		assert.Equal(t, 13.5, status.BatteryCapacityKWH)
+		assert.Equal(t, 5.0, status.MaxBatteryChargeKW)
+		assert.Equal(t, "America/Denver", status.Timestamp.Location().String())
+		assert.True(t, status.CanImportBattery)
+		assert.False(t, status.CanExportSolar)
+		assert.True(t, status.BatteryAboveMinSOC)
+	})
+
+	t.Run("GetStatus Unavailable", func(t *testing.T) {
+		h, f := newTestHomeAssistant(t)
+		f.setState("sensor.battery_soc", "unavailable", "%")
+
+		_, err := h.GetStatus(ctx)
+		assert.ErrorContains(t, err, "sensor.battery_soc is unavailable")
+	})
+
+	t.Run("GetStatus Unsupported Unit", func(t *testing.T) {
+		h, f := newTestHomeAssistant(t)
+		f.setState("sensor.grid_power", "5", "A")
+
+		_, err := h.GetStatus(ctx)
+		assert.ErrorContains(t, err, "unsupported unit")
+	})
+
+	t.Run("SetModes", func(t *testing.T) {
+		h, f := newTestHomeAssistant(t)
+
+		require.NoError(t, h.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoChange))
+		require.NoError(t, h.SetModes(ctx, types.BatteryModeLoad, types.SolarModeNoExport))
+		// there's no charge solar service so standby is used
+		require.NoError(t, h.SetModes(ctx, types.BatteryModeChargeSolar, types.SolarModeNoChange))
+		// there's no export service so it's ignored
+		require.NoError(t, h.SetModes(ctx, types.BatteryModeNoChange, types.SolarModeAny))
+
+		assert.Equal(t, []string{
+			`select/select_option {"entity_id":"select.battery_mode","option":"Force Charge"}`,
+			`select/select_option {"entity_id":"select.battery_mode","option":"Self Use"}`,
+			`number/set_value {"entity_id":"number.battery_reserve","value":20}`,
+			`number/set_value {"entity_id":"number.export_limit","value":0}`,
+			`select/select_option {"entity_id":"select.battery_mode","option":"Backup"}`,
+		}, f.serviceCalls())
+	})
+
+	t.Run("SetModes Service Error", func(t *testing.T) {
+		h, f := newTestHomeAssistant(t)
+		f.mu.Lock()
+		f.token = "revoked"
+		f.mu.Unlock()
+
+		err := h.SetModes(ctx, types.BatteryModeStandby, types.SolarModeNoChange)
+		assert.ErrorContains(t, err, "select.select_option")
+	})
+
+	t.Run("GetEnergyHistory", func(t *testing.T) {
+		h, _ := newTestHomeAssistant(t)
+
+		stats, err := h.GetEnergyHistory(ctx, time.Now().Add(-time.Hour), time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, stats)
+
+		// the units are all converted to Wh
+		cur, ok := h.history.hours[time.Now().Truncate(time.Hour).Unix()]
+		require.True(t, ok)
+		assert.Equal(t, 9000000.0, cur.first.HomeWH)
+		assert.Equal(t, 12000000.0, cur.first.SolarWH)
+		assert.Equal(t, 5000000.0, cur.first.GridImportWH)
+		assert.Equal(t, 7000000.0, cur.first.GridExportWH)
+		assert.Equal(t, 55.0, cur.minSOC)
+		// there are no battery energy sensors so the battery is derived
+		assert.True(t, h.history.deriveBattery)
+	})
+
+	t.Run("GetEnergyHistory No Energy Sensors", func(t *testing.T) {
+		_, srv := newFakeHomeAssistant(t)
+		h := newHomeAssistant()
+		creds := testHomeAssistantCredentials(srv.URL)
+		creds.HomeEnergyEntity = ""
+		_, _, err := h.Authenticate(ctx, types.Credentials{HomeAssistant: creds})
+		require.NoError(t, err)
+
+		stats, err := h.GetEnergyHistory(ctx, time.Now().Add(-time.Hour), time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, stats)
+		assert.Empty(t, h.history.hours)
+	})
+}
+
+func TestParseHAServiceCalls(t *testing.T) {
+	calls, err := parseHAServiceCalls(`{"service": "number.set_value", "entity_id": "number.reserve", "value": 10}`)
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+	assert.Equal(t, "number", calls[0].Domain)
+	assert.Equal(t, "set_value", calls[0].Service)
+	assert.Equal(t, map[string]interface{}{"entity_id": "number.reserve", "value": 10.0}, calls[0].Data)
+
+	calls, err = parseHAServiceCalls("")
+	require.NoError(t, err)
+	assert.Empty(t, calls)
+
+	_, err = parseHAServiceCalls(`{"service": "set_value"}`)
+	assert.Error(t, err)
+	_, err = parseHAServiceCalls(`not json`)
+	assert.Error(t, err)
+}
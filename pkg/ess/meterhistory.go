@@ -0,0 +1,116 @@
+package ess
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// meterHistoryRetention is how long meter snapshots are kept in memory to
+// build the energy history.
+const meterHistoryRetention = 7 * 24 * time.Hour
+
+// meterCounters are the lifetime energy counters (Wh) reported by a system's
+// meters.
+type meterCounters struct {
+	HomeWH           float64
+	SolarWH          float64
+	GridImportWH     float64
+	GridExportWH     float64
+	BatteryChargedWH float64
+	BatteryUsedWH    float64
+}
+
+// meterHourSnapshot is the first meter snapshot taken in an hour along with
+// the range of SOCs seen during the hour.
+type meterHourSnapshot struct {
+	first  meterCounters
+	minSOC float64
+	maxSOC float64
+}
+
+// meterHistory builds hourly energy history for systems that don't store any
+// history themselves but expose lifetime meter counters. Each hour's energy is
+// the difference between the first snapshot of the hour and the first
+// snapshot of the next hour so history is only available for hours that we've
+// taken snapshots in.
+type meterHistory struct {
+	// deriveBattery is set when the meters don't include the battery and the
+	// battery's energy should be derived from the energy balance of the
+	// others. Systems set it with each snapshot since it depends on which
+	// meters they found.
+	deriveBattery bool
+	// hours is keyed by the unix hour the snapshot was taken in
+	hours map[int64]meterHourSnapshot
+}
+
+func newMeterHistory() *meterHistory {
+	return &meterHistory{hours: make(map[int64]meterHourSnapshot)}
+}
+
+// record adds a snapshot of the meters taken at now.
+func (h *meterHistory) record(now time.Time, c meterCounters, soc float64) {
+	key := now.Truncate(time.Hour).Unix()
+	s, ok := h.hours[key]
+	if !ok {
+		s = meterHourSnapshot{first: c, minSOC: soc, maxSOC: soc}
+	}
+	s.minSOC = math.Min(s.minSOC, soc)
+	s.maxSOC = math.Max(s.maxSOC, soc)
+	h.hours[key] = s
+
+	for k := range h.hours {
+		if time.Unix(k, 0).Before(now.Add(-meterHistoryRetention)) {
+			delete(h.hours, k)
+		}
+	}
+}
+
+// stats returns the hourly energy stats for the complete hours in
+// [start, end) in the given location.
+func (h *meterHistory) stats(start, end time.Time, loc *time.Location) []types.EnergyStats {
+	var keys []int64
+	for k := range h.hours {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var stats []types.EnergyStats
+	for _, k := range keys {
+		hourStart := time.Unix(k, 0).In(loc)
+		if hourStart.Before(start) || hourStart.Add(time.Hour).After(end) {
+			continue
+		}
+		next, ok := h.hours[hourStart.Add(time.Hour).Unix()]
+		if !ok {
+			// we need the start of the next hour to know how much energy was used
+			continue
+		}
+		cur := h.hours[k]
+		a := cur.first
+		b := next.first
+
+		s := types.EnergyStats{
+			TSHourStart:       hourStart,
+			MinBatterySOC:     cur.minSOC,
+			MaxBatterySOC:     cur.maxSOC,
+			HomeKWH:           (b.HomeWH - a.HomeWH) / 1000,
+			SolarKWH:          (b.SolarWH - a.SolarWH) / 1000,
+			GridImportKWH:     (b.GridImportWH - a.GridImportWH) / 1000,
+			GridExportKWH:     (b.GridExportWH - a.GridExportWH) / 1000,
+			BatteryChargedKWH: (b.BatteryChargedWH - a.BatteryChargedWH) / 1000,
+			BatteryUsedKWH:    (b.BatteryUsedWH - a.BatteryUsedWH) / 1000,
+		}
+		if h.deriveBattery {
+			// whatever the home used that didn't come from solar or the grid must
+			// have come from the battery
+			net := s.HomeKWH + s.GridExportKWH - s.SolarKWH - s.GridImportKWH
+			s.BatteryUsedKWH = math.Max(net, 0)
+			s.BatteryChargedKWH = math.Max(-net, 0)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
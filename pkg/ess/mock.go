@@ -2,51 +2,114 @@ package ess
 
 import (
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/raterudder/raterudder/pkg/storage"
 	"github.com/raterudder/raterudder/pkg/types"
 )
 
 var (
-	mockDB storage.Database
+	mockStore MockStateStore
 )
 
-// ConfigureMock sets the database for the mock ESS provider.
-func ConfigureMock(db storage.Database) {
-	mockDB = db
+// ConfigureMock sets the store used by mock ESS systems created through Map.
+// storage.Database can be used directly. If it's never called the state is
+// only kept in memory.
+func ConfigureMock(store MockStateStore) {
+	mockStore = store
 }
 
+// MockESS simulates a home with a battery and solar. The "simple" strategy
+// uses a sine wave home load and a solar bell curve peaking at 13:00. The
+// "profile" strategy instead reads the home load and solar from an hourly CSV
+// with homeKW and solarKW columns. The CSV can have multiple days of rows and
+// the days are repeated through the year. Both can add seeded noise and cloudy
+// days so the simulation is repeatable.
 type MockESS struct {
 	mu       sync.Mutex
 	settings types.Settings
 	siteID   string
 	location *time.Location
-	strategy string
+	cfg      mockConfig
+	store    MockStateStore
+	now      func() time.Time
 }
 
+// mockConfig is the parsed configuration from the credentials.
+type mockConfig struct {
+	strategy            string
+	capacityKWH         float64
+	maxChargeKW         float64
+	maxDischargeKW      float64
+	roundTripEfficiency float64
+	solarPeakKW         float64
+	homeLoadKW          float64
+	profile             []mockProfileHour
+	seed                int64
+	noise               float64
+	cloudyDayChance     float64
+}
+
+type mockProfileHour struct {
+	homeKW  float64
+	solarKW float64
+}
+
+// cloudy days only produce this fraction of the normal solar
+const mockCloudySolarFactor = 0.3
+
 func newMock(siteID string) *MockESS {
+	return NewMock(siteID, mockStore, nil)
+}
+
+// NewMock returns a mock ESS that keeps its state in the store and uses now as
+// the current time. A nil store keeps the state in memory and a nil now uses
+// the wall clock. Authenticate must be called before using it.
+func NewMock(siteID string, store MockStateStore, now func() time.Time) *MockESS {
+	if store == nil {
+		store = NewMemoryMockStateStore()
+	}
+	if now == nil {
+		now = time.Now
+	}
 	return &MockESS{
 		siteID: siteID,
+		store:  store,
+		now:    now,
 	}
 }
 
 func mockInfo() types.ESSProviderInfo {
 	return types.ESSProviderInfo{
-		ID:   "mock",
-		Name: "Mock ESS",
+		ID:           "mock",
+		Name:         "Mock ESS",
+		Capabilities: types.AllESSCapabilities(),
 		Credentials: []types.ESSCredential{
 			{
 				Field:       "strategy",
 				Name:        "Strategy",
 				Type:        "string",
 				Required:    true,
-				Description: "The simulation strategy (e.g., 'simple')",
+				Description: "The simulation strategy, 'simple' or 'profile'",
 			},
+			mockOption("capacityKWH", "Battery Capacity kWh", "Defaults to 10."),
+			mockOption("maxChargeKW", "Battery Max Charge kW", "Defaults to 5."),
+			mockOption("maxDischargeKW", "Battery Max Discharge kW", "Defaults to 5."),
+			mockOption("roundTripEfficiency", "Round-Trip Efficiency %", "Defaults to 100."),
+			mockOption("solarPeakKW", "Solar Peak kW", "Peak of the simple solar curve, defaults to 3."),
+			mockOption("homeLoadKW", "Average Home Load kW", "Average of the simple home load, defaults to 1.5."),
+			mockOption("profile", "Hourly Profile CSV", "Rows of hourly homeKW and solarKW for the profile strategy."),
+			mockOption("seed", "Random Seed", "Seed for the noise and cloudy days."),
+			mockOption("noisePercent", "Noise %", "Random variation applied to the home load and solar."),
+			mockOption("cloudyDayPercent", "Cloudy Day %", "Chance that a day only produces 30% of the solar."),
 		},
 		Hidden: true,
 	}
@@ -60,6 +123,11 @@ func (m *MockESS) ApplySettings(ctx context.Context, settings types.Settings) er
 	return nil
 }
 
+// Capabilities returns every mode since the simulation supports all of them.
+func (m *MockESS) Capabilities() types.ESSCapabilities {
+	return types.AllESSCapabilities()
+}
+
 // Authenticate prepares credentials and initializes the location for the mock.
 // If no mock credentials exist, it creates defaults.
 func (m *MockESS) Authenticate(ctx context.Context, creds types.Credentials) (types.Credentials, bool, error) {
@@ -72,8 +140,9 @@ func (m *MockESS) Authenticate(ctx context.Context, creds types.Credentials) (ty
 		}
 		updated = true
 	}
-	if creds.Mock.Strategy != "simple" {
-		return creds, false, fmt.Errorf("invalid strategy: %s", creds.Mock.Strategy)
+	cfg, err := parseMockConfig(creds.Mock)
+	if err != nil {
+		return creds, false, err
 	}
 	if creds.Mock.Location == "" {
 		creds.Mock.Location = "America/Chicago"
@@ -85,20 +154,181 @@ func (m *MockESS) Authenticate(ctx context.Context, creds types.Credentials) (ty
 	}
 	m.mu.Lock()
 	m.location = loc
-	m.strategy = creds.Mock.Strategy
+	m.cfg = cfg
 	m.mu.Unlock()
 	return creds, updated, nil
 }
 
+func mockOption(field, name, description string) types.ESSCredential {
+	return types.ESSCredential{
+		Field:       field,
+		Name:        name + " (Optional)",
+		Type:        "string",
+		Description: description,
+	}
+}
+
+func parseMockConfig(c *types.MockCredentials) (mockConfig, error) {
+	cfg := mockConfig{
+		strategy:            c.Strategy,
+		capacityKWH:         10,
+		maxChargeKW:         5,
+		maxDischargeKW:      5,
+		roundTripEfficiency: 1,
+		solarPeakKW:         3,
+		homeLoadKW:          1.5,
+	}
+	switch c.Strategy {
+	case "simple":
+	case "profile":
+		profile, err := parseMockProfile(strings.NewReader(c.Profile))
+		if err != nil {
+			return cfg, err
+		}
+		cfg.profile = profile
+	default:
+		return cfg, fmt.Errorf("invalid strategy: %s", c.Strategy)
+	}
+
+	for _, o := range []struct {
+		dest     *float64
+		value    string
+		name     string
+		max      float64
+		positive bool
+	}{
+		{&cfg.capacityKWH, c.CapacityKWH, "battery capacity", math.Inf(1), true},
+		{&cfg.maxChargeKW, c.MaxChargeKW, "max charge", math.Inf(1), true},
+		{&cfg.maxDischargeKW, c.MaxDischargeKW, "max discharge", math.Inf(1), true},
+		{&cfg.roundTripEfficiency, c.RoundTripEfficiency, "round-trip efficiency", 100, true},
+		{&cfg.solarPeakKW, c.SolarPeakKW, "solar peak", math.Inf(1), false},
+		{&cfg.homeLoadKW, c.HomeLoadKW, "home load", math.Inf(1), true},
+		{&cfg.noise, c.NoisePercent, "noise", 100, false},
+		{&cfg.cloudyDayChance, c.CloudyDayPercent, "cloudy day", 100, false},
+	} {
+		if o.value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(o.value), 64)
+		if err != nil || v < 0 || v > o.max || (o.positive && v == 0) {
+			return cfg, fmt.Errorf("invalid %s: %q", o.name, o.value)
+		}
+		*o.dest = v
+	}
+	// the percentages are entered as 0-100
+	if c.RoundTripEfficiency != "" {
+		cfg.roundTripEfficiency /= 100
+	}
+	cfg.noise /= 100
+	cfg.cloudyDayChance /= 100
+
+	if c.Seed != "" {
+		seed, err := strconv.ParseInt(strings.TrimSpace(c.Seed), 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid seed: %q", c.Seed)
+		}
+		cfg.seed = seed
+	}
+	return cfg, nil
+}
+
+// parseMockProfile reads an hourly CSV with homeKW and solarKW columns in any
+// order. Other columns, like a timestamp, are ignored. The rows must cover
+// whole days.
+func parseMockProfile(r io.Reader) ([]mockProfileHour, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing profile")
+	}
+	homeCol, solarCol := -1, -1
+	for i, name := range records[0] {
+		switch strings.TrimSpace(name) {
+		case "homeKW":
+			homeCol = i
+		case "solarKW":
+			solarCol = i
+		}
+	}
+	if homeCol < 0 || solarCol < 0 {
+		return nil, errors.New("profile is missing the homeKW or solarKW column")
+	}
+	rows := records[1:]
+	if len(rows) == 0 || len(rows)%24 != 0 {
+		return nil, fmt.Errorf("profile has %d hours, expected whole days", len(rows))
+	}
+	profile := make([]mockProfileHour, len(rows))
+	for i, row := range rows {
+		home, err := strconv.ParseFloat(strings.TrimSpace(row[homeCol]), 64)
+		if err != nil || home < 0 {
+			return nil, fmt.Errorf("invalid homeKW on line %d: %q", i+2, row[homeCol])
+		}
+		solar, err := strconv.ParseFloat(strings.TrimSpace(row[solarCol]), 64)
+		if err != nil || solar < 0 {
+			return nil, fmt.Errorf("invalid solarKW on line %d: %q", i+2, row[solarCol])
+		}
+		profile[i] = mockProfileHour{homeKW: home, solarKW: solar}
+	}
+	return profile, nil
+}
+
+// mockRand returns a value in [0, 1) that only depends on the seed and key so
+// the simulation is the same no matter how often the state is advanced.
+func mockRand(seed, key int64) float64 {
+	// splitmix64
+	x := uint64(seed) + uint64(key)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// homeAndSolarKW returns the simulated home load and solar generation at t,
+// which must be in the mock's location.
+func (c mockConfig) homeAndSolarKW(t time.Time) (homeKW, solarKW float64) {
+	hour := float64(t.Hour()) + float64(t.Minute())/60.0
+
+	if c.profile != nil {
+		day := (t.YearDay() - 1) % (len(c.profile) / 24)
+		p := c.profile[day*24+t.Hour()]
+		homeKW = p.homeKW
+		solarKW = p.solarKW
+	} else {
+		// Predictable home load on a sine wave that peaks every 2 hours, 1.5 -
+		// 2.5 kW by default
+		homeKW = c.homeLoadKW * (1 + math.Sin(hour*math.Pi)/3)
+		if homeKW < c.homeLoadKW*2/3 {
+			homeKW = c.homeLoadKW * 2 / 3
+		}
+
+		// Solar generation: Bell curve peak at 13:00
+		if hour >= 6 && hour <= 19 {
+			solarKW = c.solarPeakKW * math.Sin((hour-6)/13*math.Pi)
+		}
+	}
+
+	if c.cloudyDayChance > 0 {
+		day := int64(t.Year())*1000 + int64(t.YearDay())
+		if mockRand(c.seed, day) < c.cloudyDayChance {
+			solarKW *= mockCloudySolarFactor
+		}
+	}
+	if c.noise > 0 {
+		// keep the noise constant within each 5 minute step
+		key := t.Truncate(5*time.Minute).Unix() * 2
+		homeKW *= 1 + c.noise*(2*mockRand(c.seed, key)-1)
+		solarKW *= 1 + c.noise*(2*mockRand(c.seed, key+1)-1)
+	}
+	return homeKW, solarKW
+}
+
 func getMidnight(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
 
 func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batteryKW, solarKW, homeKW, gridKW float64) {
-	if m.strategy != "simple" {
-		panic(fmt.Sprintf("unsupported strategy: %s", m.strategy))
-	}
-
 	now = now.In(m.location)
 
 	lastMidnight := getMidnight(state.Timestamp.In(m.location))
@@ -127,13 +357,16 @@ func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batter
 	}
 
 	stepStart := state.Timestamp
-	capacityKWH := 10.0
-	maxChargeRateKW := 5.0
-	maxDischargeRateKW := 5.0
-
-	// Use at most 5 minute steps
+	capacityKWH := m.cfg.capacityKWH
+	maxChargeRateKW := m.cfg.maxChargeKW
+	maxDischargeRateKW := m.cfg.maxDischargeKW
+	// split the losses evenly between charging and discharging
+	efficiency := math.Sqrt(m.cfg.roundTripEfficiency)
+
+	// Use at most 5 minute steps aligned to the clock so the noise doesn't
+	// depend on how often we're advanced
 	for stepStart.Before(now) {
-		stepEnd := stepStart.Add(5 * time.Minute)
+		stepEnd := stepStart.Truncate(5 * time.Minute).Add(5 * time.Minute)
 		if stepEnd.After(now) {
 			stepEnd = now
 		}
@@ -144,26 +377,14 @@ func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batter
 		}
 
 		stepMid := stepStart.Add(stepEnd.Sub(stepStart) / 2)
-
-		hour := float64(stepMid.Hour()) + float64(stepMid.Minute())/60.0
-
-		// Predictable home load 1.5 - 2.5 kW on a sine wave that peaks every 2 hours
-		stepHomeKW := 1.5 + 0.5*math.Sin(hour*math.Pi)
-		if stepHomeKW < 1.0 {
-			stepHomeKW = 1.0
-		}
-
-		// Solar generation: Bell curve peak at 13:00
-		stepSolarKW := 0.0
-		if hour >= 6 && hour <= 19 {
-			stepSolarKW = 3.0 * math.Sin((hour-6)/13*math.Pi)
-		}
+		stepHomeKW, stepSolarKW := m.cfg.homeAndSolarKW(stepMid)
 
 		net := stepSolarKW - stepHomeKW
 		stepBatteryKW := 0.0
 		stepGridKW := 0.0
 
-		// calculate how much space is available and how much energy is stored
+		// calculate how much space is available and how much energy is stored,
+		// both measured at the battery's terminals
 		spaceKWH := (100.0 - state.BatterySOC) / 100.0 * capacityKWH
 
 		usableSOC := state.BatterySOC - m.settings.MinBatterySOC
@@ -172,13 +393,13 @@ func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batter
 		}
 		usableEnergyKWH := (usableSOC / 100.0) * capacityKWH
 
-		maxChargeKWH := spaceKWH
-		maxDischargeKWH := usableEnergyKWH
+		maxChargeKWH := spaceKWH / efficiency
+		maxDischargeKWH := usableEnergyKWH * efficiency
 
 		// if we have excess solar what do we do with it?
 		if net > 0 {
-			// if battery is in standby or load mode, we don't charge it, unless we're below min SOC
-			if (state.BatteryMode == types.BatteryModeStandby || state.BatteryMode == types.BatteryModeLoad) && state.BatterySOC >= m.settings.MinBatterySOC {
+			// if battery is in standby, load or export mode, we don't charge it, unless we're below min SOC
+			if (state.BatteryMode == types.BatteryModeStandby || state.BatteryMode == types.BatteryModeLoad || state.BatteryMode == types.BatteryModeExport) && state.BatterySOC >= m.settings.MinBatterySOC {
 				stepBatteryKW = 0
 			} else {
 				tryChargeKW := min(net, maxChargeRateKW)
@@ -216,6 +437,21 @@ func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batter
 			stepGridKW = remainingDeficit
 		}
 
+		// if we're exporting, discharge as fast as we can and send whatever the
+		// home doesn't use to the grid
+		batteryToGridKW := 0.0
+		if state.BatteryMode == types.BatteryModeExport && stepBatteryKW < maxDischargeRateKW {
+			exportKW := maxDischargeRateKW
+			if exportKW*durationHours > maxDischargeKWH {
+				exportKW = maxDischargeKWH / durationHours
+			}
+			if exportKW > stepBatteryKW {
+				batteryToGridKW = exportKW - stepBatteryKW
+				stepBatteryKW = exportKW
+				stepGridKW -= batteryToGridKW
+			}
+		}
+
 		// if we're supposed to be charging, or if we're below min SOC, pull from the grid
 		// whatever solar isn't giving us
 		if (state.BatteryMode == types.BatteryModeChargeAny && state.BatterySOC < 100) || state.BatterySOC < m.settings.MinBatterySOC {
@@ -231,6 +467,11 @@ func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batter
 		}
 
 		deltaKWH := -stepBatteryKW * durationHours
+		if deltaKWH > 0 {
+			deltaKWH *= efficiency
+		} else {
+			deltaKWH /= efficiency
+		}
 		state.BatterySOC += (deltaKWH / capacityKWH) * 100.0
 		if state.BatterySOC > 100 {
 			state.BatterySOC = 100
@@ -268,8 +509,10 @@ func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batter
 			}
 		} else {
 			dischargeKWH := stepBatteryKW * durationHours
+			batteryToGridKWH := batteryToGridKW * durationHours
 			stats.BatteryUsedKWH += dischargeKWH
-			stats.BatteryToHomeKWH += dischargeKWH
+			stats.BatteryToHomeKWH += dischargeKWH - batteryToGridKWH
+			stats.BatteryToGridKWH += batteryToGridKWH
 		}
 
 		if stepGridKW > 0 {
@@ -277,7 +520,7 @@ func (m *MockESS) advanceState(state *types.ESSMockState, now time.Time) (batter
 		} else {
 			exportKWH := -stepGridKW * durationHours
 			stats.GridExportKWH += exportKWH
-			stats.SolarToGridKWH += exportKWH
+			stats.SolarToGridKWH += exportKWH - batteryToGridKW*durationHours
 		}
 
 		stats.SolarToHomeKWH += math.Min(solarKWH, homeKWH)
@@ -302,15 +545,15 @@ func (m *MockESS) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	state, err := mockDB.GetESSMockState(ctx, m.siteID)
+	state, err := m.store.GetESSMockState(ctx, m.siteID)
 	if err != nil {
 		return types.SystemStatus{}, err
 	}
 
-	now := time.Now()
+	now := m.now()
 	batteryKW, solarKW, homeKW, gridKW := m.advanceState(&state, now)
 
-	if err := mockDB.UpdateESSMockState(ctx, m.siteID, state); err != nil {
+	if err := m.store.UpdateESSMockState(ctx, m.siteID, state); err != nil {
 		return types.SystemStatus{}, err
 	}
 
@@ -322,13 +565,13 @@ func (m *MockESS) GetStatus(ctx context.Context) (types.SystemStatus, error) {
 		SolarKW:               solarKW,
 		HomeKW:                homeKW,
 		GridKW:                gridKW,
-		BatteryCapacityKWH:    10.0,
-		MaxBatteryChargeKW:    5.0,
-		MaxBatteryDischargeKW: 5.0,
+		BatteryCapacityKWH:    m.cfg.capacityKWH,
+		MaxBatteryChargeKW:    m.cfg.maxChargeKW,
+		MaxBatteryDischargeKW: m.cfg.maxDischargeKW,
 		CanExportSolar:        state.SolarMode != types.SolarModeNoExport,
 		CanExportBattery:      true,
 		CanImportBattery:      state.BatteryMode == types.BatteryModeChargeAny,
-		ElevatedMinBatterySOC: state.BatteryMode != types.BatteryModeLoad,
+		ElevatedMinBatterySOC: state.BatteryMode != types.BatteryModeLoad && state.BatteryMode != types.BatteryModeExport,
 		BatteryAboveMinSOC:    false,
 	}, nil
 }
@@ -338,19 +581,19 @@ func (m *MockESS) SetModes(ctx context.Context, bat types.BatteryMode, sol types
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	state, err := mockDB.GetESSMockState(ctx, m.siteID)
+	state, err := m.store.GetESSMockState(ctx, m.siteID)
 	if err != nil {
 		return err
 	}
 
 	// advance time to now with current modes before switching
-	m.advanceState(&state, time.Now())
+	m.advanceState(&state, m.now())
 
 	// now set the modes so the next time we can apply them
 	state.BatteryMode = bat
 	state.SolarMode = sol
 
-	return mockDB.UpdateESSMockState(ctx, m.siteID, state)
+	return m.store.UpdateESSMockState(ctx, m.siteID, state)
 }
 
 // GetEnergyHistory returns historical hourly energy data between a start and end time.
@@ -358,14 +601,14 @@ func (m *MockESS) SetModes(ctx context.Context, bat types.BatteryMode, sol types
 func (m *MockESS) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	state, err := mockDB.GetESSMockState(ctx, m.siteID)
+	state, err := m.store.GetESSMockState(ctx, m.siteID)
 	if err != nil {
 		return nil, err
 	}
 
 	var history []types.EnergyStats
 	var needsSave bool
-	now := time.Now().In(m.location)
+	now := m.now().In(m.location)
 
 	if state.Timestamp.IsZero() {
 		// Backfill: previous day and today up to 'now'
@@ -392,7 +635,7 @@ func (m *MockESS) GetEnergyHistory(ctx context.Context, start, end time.Time) ([
 	}
 
 	if needsSave {
-		if err := mockDB.UpdateESSMockState(ctx, m.siteID, state); err != nil {
+		if err := m.store.UpdateESSMockState(ctx, m.siteID, state); err != nil {
 			return nil, err
 		}
 	}
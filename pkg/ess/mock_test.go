@@ -2,6 +2,8 @@ package ess
 
 import (
 	"context"
+	"math"
+	"os"
 	"testing"
 	"time"
 
@@ -50,6 +52,16 @@ func (m *MockDatabase) GetESSMockState(ctx context.Context, siteID string) (type
 	return args.Get(0).(types.ESSMockState), args.Error(1)
 }
 
+func (m *MockDatabase) UpsertBillReconciliation(ctx context.Context, siteID string, report types.BillReconciliation) error {
+	args := m.Called(ctx, siteID, report)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) GetBillReconciliations(ctx context.Context, siteID string, start, end time.Time) ([]types.BillReconciliation, error) {
+	args := m.Called(ctx, siteID, start, end)
+	return args.Get(0).([]types.BillReconciliation), args.Error(1)
+}
+
 func (m *MockDatabase) GetPriceHistory(ctx context.Context, siteID string, start, end time.Time) ([]types.Price, error) {
 	args := m.Called(ctx, siteID, start, end)
 	return args.Get(0).([]types.Price), args.Error(1)
@@ -325,6 +337,40 @@ func TestMockESS(t *testing.T) {
 		assert.Greater(t, status.BatterySOC, 10.0, "Battery should have charged even in Standby mode because it was below MinBatterySOC")
 	})
 
+	t.Run("Export", func(t *testing.T) {
+		ess := newMock("test-site")
+		_, _, err := ess.Authenticate(context.Background(), types.Credentials{})
+		require.NoError(t, err)
+		require.NoError(t, ess.ApplySettings(context.Background(), types.Settings{
+			MinBatterySOC: 20,
+		}))
+
+		start := time.Date(2026, 1, 10, 2, 0, 0, 0, ess.location)
+		state := types.ESSMockState{
+			Timestamp:   start,
+			BatterySOC:  80,
+			BatteryMode: types.BatteryModeExport,
+			SolarMode:   types.SolarModeNoExport,
+		}
+		batteryKW, solarKW, homeKW, gridKW := ess.advanceState(&state, start.Add(30*time.Minute))
+
+		// the battery discharges at the max rate with the rest going to the grid
+		assert.Equal(t, 5.0, batteryKW)
+		assert.Equal(t, 0.0, solarKW)
+		assert.InDelta(t, homeKW-batteryKW, gridKW, 0.001)
+		assert.InDelta(t, 55.0, state.BatterySOC, 0.001)
+
+		stats := state.DailyHistory[start.UTC().Format(time.RFC3339)]
+		assert.InDelta(t, 2.5, stats.BatteryUsedKWH, 0.001)
+		assert.InDelta(t, stats.BatteryUsedKWH-stats.HomeKWH, stats.BatteryToGridKWH, 0.001)
+		assert.InDelta(t, stats.BatteryToGridKWH, stats.GridExportKWH, 0.001)
+		assert.InDelta(t, 0, stats.SolarToGridKWH, 0.001)
+
+		// it stops at the min SOC
+		ess.advanceState(&state, start.Add(2*time.Hour))
+		assert.InDelta(t, 20.0, state.BatterySOC, 0.001)
+	})
+
 	t.Run("Repeated GetStatus", func(t *testing.T) {
 		db := new(MockDatabase)
 		ConfigureMock(db)
@@ -355,4 +401,189 @@ func TestMockESS(t *testing.T) {
 
 		db.AssertExpectations(t)
 	})
+
+	t.Run("Hardware Config", func(t *testing.T) {
+		ess := newMock("test-site")
+		_, _, err := ess.Authenticate(context.Background(), types.Credentials{
+			Mock: &types.MockCredentials{
+				Strategy:            "simple",
+				CapacityKWH:         "20",
+				MaxChargeKW:         "8",
+				MaxDischargeKW:      "4",
+				RoundTripEfficiency: "81",
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, ess.ApplySettings(context.Background(), types.Settings{
+			MinBatterySOC: 20,
+		}))
+
+		start := time.Date(2026, 1, 10, 2, 0, 0, 0, ess.location)
+		state := types.ESSMockState{
+			Timestamp:   start,
+			BatterySOC:  50,
+			BatteryMode: types.BatteryModeChargeAny,
+			SolarMode:   types.SolarModeNoExport,
+		}
+		batteryKW, _, _, _ := ess.advanceState(&state, start.Add(time.Hour))
+		assert.Equal(t, -8.0, batteryKW)
+		// 8 kWh in with 90% efficiency each way
+		assert.InDelta(t, 50+7.2/20*100, state.BatterySOC, 0.001)
+
+		state.BatteryMode = types.BatteryModeExport
+		batteryKW, _, _, _ = ess.advanceState(&state, start.Add(2*time.Hour))
+		assert.Equal(t, 4.0, batteryKW)
+		assert.InDelta(t, 86-4/0.9/20*100, state.BatterySOC, 0.001)
+	})
+
+	t.Run("Profile", func(t *testing.T) {
+		profile, err := os.ReadFile("testdata/mock/profile.csv")
+		require.NoError(t, err)
+
+		ess := newMock("test-site")
+		_, _, err = ess.Authenticate(context.Background(), types.Credentials{
+			Mock: &types.MockCredentials{
+				Strategy: "profile",
+				Profile:  string(profile),
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, ess.ApplySettings(context.Background(), types.Settings{
+			MinBatterySOC:   20,
+			GridExportSolar: true,
+		}))
+
+		start := time.Date(2026, 6, 10, 0, 0, 0, 0, ess.location)
+		state := types.ESSMockState{
+			Timestamp:   start,
+			BatterySOC:  50,
+			BatteryMode: types.BatteryModeStandby,
+		}
+		ess.advanceState(&state, start.Add(24*time.Hour-time.Second))
+
+		noon := state.DailyHistory[start.Add(12*time.Hour).UTC().Format(time.RFC3339)]
+		assert.InDelta(t, 1.2, noon.HomeKWH, 0.001)
+		assert.InDelta(t, 5.96, noon.SolarKWH, 0.001)
+		evening := state.DailyHistory[start.Add(20*time.Hour).UTC().Format(time.RFC3339)]
+		assert.InDelta(t, 2.5, evening.HomeKWH, 0.001)
+		assert.InDelta(t, 0, evening.SolarKWH, 0.001)
+	})
+
+	t.Run("Noise and Cloudy Days", func(t *testing.T) {
+		simulate := func(seed string) []types.EnergyStats {
+			ess := newMock("test-site")
+			_, _, err := ess.Authenticate(context.Background(), types.Credentials{
+				Mock: &types.MockCredentials{
+					Strategy:         "simple",
+					Seed:             seed,
+					NoisePercent:     "20",
+					CloudyDayPercent: "50",
+				},
+			})
+			require.NoError(t, err)
+			start := time.Date(2026, 6, 1, 0, 0, 0, 0, ess.location)
+			state := types.ESSMockState{Timestamp: start, BatterySOC: 50}
+
+			var days []types.EnergyStats
+			for day := 1; day <= 10; day++ {
+				// advance in uneven steps to make sure that doesn't matter
+				for ts := start.Add(7 * time.Minute); ts.Before(start.Add(24*time.Hour - time.Second)); ts = ts.Add(53 * time.Minute) {
+					ess.advanceState(&state, ts)
+				}
+				ess.advanceState(&state, start.Add(24*time.Hour-time.Second))
+				var total types.EnergyStats
+				for _, stats := range state.DailyHistory {
+					total.SolarKWH += stats.SolarKWH
+					total.HomeKWH += stats.HomeKWH
+				}
+				days = append(days, total)
+				start = start.Add(24 * time.Hour)
+			}
+			return days
+		}
+
+		days := simulate("42")
+		again := simulate("42")
+		other := simulate("7")
+		var different bool
+		for i := range days {
+			assert.InDelta(t, days[i].SolarKWH, again[i].SolarKWH, 0.001, "the same seed should simulate the same days")
+			assert.InDelta(t, days[i].HomeKWH, again[i].HomeKWH, 0.001, "the same seed should simulate the same days")
+			if math.Abs(days[i].HomeKWH-other[i].HomeKWH) > 0.001 {
+				different = true
+			}
+		}
+		assert.True(t, different, "a different seed should simulate different days")
+
+		var cloudy, sunny int
+		for _, day := range days {
+			if day.SolarKWH < 15 {
+				cloudy++
+			} else {
+				sunny++
+			}
+			// the noise averages out over the day
+			assert.InDelta(t, 36, day.HomeKWH, 3)
+		}
+		assert.NotZero(t, cloudy)
+		assert.NotZero(t, sunny)
+	})
+
+	t.Run("Invalid Config", func(t *testing.T) {
+		for name, creds := range map[string]types.MockCredentials{
+			"strategy":        {Strategy: "complex"},
+			"capacity":        {Strategy: "simple", CapacityKWH: "0"},
+			"efficiency":      {Strategy: "simple", RoundTripEfficiency: "120"},
+			"noise":           {Strategy: "simple", NoisePercent: "abc"},
+			"seed":            {Strategy: "simple", Seed: "1.5"},
+			"missing profile": {Strategy: "profile"},
+			"partial day":     {Strategy: "profile", Profile: "homeKW,solarKW\n1,0\n"},
+			"missing column":  {Strategy: "profile", Profile: "hour,homeKW\n0,1\n"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, _, err := newMock("test-site").Authenticate(context.Background(), types.Credentials{Mock: &creds})
+				assert.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("Simulated Clock", func(t *testing.T) {
+		ctx := context.Background()
+		loc, err := time.LoadLocation("America/Chicago")
+		require.NoError(t, err)
+		start := time.Date(2026, 6, 10, 0, 0, 0, 0, loc)
+		clock := NewMockClock(start)
+		store := NewMemoryMockStateStore()
+
+		ess := NewMock("test-site", store, clock.Now)
+		_, _, err = ess.Authenticate(ctx, types.Credentials{})
+		require.NoError(t, err)
+		require.NoError(t, ess.ApplySettings(ctx, types.Settings{MinBatterySOC: 20}))
+
+		// start the day at 50% without needing a database
+		status, err := ess.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, start, status.Timestamp)
+		assert.Equal(t, 50.0, status.BatterySOC)
+
+		// charge from the grid for an hour
+		require.NoError(t, ess.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeAny))
+		clock.Advance(time.Hour)
+		status, err = ess.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, start.Add(time.Hour), status.Timestamp)
+		assert.InDelta(t, 100.0, status.BatterySOC, 0.001)
+
+		// the rest of the day passes instantly
+		require.NoError(t, ess.SetModes(ctx, types.BatteryModeLoad, types.SolarModeAny))
+		clock.Advance(23*time.Hour - time.Second)
+		history, err := ess.GetEnergyHistory(ctx, start, start.Add(24*time.Hour))
+		require.NoError(t, err)
+		assert.Len(t, history, 24)
+		assert.InDelta(t, 5.0, history[0].BatteryChargedKWH, 0.001)
+
+		state, err := store.GetESSMockState(ctx, "test-site")
+		require.NoError(t, err)
+		assert.Equal(t, clock.Now(), state.Timestamp)
+	})
 }
@@ -0,0 +1,145 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// MockStateStore persists the simulated state of a mock ESS between calls.
+type MockStateStore interface {
+	GetESSMockState(ctx context.Context, siteID string) (types.ESSMockState, error)
+	UpdateESSMockState(ctx context.Context, siteID string, state types.ESSMockState) error
+}
+
+// MemoryMockStateStore keeps the mock state in memory.
+type MemoryMockStateStore struct {
+	mu     sync.Mutex
+	states map[string]types.ESSMockState
+}
+
+// NewMemoryMockStateStore returns an empty in-memory store.
+func NewMemoryMockStateStore() *MemoryMockStateStore {
+	return &MemoryMockStateStore{
+		states: make(map[string]types.ESSMockState),
+	}
+}
+
+// GetESSMockState returns the state for the site or an empty state if there
+// isn't one yet.
+func (s *MemoryMockStateStore) GetESSMockState(ctx context.Context, siteID string) (types.ESSMockState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.states[siteID]
+	// copy the history so the caller can't modify what we stored
+	state.DailyHistory = maps.Clone(state.DailyHistory)
+	return state, nil
+}
+
+// UpdateESSMockState stores the state for the site.
+func (s *MemoryMockStateStore) UpdateESSMockState(ctx context.Context, siteID string, state types.ESSMockState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state.DailyHistory = maps.Clone(state.DailyHistory)
+	s.states[siteID] = state
+	return nil
+}
+
+// FileMockStateStore keeps the mock state as a JSON file per site in a
+// directory so simulations survive restarts without a database.
+type FileMockStateStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileMockStateStore returns a store that writes to dir, which is created
+// if it doesn't exist.
+func NewFileMockStateStore(dir string) (*FileMockStateStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mock state directory: %w", err)
+	}
+	return &FileMockStateStore{dir: dir}, nil
+}
+
+func (s *FileMockStateStore) path(siteID string) string {
+	// the site ID comes from our own database but don't let it escape the dir
+	return filepath.Join(s.dir, filepath.Base(filepath.Clean("/"+siteID))+".json")
+}
+
+// GetESSMockState returns the state for the site or an empty state if there
+// isn't one yet.
+func (s *FileMockStateStore) GetESSMockState(ctx context.Context, siteID string) (types.ESSMockState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(s.path(siteID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return types.ESSMockState{}, nil
+	}
+	if err != nil {
+		return types.ESSMockState{}, fmt.Errorf("failed to read mock state: %w", err)
+	}
+	var state types.ESSMockState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return types.ESSMockState{}, fmt.Errorf("failed to parse mock state: %w", err)
+	}
+	return state, nil
+}
+
+// UpdateESSMockState writes the state for the site.
+func (s *FileMockStateStore) UpdateESSMockState(ctx context.Context, siteID string, state types.ESSMockState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash doesn't leave partial JSON
+	path := s.path(siteID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write mock state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// MockClock is a manually advanced clock for running a mock ESS faster than
+// real time. Pass its Now method to NewMock.
+type MockClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewMockClock returns a clock stopped at t.
+func NewMockClock(t time.Time) *MockClock {
+	return &MockClock{t: t}
+}
+
+// Now returns the clock's current time.
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d.
+func (c *MockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// Set moves the clock to t.
+func (c *MockClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
@@ -0,0 +1,77 @@
+package ess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockStateStores(t *testing.T) {
+	fileStore, err := NewFileMockStateStore(t.TempDir())
+	require.NoError(t, err)
+
+	for name, store := range map[string]MockStateStore{
+		"Memory": NewMemoryMockStateStore(),
+		"File":   fileStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			state, err := store.GetESSMockState(ctx, "site1")
+			require.NoError(t, err)
+			assert.True(t, state.Timestamp.IsZero())
+
+			ts := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+			hourKey := ts.Format(time.RFC3339)
+			require.NoError(t, store.UpdateESSMockState(ctx, "site1", types.ESSMockState{
+				Timestamp:   ts,
+				BatterySOC:  55,
+				BatteryMode: types.BatteryModeStandby,
+				SolarMode:   types.SolarModeNoExport,
+				DailyHistory: map[string]types.EnergyStats{
+					hourKey: {TSHourStart: ts, HomeKWH: 1.5},
+				},
+			}))
+
+			state, err = store.GetESSMockState(ctx, "site1")
+			require.NoError(t, err)
+			assert.True(t, ts.Equal(state.Timestamp))
+			assert.Equal(t, 55.0, state.BatterySOC)
+			assert.Equal(t, types.BatteryModeStandby, state.BatteryMode)
+			assert.Equal(t, types.SolarModeNoExport, state.SolarMode)
+			assert.Equal(t, 1.5, state.DailyHistory[hourKey].HomeKWH)
+
+			// changing what we got back doesn't change the store
+			state.DailyHistory[hourKey] = types.EnergyStats{}
+			state, err = store.GetESSMockState(ctx, "site1")
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, state.DailyHistory[hourKey].HomeKWH)
+
+			// sites are separate
+			state, err = store.GetESSMockState(ctx, "site2")
+			require.NoError(t, err)
+			assert.Zero(t, state.BatterySOC)
+		})
+	}
+
+	t.Run("File Path", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := NewFileMockStateStore(dir)
+		require.NoError(t, err)
+		assert.Equal(t, dir+"/passwd.json", store.path("../../etc/passwd"))
+	})
+}
+
+func TestMockClock(t *testing.T) {
+	start := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+	c := NewMockClock(start)
+	assert.Equal(t, start, c.Now())
+	c.Advance(90 * time.Minute)
+	assert.Equal(t, start.Add(90*time.Minute), c.Now())
+	c.Set(start)
+	assert.Equal(t, start, c.Now())
+}
@@ -0,0 +1,206 @@
+package ess
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	modbusFuncReadHoldingRegisters   = 0x03
+	modbusFuncWriteMultipleRegisters = 0x10
+
+	// the maximum number of registers in a single request
+	modbusMaxReadRegisters  = 125
+	modbusMaxWriteRegisters = 123
+
+	modbusDefaultPort = "502"
+)
+
+// modbusError is an exception response from a Modbus server.
+type modbusError struct {
+	Function  byte
+	Exception byte
+}
+
+func (e *modbusError) Error() string {
+	return fmt.Sprintf("modbus exception %d for function %d", e.Exception, e.Function)
+}
+
+// modbusClient is a minimal Modbus TCP client that supports reading and
+// writing holding registers. The connection is kept open between requests and
+// re-established if it fails.
+type modbusClient struct {
+	addr    string
+	unitID  byte
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	txID uint16
+}
+
+func newModbusClient(addr string, unitID byte) *modbusClient {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, modbusDefaultPort)
+	}
+	return &modbusClient{
+		addr:    addr,
+		unitID:  unitID,
+		timeout: 10 * time.Second,
+	}
+}
+
+// Close closes the connection if it's open.
+func (c *modbusClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// readHoldingRegisters reads count registers starting at addr, splitting the
+// read into multiple requests if needed.
+func (c *modbusClient) readHoldingRegisters(ctx context.Context, addr, count uint16) ([]uint16, error) {
+	regs := make([]uint16, 0, count)
+	for count > 0 {
+		n := min(count, modbusMaxReadRegisters)
+		pdu := make([]byte, 5)
+		pdu[0] = modbusFuncReadHoldingRegisters
+		binary.BigEndian.PutUint16(pdu[1:], addr)
+		binary.BigEndian.PutUint16(pdu[3:], n)
+		res, err := c.do(ctx, pdu)
+		if err != nil {
+			return nil, err
+		}
+		if len(res) < 2 || int(res[1]) != int(n)*2 || len(res) != 2+int(n)*2 {
+			return nil, fmt.Errorf("modbus: invalid read response length %d", len(res))
+		}
+		for i := 0; i < int(n); i++ {
+			regs = append(regs, binary.BigEndian.Uint16(res[2+i*2:]))
+		}
+		addr += n
+		count -= n
+	}
+	return regs, nil
+}
+
+// writeRegisters writes values to the registers starting at addr.
+func (c *modbusClient) writeRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	for len(values) > 0 {
+		n := min(len(values), modbusMaxWriteRegisters)
+		pdu := make([]byte, 6+n*2)
+		pdu[0] = modbusFuncWriteMultipleRegisters
+		binary.BigEndian.PutUint16(pdu[1:], addr)
+		binary.BigEndian.PutUint16(pdu[3:], uint16(n))
+		pdu[5] = byte(n * 2)
+		for i, v := range values[:n] {
+			binary.BigEndian.PutUint16(pdu[6+i*2:], v)
+		}
+		res, err := c.do(ctx, pdu)
+		if err != nil {
+			return err
+		}
+		if len(res) != 5 || binary.BigEndian.Uint16(res[1:]) != addr || binary.BigEndian.Uint16(res[3:]) != uint16(n) {
+			return errors.New("modbus: invalid write response")
+		}
+		addr += uint16(n)
+		values = values[n:]
+	}
+	return nil
+}
+
+// do sends the PDU and returns the response PDU. A request on a stale
+// connection is retried once on a new connection.
+func (c *modbusClient) do(ctx context.Context, pdu []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var lastErr error
+	for i := 0; i < 2; i++ {
+		res, err := c.roundTrip(ctx, pdu)
+		if err == nil {
+			return res, nil
+		}
+		var mbErr *modbusError
+		if errors.As(err, &mbErr) || ctx.Err() != nil {
+			return nil, err
+		}
+		// the connection is in an unknown state so start over
+		if c.conn != nil {
+			_ = c.conn.Close()
+			c.conn = nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("modbus request failed: %w", lastErr)
+}
+
+// roundTrip sends a single request. Must be called with c.mu held.
+func (c *modbusClient) roundTrip(ctx context.Context, pdu []byte) ([]byte, error) {
+	if c.conn == nil {
+		d := net.Dialer{Timeout: c.timeout}
+		conn, err := d.DialContext(ctx, "tcp", c.addr)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	c.txID++
+	frame := make([]byte, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], c.txID)
+	// protocol identifier is always 0
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(pdu)+1))
+	frame[6] = c.unitID
+	copy(frame[7:], pdu)
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 2 || length > 256 {
+			return nil, fmt.Errorf("modbus: invalid frame length %d", length)
+		}
+		res := make([]byte, length-1)
+		if _, err := io.ReadFull(c.conn, res); err != nil {
+			return nil, err
+		}
+		if binary.BigEndian.Uint16(header[0:]) != c.txID {
+			// a late response to a request that timed out
+			continue
+		}
+		if res[0] == pdu[0]|0x80 {
+			if len(res) < 2 {
+				return nil, errors.New("modbus: invalid exception response")
+			}
+			return nil, &modbusError{Function: pdu[0], Exception: res[1]}
+		}
+		if res[0] != pdu[0] {
+			return nil, fmt.Errorf("modbus: unexpected function %d in response", res[0])
+		}
+		return res, nil
+	}
+}
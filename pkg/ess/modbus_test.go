@@ -0,0 +1,220 @@
+package ess
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeModbusServer is an in-process Modbus TCP server backed by a map of
+// holding registers. Reading or writing an address that isn't in the map
+// returns an illegal data address exception.
+type fakeModbusServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu        sync.Mutex
+	unitID    byte
+	registers map[uint16]uint16
+	writes    int
+	conns     []net.Conn
+}
+
+func newFakeModbusServer(t *testing.T, unitID byte) *fakeModbusServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeModbusServer{
+		t:         t,
+		ln:        ln,
+		unitID:    unitID,
+		registers: make(map[uint16]uint16),
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.dropConnections()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *fakeModbusServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// set sets the registers starting at addr.
+func (s *fakeModbusServer) set(addr uint16, values ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range values {
+		s.registers[addr+uint16(i)] = v
+	}
+}
+
+func (s *fakeModbusServer) get(addr uint16) uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registers[addr]
+}
+
+// dropConnections closes all open connections like a device restarting.
+func (s *fakeModbusServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeModbusServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeModbusServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		if header[6] != s.unitID {
+			// devices don't respond to other unit ids
+			continue
+		}
+		res := s.process(pdu)
+		frame := make([]byte, 7+len(res))
+		copy(frame, header[:4])
+		binary.BigEndian.PutUint16(frame[4:], uint16(len(res)+1))
+		frame[6] = header[6]
+		copy(frame[7:], res)
+		if _, err := conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeModbusServer) process(pdu []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exception := func(code byte) []byte {
+		return []byte{pdu[0] | 0x80, code}
+	}
+	addr := binary.BigEndian.Uint16(pdu[1:])
+	count := binary.BigEndian.Uint16(pdu[3:])
+	switch pdu[0] {
+	case modbusFuncReadHoldingRegisters:
+		if count > modbusMaxReadRegisters {
+			return exception(3)
+		}
+		res := []byte{pdu[0], byte(count * 2)}
+		for i := uint16(0); i < count; i++ {
+			v, ok := s.registers[addr+i]
+			if !ok {
+				return exception(2)
+			}
+			res = binary.BigEndian.AppendUint16(res, v)
+		}
+		return res
+	case modbusFuncWriteMultipleRegisters:
+		if count > modbusMaxWriteRegisters || int(pdu[5]) != int(count)*2 {
+			return exception(3)
+		}
+		for i := uint16(0); i < count; i++ {
+			if _, ok := s.registers[addr+i]; !ok {
+				return exception(2)
+			}
+		}
+		for i := uint16(0); i < count; i++ {
+			s.registers[addr+i] = binary.BigEndian.Uint16(pdu[6+i*2:])
+		}
+		s.writes++
+		return pdu[:5]
+	default:
+		return exception(1)
+	}
+}
+
+func TestModbusClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Read and Write", func(t *testing.T) {
+		srv := newFakeModbusServer(t, 3)
+		values := make([]uint16, 300)
+		for i := range values {
+			values[i] = uint16(i * 2)
+		}
+		srv.set(1000, values...)
+
+		c := newModbusClient(srv.Addr(), 3)
+		defer c.Close()
+
+		// larger than a single request
+		regs, err := c.readHoldingRegisters(ctx, 1000, 300)
+		require.NoError(t, err)
+		assert.Equal(t, values, regs)
+
+		require.NoError(t, c.writeRegisters(ctx, 1100, []uint16{7, 8, 9}))
+		assert.Equal(t, uint16(8), srv.get(1101))
+
+		regs, err = c.readHoldingRegisters(ctx, 1100, 3)
+		require.NoError(t, err)
+		assert.Equal(t, []uint16{7, 8, 9}, regs)
+	})
+
+	t.Run("Exception", func(t *testing.T) {
+		srv := newFakeModbusServer(t, 1)
+		c := newModbusClient(srv.Addr(), 1)
+		defer c.Close()
+
+		_, err := c.readHoldingRegisters(ctx, 5, 1)
+		var mbErr *modbusError
+		require.True(t, errors.As(err, &mbErr))
+		assert.Equal(t, byte(2), mbErr.Exception)
+		assert.Equal(t, byte(modbusFuncReadHoldingRegisters), mbErr.Function)
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		srv := newFakeModbusServer(t, 1)
+		srv.set(0, 42)
+		c := newModbusClient(srv.Addr(), 1)
+		defer c.Close()
+
+		regs, err := c.readHoldingRegisters(ctx, 0, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []uint16{42}, regs)
+
+		srv.dropConnections()
+
+		regs, err = c.readHoldingRegisters(ctx, 0, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []uint16{42}, regs)
+	})
+
+	t.Run("Default Port", func(t *testing.T) {
+		c := newModbusClient("192.168.1.10", 1)
+		assert.Equal(t, "192.168.1.10:502", c.addr)
+		c = newModbusClient("inverter.local:1502", 1)
+		assert.Equal(t, "inverter.local:1502", c.addr)
+	})
+}
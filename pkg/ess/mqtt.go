@@ -0,0 +1,394 @@
+package ess
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttPubAck     = 4
+	mqttSubscribe  = 8
+	mqttSubAck     = 9
+	mqttPingReq    = 12
+	mqttPingResp   = 13
+	mqttDisconnect = 14
+
+	mqttKeepAlive = 60 * time.Second
+	// the largest packet we accept from the broker
+	mqttMaxPacketSize = 1 << 20
+)
+
+// mqttClient is a minimal MQTT 3.1.1 client supporting QoS 0/1 publishes and
+// subscriptions. Messages are delivered to onMessage from the connection's
+// read loop so it must not block.
+type mqttClient struct {
+	conn      net.Conn
+	onMessage func(topic string, payload []byte)
+	timeout   time.Duration
+
+	// writeMu serializes writes to the connection
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan []byte
+	err     error
+	done    chan struct{}
+}
+
+// mqttBrokerAddress returns the network address of the broker URL and
+// whether TLS should be used.
+func mqttBrokerAddress(broker string) (string, bool, error) {
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		// allow a bare host[:port]
+		u, err = url.Parse("tcp://" + broker)
+		if err != nil || u.Host == "" {
+			return "", false, fmt.Errorf("invalid mqtt broker: %s", broker)
+		}
+	}
+	var useTLS bool
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS = true
+		port = "8883"
+	default:
+		return "", false, fmt.Errorf("unsupported mqtt broker scheme: %s", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// dialMQTT connects to the broker and starts reading messages.
+func dialMQTT(ctx context.Context, broker, username, password, clientID string, onMessage func(topic string, payload []byte)) (*mqttClient, error) {
+	addr, useTLS, err := mqttBrokerAddress(broker)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mqttClient{
+		onMessage: onMessage,
+		timeout:   10 * time.Second,
+		pending:   make(map[uint16]chan []byte),
+		done:      make(chan struct{}),
+	}
+	d := net.Dialer{Timeout: c.timeout}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		td := tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		c.conn, err = td.DialContext(ctx, "tcp", addr)
+	} else {
+		c.conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mqtt broker: %w", err)
+	}
+
+	var body []byte
+	body = mqttAppendString(body, "MQTT")
+	// protocol level 4 is 3.1.1
+	body = append(body, 4)
+	// clean session
+	flags := byte(0x02)
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = mqttAppendString(body, clientID)
+	if username != "" {
+		body = mqttAppendString(body, username)
+	}
+	if password != "" {
+		body = mqttAppendString(body, password)
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = c.conn.SetDeadline(deadline)
+	if err := c.write(mqttConnect<<4, body); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(c.conn)
+	typ, res, err := mqttReadPacket(r)
+	if err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("failed to read mqtt connack: %w", err)
+	}
+	if typ>>4 != mqttConnAck || len(res) != 2 {
+		c.conn.Close()
+		return nil, errors.New("mqtt: expected connack")
+	}
+	if res[1] != 0 {
+		c.conn.Close()
+		return nil, fmt.Errorf("mqtt connection refused: code %d", res[1])
+	}
+	_ = c.conn.SetDeadline(time.Time{})
+
+	go c.readLoop(r)
+	go c.keepAlive()
+	return c, nil
+}
+
+// Close disconnects from the broker.
+func (c *mqttClient) Close() error {
+	_ = c.write(mqttDisconnect<<4, nil)
+	err := c.conn.Close()
+	c.fail(errors.New("mqtt client closed"))
+	return err
+}
+
+// Err returns why the connection closed or nil if it's still open.
+func (c *mqttClient) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// subscribe subscribes to the topics with QoS 0 and waits for the broker to
+// acknowledge them.
+func (c *mqttClient) subscribe(ctx context.Context, topics []string) error {
+	id, ch := c.register()
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, t := range topics {
+		body = mqttAppendString(body, t)
+		body = append(body, 0)
+	}
+	// subscribe has the reserved flags set to 0b0010
+	if err := c.write(mqttSubscribe<<4|0x02, body); err != nil {
+		return err
+	}
+	res, err := c.wait(ctx, id, ch)
+	if err != nil {
+		return err
+	}
+	for i, code := range res {
+		if code == 0x80 {
+			return fmt.Errorf("mqtt subscription to %s refused", topics[i])
+		}
+	}
+	return nil
+}
+
+// publish publishes the payload with QoS 1 and waits for the broker to
+// acknowledge it.
+func (c *mqttClient) publish(ctx context.Context, topic string, payload []byte, retain bool) error {
+	id, ch := c.register()
+	body := mqttAppendString(nil, topic)
+	body = binary.BigEndian.AppendUint16(body, id)
+	body = append(body, payload...)
+	header := byte(mqttPublish<<4 | 1<<1)
+	if retain {
+		header |= 0x01
+	}
+	if err := c.write(header, body); err != nil {
+		return err
+	}
+	_, err := c.wait(ctx, id, ch)
+	return err
+}
+
+func (c *mqttClient) register() (uint16, chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	if c.nextID == 0 {
+		// packet id 0 isn't allowed
+		c.nextID++
+	}
+	ch := make(chan []byte, 1)
+	c.pending[c.nextID] = ch
+	return c.nextID, ch
+}
+
+func (c *mqttClient) wait(ctx context.Context, id uint16, ch chan []byte) ([]byte, error) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, errors.New("mqtt: timed out waiting for acknowledgement")
+	}
+}
+
+func (c *mqttClient) write(header byte, body []byte) error {
+	pkt := []byte{header}
+	pkt = mqttAppendLength(pkt, len(body))
+	pkt = append(pkt, body...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(pkt); err != nil {
+		c.fail(err)
+		return fmt.Errorf("mqtt write failed: %w", err)
+	}
+	return nil
+}
+
+// fail marks the connection as closed with err.
+func (c *mqttClient) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
+
+func (c *mqttClient) keepAlive() {
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			_ = c.write(mqttPingReq<<4, nil)
+		}
+	}
+}
+
+func (c *mqttClient) readLoop(r *bufio.Reader) {
+	for {
+		header, body, err := mqttReadPacket(r)
+		if err != nil {
+			c.fail(err)
+			c.conn.Close()
+			return
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			qos := (header >> 1) & 0x03
+			topic, rest, err := mqttReadString(body)
+			if err != nil {
+				c.fail(err)
+				c.conn.Close()
+				return
+			}
+			if qos > 0 {
+				if len(rest) < 2 {
+					c.fail(errors.New("mqtt: publish missing packet id"))
+					c.conn.Close()
+					return
+				}
+				id := rest[:2]
+				rest = rest[2:]
+				_ = c.write(mqttPubAck<<4, id)
+			}
+			if c.onMessage != nil {
+				c.onMessage(topic, rest)
+			}
+		case mqttPubAck, mqttSubAck:
+			if len(body) < 2 {
+				continue
+			}
+			id := binary.BigEndian.Uint16(body)
+			c.mu.Lock()
+			ch, ok := c.pending[id]
+			c.mu.Unlock()
+			if ok {
+				ch <- body[2:]
+			}
+		case mqttPingResp:
+		}
+	}
+}
+
+// mqttReadPacket reads a single packet returning the fixed header byte and
+// the rest of the packet.
+func mqttReadPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var length, shift int
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("mqtt: invalid remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	if length > mqttMaxPacketSize {
+		return 0, nil, fmt.Errorf("mqtt: packet too large: %d", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func mqttAppendLength(b []byte, n int) []byte {
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func mqttAppendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func mqttReadString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("mqtt: short string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("mqtt: short string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
@@ -0,0 +1,290 @@
+package ess
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMQTTBroker is an in-process MQTT 3.1.1 broker supporting exact topic
+// subscriptions, retained messages and QoS 0/1 publishes.
+type fakeMQTTBroker struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu       sync.Mutex
+	username string
+	password string
+	retained map[string][]byte
+	subs     map[net.Conn][]string
+	clients  []string
+	writeMu  map[net.Conn]*sync.Mutex
+}
+
+func newFakeMQTTBroker(t *testing.T) *fakeMQTTBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &fakeMQTTBroker{
+		t:        t,
+		ln:       ln,
+		retained: make(map[string][]byte),
+		subs:     make(map[net.Conn][]string),
+		writeMu:  make(map[net.Conn]*sync.Mutex),
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		b.dropConnections()
+	})
+	go b.serve()
+	return b
+}
+
+func (b *fakeMQTTBroker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// dropConnections closes all client connections like a broker restarting.
+func (b *fakeMQTTBroker) dropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.subs {
+		_ = c.Close()
+	}
+	b.subs = make(map[net.Conn][]string)
+}
+
+// publish publishes a message as if another client had sent it.
+func (b *fakeMQTTBroker) publish(topic string, payload []byte, retain bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.route(topic, payload, retain)
+}
+
+func (b *fakeMQTTBroker) retainedMessage(topic string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+// route delivers a message to the subscribers. Must be called with b.mu held.
+func (b *fakeMQTTBroker) route(topic string, payload []byte, retain bool) {
+	if retain {
+		b.retained[topic] = payload
+	}
+	for conn, topics := range b.subs {
+		for _, t := range topics {
+			if t == topic {
+				b.send(conn, mqttPublish<<4, append(mqttAppendString(nil, topic), payload...))
+			}
+		}
+	}
+}
+
+// send writes a packet to the connection. Must be called with b.mu held.
+func (b *fakeMQTTBroker) send(conn net.Conn, header byte, body []byte) {
+	pkt := mqttAppendLength([]byte{header}, len(body))
+	pkt = append(pkt, body...)
+	mu := b.writeMu[conn]
+	mu.Lock()
+	defer mu.Unlock()
+	_, _ = conn.Write(pkt)
+}
+
+func (b *fakeMQTTBroker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.subs[conn] = nil
+		b.writeMu[conn] = &sync.Mutex{}
+		b.mu.Unlock()
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeMQTTBroker) handle(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+
+	header, body, err := mqttReadPacket(r)
+	if err != nil || header>>4 != mqttConnect {
+		return
+	}
+	// skip the protocol name and level
+	_, rest, err := mqttReadString(body)
+	if err != nil || len(rest) < 4 {
+		return
+	}
+	flags := rest[1]
+	clientID, rest, _ := mqttReadString(rest[4:])
+	var username, password string
+	if flags&0x80 != 0 {
+		username, rest, _ = mqttReadString(rest)
+	}
+	if flags&0x40 != 0 {
+		password, _, _ = mqttReadString(rest)
+	}
+	b.mu.Lock()
+	if b.username != "" && (username != b.username || password != b.password) {
+		// bad username or password
+		b.send(conn, mqttConnAck<<4, []byte{0, 4})
+		b.mu.Unlock()
+		return
+	}
+	b.clients = append(b.clients, clientID)
+	b.send(conn, mqttConnAck<<4, []byte{0, 0})
+	b.mu.Unlock()
+
+	for {
+		header, body, err := mqttReadPacket(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case mqttSubscribe:
+			id := body[:2]
+			rest := body[2:]
+			var topics []string
+			var codes []byte
+			for len(rest) > 0 {
+				var topic string
+				topic, rest, err = mqttReadString(rest)
+				if err != nil || len(rest) < 1 {
+					return
+				}
+				rest = rest[1:]
+				topics = append(topics, topic)
+				codes = append(codes, 0)
+			}
+			b.mu.Lock()
+			b.subs[conn] = append(b.subs[conn], topics...)
+			b.send(conn, mqttSubAck<<4, append(id, codes...))
+			for _, t := range topics {
+				if payload, ok := b.retained[t]; ok {
+					b.send(conn, mqttPublish<<4|0x01, append(mqttAppendString(nil, t), payload...))
+				}
+			}
+			b.mu.Unlock()
+		case mqttPublish:
+			qos := (header >> 1) & 0x03
+			topic, rest, err := mqttReadString(body)
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			if qos > 0 {
+				b.send(conn, mqttPubAck<<4, rest[:2])
+				rest = rest[2:]
+			}
+			b.route(topic, rest, header&0x01 != 0)
+			b.mu.Unlock()
+		case mqttPingReq:
+			b.mu.Lock()
+			b.send(conn, mqttPingResp<<4, nil)
+			b.mu.Unlock()
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func TestMQTTClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Publish and Subscribe", func(t *testing.T) {
+		broker := newFakeMQTTBroker(t)
+		broker.publish("a/retained", []byte("1"), true)
+
+		received := make(chan string, 10)
+		c, err := dialMQTT(ctx, broker.URL(), "", "", "test", func(topic string, payload []byte) {
+			received <- topic + "=" + string(payload)
+		})
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.NoError(t, c.subscribe(ctx, []string{"a/retained", "a/live"}))
+		assert.Equal(t, "a/retained=1", <-received)
+
+		require.NoError(t, c.publish(ctx, "a/live", []byte(`{"v":2}`), false))
+		assert.Equal(t, `a/live={"v":2}`, <-received)
+		assert.Nil(t, broker.retainedMessage("a/live"))
+
+		require.NoError(t, c.publish(ctx, "a/cmd", []byte("x"), true))
+		assert.Equal(t, []byte("x"), broker.retainedMessage("a/cmd"))
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		broker := newFakeMQTTBroker(t)
+		broker.username = "user"
+		broker.password = "pass"
+
+		_, err := dialMQTT(ctx, broker.URL(), "user", "wrong", "test", nil)
+		assert.ErrorContains(t, err, "refused")
+
+		c, err := dialMQTT(ctx, broker.URL(), "user", "pass", "test", nil)
+		require.NoError(t, err)
+		c.Close()
+	})
+
+	t.Run("Connection Lost", func(t *testing.T) {
+		broker := newFakeMQTTBroker(t)
+		c, err := dialMQTT(ctx, broker.URL(), "", "", "test", nil)
+		require.NoError(t, err)
+		assert.NoError(t, c.Err())
+
+		broker.dropConnections()
+		assert.Eventually(t, func() bool { return c.Err() != nil }, time.Second, 10*time.Millisecond)
+		assert.Error(t, c.publish(ctx, "a", nil, false))
+	})
+
+	t.Run("Broker Address", func(t *testing.T) {
+		for broker, want := range map[string]struct {
+			addr string
+			tls  bool
+		}{
+			"tcp://broker.local":      {"broker.local:1883", false},
+			"broker.local:1884":       {"broker.local:1884", false},
+			"mqtts://broker.local":    {"broker.local:8883", true},
+			"ssl://broker.local:9000": {"broker.local:9000", true},
+		} {
+			addr, useTLS, err := mqttBrokerAddress(broker)
+			require.NoError(t, err, broker)
+			assert.Equal(t, want.addr, addr, broker)
+			assert.Equal(t, want.tls, useTLS, broker)
+		}
+		_, _, err := mqttBrokerAddress("ws://broker.local")
+		assert.Error(t, err)
+	})
+
+	t.Run("Remaining Length", func(t *testing.T) {
+		for _, n := range []int{0, 127, 128, 16383, 16384, 2097151} {
+			b := mqttAppendLength(nil, n)
+			b = append(b, make([]byte, n)...)
+			r := bufio.NewReader(bytes.NewReader(append([]byte{0x30}, b...)))
+			_, body, err := mqttReadPacket(r)
+			if n > mqttMaxPacketSize {
+				assert.Error(t, err)
+				continue
+			}
+			require.NoError(t, err)
+			assert.Len(t, body, n)
+		}
+		assert.Equal(t, []byte{0x80, 0x01}, mqttAppendLength(nil, 128))
+		assert.Equal(t, uint16(0x0003), binary.BigEndian.Uint16(mqttAppendString(nil, "abc")))
+	})
+}
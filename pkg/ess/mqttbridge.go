@@ -0,0 +1,634 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+const (
+	// mqttTelemetryWait is how long to wait for telemetry after subscribing
+	mqttTelemetryWait = 10 * time.Second
+	// mqttTelemetryMaxAge is how old telemetry can be before it's ignored
+	mqttTelemetryMaxAge = 15 * time.Minute
+)
+
+// mqttBatteryModes are the names of the battery modes in command payloads.
+var mqttBatteryModes = map[types.BatteryMode]string{
+	types.BatteryModeStandby:     "standby",
+	types.BatteryModeChargeAny:   "charge_any",
+	types.BatteryModeChargeSolar: "charge_solar",
+	types.BatteryModeLoad:        "load",
+	types.BatteryModeExport:      "export",
+}
+
+// mqttSolarModes are the names of the solar modes in command payloads.
+var mqttSolarModes = map[types.SolarMode]string{
+	types.SolarModeNoExport: "no_export",
+	types.SolarModeAny:      "any",
+}
+
+// mqttField maps a value to a topic and the path of the value in the topic's
+// JSON payload. If Value is set it's used as a constant instead.
+type mqttField struct {
+	Topic string `json:"topic,omitempty"`
+	// Path is the dot separated path of the value in the payload, if empty the
+	// payload is the value
+	Path string `json:"path,omitempty"`
+	// Scale multiplies the value, e.g. 0.001 to convert W to kW
+	Scale float64  `json:"scale,omitempty"`
+	Value *float64 `json:"value,omitempty"`
+}
+
+// mqttMapping is the topic and payload mapping from the credentials. Power is
+// in kW with the battery positive when discharging and the grid positive when
+// importing. Energy counters are lifetime totals in kWh.
+type mqttMapping struct {
+	CommandTopic string `json:"commandTopic"`
+	Status       struct {
+		BatterySOC            *mqttField `json:"batterySOC"`
+		BatteryKW             *mqttField `json:"batteryKW"`
+		SolarKW               *mqttField `json:"solarKW"`
+		GridKW                *mqttField `json:"gridKW"`
+		HomeKW                *mqttField `json:"homeKW"`
+		BatteryCapacityKWH    *mqttField `json:"batteryCapacityKWH"`
+		MaxBatteryChargeKW    *mqttField `json:"maxBatteryChargeKW"`
+		MaxBatteryDischargeKW *mqttField `json:"maxBatteryDischargeKW"`
+	} `json:"status"`
+	Energy struct {
+		HomeKWH           *mqttField `json:"homeKWH"`
+		SolarKWH          *mqttField `json:"solarKWH"`
+		GridImportKWH     *mqttField `json:"gridImportKWH"`
+		GridExportKWH     *mqttField `json:"gridExportKWH"`
+		BatteryChargedKWH *mqttField `json:"batteryChargedKWH"`
+		BatteryUsedKWH    *mqttField `json:"batteryUsedKWH"`
+	} `json:"energy"`
+}
+
+// parseMQTTMapping parses and validates the mapping from the credentials.
+func parseMQTTMapping(s string) (mqttMapping, error) {
+	var m mqttMapping
+	if strings.TrimSpace(s) == "" {
+		return m, errors.New("missing mqtt mapping")
+	}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return m, fmt.Errorf("invalid mqtt mapping: %w", err)
+	}
+	if m.CommandTopic == "" {
+		return m, errors.New("mqtt mapping missing commandTopic")
+	}
+	if strings.ContainsAny(m.CommandTopic, "+#") {
+		return m, errors.New("mqtt commandTopic cannot contain wildcards")
+	}
+	required := map[string]*mqttField{
+		"batterySOC":         m.Status.BatterySOC,
+		"batteryKW":          m.Status.BatteryKW,
+		"gridKW":             m.Status.GridKW,
+		"batteryCapacityKWH": m.Status.BatteryCapacityKWH,
+	}
+	for name, f := range required {
+		if f == nil {
+			return m, fmt.Errorf("mqtt mapping missing status.%s", name)
+		}
+	}
+	for _, f := range m.fields() {
+		if f.Value == nil && f.Topic == "" {
+			return m, errors.New("mqtt mapping fields need a topic or value")
+		}
+		if strings.ContainsAny(f.Topic, "+#") {
+			return m, fmt.Errorf("mqtt topic %s cannot contain wildcards", f.Topic)
+		}
+	}
+	return m, nil
+}
+
+// fields returns all of the mapped fields.
+func (m *mqttMapping) fields() []*mqttField {
+	var res []*mqttField
+	for _, f := range []*mqttField{
+		m.Status.BatterySOC, m.Status.BatteryKW, m.Status.SolarKW, m.Status.GridKW,
+		m.Status.HomeKW, m.Status.BatteryCapacityKWH, m.Status.MaxBatteryChargeKW,
+		m.Status.MaxBatteryDischargeKW, m.Energy.HomeKWH, m.Energy.SolarKWH,
+		m.Energy.GridImportKWH, m.Energy.GridExportKWH, m.Energy.BatteryChargedKWH,
+		m.Energy.BatteryUsedKWH,
+	} {
+		if f != nil {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// topics returns the unique telemetry topics.
+func (m *mqttMapping) topics() []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, f := range m.fields() {
+		if f.Topic != "" && !seen[f.Topic] {
+			seen[f.Topic] = true
+			res = append(res, f.Topic)
+		}
+	}
+	return res
+}
+
+// mqttPathValue returns the number at the dot separated path in the payload.
+// Numbers encoded as strings and booleans are also accepted.
+func mqttPathValue(payload []byte, path string) (float64, error) {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		if path != "" {
+			return 0, fmt.Errorf("invalid json payload: %w", err)
+		}
+		// a bare value that isn't valid JSON like "on" or " 12.5\n"
+		v = strings.TrimSpace(string(payload))
+	}
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch cur := v.(type) {
+			case map[string]interface{}:
+				var ok bool
+				if v, ok = cur[key]; !ok {
+					return 0, fmt.Errorf("missing %s", key)
+				}
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(cur) {
+					return 0, fmt.Errorf("invalid index %s", key)
+				}
+				v = cur[i]
+			default:
+				return 0, fmt.Errorf("cannot find %s in %T", key, v)
+			}
+		}
+	}
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case bool:
+		if val {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a number: %q", val)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("not a number: %v", v)
+	}
+}
+
+type mqttMessage struct {
+	payload  []byte
+	received time.Time
+}
+
+// MQTTBridge implements the System interface for any battery that can be
+// driven over MQTT, e.g. through Home Assistant or Node-RED. Commands are
+// published as JSON to a single topic and the status is built from telemetry
+// topics using the mapping in the credentials.
+type MQTTBridge struct {
+	mu       sync.Mutex
+	settings types.Settings
+	siteID   string
+	creds    types.MQTTCredentials
+	mapping  mqttMapping
+	location *time.Location
+	client   *mqttClient
+
+	// messages are written from the client's read loop so they have their own
+	// lock
+	msgMu    sync.Mutex
+	messages map[string]mqttMessage
+	received chan struct{}
+
+	// telemetry only has the current values so we build the history from
+	// snapshots of the energy counters
+	history *meterHistory
+}
+
+func newMQTTBridge(siteID string) *MQTTBridge {
+	return &MQTTBridge{
+		siteID:   siteID,
+		messages: make(map[string]mqttMessage),
+		received: make(chan struct{}, 1),
+		history:  newMeterHistory(),
+	}
+}
+
+func mqttInfo() types.ESSProviderInfo {
+	return types.ESSProviderInfo{
+		ID:           "mqtt",
+		Name:         "MQTT Bridge",
+		Capabilities: mqttCapabilities(),
+		Credentials: []types.ESSCredential{
+			{
+				Field:       "broker",
+				Name:        "Broker URL",
+				Type:        "string",
+				Required:    true,
+				Description: "The MQTT broker, e.g. mqtts://broker.example.com:8883. It must be reachable from the internet.",
+			},
+			{
+				Field:    "username",
+				Name:     "Username (Optional)",
+				Type:     "string",
+				Required: false,
+			},
+			{
+				Field:    "password",
+				Name:     "Password (Optional)",
+				Type:     "password",
+				Required: false,
+			},
+			{
+				Field:       "mapping",
+				Name:        "Topic Mapping",
+				Type:        "string",
+				Required:    true,
+				Description: "JSON with the commandTopic and the topic/path of each status and energy value.",
+			},
+			{
+				Field:       "location",
+				Name:        "Timezone (Optional)",
+				Type:        "string",
+				Required:    false,
+				Description: "The battery's timezone, defaults to America/Chicago.",
+			},
+		},
+	}
+}
+
+// ApplySettings applies the given settings to the MQTTBridge struct.
+func (b *MQTTBridge) ApplySettings(ctx context.Context, settings types.Settings) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settings = settings
+	return nil
+}
+
+// Capabilities returns every mode since it's up to the bridge to implement
+// them.
+func (b *MQTTBridge) Capabilities() types.ESSCapabilities {
+	return mqttCapabilities()
+}
+
+// mqttCapabilities are the modes that can be sent in commands.
+func mqttCapabilities() types.ESSCapabilities {
+	return types.AllESSCapabilities()
+}
+
+// Authenticate validates the mapping and connects to the broker. If no
+// timezone was given the default is filled in.
+func (b *MQTTBridge) Authenticate(ctx context.Context, creds types.Credentials) (types.Credentials, bool, error) {
+	if creds.MQTT == nil {
+		return creds, false, errors.New("missing mqtt credentials")
+	}
+	if creds.MQTT.Broker == "" {
+		return creds, false, errors.New("missing mqtt broker")
+	}
+	if _, _, err := mqttBrokerAddress(creds.MQTT.Broker); err != nil {
+		return creds, false, err
+	}
+	mapping, err := parseMQTTMapping(creds.MQTT.Mapping)
+	if err != nil {
+		return creds, false, err
+	}
+	var updated bool
+	if creds.MQTT.Location == "" {
+		creds.MQTT.Location = "America/Chicago"
+		updated = true
+	}
+	loc, err := time.LoadLocation(creds.MQTT.Location)
+	if err != nil {
+		return creds, false, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client != nil && b.creds != *creds.MQTT {
+		_ = b.client.Close()
+		b.client = nil
+		b.msgMu.Lock()
+		b.messages = make(map[string]mqttMessage)
+		b.msgMu.Unlock()
+	}
+	b.creds = *creds.MQTT
+	b.mapping = mapping
+	b.location = loc
+
+	if err := b.ensureConnected(ctx); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "mqtt credential validation failed", slog.Any("error", err))
+		return creds, false, fmt.Errorf("credential validation failed: %w", err)
+	}
+	return creds, updated, nil
+}
+
+// ensureConnected connects and subscribes to the telemetry topics if we
+// aren't connected. Must be called with b.mu held.
+func (b *MQTTBridge) ensureConnected(ctx context.Context) error {
+	if b.creds.Broker == "" {
+		return errors.New("mqtt not authenticated")
+	}
+	if b.client != nil {
+		err := b.client.Err()
+		if err == nil {
+			return nil
+		}
+		log.Ctx(ctx).WarnContext(ctx, "mqtt connection lost, reconnecting", slog.Any("error", err))
+		b.client = nil
+	}
+
+	clientID := "raterudder-" + b.siteID
+	client, err := dialMQTT(ctx, b.creds.Broker, b.creds.Username, b.creds.Password, clientID, b.onMessage)
+	if err != nil {
+		return err
+	}
+	if topics := b.mapping.topics(); len(topics) > 0 {
+		if err := client.subscribe(ctx, topics); err != nil {
+			_ = client.Close()
+			return fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+	log.Ctx(ctx).DebugContext(ctx, "connected to mqtt broker", slog.String("clientID", clientID))
+	b.client = client
+	return nil
+}
+
+func (b *MQTTBridge) onMessage(topic string, payload []byte) {
+	b.msgMu.Lock()
+	b.messages[topic] = mqttMessage{payload: payload, received: time.Now()}
+	b.msgMu.Unlock()
+	select {
+	case b.received <- struct{}{}:
+	default:
+	}
+}
+
+// value returns the current value of the field.
+func (b *MQTTBridge) value(f *mqttField) (float64, error) {
+	if f.Value != nil {
+		return *f.Value, nil
+	}
+	b.msgMu.Lock()
+	msg, ok := b.messages[f.Topic]
+	b.msgMu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("no telemetry received on %s", f.Topic)
+	}
+	if time.Since(msg.received) > mqttTelemetryMaxAge {
+		return 0, fmt.Errorf("telemetry on %s is stale", f.Topic)
+	}
+	v, err := mqttPathValue(msg.payload, f.Path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s from %s: %w", f.Path, f.Topic, err)
+	}
+	scale := f.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return v * scale, nil
+}
+
+// waitForTelemetry waits until a message has been received on every topic.
+// Retained messages are delivered right after subscribing so this is usually
+// quick. Must be called with b.mu held.
+func (b *MQTTBridge) waitForTelemetry(ctx context.Context) error {
+	topics := b.mapping.topics()
+	timer := time.NewTimer(mqttTelemetryWait)
+	defer timer.Stop()
+	for {
+		var missing []string
+		b.msgMu.Lock()
+		for _, t := range topics {
+			if msg, ok := b.messages[t]; !ok || time.Since(msg.received) > mqttTelemetryMaxAge {
+				missing = append(missing, t)
+			}
+		}
+		b.msgMu.Unlock()
+		if len(missing) == 0 {
+			return nil
+		}
+
+		select {
+		case <-b.received:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("no telemetry received on %s", strings.Join(missing, ", "))
+		}
+	}
+}
+
+// optionalValue returns the value of f or def if f isn't mapped.
+func (b *MQTTBridge) optionalValue(f *mqttField, def float64) (float64, error) {
+	if f == nil {
+		return def, nil
+	}
+	return b.value(f)
+}
+
+// recordSnapshot records the energy counters if they're mapped. Must be
+// called with b.mu held.
+func (b *MQTTBridge) recordSnapshot(ctx context.Context, soc float64) {
+	e := b.mapping.Energy
+	if e.HomeKWH == nil || e.SolarKWH == nil || e.GridImportKWH == nil || e.GridExportKWH == nil {
+		return
+	}
+	var c meterCounters
+	for _, v := range []struct {
+		f    *mqttField
+		dest *float64
+	}{
+		{e.HomeKWH, &c.HomeWH},
+		{e.SolarKWH, &c.SolarWH},
+		{e.GridImportKWH, &c.GridImportWH},
+		{e.GridExportKWH, &c.GridExportWH},
+		{e.BatteryChargedKWH, &c.BatteryChargedWH},
+		{e.BatteryUsedKWH, &c.BatteryUsedWH},
+	} {
+		if v.f == nil {
+			continue
+		}
+		kwh, err := b.value(v.f)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to read mqtt energy counter, skipping history snapshot", slog.Any("error", err))
+			return
+		}
+		*v.dest = kwh * 1000
+	}
+	// without battery counters the battery's energy is whatever is left over
+	// after the other counters
+	b.history.deriveBattery = e.BatteryChargedKWH == nil || e.BatteryUsedKWH == nil
+	b.history.record(time.Now(), c, soc)
+}
+
+// GetStatus returns the status built from the latest telemetry.
+func (b *MQTTBridge) GetStatus(ctx context.Context) (types.SystemStatus, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting mqtt system status")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.ensureConnected(ctx); err != nil {
+		return types.SystemStatus{}, err
+	}
+	if err := b.waitForTelemetry(ctx); err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	st := b.mapping.Status
+	soc, err := b.value(st.BatterySOC)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	batteryKW, err := b.value(st.BatteryKW)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	gridKW, err := b.value(st.GridKW)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	capacityKWH, err := b.value(st.BatteryCapacityKWH)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	solarKW, err := b.optionalValue(st.SolarKW, 0)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	// the home uses whatever isn't going to or coming from somewhere else
+	homeKW, err := b.optionalValue(st.HomeKW, solarKW+gridKW+batteryKW)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	maxChargeKW, err := b.optionalValue(st.MaxBatteryChargeKW, 0)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+	maxDischargeKW, err := b.optionalValue(st.MaxBatteryDischargeKW, 0)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	b.recordSnapshot(ctx, soc)
+
+	log.Ctx(ctx).DebugContext(
+		ctx,
+		"mqtt status",
+		slog.Float64("soc", soc),
+		slog.Float64("batteryKW", batteryKW),
+		slog.Float64("solarKW", solarKW),
+		slog.Float64("gridKW", gridKW),
+		slog.Float64("homeKW", homeKW),
+	)
+
+	return types.SystemStatus{
+		Timestamp:             time.Now().In(b.location),
+		BatterySOC:            soc,
+		BatteryKW:             batteryKW,
+		SolarKW:               math.Max(solarKW, 0),
+		GridKW:                gridKW,
+		HomeKW:                homeKW,
+		BatteryCapacityKWH:    capacityKWH,
+		MaxBatteryChargeKW:    maxChargeKW,
+		MaxBatteryDischargeKW: maxDischargeKW,
+		// whatever is on the other end of the bridge is expected to follow the
+		// settings sent with each command
+		CanExportSolar:     b.settings.GridExportSolar,
+		CanExportBattery:   false,
+		CanImportBattery:   b.settings.GridChargeBatteries,
+		BatteryAboveMinSOC: soc >= b.settings.MinBatterySOC,
+	}, nil
+}
+
+// mqttCommand is the payload published to the command topic.
+type mqttCommand struct {
+	BatteryMode         string    `json:"batteryMode,omitempty"`
+	SolarMode           string    `json:"solarMode,omitempty"`
+	MinBatterySOC       float64   `json:"minBatterySOC"`
+	GridChargeBatteries bool      `json:"gridChargeBatteries"`
+	GridExportSolar     bool      `json:"gridExportSolar"`
+	Timestamp           time.Time `json:"timestamp"`
+}
+
+// SetModes publishes the modes to the command topic. The command is retained
+// so whatever is driving the battery gets the latest command when it
+// reconnects.
+func (b *MQTTBridge) SetModes(ctx context.Context, bat types.BatteryMode, sol types.SolarMode) error {
+	log.Ctx(ctx).DebugContext(ctx, "SetModes called", slog.Any("batteryMode", bat), slog.Any("solarMode", sol))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if bat == types.BatteryModeNoChange && sol == types.SolarModeNoChange {
+		return nil
+	}
+	cmd := mqttCommand{
+		MinBatterySOC:       b.settings.MinBatterySOC,
+		GridChargeBatteries: b.settings.GridChargeBatteries,
+		GridExportSolar:     b.settings.GridExportSolar,
+		Timestamp:           time.Now().UTC(),
+	}
+	if bat != types.BatteryModeNoChange {
+		name, ok := mqttBatteryModes[bat]
+		if !ok {
+			return fmt.Errorf("unknown battery mode: %v", bat)
+		}
+		cmd.BatteryMode = name
+	}
+	if sol != types.SolarModeNoChange {
+		name, ok := mqttSolarModes[sol]
+		if !ok {
+			return fmt.Errorf("unknown solar mode: %v", sol)
+		}
+		cmd.SolarMode = name
+	}
+
+	if err := b.ensureConnected(ctx); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	log.Ctx(ctx).InfoContext(ctx, "publishing mqtt command", slog.String("topic", b.mapping.CommandTopic), slog.String("payload", string(payload)))
+	if err := b.client.publish(ctx, b.mapping.CommandTopic, payload, true); err != nil {
+		return fmt.Errorf("failed to publish command: %w", err)
+	}
+	return nil
+}
+
+// GetEnergyHistory returns the hourly energy history from the mapped energy
+// counters, or nothing if none are mapped.
+func (b *MQTTBridge) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting mqtt energy history", slog.Time("start", start), slog.Time("end", end))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.ensureConnected(ctx); err != nil {
+		return nil, err
+	}
+	if err := b.waitForTelemetry(ctx); err != nil {
+		return nil, err
+	}
+	soc, err := b.value(b.mapping.Status.BatterySOC)
+	if err != nil {
+		return nil, err
+	}
+	b.recordSnapshot(ctx, soc)
+	return b.history.stats(start, end, b.location), nil
+}
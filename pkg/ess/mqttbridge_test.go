@@ -0,0 +1,218 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMQTTMapping = `{
+	"commandTopic": "raterudder/battery/set",
+	"status": {
+		"batterySOC": {"topic": "home/battery", "path": "soc"},
+		"batteryKW": {"topic": "home/battery", "path": "power", "scale": 0.001},
+		"solarKW": {"topic": "home/solar/power", "scale": 0.001},
+		"gridKW": {"topic": "home/grid", "path": "meters.0.power", "scale": 0.001},
+		"batteryCapacityKWH": {"value": 13.5},
+		"maxBatteryChargeKW": {"value": 5},
+		"maxBatteryDischargeKW": {"value": 5}
+	},
+	"energy": {
+		"homeKWH": {"topic": "home/energy", "path": "home"},
+		"solarKWH": {"topic": "home/energy", "path": "solar"},
+		"gridImportKWH": {"topic": "home/energy", "path": "import"},
+		"gridExportKWH": {"topic": "home/energy", "path": "export"}
+	}
+}`
+
+func publishTestMQTTTelemetry(broker *fakeMQTTBroker) {
+	broker.publish("home/battery", []byte(`{"soc": 64, "power": -1500}`), true)
+	broker.publish("home/solar/power", []byte(`4000`), true)
+	broker.publish("home/grid", []byte(`{"meters": [{"power": "-500"}]}`), true)
+	broker.publish("home/energy", []byte(`{"home": 1000, "solar": 800, "import": 400, "export": 150}`), true)
+}
+
+func newTestMQTTBridge(t *testing.T) (*MQTTBridge, *fakeMQTTBroker) {
+	broker := newFakeMQTTBroker(t)
+	publishTestMQTTTelemetry(broker)
+
+	b := newMQTTBridge("site1")
+	require.NoError(t, b.ApplySettings(context.Background(), types.Settings{
+		MinBatterySOC:       20,
+		GridChargeBatteries: true,
+		GridExportSolar:     true,
+	}))
+	_, _, err := b.Authenticate(context.Background(), types.Credentials{
+		MQTT: &types.MQTTCredentials{Broker: broker.URL(), Mapping: testMQTTMapping, Location: "America/New_York"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.client.Close() })
+	return b, broker
+}
+
+func TestMQTTBridge(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Authenticate", func(t *testing.T) {
+		broker := newFakeMQTTBroker(t)
+		broker.username = "user"
+		broker.password = "pass"
+
+		b := newMQTTBridge("site1")
+		_, _, err := b.Authenticate(ctx, types.Credentials{})
+		assert.Error(t, err)
+
+		_, _, err = b.Authenticate(ctx, types.Credentials{
+			MQTT: &types.MQTTCredentials{Broker: broker.URL(), Mapping: `{"commandTopic": "a"}`},
+		})
+		assert.ErrorContains(t, err, "missing status")
+
+		_, _, err = b.Authenticate(ctx, types.Credentials{
+			MQTT: &types.MQTTCredentials{Broker: broker.URL(), Mapping: testMQTTMapping, Username: "user", Password: "wrong"},
+		})
+		assert.ErrorContains(t, err, "credential validation failed")
+
+		creds, updated, err := b.Authenticate(ctx, types.Credentials{
+			MQTT: &types.MQTTCredentials{Broker: broker.URL(), Mapping: testMQTTMapping, Username: "user", Password: "pass"},
+		})
+		require.NoError(t, err)
+		defer b.client.Close()
+		assert.True(t, updated)
+		assert.Equal(t, "America/Chicago", creds.MQTT.Location)
+		broker.mu.Lock()
+		assert.Equal(t, []string{"raterudder-site1"}, broker.clients)
+		broker.mu.Unlock()
+	})
+
+	t.Run("GetStatus", func(t *testing.T) {
+		b, _ := newTestMQTTBridge(t)
+
+		status, err := b.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.InDelta(t, 64.0, status.BatterySOC, 0.001)
+		assert.InDelta(t, -1.5, status.BatteryKW, 0.001)
+		assert.InDelta(t, 4.0, status.SolarKW, 0.001)
+		assert.InDelta(t, -0.5, status.GridKW, 0.001)
+		// derived from the others
+		assert.InDelta(t, 2.0, status.HomeKW, 0.001)
+		assert.InDelta(t, 13.5, status.BatteryCapacityKWH, 0.001)
+		assert.InDelta(t, 5.0, status.MaxBatteryChargeKW, 0.001)
+		assert.True(t, status.BatteryAboveMinSOC)
+		assert.True(t, status.CanImportBattery)
+		assert.Equal(t, "America/New_York", status.Timestamp.Location().String())
+	})
+
+	t.Run("GetStatus Live Telemetry", func(t *testing.T) {
+		b, broker := newTestMQTTBridge(t)
+		_, err := b.GetStatus(ctx)
+		require.NoError(t, err)
+
+		broker.publish("home/battery", []byte(`{"soc": 65, "power": 2000}`), false)
+		assert.Eventually(t, func() bool {
+			status, err := b.GetStatus(ctx)
+			return err == nil && status.BatterySOC == 65
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("GetStatus Missing Telemetry", func(t *testing.T) {
+		broker := newFakeMQTTBroker(t)
+		b := newMQTTBridge("site1")
+		_, _, err := b.Authenticate(ctx, types.Credentials{
+			MQTT: &types.MQTTCredentials{Broker: broker.URL(), Mapping: testMQTTMapping},
+		})
+		require.NoError(t, err)
+		defer b.client.Close()
+
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err = b.GetStatus(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("GetStatus Reconnects", func(t *testing.T) {
+		b, broker := newTestMQTTBridge(t)
+		broker.dropConnections()
+		require.Eventually(t, func() bool { return b.client.Err() != nil }, time.Second, 10*time.Millisecond)
+
+		_, err := b.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.NoError(t, b.client.Err())
+	})
+
+	t.Run("SetModes", func(t *testing.T) {
+		b, broker := newTestMQTTBridge(t)
+
+		require.NoError(t, b.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeAny))
+		var cmd map[string]interface{}
+		require.NoError(t, json.Unmarshal(broker.retainedMessage("raterudder/battery/set"), &cmd))
+		assert.Equal(t, "charge_any", cmd["batteryMode"])
+		assert.Equal(t, "any", cmd["solarMode"])
+		assert.Equal(t, 20.0, cmd["minBatterySOC"])
+		assert.Equal(t, true, cmd["gridChargeBatteries"])
+		assert.NotEmpty(t, cmd["timestamp"])
+
+		require.NoError(t, b.SetModes(ctx, types.BatteryModeLoad, types.SolarModeNoChange))
+		cmd = nil
+		require.NoError(t, json.Unmarshal(broker.retainedMessage("raterudder/battery/set"), &cmd))
+		assert.Equal(t, "load", cmd["batteryMode"])
+		assert.NotContains(t, cmd, "solarMode")
+
+		assert.Error(t, b.SetModes(ctx, types.BatteryMode(42), types.SolarModeNoChange))
+	})
+
+	t.Run("GetEnergyHistory", func(t *testing.T) {
+		b, _ := newTestMQTTBridge(t)
+
+		hour := time.Now().Truncate(time.Hour).Add(-time.Hour)
+		b.history.record(hour, meterCounters{
+			HomeWH:       998000,
+			SolarWH:      797000,
+			GridImportWH: 399500,
+			GridExportWH: 150000,
+		}, 60)
+
+		stats, err := b.GetEnergyHistory(ctx, hour, time.Now())
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+		assert.InDelta(t, 2.0, stats[0].HomeKWH, 0.001)
+		assert.InDelta(t, 3.0, stats[0].SolarKWH, 0.001)
+		assert.InDelta(t, 0.5, stats[0].GridImportKWH, 0.001)
+		// there are no battery counters so the rest went to the battery
+		assert.True(t, b.history.deriveBattery)
+		assert.InDelta(t, 1.5, stats[0].BatteryChargedKWH, 0.001)
+		assert.InDelta(t, 0.0, stats[0].BatteryUsedKWH, 0.001)
+	})
+}
+
+func TestMQTTPathValue(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		path    string
+		want    float64
+		err     bool
+	}{
+		{`12.5`, "", 12.5, false},
+		{` 7 `, "", 7, false},
+		{`"3.5"`, "", 3.5, false},
+		{`true`, "", 1, false},
+		{`{"a": {"b": 2}}`, "a.b", 2, false},
+		{`{"a": [1, {"b": 4}]}`, "a.1.b", 4, false},
+		{`{"a": 1}`, "b", 0, true},
+		{`{"a": [1]}`, "a.3", 0, true},
+		{`on`, "", 0, true},
+		{`not json`, "a", 0, true},
+	} {
+		v, err := mqttPathValue([]byte(tc.payload), tc.path)
+		if tc.err {
+			assert.Error(t, err, tc.payload)
+			continue
+		}
+		require.NoError(t, err, tc.payload)
+		assert.Equal(t, tc.want, v, tc.payload)
+	}
+}
@@ -0,0 +1,581 @@
+package ess
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/common"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+const (
+	powerwallLoginPath = "/api/login/Basic"
+
+	powerwallModeSelfConsumption = "self_consumption"
+	powerwallModeBackup          = "backup"
+
+	powerwallGridConnected = "SystemGridConnected"
+)
+
+// Powerwall implements the System interface for a Tesla Powerwall using the
+// gateway's local HTTPS API.
+type Powerwall struct {
+	client   *http.Client
+	baseURL  string
+	email    string
+	password string
+	tokenStr string
+	mu       sync.Mutex
+	settings types.Settings
+	location *time.Location
+
+	// the local API has no history, only the meters' lifetime totals
+	history *meterHistory
+}
+
+func newPowerwall() *Powerwall {
+	// the gateway only serves a self-signed certificate
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402
+	return &Powerwall{
+		client:  common.LocalHTTPClientWithTransport(time.Minute, transport),
+		history: newMeterHistory(),
+	}
+}
+
+// powerwallFaultAlerts are system alerts that don't have Fault or Failure in
+// their name but still mean the system isn't working.
+var powerwallFaultAlerts = []string{"BatteryBreakerOpen", "BatteryComms"}
+
+// isPowerwallFault returns whether the system alert is a fault. Most alerts,
+// like SystemConnectedToGrid or FWUpdateSucceeded, are informational and
+// reported all the time so they aren't alarms.
+func isPowerwallFault(alert string) bool {
+	return strings.Contains(alert, "Fault") || strings.Contains(alert, "Failure") || slices.Contains(powerwallFaultAlerts, alert)
+}
+
+func powerwallInfo() types.ESSProviderInfo {
+	return types.ESSProviderInfo{
+		ID:           "powerwall",
+		Name:         "Tesla Powerwall",
+		Capabilities: powerwallCapabilities(),
+		Credentials: []types.ESSCredential{
+			{
+				Field:       "host",
+				Name:        "Gateway Address",
+				Type:        "string",
+				Required:    true,
+				Description: "IP address or hostname of the Powerwall Gateway on your local network.",
+			},
+			{
+				Field:    "email",
+				Name:     "Email (Optional)",
+				Type:     "string",
+				Required: false,
+			},
+			{
+				Field:       "password",
+				Name:        "Customer Password",
+				Type:        "password",
+				Required:    true,
+				Description: "The customer password for the gateway, usually the last 5 characters of the gateway password.",
+			},
+		},
+	}
+}
+
+// ApplySettings applies the given settings to the Powerwall struct.
+func (p *Powerwall) ApplySettings(ctx context.Context, settings types.Settings) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settings = settings
+	return nil
+}
+
+// Capabilities returns the modes that can be set through the backup reserve.
+func (p *Powerwall) Capabilities() types.ESSCapabilities {
+	return powerwallCapabilities()
+}
+
+// powerwallCapabilities are the capabilities of the local API which can only
+// change the backup reserve.
+func powerwallCapabilities() types.ESSCapabilities {
+	return types.ESSCapabilities{
+		BatteryModes: []types.BatteryMode{types.BatteryModeChargeAny, types.BatteryModeChargeSolar, types.BatteryModeStandby, types.BatteryModeLoad},
+		GridCharging: true,
+	}
+}
+
+// Authenticate logs into the gateway unless a token is already stored in
+// creds and the host/password haven't changed. After a successful login the
+// new token is written back into creds so the caller can persist it.
+func (p *Powerwall) Authenticate(ctx context.Context, creds types.Credentials) (types.Credentials, bool, error) {
+	if creds.Powerwall == nil {
+		return creds, false, errors.New("missing powerwall credentials")
+	}
+	if creds.Powerwall.Host == "" {
+		return creds, false, errors.New("missing powerwall gateway host")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	baseURL, err := powerwallBaseURL(creds.Powerwall.Host)
+	if err != nil {
+		return creds, false, err
+	}
+
+	var changed bool
+	needLogin := creds.Powerwall.Token == ""
+	if !needLogin && p.baseURL != "" {
+		// we've previously authenticated so check if the credentials changed
+		needLogin = p.baseURL != baseURL || p.email != creds.Powerwall.Email || p.password != creds.Powerwall.Password
+	}
+
+	p.baseURL = baseURL
+	if needLogin {
+		log.Ctx(ctx).DebugContext(ctx, "logging in to powerwall gateway")
+		token, err := p.login(ctx, creds.Powerwall.Email, creds.Powerwall.Password)
+		if err != nil {
+			return creds, false, err
+		}
+		p.tokenStr = token
+		creds.Powerwall.Token = token
+		changed = true
+	} else {
+		log.Ctx(ctx).DebugContext(ctx, "restored powerwall credentials from cache")
+		p.tokenStr = creds.Powerwall.Token
+	}
+	p.email = creds.Powerwall.Email
+	p.password = creds.Powerwall.Password
+
+	// validate the credentials and cache the site's timezone
+	if _, err := p.getLocation(ctx); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "powerwall credential validation failed", slog.Any("error", err))
+		return creds, false, fmt.Errorf("credential validation failed: %w", err)
+	}
+
+	return creds, changed, nil
+}
+
+// powerwallBaseURL returns the base URL of the gateway for a host which may
+// or may not include the scheme.
+func powerwallBaseURL(host string) (string, error) {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid powerwall gateway host: %w", err)
+	}
+	if u.Host == "" {
+		return "", errors.New("invalid powerwall gateway host")
+	}
+	return u.String(), nil
+}
+
+type powerwallLoginResult struct {
+	Email     string   `json:"email"`
+	Token     string   `json:"token"`
+	Roles     []string `json:"roles"`
+	LoginTime string   `json:"loginTime"`
+}
+
+func (p *Powerwall) login(ctx context.Context, email, password string) (string, error) {
+	if password == "" {
+		return "", errors.New("missing password")
+	}
+
+	data := map[string]interface{}{
+		"username":     "customer",
+		"email":        email,
+		"password":     password,
+		"force_sm_off": false,
+	}
+	var res powerwallLoginResult
+	if err := p.doRequest(ctx, "POST", powerwallLoginPath, data, &res); err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "powerwall login failed", slog.Any("error", err))
+		return "", fmt.Errorf("login failed: %w", err)
+	}
+	if res.Token == "" {
+		return "", errors.New("login failed: missing token")
+	}
+	log.Ctx(ctx).DebugContext(ctx, "powerwall login success")
+	return res.Token, nil
+}
+
+// ensureLogin logs in if we don't have a token
+func (p *Powerwall) ensureLogin(ctx context.Context) error {
+	if p.baseURL == "" {
+		return errors.New("powerwall not authenticated")
+	}
+	if p.tokenStr == "" {
+		token, err := p.login(ctx, p.email, p.password)
+		if err != nil {
+			return fmt.Errorf("failed to login: %w", err)
+		}
+		p.tokenStr = token
+	}
+	return nil
+}
+
+func (p *Powerwall) doRequest(ctx context.Context, method, path string, data interface{}, dest interface{}) error {
+	isLogin := path == powerwallLoginPath
+
+	// we try up to 2 times because we might have an expired token
+	for i := 0; i < 2; i++ {
+		var body io.Reader
+		if data != nil {
+			b, err := json.Marshal(data)
+			if err != nil {
+				return err
+			}
+			body = bytes.NewReader(b)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if !isLogin {
+			req.Header.Set("Authorization", "Bearer "+p.tokenStr)
+			req.AddCookie(&http.Cookie{Name: "AuthCookie", Value: p.tokenStr})
+		}
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return err
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			if !isLogin && p.tokenStr != "" {
+				log.Ctx(ctx).DebugContext(ctx, "powerwall token expired")
+				p.tokenStr = ""
+				if err := p.ensureLogin(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Ctx(ctx).ErrorContext(ctx, "powerwall api error", slog.Int("status", resp.StatusCode), slog.String("path", path), slog.String("body", string(respBody)))
+			return fmt.Errorf("powerwall api error: %w", common.NewStatusError(resp))
+		}
+
+		if dest != nil {
+			if err := json.Unmarshal(respBody, dest); err != nil {
+				log.Ctx(ctx).ErrorContext(ctx, "failed to decode powerwall response", slog.Any("error", err), slog.String("body", string(respBody)))
+				return fmt.Errorf("failed to decode powerwall response: %w", err)
+			}
+		}
+		return nil
+	}
+	return errors.New("powerwall authentication failed")
+}
+
+// getLocation returns the site's timezone, fetching it from the gateway if it
+// isn't cached. Must be called with p.mu held.
+func (p *Powerwall) getLocation(ctx context.Context) (*time.Location, error) {
+	if p.location != nil {
+		return p.location, nil
+	}
+	var res powerwallSiteInfo
+	if err := p.doRequest(ctx, "GET", "/api/site_info", nil, &res); err != nil {
+		return nil, fmt.Errorf("site_info failed: %w", err)
+	}
+	loc, err := time.LoadLocation(res.Timezone)
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to load location, defaulting to UTC", slog.String("tz", res.Timezone), slog.Any("error", err))
+		loc = time.UTC
+	}
+	p.location = loc
+	return loc, nil
+}
+
+// powerwallUserSOC converts the gateway's raw state of energy to the
+// percentage shown in the Tesla app which reserves the bottom 5%.
+func powerwallUserSOC(raw float64) float64 {
+	return math.Max(0, math.Min(100, (raw-5)/0.95))
+}
+
+// powerwallRawSOC converts a percentage shown in the Tesla app to the
+// gateway's raw state of energy.
+func powerwallRawSOC(user float64) float64 {
+	return user*0.95 + 5
+}
+
+// GetStatus returns the status of the powerwall system
+func (p *Powerwall) GetStatus(ctx context.Context) (types.SystemStatus, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting powerwall system status")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ensureLogin(ctx); err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	loc, err := p.getLocation(ctx)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	agg, soc, err := p.recordSnapshot(ctx)
+	if err != nil {
+		return types.SystemStatus{}, err
+	}
+
+	var op powerwallOperation
+	if err := p.doRequest(ctx, "GET", "/api/operation", nil, &op); err != nil {
+		return types.SystemStatus{}, fmt.Errorf("operation failed: %w", err)
+	}
+
+	var sys powerwallSystemStatus
+	if err := p.doRequest(ctx, "GET", "/api/system_status", nil, &sys); err != nil {
+		return types.SystemStatus{}, fmt.Errorf("system_status failed: %w", err)
+	}
+
+	var grid powerwallGridStatus
+	if err := p.doRequest(ctx, "GET", "/api/system_status/grid_status", nil, &grid); err != nil {
+		return types.SystemStatus{}, fmt.Errorf("grid_status failed: %w", err)
+	}
+
+	reserveSOC := powerwallUserSOC(op.BackupReservePercent)
+	log.Ctx(ctx).DebugContext(
+		ctx,
+		"powerwall status",
+		slog.Float64("soc", soc),
+		slog.Float64("reserveSOC", reserveSOC),
+		slog.String("mode", op.RealMode),
+		slog.String("gridStatus", grid.GridStatus),
+		slog.Float64("batteryW", agg.Battery.InstantPower),
+		slog.Float64("solarW", agg.Solar.InstantPower),
+		slog.Float64("siteW", agg.Site.InstantPower),
+		slog.Float64("loadW", agg.Load.InstantPower),
+	)
+
+	var alarms []types.SystemAlarm
+	var alerts []string
+	for _, alert := range sys.SystemAlerts {
+		if !isPowerwallFault(alert) {
+			alerts = append(alerts, alert)
+			continue
+		}
+		alarms = append(alarms, types.SystemAlarm{
+			Name: alert,
+			Time: time.Now(),
+		})
+	}
+	if len(alerts) > 0 {
+		log.Ctx(ctx).DebugContext(ctx, "powerwall alerts", slog.Any("alerts", alerts))
+	}
+
+	return types.SystemStatus{
+		Timestamp:          time.Now().In(loc),
+		BatterySOC:         soc,
+		BatteryKW:          agg.Battery.InstantPower / 1000,
+		SolarKW:            math.Max(agg.Solar.InstantPower/1000, 0),
+		GridKW:             agg.Site.InstantPower / 1000,
+		HomeKW:             agg.Load.InstantPower / 1000,
+		BatteryCapacityKWH: sys.NominalFullPackEnergy / 1000,
+		// the reported max power is the total of all the batteries
+		MaxBatteryChargeKW:    sys.MaxChargePower / 1000,
+		MaxBatteryDischargeKW: sys.MaxDischargePower / 1000,
+		EmergencyMode:         op.RealMode == powerwallModeBackup || grid.GridStatus != powerwallGridConnected,
+		// export and grid charging are configured in the Tesla app and aren't
+		// available from the local API so we assume they match the settings
+		CanExportSolar:        p.settings.GridExportSolar,
+		CanExportBattery:      false,
+		CanImportBattery:      p.settings.GridChargeBatteries,
+		ElevatedMinBatterySOC: reserveSOC > 0 && reserveSOC > p.settings.MinBatterySOC,
+		BatteryAboveMinSOC:    soc >= reserveSOC,
+		Alarms:                alarms,
+	}, nil
+}
+
+// SetModes sets the battery mode by changing the backup reserve while in
+// self-consumption mode. The local API can't change the export settings so
+// the solar mode is ignored.
+func (p *Powerwall) SetModes(ctx context.Context, bat types.BatteryMode, sol types.SolarMode) error {
+	log.Ctx(ctx).DebugContext(ctx, "SetModes called", slog.Any("batteryMode", bat), slog.Any("solarMode", sol))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ensureLogin(ctx); err != nil {
+		return err
+	}
+
+	if sol != types.SolarModeNoChange {
+		log.Ctx(ctx).WarnContext(ctx, "powerwall local api cannot change solar export, ignoring", slog.Any("solarMode", sol))
+	}
+	if bat == types.BatteryModeNoChange {
+		return nil
+	}
+
+	var op powerwallOperation
+	if err := p.doRequest(ctx, "GET", "/api/operation", nil, &op); err != nil {
+		return fmt.Errorf("operation failed: %w", err)
+	}
+	if op.RealMode == powerwallModeBackup {
+		log.Ctx(ctx).InfoContext(ctx, "device is in backup mode, skipping set modes")
+		return errors.New("device is in backup mode")
+	}
+
+	var soe powerwallSOE
+	if err := p.doRequest(ctx, "GET", "/api/system_status/soe", nil, &soe); err != nil {
+		return fmt.Errorf("soe failed: %w", err)
+	}
+	soc := powerwallUserSOC(soe.Percentage)
+	minBatterySOC := p.settings.MinBatterySOC
+
+	// holding the reserve at the current SOC stops the battery from discharging
+	// while excess solar still charges it
+	holdSOC := math.Max(math.Floor(soc), minBatterySOC)
+
+	var reserve float64
+	switch bat {
+	case types.BatteryModeChargeAny:
+		// the gateway charges from the grid to reach the reserve so only raise it
+		// all the way if grid charging is allowed
+		if p.settings.GridChargeBatteries {
+			reserve = 100
+		} else {
+			reserve = holdSOC
+		}
+	case types.BatteryModeChargeSolar, types.BatteryModeStandby:
+		reserve = holdSOC
+	case types.BatteryModeLoad:
+		reserve = minBatterySOC
+	default:
+		return fmt.Errorf("unknown battery mode: %v", bat)
+	}
+
+	data := powerwallOperation{
+		RealMode:             powerwallModeSelfConsumption,
+		BackupReservePercent: math.Round(powerwallRawSOC(reserve)*10) / 10,
+	}
+	log.Ctx(ctx).InfoContext(
+		ctx,
+		"setting powerwall operation",
+		slog.String("mode", data.RealMode),
+		slog.Float64("reserveSOC", reserve),
+		slog.Float64("backupReservePercent", data.BackupReservePercent),
+	)
+	if err := p.doRequest(ctx, "POST", "/api/operation", data, nil); err != nil {
+		return fmt.Errorf("failed to set operation: %w", err)
+	}
+	// changes are only applied once the config is marked as completed
+	if err := p.doRequest(ctx, "GET", "/api/config/completed", nil, nil); err != nil {
+		return fmt.Errorf("failed to apply config: %w", err)
+	}
+	return nil
+}
+
+// recordSnapshot fetches the current meters and SOC and records them for the
+// energy history. Must be called with p.mu held.
+func (p *Powerwall) recordSnapshot(ctx context.Context) (powerwallAggregates, float64, error) {
+	var agg powerwallAggregates
+	if err := p.doRequest(ctx, "GET", "/api/meters/aggregates", nil, &agg); err != nil {
+		return powerwallAggregates{}, 0, fmt.Errorf("meters/aggregates failed: %w", err)
+	}
+	var soe powerwallSOE
+	if err := p.doRequest(ctx, "GET", "/api/system_status/soe", nil, &soe); err != nil {
+		return powerwallAggregates{}, 0, fmt.Errorf("soe failed: %w", err)
+	}
+	soc := powerwallUserSOC(soe.Percentage)
+
+	p.history.record(time.Now(), meterCounters{
+		HomeWH:           agg.Load.EnergyImported,
+		SolarWH:          agg.Solar.EnergyExported,
+		GridImportWH:     agg.Site.EnergyImported,
+		GridExportWH:     agg.Site.EnergyExported,
+		BatteryChargedWH: agg.Battery.EnergyImported,
+		BatteryUsedWH:    agg.Battery.EnergyExported,
+	}, soc)
+	return agg, soc, nil
+}
+
+// GetEnergyHistory returns the hourly energy history from the gateway's meter
+// aggregates.
+func (p *Powerwall) GetEnergyHistory(ctx context.Context, start, end time.Time) ([]types.EnergyStats, error) {
+	log.Ctx(ctx).DebugContext(ctx, "getting powerwall energy history", slog.Time("start", start), slog.Time("end", end))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ensureLogin(ctx); err != nil {
+		return nil, err
+	}
+	if _, _, err := p.recordSnapshot(ctx); err != nil {
+		return nil, err
+	}
+	loc, err := p.getLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.history.stats(start, end, loc), nil
+}
+
+// Internal Structs
+
+type powerwallMeter struct {
+	InstantPower   float64 `json:"instant_power"`   // W
+	EnergyExported float64 `json:"energy_exported"` // Wh lifetime
+	EnergyImported float64 `json:"energy_imported"` // Wh lifetime
+}
+
+type powerwallAggregates struct {
+	// site is positive when importing from the grid
+	Site powerwallMeter `json:"site"`
+	// battery is positive when discharging
+	Battery powerwallMeter `json:"battery"`
+	Load    powerwallMeter `json:"load"`
+	Solar   powerwallMeter `json:"solar"`
+}
+
+type powerwallSOE struct {
+	Percentage float64 `json:"percentage"`
+}
+
+type powerwallOperation struct {
+	RealMode             string  `json:"real_mode"`
+	BackupReservePercent float64 `json:"backup_reserve_percent"`
+}
+
+type powerwallSystemStatus struct {
+	NominalFullPackEnergy  float64  `json:"nominal_full_pack_energy"` // Wh
+	NominalEnergyRemaining float64  `json:"nominal_energy_remaining"` // Wh
+	MaxChargePower         float64  `json:"max_charge_power"`         // W
+	MaxDischargePower      float64  `json:"max_discharge_power"`      // W
+	AvailableBlocks        int      `json:"available_blocks"`         // number of batteries
+	SystemAlerts           []string `json:"system_alerts"`
+}
+
+type powerwallGridStatus struct {
+	GridStatus string `json:"grid_status"`
+}
+
+type powerwallSiteInfo struct {
+	SiteName string `json:"site_name"`
+	Timezone string `json:"timezone"`
+}
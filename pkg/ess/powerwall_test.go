@@ -0,0 +1,324 @@
+package ess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePowerwallGateway is a stand-in for the gateway's local API.
+type fakePowerwallGateway struct {
+	t *testing.T
+
+	mu         sync.Mutex
+	password   string
+	token      string
+	logins     int
+	completed  int
+	soe        float64
+	operation  powerwallOperation
+	aggregates powerwallAggregates
+	gridStatus string
+	alerts     []string
+}
+
+func newFakePowerwallGateway(t *testing.T) *fakePowerwallGateway {
+	return &fakePowerwallGateway{
+		t:        t,
+		password: "12345",
+		token:    "tok-1",
+		// 50% in the app
+		soe: 52.5,
+		// 20% in the app
+		operation:  powerwallOperation{RealMode: powerwallModeSelfConsumption, BackupReservePercent: 24},
+		gridStatus: powerwallGridConnected,
+		aggregates: powerwallAggregates{
+			Site:    powerwallMeter{InstantPower: 1500},
+			Battery: powerwallMeter{InstantPower: -2000},
+			Load:    powerwallMeter{InstantPower: 1000},
+			Solar:   powerwallMeter{InstantPower: 1500},
+		},
+	}
+}
+
+func (g *fakePowerwallGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if r.URL.Path == powerwallLoginPath {
+		var req map[string]interface{}
+		require.NoError(g.t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(g.t, "customer", req["username"])
+		if req["password"] != g.password {
+			http.Error(w, `{"error":"bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		g.logins++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"token": g.token})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+g.token {
+		http.Error(w, `{"code":401,"error":"bad token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var res interface{}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /api/site_info":
+		res = powerwallSiteInfo{SiteName: "home", Timezone: "America/Chicago"}
+	case "GET /api/meters/aggregates":
+		res = g.aggregates
+	case "GET /api/system_status/soe":
+		res = powerwallSOE{Percentage: g.soe}
+	case "GET /api/operation":
+		res = g.operation
+	case "POST /api/operation":
+		require.NoError(g.t, json.NewDecoder(r.Body).Decode(&g.operation))
+		res = g.operation
+	case "GET /api/config/completed":
+		g.completed++
+		res = map[string]interface{}{}
+	case "GET /api/system_status":
+		res = powerwallSystemStatus{
+			NominalFullPackEnergy: 27000,
+			MaxChargePower:        10000,
+			MaxDischargePower:     10000,
+			AvailableBlocks:       2,
+			SystemAlerts:          g.alerts,
+		}
+	case "GET /api/system_status/grid_status":
+		res = powerwallGridStatus{GridStatus: g.gridStatus}
+	default:
+		http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func newTestPowerwall(t *testing.T) (*Powerwall, *fakePowerwallGateway) {
+	gw := newFakePowerwallGateway(t)
+	ts := httptest.NewTLSServer(gw)
+	t.Cleanup(ts.Close)
+
+	p := newPowerwall()
+	p.client = ts.Client()
+	require.NoError(t, p.ApplySettings(context.Background(), types.Settings{
+		MinBatterySOC:       20,
+		GridChargeBatteries: true,
+		GridExportSolar:     true,
+	}))
+
+	creds, changed, err := p.Authenticate(context.Background(), types.Credentials{
+		Powerwall: &types.PowerwallCredentials{Host: ts.URL, Password: "12345"},
+	})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "tok-1", creds.Powerwall.Token)
+	return p, gw
+}
+
+func TestPowerwall(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Authenticate", func(t *testing.T) {
+		gw := newFakePowerwallGateway(t)
+		ts := httptest.NewTLSServer(gw)
+		defer ts.Close()
+
+		p := newPowerwall()
+		p.client = ts.Client()
+
+		_, _, err := p.Authenticate(ctx, types.Credentials{})
+		assert.Error(t, err)
+
+		_, _, err = p.Authenticate(ctx, types.Credentials{
+			Powerwall: &types.PowerwallCredentials{Host: ts.URL, Password: "wrong"},
+		})
+		assert.Error(t, err)
+
+		creds, changed, err := p.Authenticate(ctx, types.Credentials{
+			Powerwall: &types.PowerwallCredentials{Host: ts.URL, Password: "12345"},
+		})
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "tok-1", creds.Powerwall.Token)
+		assert.Equal(t, 1, gw.logins)
+		assert.Equal(t, "America/Chicago", p.location.String())
+
+		// a stored token skips logging in again
+		p2 := newPowerwall()
+		p2.client = ts.Client()
+		creds, changed, err = p2.Authenticate(ctx, creds)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, 1, gw.logins)
+	})
+
+	t.Run("GetStatus", func(t *testing.T) {
+		p, _ := newTestPowerwall(t)
+
+		status, err := p.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.InDelta(t, 50.0, status.BatterySOC, 0.001)
+		assert.InDelta(t, -2.0, status.BatteryKW, 0.001)
+		assert.InDelta(t, 1.5, status.SolarKW, 0.001)
+		assert.InDelta(t, 1.5, status.GridKW, 0.001)
+		assert.InDelta(t, 1.0, status.HomeKW, 0.001)
+		assert.InDelta(t, 27.0, status.BatteryCapacityKWH, 0.001)
+		assert.InDelta(t, 10.0, status.MaxBatteryChargeKW, 0.001)
+		assert.InDelta(t, 10.0, status.MaxBatteryDischargeKW, 0.001)
+		assert.False(t, status.EmergencyMode)
+		assert.True(t, status.CanExportSolar)
+		assert.True(t, status.CanImportBattery)
+		assert.False(t, status.CanExportBattery)
+		// the reserve is at the minimum
+		assert.False(t, status.ElevatedMinBatterySOC)
+		assert.True(t, status.BatteryAboveMinSOC)
+		assert.Equal(t, "America/Chicago", status.Timestamp.Location().String())
+	})
+
+	t.Run("GetStatus Alerts", func(t *testing.T) {
+		p, gw := newTestPowerwall(t)
+		gw.alerts = []string{"SystemConnectedToGrid", "FWUpdateSucceeded", "PINV_a008_vBusCalibrationFault", "BatteryComms"}
+
+		status, err := p.GetStatus(ctx)
+		require.NoError(t, err)
+		// only faults are alarms
+		require.Len(t, status.Alarms, 2)
+		assert.Equal(t, "PINV_a008_vBusCalibrationFault", status.Alarms[0].Name)
+		assert.Equal(t, "BatteryComms", status.Alarms[1].Name)
+	})
+
+	t.Run("GetStatus Off Grid", func(t *testing.T) {
+		p, gw := newTestPowerwall(t)
+		gw.gridStatus = "SystemIslandedActive"
+
+		status, err := p.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.True(t, status.EmergencyMode)
+	})
+
+	t.Run("SetModes", func(t *testing.T) {
+		p, gw := newTestPowerwall(t)
+
+		require.NoError(t, p.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoChange))
+		assert.Equal(t, powerwallModeSelfConsumption, gw.operation.RealMode)
+		assert.InDelta(t, 100.0, gw.operation.BackupReservePercent, 0.001)
+		assert.Equal(t, 1, gw.completed)
+
+		status, err := p.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.True(t, status.ElevatedMinBatterySOC)
+		assert.False(t, status.BatteryAboveMinSOC)
+
+		// standby holds the current SOC (50% in the app)
+		require.NoError(t, p.SetModes(ctx, types.BatteryModeStandby, types.SolarModeNoChange))
+		assert.InDelta(t, 52.5, gw.operation.BackupReservePercent, 0.001)
+
+		// load drops the reserve to the minimum SOC (20% in the app)
+		require.NoError(t, p.SetModes(ctx, types.BatteryModeLoad, types.SolarModeNoChange))
+		assert.InDelta(t, 24.0, gw.operation.BackupReservePercent, 0.001)
+		assert.Equal(t, 3, gw.completed)
+
+		// solar only changes are ignored
+		require.NoError(t, p.SetModes(ctx, types.BatteryModeNoChange, types.SolarModeNoExport))
+		assert.Equal(t, 3, gw.completed)
+	})
+
+	t.Run("SetModes No Grid Charging", func(t *testing.T) {
+		p, gw := newTestPowerwall(t)
+		require.NoError(t, p.ApplySettings(ctx, types.Settings{MinBatterySOC: 20}))
+
+		require.NoError(t, p.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoChange))
+		assert.InDelta(t, 52.5, gw.operation.BackupReservePercent, 0.001)
+	})
+
+	t.Run("SetModes Backup Mode", func(t *testing.T) {
+		p, gw := newTestPowerwall(t)
+		gw.operation.RealMode = powerwallModeBackup
+
+		err := p.SetModes(ctx, types.BatteryModeLoad, types.SolarModeNoChange)
+		assert.ErrorContains(t, err, "backup mode")
+		assert.Equal(t, 0, gw.completed)
+	})
+
+	t.Run("Token Expired", func(t *testing.T) {
+		p, gw := newTestPowerwall(t)
+		gw.mu.Lock()
+		gw.token = "tok-2"
+		gw.mu.Unlock()
+
+		_, err := p.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, gw.logins)
+		assert.Equal(t, "tok-2", p.tokenStr)
+	})
+
+	t.Run("GetEnergyHistory", func(t *testing.T) {
+		p, gw := newTestPowerwall(t)
+
+		hour := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+		p.history.record(hour.Add(2*time.Minute), meterCounters{
+			HomeWH:           10000,
+			SolarWH:          4000,
+			GridImportWH:     1000,
+			GridExportWH:     500,
+			BatteryChargedWH: 2000,
+			BatteryUsedWH:    3000,
+		}, 40)
+		p.history.record(hour.Add(30*time.Minute), meterCounters{HomeWH: 11000}, 45)
+		p.history.record(hour.Add(time.Hour+time.Minute), meterCounters{
+			HomeWH:           12000,
+			SolarWH:          5000,
+			GridImportWH:     2500,
+			GridExportWH:     700,
+			BatteryChargedWH: 2500,
+			BatteryUsedWH:    4000,
+		}, 45)
+		gw.aggregates.Load.EnergyImported = 13000
+
+		stats, err := p.GetEnergyHistory(ctx, hour, time.Now())
+		require.NoError(t, err)
+		require.Len(t, stats, 2)
+
+		assert.True(t, stats[0].TSHourStart.Equal(hour))
+		assert.InDelta(t, 1.5, stats[0].GridImportKWH, 0.001)
+		assert.InDelta(t, 0.2, stats[0].GridExportKWH, 0.001)
+		assert.InDelta(t, 0.5, stats[0].BatteryChargedKWH, 0.001)
+		assert.InDelta(t, 1.0, stats[0].BatteryUsedKWH, 0.001)
+		assert.InDelta(t, 2.0, stats[0].HomeKWH, 0.001)
+		assert.InDelta(t, 1.0, stats[0].SolarKWH, 0.001)
+		assert.Equal(t, 40.0, stats[0].MinBatterySOC)
+		assert.Equal(t, 45.0, stats[0].MaxBatterySOC)
+
+		// the previous hour ends at the snapshot that was just taken
+		assert.True(t, stats[1].TSHourStart.Equal(hour.Add(time.Hour)))
+		assert.InDelta(t, 1.0, stats[1].HomeKWH, 0.001)
+
+		// the current hour isn't complete
+		_, ok := p.history.hours[time.Now().Truncate(time.Hour).Unix()]
+		assert.True(t, ok)
+	})
+}
+
+func TestPowerwallBaseURL(t *testing.T) {
+	u, err := powerwallBaseURL("192.168.1.50")
+	require.NoError(t, err)
+	assert.Equal(t, "https://192.168.1.50", u)
+
+	u, err = powerwallBaseURL(" https://powerwall.local/ ")
+	require.NoError(t, err)
+	assert.Equal(t, "https://powerwall.local", u)
+
+	_, err = powerwallBaseURL("https://[::1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,317 @@
+package ess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sunspecMapBuilder lays out SunSpec models in a fake Modbus server.
+type sunspecMapBuilder struct {
+	srv  *fakeModbusServer
+	next uint16
+	// data holds the address of each model's data
+	data map[uint16]uint16
+}
+
+func newSunSpecMapBuilder(srv *fakeModbusServer, base uint16) *sunspecMapBuilder {
+	srv.set(base, sunspecMarker[0], sunspecMarker[1])
+	return &sunspecMapBuilder{srv: srv, next: base + 2, data: make(map[uint16]uint16)}
+}
+
+// model adds a model of the given length with the registers set at the given
+// offsets.
+func (b *sunspecMapBuilder) model(id, length uint16, values map[uint16]uint16) {
+	b.srv.set(b.next, id, length)
+	data := make([]uint16, length)
+	for offset, v := range values {
+		data[offset] = v
+	}
+	b.srv.set(b.next+2, data...)
+	b.data[id] = b.next + 2
+	b.next += 2 + length
+}
+
+func (b *sunspecMapBuilder) end() {
+	b.srv.set(b.next, sunspecModelEnd, 0)
+}
+
+func sunspecSF(sf int16) uint16 {
+	return uint16(sf)
+}
+
+func sunspecInt(v int16) uint16 {
+	return uint16(v)
+}
+
+// newFakeSunSpecInverter returns a fake hybrid inverter with a 13.5kWh
+// battery charging at 2kW from 5kW of solar while the home uses 2.5kW and
+// 0.5kW is exported.
+func newFakeSunSpecInverter(t *testing.T, withBattery bool) (*fakeModbusServer, *sunspecMapBuilder) {
+	srv := newFakeModbusServer(t, 1)
+	b := newSunSpecMapBuilder(srv, 40000)
+	// common model
+	b.model(1, 66, nil)
+	b.model(sunspecModelInverterThree, 50, map[uint16]uint16{
+		sunspecInvW:      3000,
+		sunspecInvWSF:    sunspecSF(0),
+		sunspecInvWH:     0x0098,
+		sunspecInvWH + 1: 0x9680, // 10,000,000 Wh
+		sunspecInvWHSF:   sunspecSF(0),
+		36:               4, // St, after Tmp_SF and before StVnd
+	})
+	b.model(sunspecModelMeterWye, 105, map[uint16]uint16{
+		sunspecMeterW:            sunspecInt(-500),
+		sunspecMeterWSF:          sunspecSF(0),
+		sunspecMeterTotWhExp:     0x001e,
+		sunspecMeterTotWhExp + 1: 0x8480, // 2,000,000 Wh
+		sunspecMeterTotWhImp:     0x004c,
+		sunspecMeterTotWhImp + 1: 0x4b40, // 5,000,000 Wh
+		sunspecMeterTotWhSF:      sunspecSF(0),
+	})
+	b.model(sunspecModelStorage, sunspecStorModelLength, map[uint16]uint16{
+		sunspecStorWChaMax:     5000,
+		sunspecStorMinRsvPct:   200,
+		sunspecStorChaState:    555,
+		sunspecStorOutWRte:     1000,
+		sunspecStorInWRte:      1000,
+		sunspecStorRvrtTms:     600,
+		sunspecStorChaGriSet:   sunspecChaGriSetPV,
+		sunspecStorWChaMaxSF:   sunspecSF(0),
+		sunspecStorMinRsvPctSF: sunspecSF(-1),
+		sunspecStorChaStateSF:  sunspecSF(-1),
+		sunspecStorInOutWRteSF: sunspecSF(-1),
+	})
+	if withBattery {
+		b.model(sunspecModelBattery, sunspecBatModelLength, map[uint16]uint16{
+			sunspecBatWHRtg:         1350,
+			sunspecBatWHRtgSF:       sunspecSF(1),
+			sunspecBatWChaRteMax:    5000,
+			sunspecBatWDisChaRteMax: 6000,
+			sunspecBatWMaxSF:        sunspecSF(0),
+			sunspecBatSoC:           56,
+			sunspecBatSoCSF:         sunspecSF(0),
+			sunspecBatW:             sunspecInt(-2000),
+			sunspecBatWSF:           sunspecSF(0),
+		})
+	}
+	b.end()
+	return srv, b
+}
+
+func newTestSunSpec(t *testing.T, withBattery bool) (*SunSpec, *fakeModbusServer, *sunspecMapBuilder) {
+	srv, b := newFakeSunSpecInverter(t, withBattery)
+
+	s := newSunSpec()
+	require.NoError(t, s.ApplySettings(context.Background(), types.Settings{
+		MinBatterySOC:       20,
+		GridChargeBatteries: true,
+		GridExportSolar:     true,
+	}))
+	creds := &types.SunSpecCredentials{Host: srv.Addr(), Location: "America/Denver"}
+	if !withBattery {
+		creds.CapacityKWH = "10"
+	}
+	_, _, err := s.Authenticate(context.Background(), types.Credentials{SunSpec: creds})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.client.Close() })
+	return s, srv, b
+}
+
+func TestSunSpec(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Authenticate", func(t *testing.T) {
+		srv, _ := newFakeSunSpecInverter(t, true)
+
+		s := newSunSpec()
+		_, _, err := s.Authenticate(ctx, types.Credentials{})
+		assert.Error(t, err)
+
+		_, _, err = s.Authenticate(ctx, types.Credentials{
+			SunSpec: &types.SunSpecCredentials{Host: srv.Addr(), UnitID: "300"},
+		})
+		assert.ErrorContains(t, err, "unit id")
+
+		creds, updated, err := s.Authenticate(ctx, types.Credentials{
+			SunSpec: &types.SunSpecCredentials{Host: srv.Addr()},
+		})
+		require.NoError(t, err)
+		defer s.client.Close()
+		// the default timezone is filled in
+		assert.True(t, updated)
+		assert.Equal(t, "America/Chicago", creds.SunSpec.Location)
+		assert.Len(t, s.models, 5)
+		assert.Equal(t, uint16(40004), s.models[1].Addr)
+		assert.Equal(t, uint16(sunspecStorModelLength), s.models[sunspecModelStorage].Length)
+
+		_, updated, err = s.Authenticate(ctx, creds)
+		require.NoError(t, err)
+		assert.False(t, updated)
+	})
+
+	t.Run("Authenticate Wrong Unit", func(t *testing.T) {
+		srv, _ := newFakeSunSpecInverter(t, true)
+
+		s := newSunSpec()
+		// the inverter doesn't respond to other unit ids
+		ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+		_, _, err := s.Authenticate(ctx, types.Credentials{
+			SunSpec: &types.SunSpecCredentials{Host: srv.Addr(), UnitID: "2"},
+		})
+		assert.ErrorContains(t, err, "credential validation failed")
+		_ = s.client.Close()
+	})
+
+	t.Run("Authenticate Requires Capacity", func(t *testing.T) {
+		srv, _ := newFakeSunSpecInverter(t, false)
+
+		s := newSunSpec()
+		_, _, err := s.Authenticate(ctx, types.Credentials{
+			SunSpec: &types.SunSpecCredentials{Host: srv.Addr()},
+		})
+		assert.ErrorContains(t, err, "capacity is required")
+		_ = s.client.Close()
+	})
+
+	t.Run("Authenticate Other Base Address", func(t *testing.T) {
+		srv := newFakeModbusServer(t, 1)
+		b := newSunSpecMapBuilder(srv, 0)
+		b.model(sunspecModelInverterSingle, 50, nil)
+		b.end()
+
+		s := newSunSpec()
+		_, _, err := s.Authenticate(ctx, types.Credentials{
+			SunSpec: &types.SunSpecCredentials{Host: srv.Addr()},
+		})
+		// the marker is found but the required models aren't there
+		assert.ErrorContains(t, err, "no sunspec meter model")
+		_ = s.client.Close()
+	})
+
+	t.Run("GetStatus", func(t *testing.T) {
+		s, _, _ := newTestSunSpec(t, true)
+
+		status, err := s.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.InDelta(t, 56.0, status.BatterySOC, 0.001)
+		assert.InDelta(t, -2.0, status.BatteryKW, 0.001)
+		assert.InDelta(t, 5.0, status.SolarKW, 0.001)
+		assert.InDelta(t, -0.5, status.GridKW, 0.001)
+		assert.InDelta(t, 2.5, status.HomeKW, 0.001)
+		assert.InDelta(t, 13.5, status.BatteryCapacityKWH, 0.001)
+		assert.InDelta(t, 5.0, status.MaxBatteryChargeKW, 0.001)
+		assert.InDelta(t, 6.0, status.MaxBatteryDischargeKW, 0.001)
+		assert.False(t, status.EmergencyMode)
+		assert.True(t, status.CanExportSolar)
+		assert.True(t, status.CanImportBattery)
+		assert.False(t, status.ElevatedMinBatterySOC)
+		assert.True(t, status.BatteryAboveMinSOC)
+		assert.Empty(t, status.Alarms)
+		assert.Equal(t, "America/Denver", status.Timestamp.Location().String())
+	})
+
+	t.Run("GetStatus Storage Model Only", func(t *testing.T) {
+		s, _, _ := newTestSunSpec(t, false)
+
+		status, err := s.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.InDelta(t, 55.5, status.BatterySOC, 0.001)
+		assert.InDelta(t, 10.0, status.BatteryCapacityKWH, 0.001)
+		assert.InDelta(t, 5.0, status.MaxBatteryChargeKW, 0.001)
+		assert.InDelta(t, 5.0, status.MaxBatteryDischargeKW, 0.001)
+		assert.InDelta(t, 3.0, status.SolarKW, 0.001)
+	})
+
+	t.Run("GetStatus Fault", func(t *testing.T) {
+		s, srv, b := newTestSunSpec(t, true)
+		// St is register 36 of the inverter model
+		srv.set(b.data[sunspecModelInverterThree]+36, sunspecInvStFault)
+
+		status, err := s.GetStatus(ctx)
+		require.NoError(t, err)
+		require.Len(t, status.Alarms, 1)
+		assert.Equal(t, "inverter fault", status.Alarms[0].Name)
+	})
+
+	t.Run("SetModes", func(t *testing.T) {
+		s, srv, b := newTestSunSpec(t, true)
+		stor := b.data[sunspecModelStorage]
+
+		require.NoError(t, s.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoChange))
+		assert.Equal(t, uint16(sunspecStorCtlDischarge), srv.get(stor+sunspecStorCtlMod))
+		assert.Equal(t, sunspecInt(-1000), srv.get(stor+sunspecStorOutWRte))
+		assert.Equal(t, uint16(1000), srv.get(stor+sunspecStorInWRte))
+		assert.Equal(t, uint16(sunspecChaGriSetGrid), srv.get(stor+sunspecStorChaGriSet))
+		assert.Equal(t, uint16(200), srv.get(stor+sunspecStorMinRsvPct))
+		assert.Equal(t, uint16(0), srv.get(stor+sunspecStorRvrtTms))
+
+		require.NoError(t, s.SetModes(ctx, types.BatteryModeStandby, types.SolarModeNoChange))
+		assert.Equal(t, uint16(sunspecStorCtlDischarge), srv.get(stor+sunspecStorCtlMod))
+		assert.Equal(t, uint16(0), srv.get(stor+sunspecStorOutWRte))
+		assert.Equal(t, uint16(sunspecChaGriSetPV), srv.get(stor+sunspecStorChaGriSet))
+
+		require.NoError(t, s.SetModes(ctx, types.BatteryModeLoad, types.SolarModeNoChange))
+		assert.Equal(t, uint16(0), srv.get(stor+sunspecStorCtlMod))
+		assert.Equal(t, uint16(1000), srv.get(stor+sunspecStorOutWRte))
+		assert.Equal(t, uint16(sunspecChaGriSetPV), srv.get(stor+sunspecStorChaGriSet))
+
+		// solar only changes are ignored
+		srv.mu.Lock()
+		writes := srv.writes
+		srv.mu.Unlock()
+		require.NoError(t, s.SetModes(ctx, types.BatteryModeNoChange, types.SolarModeNoExport))
+		assert.Equal(t, writes, srv.writes)
+	})
+
+	t.Run("SetModes No Grid Charging", func(t *testing.T) {
+		s, srv, b := newTestSunSpec(t, true)
+		stor := b.data[sunspecModelStorage]
+		require.NoError(t, s.ApplySettings(ctx, types.Settings{MinBatterySOC: 30}))
+
+		require.NoError(t, s.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeNoChange))
+		assert.Equal(t, uint16(sunspecStorCtlDischarge), srv.get(stor+sunspecStorCtlMod))
+		assert.Equal(t, uint16(0), srv.get(stor+sunspecStorOutWRte))
+		assert.Equal(t, uint16(sunspecChaGriSetPV), srv.get(stor+sunspecStorChaGriSet))
+		assert.Equal(t, uint16(300), srv.get(stor+sunspecStorMinRsvPct))
+
+		// the reserve matches the new minimum
+		status, err := s.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.False(t, status.ElevatedMinBatterySOC)
+		assert.True(t, status.BatteryAboveMinSOC)
+	})
+
+	t.Run("GetEnergyHistory", func(t *testing.T) {
+		s, _, _ := newTestSunSpec(t, true)
+
+		stats, err := s.GetEnergyHistory(ctx, time.Now().Add(-time.Hour), time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, stats)
+
+		cur, ok := s.history.hours[time.Now().Truncate(time.Hour).Unix()]
+		require.True(t, ok)
+		assert.Equal(t, 10000000.0, cur.first.SolarWH)
+		assert.Equal(t, 5000000.0, cur.first.GridImportWH)
+		assert.Equal(t, 2000000.0, cur.first.GridExportWH)
+		assert.Equal(t, 13000000.0, cur.first.HomeWH)
+		assert.Equal(t, 56.0, cur.minSOC)
+	})
+}
+
+func TestSunSpecScaled(t *testing.T) {
+	assert.InDelta(t, 20.0, sunspecScaled(200, false, sunspecSF(-1)), 0.0001)
+	assert.InDelta(t, -500.0, sunspecScaled(sunspecInt(-5), true, sunspecSF(2)), 0.0001)
+	// not implemented
+	assert.Equal(t, 0.0, sunspecScaled(0x8000, true, 0))
+	assert.Equal(t, 0.0, sunspecScaled(0xFFFF, false, 0))
+	assert.Equal(t, 0.0, sunspecScaled(10, false, 0x8000))
+
+	assert.Equal(t, sunspecInt(-1000), sunspecRaw(-100, sunspecSF(-1)))
+	assert.Equal(t, uint16(25), sunspecRaw(2500, sunspecSF(2)))
+}
@@ -0,0 +1,346 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+	"golang.org/x/net/websocket"
+)
+
+// AuthorizeFunc returns an error if the charge point can't connect to the
+// site with the password it sent using HTTP basic auth.
+type AuthorizeFunc func(ctx context.Context, siteID, chargePointID, password string) error
+
+// ChargePointState is what the central system knows about a charge point.
+type ChargePointState struct {
+	ID              string    `json:"id"`
+	Connected       bool      `json:"connected"`
+	Vendor          string    `json:"vendor,omitempty"`
+	Model           string    `json:"model,omitempty"`
+	FirmwareVersion string    `json:"firmwareVersion,omitempty"`
+	LastSeen        time.Time `json:"lastSeen"`
+	// Connectors is the last status of each connector, 0 is the whole
+	// charge point
+	Connectors map[int]string `json:"connectors,omitempty"`
+	// Transaction is the charging session in progress, if any
+	Transaction *Transaction `json:"transaction,omitempty"`
+	// LastTransaction is the most recently finished charging session
+	LastTransaction *Transaction `json:"lastTransaction,omitempty"`
+	// ChargingProfile is the last profile the charge point accepted
+	ChargingProfile *ChargingProfile `json:"chargingProfile,omitempty"`
+}
+
+// Transaction is a charging session.
+type Transaction struct {
+	ID           int       `json:"id"`
+	ConnectorID  int       `json:"connectorId"`
+	IDTag        string    `json:"idTag"`
+	Start        time.Time `json:"start"`
+	Stop         time.Time `json:"stop,omitzero"`
+	MeterStartWh int       `json:"meterStartWh"`
+	MeterWh      int       `json:"meterWh"`
+	PowerKW      float64   `json:"powerKW"`
+}
+
+// EnergyKWH is how much energy has been delivered in the session.
+func (t Transaction) EnergyKWH() float64 {
+	return float64(t.MeterWh-t.MeterStartWh) / 1000
+}
+
+// chargePoint is a charge point that has connected at least once.
+type chargePoint struct {
+	state ChargePointState
+	// conn is nil while the charge point is disconnected
+	conn *rpcConn
+}
+
+// CentralSystem accepts OCPP 1.6J connections from charge points, tracks
+// their sessions and meter values, and sends them charging profiles. Charge
+// points connect to /ocpp/{siteID}/{chargePointID}.
+type CentralSystem struct {
+	authorize AuthorizeFunc
+	now       func() time.Time
+	// heartbeatInterval is how often charge points are told to send
+	// heartbeats
+	heartbeatInterval time.Duration
+
+	mu                sync.Mutex
+	chargePoints      map[string]*chargePoint
+	nextTransactionID int
+}
+
+// NewCentralSystem returns a CentralSystem that lets charge points connect if
+// authorize allows it.
+func NewCentralSystem(authorize AuthorizeFunc) *CentralSystem {
+	return &CentralSystem{
+		authorize:         authorize,
+		now:               time.Now,
+		heartbeatInterval: 5 * time.Minute,
+		chargePoints:      make(map[string]*chargePoint),
+	}
+}
+
+func chargePointKey(siteID, chargePointID string) string {
+	return siteID + "/" + chargePointID
+}
+
+// ServeHTTP upgrades a charge point's request to a websocket and serves it
+// until it disconnects. The site and charge point come from the siteID and
+// chargePointID path values.
+func (cs *CentralSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	siteID := r.PathValue("siteID")
+	if siteID == "" {
+		siteID = types.SiteIDNone
+	}
+	chargePointID := r.PathValue("chargePointID")
+	if chargePointID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	ctx = log.With(ctx, log.Ctx(ctx).With(slog.String("siteID", siteID), slog.String("chargePointID", chargePointID)))
+
+	_, password, _ := r.BasicAuth()
+	if err := cs.authorize(ctx, siteID, chargePointID, password); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "charge point not authorized", slog.Any("error", err))
+		w.Header().Set("WWW-Authenticate", `Basic realm="ocpp"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	websocket.Server{
+		Handshake: func(cfg *websocket.Config, req *http.Request) error {
+			for _, p := range cfg.Protocol {
+				if p == Subprotocol {
+					cfg.Protocol = []string{Subprotocol}
+					return nil
+				}
+			}
+			return fmt.Errorf("unsupported subprotocols: %v", cfg.Protocol)
+		},
+		Handler: func(ws *websocket.Conn) {
+			cs.serve(ctx, siteID, chargePointID, ws)
+		},
+	}.ServeHTTP(w, r)
+}
+
+func (cs *CentralSystem) serve(ctx context.Context, siteID, chargePointID string, ws *websocket.Conn) {
+	key := chargePointKey(siteID, chargePointID)
+	conn := newRPCConn(ws, func(ctx context.Context, action string, payload json.RawMessage) (any, error) {
+		return cs.handleCall(ctx, key, action, payload)
+	})
+
+	cs.mu.Lock()
+	cp := cs.chargePoints[key]
+	if cp == nil {
+		cp = &chargePoint{state: ChargePointState{ID: chargePointID}}
+		cs.chargePoints[key] = cp
+	}
+	old := cp.conn
+	cp.conn = conn
+	cp.state.Connected = true
+	cp.state.LastSeen = cs.now()
+	cs.mu.Unlock()
+
+	if old != nil {
+		// the charge point reconnected before we noticed the old connection
+		// was gone
+		_ = old.close()
+	}
+	log.Ctx(ctx).InfoContext(ctx, "charge point connected")
+
+	err := conn.run(ctx)
+
+	cs.mu.Lock()
+	if cp.conn == conn {
+		cp.conn = nil
+		cp.state.Connected = false
+	}
+	cs.mu.Unlock()
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "charge point connection failed", slog.Any("error", err))
+	} else {
+		log.Ctx(ctx).InfoContext(ctx, "charge point disconnected")
+	}
+}
+
+func (cs *CentralSystem) handleCall(ctx context.Context, key, action string, payload json.RawMessage) (any, error) {
+	now := cs.now()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cp := cs.chargePoints[key]
+	cp.state.LastSeen = now
+
+	switch action {
+	case "BootNotification":
+		var req BootNotificationRequest
+		if err := decode(payload, &req); err != nil {
+			return nil, err
+		}
+		cp.state.Vendor = req.ChargePointVendor
+		cp.state.Model = req.ChargePointModel
+		cp.state.FirmwareVersion = req.FirmwareVersion
+		log.Ctx(ctx).InfoContext(ctx, "charge point booted", slog.String("vendor", req.ChargePointVendor), slog.String("model", req.ChargePointModel))
+		return BootNotificationConfirmation{
+			Status:      RegistrationAccepted,
+			CurrentTime: DateTime{now},
+			Interval:    int(cs.heartbeatInterval / time.Second),
+		}, nil
+
+	case "Heartbeat":
+		return HeartbeatConfirmation{CurrentTime: DateTime{now}}, nil
+
+	case "StatusNotification":
+		var req StatusNotificationRequest
+		if err := decode(payload, &req); err != nil {
+			return nil, err
+		}
+		if cp.state.Connectors == nil {
+			cp.state.Connectors = make(map[int]string)
+		}
+		cp.state.Connectors[req.ConnectorID] = req.Status
+		return struct{}{}, nil
+
+	case "Authorize":
+		// the charge point is already authorized for the site so any tag can
+		// charge
+		return AuthorizeConfirmation{IDTagInfo: IDTagInfo{Status: AuthorizationAccepted}}, nil
+
+	case "StartTransaction":
+		var req StartTransactionRequest
+		if err := decode(payload, &req); err != nil {
+			return nil, err
+		}
+		cs.nextTransactionID++
+		cp.state.Transaction = &Transaction{
+			ID:           cs.nextTransactionID,
+			ConnectorID:  req.ConnectorID,
+			IDTag:        req.IDTag,
+			Start:        req.Timestamp.Time,
+			MeterStartWh: req.MeterStart,
+			MeterWh:      req.MeterStart,
+		}
+		log.Ctx(ctx).InfoContext(ctx, "charging session started", slog.Int("transactionID", cs.nextTransactionID), slog.Int("connectorID", req.ConnectorID))
+		return StartTransactionConfirmation{
+			TransactionID: cs.nextTransactionID,
+			IDTagInfo:     IDTagInfo{Status: AuthorizationAccepted},
+		}, nil
+
+	case "StopTransaction":
+		var req StopTransactionRequest
+		if err := decode(payload, &req); err != nil {
+			return nil, err
+		}
+		if tx := cp.state.Transaction; tx != nil && tx.ID == req.TransactionID {
+			tx.Stop = req.Timestamp.Time
+			tx.MeterWh = req.MeterStop
+			tx.PowerKW = 0
+			cp.state.LastTransaction = tx
+			cp.state.Transaction = nil
+			log.Ctx(ctx).InfoContext(ctx, "charging session stopped", slog.Int("transactionID", tx.ID), slog.Float64("energyKWH", tx.EnergyKWH()))
+		} else {
+			log.Ctx(ctx).WarnContext(ctx, "stop for unknown charging session", slog.Int("transactionID", req.TransactionID))
+		}
+		return StopTransactionConfirmation{IDTagInfo: &IDTagInfo{Status: AuthorizationAccepted}}, nil
+
+	case "MeterValues":
+		var req MeterValuesRequest
+		if err := decode(payload, &req); err != nil {
+			return nil, err
+		}
+		tx := cp.state.Transaction
+		if tx == nil || (req.TransactionID != nil && *req.TransactionID != tx.ID) {
+			// readings outside of a session aren't used
+			return struct{}{}, nil
+		}
+		for _, mv := range req.MeterValues {
+			for _, sv := range mv.SampledValues {
+				// only the total across phases is used
+				if sv.Phase != "" {
+					continue
+				}
+				f, err := strconv.ParseFloat(sv.Value, 64)
+				if err != nil {
+					return nil, &CallError{Code: ErrorFormationViolation, Description: fmt.Sprintf("invalid value %q", sv.Value)}
+				}
+				switch sv.Measurand {
+				case "", MeasurandEnergyImport:
+					tx.MeterWh = int(sv.kilo(f) * 1000)
+				case MeasurandPowerImport:
+					tx.PowerKW = sv.kilo(f)
+				}
+			}
+		}
+		return struct{}{}, nil
+	}
+	return nil, &CallError{Code: ErrorNotImplemented, Description: action + " isn't supported"}
+}
+
+// ChargePoint returns the state of a charge point that has connected to the
+// site.
+func (cs *CentralSystem) ChargePoint(siteID, chargePointID string) (ChargePointState, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cp := cs.chargePoints[chargePointKey(siteID, chargePointID)]
+	if cp == nil {
+		return ChargePointState{}, false
+	}
+	state := cp.state
+	if state.Connectors != nil {
+		state.Connectors = make(map[int]string, len(cp.state.Connectors))
+		for k, v := range cp.state.Connectors {
+			state.Connectors[k] = v
+		}
+	}
+	if state.Transaction != nil {
+		tx := *state.Transaction
+		state.Transaction = &tx
+	}
+	if state.LastTransaction != nil {
+		tx := *state.LastTransaction
+		state.LastTransaction = &tx
+	}
+	return state, true
+}
+
+// SetChargingProfile sends a charging profile to a connected charge point.
+// Connector 0 applies it to the whole charge point.
+func (cs *CentralSystem) SetChargingProfile(ctx context.Context, siteID, chargePointID string, connectorID int, profile ChargingProfile) error {
+	key := chargePointKey(siteID, chargePointID)
+	cs.mu.Lock()
+	var conn *rpcConn
+	if cp := cs.chargePoints[key]; cp != nil {
+		conn = cp.conn
+	}
+	cs.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	var res SetChargingProfileConfirmation
+	err := conn.call(ctx, "SetChargingProfile", SetChargingProfileRequest{
+		ConnectorID:     connectorID,
+		ChargingProfile: profile,
+	}, &res)
+	if err != nil {
+		return err
+	}
+	if res.Status != ProfileStatusAccepted {
+		return fmt.Errorf("charging profile %s", res.Status)
+	}
+
+	cs.mu.Lock()
+	if cp := cs.chargePoints[key]; cp != nil {
+		cp.state.ChargingProfile = &profile
+	}
+	cs.mu.Unlock()
+	return nil
+}
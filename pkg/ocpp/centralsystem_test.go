@@ -0,0 +1,127 @@
+package ocpp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCentralSystem(t *testing.T) (*CentralSystem, string) {
+	cs := NewCentralSystem(func(ctx context.Context, siteID, chargePointID, password string) error {
+		if siteID != "site1" || chargePointID != "cp1" || password != "secret" {
+			return errors.New("denied")
+		}
+		return nil
+	})
+	mux := http.NewServeMux()
+	mux.Handle("GET /ocpp/{siteID}/{chargePointID}", cs)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return cs, "ws" + strings.TrimPrefix(ts.URL, "http") + "/ocpp/site1/cp1"
+}
+
+func TestCentralSystem(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		_, url := newTestCentralSystem(t)
+		_, err := DialSimulatedChargePoint(ctx, url, "wrong")
+		assert.Error(t, err)
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		cs, url := newTestCentralSystem(t)
+		cp, err := DialSimulatedChargePoint(ctx, url, "secret")
+		require.NoError(t, err)
+		defer cp.Close()
+
+		boot, err := cp.Boot(ctx, "Acme", "Wallbox")
+		require.NoError(t, err)
+		assert.Equal(t, RegistrationAccepted, boot.Status)
+		assert.Equal(t, 300, boot.Interval)
+
+		_, err = cp.Heartbeat(ctx)
+		require.NoError(t, err)
+		require.NoError(t, cp.StatusNotification(ctx, 1, "Charging"))
+
+		txID, err := cp.StartTransaction(ctx, 1, "tag", 1000)
+		require.NoError(t, err)
+		require.NoError(t, cp.MeterValues(ctx, 1, txID, 3500, 7200))
+
+		state, ok := cs.ChargePoint("site1", "cp1")
+		require.True(t, ok)
+		assert.True(t, state.Connected)
+		assert.Equal(t, "Acme", state.Vendor)
+		assert.Equal(t, "Wallbox", state.Model)
+		assert.Equal(t, "Charging", state.Connectors[1])
+		require.NotNil(t, state.Transaction)
+		assert.Equal(t, txID, state.Transaction.ID)
+		assert.InDelta(t, 2.5, state.Transaction.EnergyKWH(), 0.001)
+		assert.InDelta(t, 7.2, state.Transaction.PowerKW, 0.001)
+
+		require.NoError(t, cp.StopTransaction(ctx, txID, 4000))
+		state, _ = cs.ChargePoint("site1", "cp1")
+		assert.Nil(t, state.Transaction)
+		require.NotNil(t, state.LastTransaction)
+		assert.InDelta(t, 3.0, state.LastTransaction.EnergyKWH(), 0.001)
+
+		var callErr *CallError
+		err = cp.Call(ctx, "DataTransfer", struct{}{}, nil)
+		require.ErrorAs(t, err, &callErr)
+		assert.Equal(t, ErrorNotImplemented, callErr.Code)
+
+		require.NoError(t, cp.Close())
+		require.Eventually(t, func() bool {
+			state, _ := cs.ChargePoint("site1", "cp1")
+			return !state.Connected
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Set Charging Profile", func(t *testing.T) {
+		cs, url := newTestCentralSystem(t)
+		err := cs.SetChargingProfile(ctx, "site1", "cp1", 0, ChargingProfile{})
+		assert.ErrorIs(t, err, ErrNotConnected)
+
+		cp, err := DialSimulatedChargePoint(ctx, url, "secret")
+		require.NoError(t, err)
+		defer cp.Close()
+		_, err = cp.Boot(ctx, "Acme", "Wallbox")
+		require.NoError(t, err)
+
+		start := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+		profile := NewChargingProfile([]types.EVChargeBlock{
+			{Start: start, End: start.Add(time.Hour), LimitKW: 0},
+			{Start: start.Add(time.Hour), End: start.Add(3 * time.Hour), LimitKW: 7.2},
+		})
+		require.NoError(t, cs.SetChargingProfile(ctx, "site1", "cp1", 0, profile))
+
+		profiles := cp.Profiles()
+		require.Len(t, profiles, 1)
+		assert.Equal(t, 0, profiles[0].ConnectorID)
+		got := profiles[0].ChargingProfile
+		assert.Equal(t, PurposeTxDefaultProfile, got.ChargingProfilePurpose)
+		require.NotNil(t, got.ChargingSchedule.StartSchedule)
+		assert.True(t, start.Equal(got.ChargingSchedule.StartSchedule.Time))
+		require.NotNil(t, got.ChargingSchedule.Duration)
+		assert.Equal(t, 3*3600, *got.ChargingSchedule.Duration)
+		assert.Equal(t, []ChargingSchedulePeriod{
+			{StartPeriod: 0, Limit: 0},
+			{StartPeriod: 3600, Limit: 7200},
+		}, got.ChargingSchedule.ChargingSchedulePeriod)
+
+		state, _ := cs.ChargePoint("site1", "cp1")
+		assert.NotNil(t, state.ChargingProfile)
+
+		cp.RejectProfiles(true)
+		err = cs.SetChargingProfile(ctx, "site1", "cp1", 0, profile)
+		assert.ErrorContains(t, err, "Rejected")
+	})
+}
@@ -0,0 +1,292 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Subprotocol is the websocket subprotocol for OCPP 1.6 over JSON.
+const Subprotocol = "ocpp1.6"
+
+// message types of the OCPP-J RPC framework
+const (
+	messageTypeCall       = 2
+	messageTypeCallResult = 3
+	messageTypeCallError  = 4
+)
+
+// error codes sent in CALLERRORs
+const (
+	ErrorNotImplemented     = "NotImplemented"
+	ErrorFormationViolation = "FormationViolation"
+	ErrorInternalError      = "InternalError"
+)
+
+// message is a decoded OCPP-J frame.
+type message struct {
+	typ     int
+	id      string
+	action  string
+	payload json.RawMessage
+	// for CALLERRORs
+	errorCode        string
+	errorDescription string
+}
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(b, &parts); err != nil {
+		return err
+	}
+	if len(parts) < 3 {
+		return fmt.Errorf("frame has %d elements", len(parts))
+	}
+	if err := json.Unmarshal(parts[0], &m.typ); err != nil {
+		return fmt.Errorf("invalid message type: %w", err)
+	}
+	if err := json.Unmarshal(parts[1], &m.id); err != nil {
+		return fmt.Errorf("invalid message id: %w", err)
+	}
+	switch m.typ {
+	case messageTypeCall:
+		if len(parts) != 4 {
+			return fmt.Errorf("call has %d elements", len(parts))
+		}
+		if err := json.Unmarshal(parts[2], &m.action); err != nil {
+			return fmt.Errorf("invalid action: %w", err)
+		}
+		m.payload = parts[3]
+	case messageTypeCallResult:
+		m.payload = parts[2]
+	case messageTypeCallError:
+		if len(parts) < 4 {
+			return fmt.Errorf("call error has %d elements", len(parts))
+		}
+		if err := json.Unmarshal(parts[2], &m.errorCode); err != nil {
+			return fmt.Errorf("invalid error code: %w", err)
+		}
+		if err := json.Unmarshal(parts[3], &m.errorDescription); err != nil {
+			return fmt.Errorf("invalid error description: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown message type %d", m.typ)
+	}
+	return nil
+}
+
+func (m message) MarshalJSON() ([]byte, error) {
+	payload := m.payload
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+	switch m.typ {
+	case messageTypeCall:
+		return json.Marshal([]any{m.typ, m.id, m.action, payload})
+	case messageTypeCallResult:
+		return json.Marshal([]any{m.typ, m.id, payload})
+	case messageTypeCallError:
+		return json.Marshal([]any{m.typ, m.id, m.errorCode, m.errorDescription, json.RawMessage("{}")})
+	}
+	return nil, fmt.Errorf("unknown message type %d", m.typ)
+}
+
+// CallError is returned when the other side responds to a call with a
+// CALLERROR.
+type CallError struct {
+	Code        string
+	Description string
+}
+
+func (e *CallError) Error() string {
+	if e.Description == "" {
+		return "ocpp call error: " + e.Code
+	}
+	return fmt.Sprintf("ocpp call error: %s: %s", e.Code, e.Description)
+}
+
+// DateTime is a timestamp in the format OCPP requires.
+type DateTime struct {
+	time.Time
+}
+
+func (d DateTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.UTC().Format("2006-01-02T15:04:05.000Z"))
+}
+
+func (d *DateTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// status values used in confirmations
+const (
+	RegistrationAccepted  = "Accepted"
+	AuthorizationAccepted = "Accepted"
+	ProfileStatusAccepted = "Accepted"
+	ProfileStatusRejected = "Rejected"
+)
+
+// BootNotificationRequest is sent by a charge point when it starts.
+type BootNotificationRequest struct {
+	ChargePointVendor       string `json:"chargePointVendor"`
+	ChargePointModel        string `json:"chargePointModel"`
+	ChargePointSerialNumber string `json:"chargePointSerialNumber,omitempty"`
+	FirmwareVersion         string `json:"firmwareVersion,omitempty"`
+}
+
+// BootNotificationConfirmation tells the charge point whether it's accepted
+// and how often to send heartbeats.
+type BootNotificationConfirmation struct {
+	Status      string   `json:"status"`
+	CurrentTime DateTime `json:"currentTime"`
+	Interval    int      `json:"interval"`
+}
+
+// HeartbeatConfirmation is the response to a Heartbeat.
+type HeartbeatConfirmation struct {
+	CurrentTime DateTime `json:"currentTime"`
+}
+
+// StatusNotificationRequest reports the status of a connector.
+type StatusNotificationRequest struct {
+	ConnectorID int       `json:"connectorId"`
+	ErrorCode   string    `json:"errorCode"`
+	Status      string    `json:"status"`
+	Timestamp   *DateTime `json:"timestamp,omitempty"`
+}
+
+// IDTagInfo is whether an id tag is allowed to charge.
+type IDTagInfo struct {
+	Status string `json:"status"`
+}
+
+// AuthorizeRequest asks whether an id tag can charge.
+type AuthorizeRequest struct {
+	IDTag string `json:"idTag"`
+}
+
+// AuthorizeConfirmation is the response to an Authorize.
+type AuthorizeConfirmation struct {
+	IDTagInfo IDTagInfo `json:"idTagInfo"`
+}
+
+// StartTransactionRequest is sent when a charging session starts.
+type StartTransactionRequest struct {
+	ConnectorID int      `json:"connectorId"`
+	IDTag       string   `json:"idTag"`
+	MeterStart  int      `json:"meterStart"`
+	Timestamp   DateTime `json:"timestamp"`
+}
+
+// StartTransactionConfirmation assigns the session its transaction ID.
+type StartTransactionConfirmation struct {
+	TransactionID int       `json:"transactionId"`
+	IDTagInfo     IDTagInfo `json:"idTagInfo"`
+}
+
+// StopTransactionRequest is sent when a charging session ends.
+type StopTransactionRequest struct {
+	TransactionID int          `json:"transactionId"`
+	MeterStop     int          `json:"meterStop"`
+	Timestamp     DateTime     `json:"timestamp"`
+	Reason        string       `json:"reason,omitempty"`
+	IDTag         string       `json:"idTag,omitempty"`
+	MeterValues   []MeterValue `json:"transactionData,omitempty"`
+}
+
+// StopTransactionConfirmation is the response to a StopTransaction.
+type StopTransactionConfirmation struct {
+	IDTagInfo *IDTagInfo `json:"idTagInfo,omitempty"`
+}
+
+// MeterValuesRequest reports meter readings for a connector.
+type MeterValuesRequest struct {
+	ConnectorID   int          `json:"connectorId"`
+	TransactionID *int         `json:"transactionId,omitempty"`
+	MeterValues   []MeterValue `json:"meterValue"`
+}
+
+// MeterValue is a set of readings taken at the same time.
+type MeterValue struct {
+	Timestamp     DateTime       `json:"timestamp"`
+	SampledValues []SampledValue `json:"sampledValue"`
+}
+
+// SampledValue is a single reading. Values are strings in OCPP.
+type SampledValue struct {
+	Value     string `json:"value"`
+	Context   string `json:"context,omitempty"`
+	Measurand string `json:"measurand,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+}
+
+// measurands we read, an empty measurand is the energy register
+const (
+	MeasurandEnergyImport = "Energy.Active.Import.Register"
+	MeasurandPowerImport  = "Power.Active.Import"
+)
+
+// kilo returns the value in kW or kWh given its unit, which defaults to W or
+// Wh.
+func (v SampledValue) kilo(f float64) float64 {
+	if strings.HasPrefix(v.Unit, "k") {
+		return f
+	}
+	return f / 1000
+}
+
+// ChargingProfile limits how fast a charge point can charge.
+type ChargingProfile struct {
+	ChargingProfileID      int              `json:"chargingProfileId"`
+	StackLevel             int              `json:"stackLevel"`
+	ChargingProfilePurpose string           `json:"chargingProfilePurpose"`
+	ChargingProfileKind    string           `json:"chargingProfileKind"`
+	ValidFrom              *DateTime        `json:"validFrom,omitempty"`
+	ValidTo                *DateTime        `json:"validTo,omitempty"`
+	ChargingSchedule       ChargingSchedule `json:"chargingSchedule"`
+}
+
+// profile purposes and kinds
+const (
+	PurposeTxDefaultProfile = "TxDefaultProfile"
+	KindAbsolute            = "Absolute"
+	RateUnitWatts           = "W"
+)
+
+// ChargingSchedule is a list of limits relative to the start of the schedule.
+type ChargingSchedule struct {
+	Duration               *int                     `json:"duration,omitempty"`
+	StartSchedule          *DateTime                `json:"startSchedule,omitempty"`
+	ChargingRateUnit       string                   `json:"chargingRateUnit"`
+	ChargingSchedulePeriod []ChargingSchedulePeriod `json:"chargingSchedulePeriod"`
+}
+
+// ChargingSchedulePeriod is a limit starting StartPeriod seconds after the
+// start of the schedule.
+type ChargingSchedulePeriod struct {
+	StartPeriod int     `json:"startPeriod"`
+	Limit       float64 `json:"limit"`
+}
+
+// SetChargingProfileRequest is sent to a charge point to set a profile.
+type SetChargingProfileRequest struct {
+	ConnectorID     int             `json:"connectorId"`
+	ChargingProfile ChargingProfile `json:"csChargingProfiles"`
+}
+
+// SetChargingProfileConfirmation is whether the charge point accepted the
+// profile.
+type SetChargingProfileConfirmation struct {
+	Status string `json:"status"`
+}
@@ -0,0 +1,42 @@
+package ocpp
+
+import (
+	"math"
+
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// ChargingProfileID is the ID of the profiles built by NewChargingProfile. A
+// profile with the same ID replaces the last one on the charge point.
+const ChargingProfileID = 1
+
+// NewChargingProfile returns a default profile that limits the charge point
+// to each block's limit for the duration of the blocks. After the last block
+// the charge point goes back to charging without a limit.
+func NewChargingProfile(blocks []types.EVChargeBlock) ChargingProfile {
+	profile := ChargingProfile{
+		ChargingProfileID:      ChargingProfileID,
+		ChargingProfilePurpose: PurposeTxDefaultProfile,
+		ChargingProfileKind:    KindAbsolute,
+		ChargingSchedule: ChargingSchedule{
+			ChargingRateUnit:       RateUnitWatts,
+			ChargingSchedulePeriod: []ChargingSchedulePeriod{},
+		},
+	}
+	if len(blocks) == 0 {
+		return profile
+	}
+
+	start := blocks[0].Start
+	duration := int(blocks[len(blocks)-1].End.Sub(start).Seconds())
+	profile.ChargingSchedule.StartSchedule = &DateTime{start}
+	profile.ChargingSchedule.Duration = &duration
+	for _, b := range blocks {
+		profile.ChargingSchedule.ChargingSchedulePeriod = append(profile.ChargingSchedule.ChargingSchedulePeriod, ChargingSchedulePeriod{
+			StartPeriod: int(b.Start.Sub(start).Seconds()),
+			// charge points only accept a single decimal
+			Limit: math.Round(b.LimitKW*10000) / 10,
+		})
+	}
+	return profile
+}
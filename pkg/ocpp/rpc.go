@@ -0,0 +1,173 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"golang.org/x/net/websocket"
+)
+
+// ErrNotConnected is returned when calling a charge point that isn't
+// connected.
+var ErrNotConnected = errors.New("charge point not connected")
+
+// callHandler handles a call from the other side and returns the
+// confirmation. Returning a *CallError sends that error, any other error is
+// sent as an InternalError.
+type callHandler func(ctx context.Context, action string, payload json.RawMessage) (any, error)
+
+// rpcConn sends and receives OCPP-J messages over a websocket. Both sides can
+// make calls so responses are matched to calls by message ID.
+type rpcConn struct {
+	ws     *websocket.Conn
+	handle callHandler
+
+	writeMu sync.Mutex
+	nextID  atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[string]chan message
+	done    chan struct{}
+}
+
+func newRPCConn(ws *websocket.Conn, handle callHandler) *rpcConn {
+	return &rpcConn{
+		ws:      ws,
+		handle:  handle,
+		pending: make(map[string]chan message),
+		done:    make(chan struct{}),
+	}
+}
+
+// run reads messages until the connection closes. Calls are handled in order
+// so a handler must not make calls of its own.
+func (c *rpcConn) run(ctx context.Context) error {
+	defer close(c.done)
+	for {
+		var raw string
+		if err := websocket.Message.Receive(c.ws, &raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "invalid ocpp message", slog.String("message", raw), slog.Any("error", err))
+			if msg.id != "" {
+				c.reply(ctx, message{typ: messageTypeCallError, id: msg.id, errorCode: ErrorFormationViolation, errorDescription: err.Error()})
+			}
+			continue
+		}
+
+		switch msg.typ {
+		case messageTypeCall:
+			c.reply(ctx, c.dispatch(ctx, msg))
+		default:
+			c.mu.Lock()
+			ch, ok := c.pending[msg.id]
+			delete(c.pending, msg.id)
+			c.mu.Unlock()
+			if !ok {
+				log.Ctx(ctx).WarnContext(ctx, "ocpp response to unknown call", slog.String("id", msg.id))
+				continue
+			}
+			ch <- msg
+		}
+	}
+}
+
+func (c *rpcConn) dispatch(ctx context.Context, msg message) message {
+	res, err := c.handle(ctx, msg.action, msg.payload)
+	if err != nil {
+		var callErr *CallError
+		if !errors.As(err, &callErr) {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to handle ocpp call", slog.String("action", msg.action), slog.Any("error", err))
+			callErr = &CallError{Code: ErrorInternalError, Description: err.Error()}
+		}
+		return message{typ: messageTypeCallError, id: msg.id, errorCode: callErr.Code, errorDescription: callErr.Description}
+	}
+	payload, err := json.Marshal(res)
+	if err != nil {
+		return message{typ: messageTypeCallError, id: msg.id, errorCode: ErrorInternalError, errorDescription: err.Error()}
+	}
+	return message{typ: messageTypeCallResult, id: msg.id, payload: payload}
+}
+
+func (c *rpcConn) reply(ctx context.Context, msg message) {
+	if err := c.send(msg); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to send ocpp response", slog.Any("error", err))
+	}
+}
+
+func (c *rpcConn) send(msg message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return websocket.Message.Send(c.ws, string(b))
+}
+
+// call sends a call and decodes the confirmation into res.
+func (c *rpcConn) call(ctx context.Context, action string, req, res any) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	id := strconv.FormatUint(c.nextID.Add(1), 10)
+	ch := make(chan message, 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(message{typ: messageTypeCall, id: id, action: action, payload: payload}); err != nil {
+		return fmt.Errorf("failed to send %s: %w", action, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrNotConnected
+	case msg := <-ch:
+		if msg.typ == messageTypeCallError {
+			return &CallError{Code: msg.errorCode, Description: msg.errorDescription}
+		}
+		if res == nil {
+			return nil
+		}
+		if err := json.Unmarshal(msg.payload, res); err != nil {
+			return fmt.Errorf("invalid %s confirmation: %w", action, err)
+		}
+		return nil
+	}
+}
+
+func (c *rpcConn) close() error {
+	return c.ws.Close()
+}
+
+// decode decodes a call's payload, returning a FormationViolation if it's
+// invalid.
+func decode(payload json.RawMessage, v any) error {
+	if err := json.Unmarshal(payload, v); err != nil {
+		return &CallError{Code: ErrorFormationViolation, Description: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// SimulatedChargePoint is a charge point that connects to a central system
+// for testing. It accepts charging profiles unless told to reject them.
+type SimulatedChargePoint struct {
+	conn *rpcConn
+
+	mu             sync.Mutex
+	profiles       []SetChargingProfileRequest
+	rejectProfiles bool
+}
+
+// DialSimulatedChargePoint connects a simulated charge point to the central
+// system at url, which should end with the charge point's ID.
+func DialSimulatedChargePoint(ctx context.Context, url, password string) (*SimulatedChargePoint, error) {
+	origin := strings.Replace(url, "ws", "http", 1)
+	cfg, err := websocket.NewConfig(url, origin)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Protocol = []string{Subprotocol}
+	if password != "" {
+		id := url[strings.LastIndex(url, "/")+1:]
+		cfg.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(id+":"+password)))
+	}
+	ws, err := cfg.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SimulatedChargePoint{}
+	s.conn = newRPCConn(ws, s.handleCall)
+	go func() {
+		_ = s.conn.run(context.Background())
+	}()
+	return s, nil
+}
+
+func (s *SimulatedChargePoint) handleCall(ctx context.Context, action string, payload json.RawMessage) (any, error) {
+	if action != "SetChargingProfile" {
+		return nil, &CallError{Code: ErrorNotImplemented}
+	}
+	var req SetChargingProfileRequest
+	if err := decode(payload, &req); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rejectProfiles {
+		return SetChargingProfileConfirmation{Status: ProfileStatusRejected}, nil
+	}
+	s.profiles = append(s.profiles, req)
+	return SetChargingProfileConfirmation{Status: ProfileStatusAccepted}, nil
+}
+
+// RejectProfiles sets whether charging profiles are rejected.
+func (s *SimulatedChargePoint) RejectProfiles(reject bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectProfiles = reject
+}
+
+// Profiles returns the charging profiles that were accepted.
+func (s *SimulatedChargePoint) Profiles() []SetChargingProfileRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SetChargingProfileRequest(nil), s.profiles...)
+}
+
+// Boot sends a BootNotification.
+func (s *SimulatedChargePoint) Boot(ctx context.Context, vendor, model string) (BootNotificationConfirmation, error) {
+	var res BootNotificationConfirmation
+	err := s.conn.call(ctx, "BootNotification", BootNotificationRequest{
+		ChargePointVendor: vendor,
+		ChargePointModel:  model,
+	}, &res)
+	return res, err
+}
+
+// Heartbeat sends a Heartbeat and returns the central system's time.
+func (s *SimulatedChargePoint) Heartbeat(ctx context.Context) (time.Time, error) {
+	var res HeartbeatConfirmation
+	err := s.conn.call(ctx, "Heartbeat", struct{}{}, &res)
+	return res.CurrentTime.Time, err
+}
+
+// StatusNotification reports the status of a connector.
+func (s *SimulatedChargePoint) StatusNotification(ctx context.Context, connectorID int, status string) error {
+	return s.conn.call(ctx, "StatusNotification", StatusNotificationRequest{
+		ConnectorID: connectorID,
+		ErrorCode:   "NoError",
+		Status:      status,
+	}, nil)
+}
+
+// StartTransaction starts a charging session and returns its transaction ID.
+func (s *SimulatedChargePoint) StartTransaction(ctx context.Context, connectorID int, idTag string, meterStartWh int) (int, error) {
+	var res StartTransactionConfirmation
+	err := s.conn.call(ctx, "StartTransaction", StartTransactionRequest{
+		ConnectorID: connectorID,
+		IDTag:       idTag,
+		MeterStart:  meterStartWh,
+		Timestamp:   DateTime{time.Now()},
+	}, &res)
+	if err != nil {
+		return 0, err
+	}
+	if res.IDTagInfo.Status != AuthorizationAccepted {
+		return 0, fmt.Errorf("transaction %s", res.IDTagInfo.Status)
+	}
+	return res.TransactionID, nil
+}
+
+// MeterValues reports the energy register and the power being drawn.
+func (s *SimulatedChargePoint) MeterValues(ctx context.Context, connectorID, transactionID, meterWh int, powerW float64) error {
+	return s.conn.call(ctx, "MeterValues", MeterValuesRequest{
+		ConnectorID:   connectorID,
+		TransactionID: &transactionID,
+		MeterValues: []MeterValue{{
+			Timestamp: DateTime{time.Now()},
+			SampledValues: []SampledValue{
+				{Value: strconv.Itoa(meterWh), Measurand: MeasurandEnergyImport, Unit: "Wh"},
+				{Value: strconv.FormatFloat(powerW, 'f', 1, 64), Measurand: MeasurandPowerImport, Unit: "W"},
+			},
+		}},
+	}, nil)
+}
+
+// StopTransaction ends a charging session.
+func (s *SimulatedChargePoint) StopTransaction(ctx context.Context, transactionID, meterStopWh int) error {
+	return s.conn.call(ctx, "StopTransaction", StopTransactionRequest{
+		TransactionID: transactionID,
+		MeterStop:     meterStopWh,
+		Timestamp:     DateTime{time.Now()},
+		Reason:        "EVDisconnected",
+	}, nil)
+}
+
+// Call sends any call, for testing how the central system handles it.
+func (s *SimulatedChargePoint) Call(ctx context.Context, action string, req, res any) error {
+	return s.conn.call(ctx, action, req, res)
+}
+
+// Close disconnects the charge point.
+func (s *SimulatedChargePoint) Close() error {
+	err := s.conn.close()
+	<-s.conn.done
+	return err
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/raterudder/raterudder/pkg/utility"
+)
+
+// maxBillingPeriod is the longest billing period we will reconcile at once.
+const maxBillingPeriod = 62 * 24 * time.Hour
+
+func (s *Server) handleReconcileBill(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	siteID := s.getSiteID(r)
+
+	user := s.getUser(r)
+	if user.ID == "" {
+		writeJSONError(w, "missing authentication", http.StatusUnauthorized)
+		return
+	}
+	if !user.Admin {
+		log.Ctx(ctx).WarnContext(ctx, "unauthorized for bill reconciliation", slog.String("userID", user.ID), slog.String("email", user.Email))
+		writeJSONError(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Bill types.Bill `json:"bill"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to decode bill", slog.Any("error", err))
+		writeJSONError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	bill := req.Bill
+	if bill.PeriodStart.IsZero() || bill.PeriodEnd.IsZero() || !bill.PeriodEnd.After(bill.PeriodStart) {
+		writeJSONError(w, "bill period start must be before period end", http.StatusBadRequest)
+		return
+	}
+	if bill.PeriodEnd.Sub(bill.PeriodStart) > maxBillingPeriod {
+		writeJSONError(w, "bill period cannot exceed 62 days", http.StatusBadRequest)
+		return
+	}
+
+	settings, _, err := s.getSettingsWithMigration(ctx, siteID)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to get settings", slog.Any("error", err))
+		writeJSONError(w, "failed to get settings", http.StatusInternalServerError)
+		return
+	}
+	u, err := s.utilities.Site(ctx, siteID, settings.Settings)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to get utility system", slog.String("utility", settings.UtilityProvider), slog.Any("error", err))
+		writeJSONError(w, "failed to get utility system", http.StatusInternalServerError)
+		return
+	}
+
+	report, err := s.reconcileBill(ctx, siteID, u, bill)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to reconcile bill", slog.Any("error", err))
+		writeJSONError(w, "failed to reconcile bill", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.storage.UpsertBillReconciliation(ctx, siteID, report); err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to save bill reconciliation", slog.Any("error", err))
+		writeJSONError(w, "failed to save bill reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	log.Ctx(ctx).InfoContext(
+		ctx,
+		"reconciled bill",
+		slog.Time("periodStart", bill.PeriodStart),
+		slog.Time("periodEnd", bill.PeriodEnd),
+		slog.Float64("differenceDollars", report.DifferenceDollars),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) handleBillReconciliations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	siteID := s.getSiteID(r)
+
+	// default to the last year of bills
+	end := time.Now()
+	start := end.AddDate(-1, 0, 0)
+	if v := r.URL.Query().Get("start"); v != "" {
+		var err error
+		start, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSONError(w, fmt.Sprintf("invalid start time: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("end"); v != "" {
+		var err error
+		end, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSONError(w, fmt.Sprintf("invalid end time: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if end.Before(start) {
+		writeJSONError(w, "start time must be before end time", http.StatusBadRequest)
+		return
+	}
+
+	reports, err := s.storage.GetBillReconciliations(ctx, siteID, start, end)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to get bill reconciliations", slog.Any("error", err))
+		writeJSONError(w, "failed to get bill reconciliations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// reconcileBill computes the estimated cost of the bill's period broken down
+// by fee description and compares it with the bill.
+func (s *Server) reconcileBill(ctx context.Context, siteID string, u utility.Utility, bill types.Bill) (types.BillReconciliation, error) {
+	prices, err := s.storage.GetPriceHistory(ctx, siteID, bill.PeriodStart, bill.PeriodEnd)
+	if err != nil {
+		return types.BillReconciliation{}, fmt.Errorf("failed to get price history: %w", err)
+	}
+	energyStats, err := s.storage.GetEnergyHistory(ctx, siteID, bill.PeriodStart, bill.PeriodEnd)
+	if err != nil {
+		return types.BillReconciliation{}, fmt.Errorf("failed to get energy history: %w", err)
+	}
+
+	hourlyPrices := make(map[time.Time]types.Price, len(prices))
+	for _, p := range prices {
+		hourlyPrices[p.TSStart.Truncate(time.Hour)] = p
+	}
+
+	report := types.BillReconciliation{
+		Timestamp: time.Now(),
+		Bill:      bill,
+	}
+
+	// keep the estimated line items in the order we first saw them
+	var estimated []types.BillLineItem
+	estimatedIdx := make(map[string]int)
+	addEstimate := func(description string, kwh, dollars float64) {
+		i, ok := estimatedIdx[description]
+		if !ok {
+			i = len(estimated)
+			estimatedIdx[description] = i
+			estimated = append(estimated, types.BillLineItem{Description: description})
+		}
+		estimated[i].KWH += kwh
+		estimated[i].Dollars += dollars
+	}
+
+	var meterHours int
+	for _, stat := range energyStats {
+		gridImportKWH := stat.GridImportKWH
+		gridExportKWH := stat.GridExportKWH
+		if stat.HasMeterData {
+			gridImportKWH = stat.MeterGridImportKWH
+			gridExportKWH = stat.MeterGridExportKWH
+			meterHours++
+		}
+		report.EstimatedTotalKWH += gridImportKWH
+
+		p, ok := hourlyPrices[stat.TSHourStart.Truncate(time.Hour)]
+		if !ok {
+			report.MissingPriceHours++
+			continue
+		}
+
+		// the stored price already includes the additional fees so we need to
+		// back them out of the energy price. Older prices didn't record their
+		// fees so they're assumed to be the current ones.
+		fees := p.Fees
+		if fees == nil {
+			for _, fee := range u.AdditionalFees(p.TSStart) {
+				fees = append(fees, types.PriceFee{
+					Description:    fee.Description,
+					DollarsPerKWH:  fee.DollarsPerKWH,
+					GridAdditional: fee.GridAdditional,
+				})
+			}
+		}
+		energyPrice := p.DollarsPerKWH
+		for _, fee := range fees {
+			addEstimate(fee.Description, gridImportKWH, gridImportKWH*fee.DollarsPerKWH)
+			if !fee.GridAdditional {
+				energyPrice -= fee.DollarsPerKWH
+			}
+		}
+		addEstimate(types.BillLineItemEnergy, gridImportKWH, gridImportKWH*energyPrice)
+		if gridExportKWH > 0 {
+			addEstimate(types.BillLineItemExportCredit, gridExportKWH, -gridExportKWH*p.DollarsPerKWH)
+		}
+	}
+
+	for _, item := range estimated {
+		report.EstimatedTotalDollars += item.Dollars
+	}
+	report.EstimatedLineItems = estimated
+
+	periodHours := int(bill.PeriodEnd.Sub(bill.PeriodStart).Hours())
+	report.MissingEnergyHours = max(0, periodHours-len(energyStats))
+	if len(energyStats) > 0 {
+		report.UsedMeterDataPercentage = float64(meterHours) / float64(len(energyStats)) * 100
+	}
+
+	billTotalDollars := bill.TotalDollars
+	if billTotalDollars == 0 {
+		for _, item := range bill.LineItems {
+			billTotalDollars += item.Dollars
+		}
+	}
+	report.DifferenceKWH = bill.TotalKWH - report.EstimatedTotalKWH
+	report.DifferenceDollars = billTotalDollars - report.EstimatedTotalDollars
+	report.Discrepancies = compareBillLineItems(bill.LineItems, estimated)
+	return report, nil
+}
+
+// compareBillLineItems matches the bill's line items with the estimated line
+// items by description (ignoring case and surrounding whitespace).
+func compareBillLineItems(billItems, estimated []types.BillLineItem) []types.BillLineItemDiscrepancy {
+	key := func(description string) string {
+		return strings.ToLower(strings.TrimSpace(description))
+	}
+
+	estimatedByKey := make(map[string]types.BillLineItem, len(estimated))
+	for _, item := range estimated {
+		estimatedByKey[key(item.Description)] = item
+	}
+
+	var discrepancies []types.BillLineItemDiscrepancy
+	matched := make(map[string]bool, len(billItems))
+	for _, item := range billItems {
+		k := key(item.Description)
+		est, ok := estimatedByKey[k]
+		matched[k] = true
+		discrepancies = append(discrepancies, types.BillLineItemDiscrepancy{
+			Description:       item.Description,
+			BillKWH:           item.KWH,
+			EstimatedKWH:      est.KWH,
+			BillDollars:       item.Dollars,
+			EstimatedDollars:  est.Dollars,
+			DifferenceDollars: item.Dollars - est.Dollars,
+			MissingEstimate:   !ok,
+		})
+	}
+	for _, est := range estimated {
+		if matched[key(est.Description)] {
+			continue
+		}
+		discrepancies = append(discrepancies, types.BillLineItemDiscrepancy{
+			Description:       est.Description,
+			EstimatedKWH:      est.KWH,
+			EstimatedDollars:  est.Dollars,
+			DifferenceDollars: -est.Dollars,
+			MissingFromBill:   true,
+		})
+	}
+
+	// round to the cent to avoid floating point noise in the report
+	for i := range discrepancies {
+		discrepancies[i].DifferenceDollars = math.Round(discrepancies[i].DifferenceDollars*100) / 100
+	}
+	return discrepancies
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/raterudder/raterudder/pkg/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleReconcileBill(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	newServer := func() (*Server, *mockStorage, *mockUtility) {
+		mockS := &mockStorage{}
+		mockS.On("GetSettings", mock.Anything, types.SiteIDNone).Return(types.Settings{UtilityProvider: "test"}, types.CurrentSettingsVersion, nil)
+		mockU := &mockUtility{}
+		mockU.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+		uMap := utility.NewMap()
+		uMap.SetProvider("test", mockU)
+		return &Server{storage: mockS, utilities: uMap, bypassAuth: true, singleSite: true}, mockS, mockU
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		srv, mockS, mockU := newServer()
+		handler := srv.setupHandler()
+
+		mockS.On("GetPriceHistory", mock.Anything, types.SiteIDNone, start, end).Return([]types.Price{
+			{TSStart: start, DollarsPerKWH: 0.15, GridUseDollarsPerKWH: 0.03},
+			{TSStart: start.Add(time.Hour), DollarsPerKWH: 0.15, GridUseDollarsPerKWH: 0.03},
+		}, nil)
+		mockS.On("GetEnergyHistory", mock.Anything, types.SiteIDNone, start, end).Return([]types.EnergyStats{
+			{TSHourStart: start, GridImportKWH: 10},
+			{TSHourStart: start.Add(time.Hour), GridImportKWH: 5, MeterGridImportKWH: 4, MeterGridExportKWH: 2, HasMeterData: true},
+		}, nil)
+		mockU.On("AdditionalFees", mock.Anything).Return([]types.UtilityAdditionalFeesPeriod{
+			{DollarsPerKWH: 0.05, Description: "Supply Fee"},
+			{DollarsPerKWH: 0.03, GridAdditional: true, Description: "Delivery"},
+		})
+
+		var saved types.BillReconciliation
+		mockS.On("UpsertBillReconciliation", mock.Anything, types.SiteIDNone, mock.Anything).Run(func(args mock.Arguments) {
+			saved = args.Get(2).(types.BillReconciliation)
+		}).Return(nil)
+
+		body, err := json.Marshal(map[string]any{
+			"bill": types.Bill{
+				PeriodStart:  start,
+				PeriodEnd:    end,
+				TotalKWH:     15,
+				TotalDollars: 2.50,
+				LineItems: []types.BillLineItem{
+					{Description: "Energy", KWH: 15, Dollars: 1.50},
+					{Description: "delivery ", KWH: 15, Dollars: 0.45},
+					{Description: "Customer Charge", Dollars: 0.55},
+				},
+			},
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/billing/reconcile", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var report types.BillReconciliation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, saved.DifferenceDollars, report.DifferenceDollars)
+
+		// meter data is preferred for the second hour: 10 + 4
+		assert.InDelta(t, 14.0, report.EstimatedTotalKWH, 0.0001)
+		assert.InDelta(t, 1.0, report.DifferenceKWH, 0.0001)
+		// energy 14*0.10 + supply 14*0.05 + delivery 14*0.03 - export 2*0.15
+		assert.InDelta(t, 2.22, report.EstimatedTotalDollars, 0.0001)
+		assert.InDelta(t, 0.28, report.DifferenceDollars, 0.0001)
+		assert.Equal(t, 0, report.MissingEnergyHours)
+		assert.Equal(t, 0, report.MissingPriceHours)
+		assert.InDelta(t, 50.0, report.UsedMeterDataPercentage, 0.0001)
+
+		discrepancies := make(map[string]types.BillLineItemDiscrepancy)
+		for _, d := range report.Discrepancies {
+			discrepancies[d.Description] = d
+		}
+		require.Len(t, discrepancies, 5)
+		assert.InDelta(t, 0.10, discrepancies["Energy"].DifferenceDollars, 0.0001)
+		assert.InDelta(t, 0.42, discrepancies["delivery "].EstimatedDollars, 0.0001)
+		assert.InDelta(t, 0.03, discrepancies["delivery "].DifferenceDollars, 0.0001)
+		assert.True(t, discrepancies["Customer Charge"].MissingEstimate)
+		assert.InDelta(t, 0.55, discrepancies["Customer Charge"].DifferenceDollars, 0.0001)
+		assert.True(t, discrepancies["Supply Fee"].MissingFromBill)
+		assert.InDelta(t, -0.70, discrepancies["Supply Fee"].DifferenceDollars, 0.0001)
+		assert.True(t, discrepancies[types.BillLineItemExportCredit].MissingFromBill)
+		assert.InDelta(t, 0.30, discrepancies[types.BillLineItemExportCredit].DifferenceDollars, 0.0001)
+
+		mockS.AssertExpectations(t)
+	})
+
+	t.Run("Stored Fees", func(t *testing.T) {
+		// the fees saved with each price are used even though they've changed
+		srv, mockS, mockU := newServer()
+		handler := srv.setupHandler()
+
+		fees := []types.PriceFee{
+			{Description: "Supply Fee", DollarsPerKWH: 0.05},
+			{Description: "Delivery", DollarsPerKWH: 0.03, GridAdditional: true},
+		}
+		mockS.On("GetPriceHistory", mock.Anything, types.SiteIDNone, start, end).Return([]types.Price{
+			{TSStart: start, DollarsPerKWH: 0.15, GridUseDollarsPerKWH: 0.03, Fees: fees},
+			{TSStart: start.Add(time.Hour), DollarsPerKWH: 0.15, GridUseDollarsPerKWH: 0.03, Fees: fees},
+		}, nil)
+		mockS.On("GetEnergyHistory", mock.Anything, types.SiteIDNone, start, end).Return([]types.EnergyStats{
+			{TSHourStart: start, GridImportKWH: 10},
+			{TSHourStart: start.Add(time.Hour), GridImportKWH: 4},
+		}, nil)
+		mockS.On("UpsertBillReconciliation", mock.Anything, types.SiteIDNone, mock.Anything).Return(nil)
+
+		body, err := json.Marshal(map[string]any{
+			"bill": types.Bill{PeriodStart: start, PeriodEnd: end, TotalKWH: 14, TotalDollars: 2.52},
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/billing/reconcile", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var report types.BillReconciliation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		// energy 14*0.10 + supply 14*0.05 + delivery 14*0.03
+		assert.InDelta(t, 2.52, report.EstimatedTotalDollars, 0.0001)
+		assert.InDelta(t, 0.0, report.DifferenceDollars, 0.0001)
+		mockU.AssertNotCalled(t, "AdditionalFees", mock.Anything)
+	})
+
+	t.Run("Invalid Period", func(t *testing.T) {
+		srv, mockS, _ := newServer()
+		handler := srv.setupHandler()
+
+		body, err := json.Marshal(map[string]any{
+			"bill": types.Bill{PeriodStart: end, PeriodEnd: start},
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/billing/reconcile", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockS.AssertNotCalled(t, "UpsertBillReconciliation", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		srv, mockS, _ := newServer()
+		handler := srv.setupHandler()
+
+		mockS.On("GetBillReconciliations", mock.Anything, types.SiteIDNone, start, end).Return([]types.BillReconciliation{
+			{Bill: types.Bill{PeriodStart: start, PeriodEnd: end}, DifferenceDollars: 1.5},
+		}, nil)
+
+		req := httptest.NewRequest("GET", "/api/billing/reconciliations?start="+start.Format(time.RFC3339)+"&end="+end.Format(time.RFC3339), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var reports []types.BillReconciliation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reports))
+		require.Len(t, reports, 1)
+		assert.Equal(t, 1.5, reports[0].DifferenceDollars)
+	})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/controller"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/ocpp"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// authorizeChargePoint lets a charge point connect if it's the one configured
+// for the site and sent the right password.
+func (s *Server) authorizeChargePoint(ctx context.Context, siteID, chargePointID, password string) error {
+	if s.singleSite && siteID != types.SiteIDNone {
+		return errors.New("only the default site is available in single-site mode")
+	}
+	_, creds, err := s.getSettingsWithMigration(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if creds.OCPP == nil || creds.OCPP.ChargePointID != chargePointID {
+		return errors.New("unknown charge point")
+	}
+	if creds.OCPP.Password == "" || subtle.ConstantTimeCompare([]byte(creds.OCPP.Password), []byte(password)) != 1 {
+		return errors.New("invalid password")
+	}
+	return nil
+}
+
+// updateEVCharging sends the site's EV charger a charging profile for the next
+// day and records the limit for now on the action. Sites without a charger
+// are skipped.
+func (s *Server) updateEVCharging(
+	ctx context.Context,
+	siteID string,
+	settings types.Settings,
+	creds types.Credentials,
+	status types.SystemStatus,
+	currentPrice types.Price,
+	futurePrices []types.Price,
+	history []types.EnergyStats,
+	schedule []types.ScheduleBlock,
+	action *types.Action,
+) {
+	if s.ocpp == nil || creds.OCPP == nil || settings.EVMaxChargeKW <= 0 {
+		return
+	}
+
+	// the battery plan is only built when it's pushed to the ESS
+	if schedule == nil {
+		schedule = s.controller.PlanSchedule(ctx, status, currentPrice, futurePrices, history, settings, *action)
+	}
+	now := status.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	blocks := controller.PlanEVCharging(now, currentPrice, futurePrices, schedule, settings)
+	if len(blocks) == 0 {
+		return
+	}
+	limit := blocks[0].LimitKW
+	action.EVChargeLimitKW = &limit
+	if settings.DryRun {
+		return
+	}
+
+	// connector 0 limits the whole charge point
+	err := s.ocpp.SetChargingProfile(ctx, siteID, creds.OCPP.ChargePointID, 0, ocpp.NewChargingProfile(blocks))
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to set ev charging profile", slog.String("chargePointID", creds.OCPP.ChargePointID), slog.Any("error", err))
+		action.EVChargeLimitKW = nil
+		return
+	}
+	log.Ctx(ctx).InfoContext(ctx, "update: set ev charging profile", slog.Float64("limitKW", limit), slog.Int("blocks", len(blocks)))
+}
+
+func (s *Server) handleGetEV(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	siteID := s.getSiteID(r)
+	_, creds, err := s.getSettingsWithMigration(ctx, siteID)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to get settings", slog.Any("error", err))
+		writeJSONError(w, "failed to get settings", http.StatusInternalServerError)
+		return
+	}
+	if s.ocpp == nil || creds.OCPP == nil {
+		writeJSONError(w, "no ev charger configured", http.StatusNotFound)
+		return
+	}
+
+	state, ok := s.ocpp.ChargePoint(siteID, creds.OCPP.ChargePointID)
+	if !ok {
+		state = ocpp.ChargePointState{ID: creds.OCPP.ChargePointID}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
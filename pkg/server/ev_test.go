@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/controller"
+	"github.com/raterudder/raterudder/pkg/ocpp"
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEVCharging(t *testing.T) {
+	ctx := context.Background()
+	srv := &Server{
+		controller:    controller.NewController(),
+		encryptionKey: "test-secret-key-1234567890123456",
+		bypassAuth:    true,
+		singleSite:    true,
+	}
+	srv.ocpp = ocpp.NewCentralSystem(srv.authorizeChargePoint)
+
+	creds := types.Credentials{
+		OCPP: &types.OCPPCredentials{ChargePointID: "cp1", Password: "secret"},
+	}
+	encrypted, err := srv.encryptCredentials(ctx, creds)
+	require.NoError(t, err)
+	settings := types.Settings{
+		EVMaxChargeKW:        7.2,
+		EVChargeHours:        2,
+		EncryptedCredentials: encrypted,
+	}
+	mockS := &mockStorage{}
+	mockS.On("GetSettings", mock.Anything, types.SiteIDNone).Return(settings, types.CurrentSettingsVersion, nil)
+	srv.storage = mockS
+
+	ts := httptest.NewServer(srv.setupHandler())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ocpp/cp1"
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		_, err := ocpp.DialSimulatedChargePoint(ctx, url, "wrong")
+		assert.Error(t, err)
+		_, err = ocpp.DialSimulatedChargePoint(ctx, strings.Replace(url, "cp1", "cp2", 1), "secret")
+		assert.Error(t, err)
+	})
+
+	cp, err := ocpp.DialSimulatedChargePoint(ctx, url, "secret")
+	require.NoError(t, err)
+	defer cp.Close()
+	_, err = cp.Boot(ctx, "Acme", "Wallbox")
+	require.NoError(t, err)
+
+	now := time.Date(2026, 3, 10, 14, 20, 0, 0, time.UTC)
+	status := types.SystemStatus{Timestamp: now}
+	currentPrice := types.Price{TSStart: now.Truncate(time.Hour), DollarsPerKWH: 0.02}
+	var futurePrices []types.Price
+	for i := 1; i < 24; i++ {
+		price := 0.10
+		if i == 5 {
+			price = 0.03
+		}
+		futurePrices = append(futurePrices, types.Price{TSStart: now.Truncate(time.Hour).Add(time.Duration(i) * time.Hour), DollarsPerKWH: price})
+	}
+
+	t.Run("Dry Run", func(t *testing.T) {
+		s := settings
+		s.DryRun = true
+		var action types.Action
+		srv.updateEVCharging(ctx, types.SiteIDNone, s, creds, status, currentPrice, futurePrices, nil, []types.ScheduleBlock{}, &action)
+		require.NotNil(t, action.EVChargeLimitKW)
+		assert.Equal(t, 7.2, *action.EVChargeLimitKW)
+		assert.Empty(t, cp.Profiles())
+	})
+
+	t.Run("Sends Profile", func(t *testing.T) {
+		var action types.Action
+		srv.updateEVCharging(ctx, types.SiteIDNone, settings, creds, status, currentPrice, futurePrices, nil, []types.ScheduleBlock{}, &action)
+		require.NotNil(t, action.EVChargeLimitKW)
+		assert.Equal(t, 7.2, *action.EVChargeLimitKW)
+
+		profiles := cp.Profiles()
+		require.Len(t, profiles, 1)
+		periods := profiles[0].ChargingProfile.ChargingSchedule.ChargingSchedulePeriod
+		assert.Equal(t, []ocpp.ChargingSchedulePeriod{
+			{StartPeriod: 0, Limit: 7200},
+			{StartPeriod: 3600, Limit: 0},
+			{StartPeriod: 5 * 3600, Limit: 7200},
+			{StartPeriod: 6 * 3600, Limit: 0},
+		}, periods)
+	})
+
+	t.Run("Charger Status", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/ev", nil)
+		w := httptest.NewRecorder()
+		srv.setupHandler().ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), `"connected":true`)
+		assert.Contains(t, w.Body.String(), `"vendor":"Acme"`)
+	})
+
+	t.Run("Disconnected", func(t *testing.T) {
+		require.NoError(t, cp.Close())
+		require.Eventually(t, func() bool {
+			state, _ := srv.ocpp.ChargePoint(types.SiteIDNone, "cp1")
+			return !state.Connected
+		}, time.Second, 10*time.Millisecond)
+
+		var action types.Action
+		srv.updateEVCharging(ctx, types.SiteIDNone, settings, creds, status, currentPrice, futurePrices, nil, []types.ScheduleBlock{}, &action)
+		assert.Nil(t, action.EVChargeLimitKW)
+	})
+}
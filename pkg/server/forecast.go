@@ -58,6 +58,7 @@ func (s *Server) handleForecast(w http.ResponseWriter, r *http.Request) {
 		log.Ctx(ctx).WarnContext(ctx, "failed to get future prices", slog.Any("error", err))
 		// Continue with empty future prices
 	}
+	futurePrices = s.forecastMissingPrices(ctx, siteID, futurePrices, time.Now())
 
 	// 5. Get History (Last 72 hours from Storage) - no backfill
 	historyStart := time.Now().Add(-72 * time.Hour)
@@ -71,6 +72,16 @@ func (s *Server) handleForecast(w http.ResponseWriter, r *http.Request) {
 
 	// 6. Run Simulation
 	now := time.Now().In(status.Timestamp.Location())
+	if len(settings.FlexibleLoads) > 0 {
+		pending, withoutLoads, err := s.pendingLoads(ctx, siteID, settings.FlexibleLoads, energyHistory, now)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to get delivered load energy", slog.Any("error", err))
+		} else {
+			settings.FlexibleLoads = pending
+			energyHistory = withoutLoads
+		}
+	}
+	status.BatteryTempForecast, settings.BatteryMinChargeTempC = s.forecastBatteryTemps(ctx, siteID, status, settings.Settings, now)
 	simHours := s.controller.SimulateState(ctx, now, status, currentPrice, futurePrices, energyHistory, settings.Settings)
 
 	w.Header().Set("Cache-Control", "private, max-age=300")
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
 )
 
 func (s *Server) handleHistoryPrices(w http.ResponseWriter, r *http.Request) {
@@ -76,6 +77,51 @@ func (s *Server) handleHistoryActions(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleHistoryBatteries returns the state of each battery module, and how
+// far apart they are, for every action in the time range that reported it.
+func (s *Server) handleHistoryBatteries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	siteID := s.getSiteID(r)
+	start, end, err := parseTimeRange(r)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("invalid time range: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	actions, err := s.storage.GetActionHistory(ctx, siteID, start, end)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to get actions", slog.String("siteID", siteID), slog.Any("error", err))
+		writeJSONError(w, "failed to get actions", http.StatusInternalServerError)
+		return
+	}
+
+	modules := []types.BatteryModules{}
+	for _, a := range actions {
+		if len(a.SystemStatus.EachBatterySOC) == 0 {
+			continue
+		}
+		m := a.SystemStatus.Modules()
+		// older statuses didn't have their own timestamp
+		if m.Timestamp.IsZero() {
+			m.Timestamp = a.Timestamp
+		}
+		modules = append(modules, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	today := truncateDay(time.Now())
+	if end.Before(today) {
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+	}
+
+	if err := json.NewEncoder(w).Encode(modules); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
 func parseTimeRange(r *http.Request) (time.Time, time.Time, error) {
 	startStr := r.URL.Query().Get("start")
 	endStr := r.URL.Query().Get("end")
@@ -187,6 +187,45 @@ func TestHistory(t *testing.T) {
 		assert.WithinDuration(t, end, mockS.lastEnd, time.Second)
 	})
 
+	t.Run("Fetch Battery Modules", func(t *testing.T) {
+		now := time.Now().Truncate(time.Second)
+		mockS.actions = []types.Action{
+			{
+				Timestamp: now.Add(-40 * time.Minute),
+				SystemStatus: types.SystemStatus{
+					Timestamp:      now.Add(-41 * time.Minute),
+					EachBatterySOC: []float64{80, 68, 75},
+					EachBatteryKW:  []float64{-1, -1.2, -1.1},
+				},
+			},
+			// no module data
+			{Timestamp: now.Add(-30 * time.Minute)},
+			{
+				Timestamp:    now.Add(-20 * time.Minute),
+				SystemStatus: types.SystemStatus{EachBatterySOC: []float64{90, 88}},
+			},
+		}
+		mockS.err = nil
+
+		q := make(url.Values)
+		q.Set("start", now.Add(-time.Hour).Format(time.RFC3339))
+		q.Set("end", now.Format(time.RFC3339))
+		req := httptest.NewRequest("GET", "/api/history/batteries?"+q.Encode(), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var modules []types.BatteryModules
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&modules))
+		require.Len(t, modules, 2)
+		assert.True(t, now.Add(-41*time.Minute).Equal(modules[0].Timestamp))
+		assert.Equal(t, []float64{80, 68, 75}, modules[0].EachBatterySOC)
+		assert.InDelta(t, 12.0, modules[0].ImbalanceSOC, 0.001)
+		// falls back to the action's timestamp
+		assert.True(t, now.Add(-20*time.Minute).Equal(modules[1].Timestamp))
+		assert.InDelta(t, 2.0, modules[1].ImbalanceSOC, 0.001)
+	})
+
 	t.Run("Fetch Prices Data", func(t *testing.T) {
 		now := time.Now()
 		expectedPrices := []types.Price{
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/storage"
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/raterudder/raterudder/pkg/utility"
+)
+
+// ImportGreenButtonRes is the response type for the green button import endpoint
+type ImportGreenButtonRes struct {
+	Hours            int       `json:"hours"`
+	MatchedESSHours  int       `json:"matchedESSHours"`
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	MeterImportKWH   float64   `json:"meterImportKWH"`
+	MeterExportKWH   float64   `json:"meterExportKWH"`
+	ESSGridImportKWH float64   `json:"essGridImportKWH"`
+	ESSGridExportKWH float64   `json:"essGridExportKWH"`
+}
+
+func (s *Server) handleImportGreenButton(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	siteID := s.getSiteID(r)
+
+	user := s.getUser(r)
+	if user.ID == "" {
+		writeJSONError(w, "missing authentication", http.StatusUnauthorized)
+		return
+	}
+	if !user.Admin {
+		log.Ctx(ctx).WarnContext(ctx, "unauthorized for green button import", slog.String("userID", user.ID), slog.String("email", user.Email))
+		writeJSONError(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		XML string `json:"xml"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to decode green button import", slog.Any("error", err))
+		writeJSONError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	meterHistory, err := utility.ParseGreenButton(strings.NewReader(req.XML))
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to parse green button data", slog.Any("error", err))
+		writeJSONError(w, fmt.Sprintf("invalid green button data: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(meterHistory) == 0 {
+		writeJSONError(w, "no interval readings found", http.StatusBadRequest)
+		return
+	}
+
+	res, err := ImportMeterHistory(ctx, s.storage, siteID, meterHistory)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to import green button data", slog.Any("error", err))
+		writeJSONError(w, "failed to import green button data", http.StatusInternalServerError)
+		return
+	}
+	log.Ctx(ctx).InfoContext(
+		ctx,
+		"imported green button data",
+		slog.Int("hours", res.Hours),
+		slog.Int("matchedESSHours", res.MatchedESSHours),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// ImportMeterHistory reconciles the hourly meter readings with the stored ESS
+// history for the site and stores the result. Hours without ESS history are
+// counted but not stored since they would move the energy history sync point
+// past hours the ESS hasn't been synced for. meterHistory must be sorted by
+// TSHourStart.
+func ImportMeterHistory(ctx context.Context, db storage.Database, siteID string, meterHistory []types.EnergyStats) (ImportGreenButtonRes, error) {
+	var res ImportGreenButtonRes
+	if len(meterHistory) == 0 {
+		return res, nil
+	}
+	res.Start = meterHistory[0].TSHourStart
+	res.End = meterHistory[len(meterHistory)-1].TSHourStart.Add(time.Hour)
+
+	essHistory, err := db.GetEnergyHistory(ctx, siteID, res.Start, res.End)
+	if err != nil {
+		return res, fmt.Errorf("failed to get energy history: %w", err)
+	}
+	essHours := make(map[int64]bool, len(essHistory))
+	for _, h := range essHistory {
+		essHours[h.TSHourStart.Truncate(time.Hour).Unix()] = true
+	}
+
+	for _, h := range utility.MergeMeterHistory(essHistory, meterHistory) {
+		res.Hours++
+		res.MeterImportKWH += h.MeterGridImportKWH
+		res.MeterExportKWH += h.MeterGridExportKWH
+		if !essHours[h.TSHourStart.Truncate(time.Hour).Unix()] {
+			continue
+		}
+		res.MatchedESSHours++
+		res.ESSGridImportKWH += h.GridImportKWH
+		res.ESSGridExportKWH += h.GridExportKWH
+		if err := db.UpsertEnergyHistory(ctx, siteID, h, types.CurrentEnergyStatsVersion); err != nil {
+			return res, fmt.Errorf("failed to upsert energy history: %w", err)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testGreenButtonFeed = `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry><content><ReadingType xmlns="http://naesb.org/espi"><flowDirection>1</flowDirection><uom>72</uom></ReadingType></content></entry>
+  <entry><content><IntervalBlock xmlns="http://naesb.org/espi">
+    <IntervalReading><timePeriod><duration>3600</duration><start>1704067200</start></timePeriod><value>1500</value></IntervalReading>
+    <IntervalReading><timePeriod><duration>3600</duration><start>1704070800</start></timePeriod><value>2500</value></IntervalReading>
+  </IntervalBlock></content></entry>
+</feed>`
+
+func TestHandleImportGreenButton(t *testing.T) {
+	start := time.Unix(1704067200, 0)
+
+	t.Run("Success", func(t *testing.T) {
+		mockS := &mockStorage{}
+		srv := &Server{storage: mockS, bypassAuth: true, singleSite: true}
+		handler := srv.setupHandler()
+
+		mockS.On("GetEnergyHistory", mock.Anything, types.SiteIDNone, mock.MatchedBy(func(ts time.Time) bool {
+			return ts.Equal(start)
+		}), mock.MatchedBy(func(ts time.Time) bool {
+			return ts.Equal(start.Add(2 * time.Hour))
+		})).Return([]types.EnergyStats{
+			{TSHourStart: start, HomeKWH: 3, GridImportKWH: 1.4},
+		}, nil)
+		mockS.On("UpsertEnergyHistory", mock.Anything, types.SiteIDNone, mock.MatchedBy(func(s types.EnergyStats) bool {
+			return s.TSHourStart.Equal(start) && s.HomeKWH == 3 && s.GridImportKWH == 1.4 && s.MeterGridImportKWH == 1.5 && s.HasMeterData
+		}), types.CurrentEnergyStatsVersion).Return(nil).Once()
+
+		body, err := json.Marshal(map[string]string{"xml": testGreenButtonFeed})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/import/greenbutton", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var res ImportGreenButtonRes
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, 2, res.Hours)
+		assert.Equal(t, 1, res.MatchedESSHours)
+		assert.InDelta(t, 4.0, res.MeterImportKWH, 0.0001)
+		assert.InDelta(t, 1.4, res.ESSGridImportKWH, 0.0001)
+		mockS.AssertExpectations(t)
+		// the hour without ESS history isn't stored
+		mockS.AssertNumberOfCalls(t, "UpsertEnergyHistory", 1)
+	})
+
+	t.Run("Invalid XML", func(t *testing.T) {
+		mockS := &mockStorage{}
+		srv := &Server{storage: mockS, bypassAuth: true, singleSite: true}
+		handler := srv.setupHandler()
+
+		body, err := json.Marshal(map[string]string{"xml": "<feed"})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/import/greenbutton", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockS.AssertNotCalled(t, "UpsertEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Not Admin", func(t *testing.T) {
+		srv := &Server{storage: &mockStorage{}}
+		req := httptest.NewRequest("POST", "/api/import/greenbutton", bytes.NewReader([]byte(`{}`)))
+		ctx := context.WithValue(req.Context(), userContextKey, types.User{ID: "user1"})
+		ctx = context.WithValue(ctx, siteIDContextKey, "site1")
+		w := httptest.NewRecorder()
+		srv.handleImportGreenButton(w, req.WithContext(ctx))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/ess"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// maxLoadRunGap is the longest a load is assumed to have kept running after
+// it was switched on if there was no update after.
+const maxLoadRunGap = time.Hour
+
+// pendingLoads returns the site's flexible loads with EnergyKWH reduced by
+// what they've already used since their last deadline. The energy is worked
+// out from how long each load was switched on in past actions. It also
+// returns history with that energy taken out of HomeKWH since the simulation
+// adds the planned runs on top of the usual home load.
+func (s *Server) pendingLoads(ctx context.Context, siteID string, loads []types.FlexibleLoad, history []types.EnergyStats, now time.Time) ([]types.FlexibleLoad, []types.EnergyStats, error) {
+	if len(loads) == 0 {
+		return nil, history, nil
+	}
+	since := now
+	for _, l := range loads {
+		if start := l.NextDeadline(now).AddDate(0, 0, -1); start.Before(since) {
+			since = start
+		}
+	}
+	for _, h := range history {
+		if !h.TSHourStart.IsZero() && h.TSHourStart.Before(since) {
+			since = h.TSHourStart
+		}
+	}
+	actions, err := s.storage.GetActionHistory(ctx, siteID, since, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hourIdx := make(map[int64]int, len(history))
+	adjusted := make([]types.EnergyStats, len(history))
+	for i, h := range history {
+		adjusted[i] = h
+		hourIdx[h.TSHourStart.Unix()] = i
+	}
+
+	pending := make([]types.FlexibleLoad, len(loads))
+	for i, l := range loads {
+		start := l.NextDeadline(now).AddDate(0, 0, -1)
+		var hours float64
+		for j, a := range actions {
+			if a.DryRun || !loadWasOn(a, l.ID) {
+				continue
+			}
+			end := now
+			if j+1 < len(actions) {
+				end = actions[j+1].Timestamp
+			}
+			end = minTime(end, a.Timestamp.Add(maxLoadRunGap))
+			if !a.Timestamp.Before(start) {
+				hours += end.Sub(a.Timestamp).Hours()
+			}
+			// split the run across the hours of history it fell in
+			for t := a.Timestamp; t.Before(end); {
+				hourEnd := minTime(t.Truncate(time.Hour).Add(time.Hour), end)
+				if idx, ok := hourIdx[t.Truncate(time.Hour).Unix()]; ok {
+					adjusted[idx].HomeKWH = max(0, adjusted[idx].HomeKWH-hourEnd.Sub(t).Hours()*l.PowerKW)
+				}
+				t = hourEnd
+			}
+		}
+		l.EnergyKWH = max(0, l.EnergyKWH-hours*l.PowerKW)
+		pending[i] = l
+	}
+	return pending, adjusted, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func loadWasOn(a types.Action, id string) bool {
+	for _, r := range a.Loads {
+		if r.ID == id {
+			return r.On && r.Error == ""
+		}
+	}
+	return false
+}
+
+// updateLoads switches each flexible load on or off depending on whether
+// it's scheduled to run now and records the results on the action. settings
+// must already have the pending loads.
+func (s *Server) updateLoads(
+	ctx context.Context,
+	siteID string,
+	settings types.Settings,
+	creds types.Credentials,
+	status types.SystemStatus,
+	currentPrice types.Price,
+	futurePrices []types.Price,
+	history []types.EnergyStats,
+	action *types.Action,
+) {
+	if len(settings.FlexibleLoads) == 0 {
+		return
+	}
+	now := status.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	schedules := s.controller.PlanLoads(ctx, now, status, currentPrice, futurePrices, history, settings)
+	for i, l := range settings.FlexibleLoads {
+		result := types.LoadResult{ID: l.ID, On: schedules[i].RunningAt(now)}
+		if !settings.DryRun {
+			err := s.setLoad(ctx, siteID, l, creds, result.On)
+			if err != nil {
+				log.Ctx(ctx).WarnContext(ctx, "failed to switch load", slog.String("loadID", l.ID), slog.Bool("on", result.On), slog.Any("error", err))
+				result.Error = err.Error()
+			}
+		}
+		action.Loads = append(action.Loads, result)
+	}
+	log.Ctx(ctx).InfoContext(ctx, "update: switched loads", slog.Any("loads", action.Loads))
+}
+
+func (s *Server) setLoad(ctx context.Context, siteID string, load types.FlexibleLoad, creds types.Credentials, on bool) error {
+	newActuator := ess.NewActuator
+	if s.newActuator != nil {
+		newActuator = s.newActuator
+	}
+	actuator, err := newActuator(siteID, load, creds)
+	if err != nil {
+		return fmt.Errorf("invalid actuator: %w", err)
+	}
+	return actuator.SetLoad(ctx, on)
+}
+
+func (s *Server) handleGetLoads(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	siteID := s.getSiteID(r)
+	settings, creds, err := s.getSettingsWithMigration(ctx, siteID)
+	if err != nil {
+		log.Ctx(ctx).ErrorContext(ctx, "failed to get settings", slog.Any("error", err))
+		writeJSONError(w, "failed to get settings", http.StatusInternalServerError)
+		return
+	}
+
+	schedules := []types.LoadSchedule{}
+	if len(settings.FlexibleLoads) > 0 {
+		essSystem, err := s.getESSSystem(ctx, siteID, settings, creds)
+		if err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to get ess system", slog.Any("error", err))
+			writeJSONError(w, "failed to get ess system", http.StatusInternalServerError)
+			return
+		}
+		status, err := essSystem.GetStatus(ctx)
+		if err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to get ess status", slog.Any("error", err))
+			writeJSONError(w, "failed to get ess status", http.StatusInternalServerError)
+			return
+		}
+		utility, err := s.utilities.Site(ctx, siteID, settings.Settings)
+		if err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to get utility system", slog.String("utility", settings.UtilityProvider))
+			writeJSONError(w, "failed to get utility system", http.StatusInternalServerError)
+			return
+		}
+		currentPrice, err := utility.GetCurrentPrice(ctx)
+		if err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to get price", slog.Any("error", err))
+			writeJSONError(w, "failed to get current price", http.StatusInternalServerError)
+			return
+		}
+		futurePrices, err := utility.GetFuturePrices(ctx)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to get future prices", slog.Any("error", err))
+		}
+		now := time.Now().In(status.Timestamp.Location())
+		futurePrices = s.forecastMissingPrices(ctx, siteID, futurePrices, now)
+		energyHistory, err := s.storage.GetEnergyHistory(ctx, siteID, now.Add(-72*time.Hour), now)
+		if err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to get energy history from storage", slog.Any("error", err))
+			writeJSONError(w, "failed to get energy history", http.StatusInternalServerError)
+			return
+		}
+		settings.FlexibleLoads, energyHistory, err = s.pendingLoads(ctx, siteID, settings.FlexibleLoads, energyHistory, now)
+		if err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to get delivered load energy", slog.Any("error", err))
+			writeJSONError(w, "failed to get action history", http.StatusInternalServerError)
+			return
+		}
+		schedules = s.controller.PlanLoads(ctx, now, status, currentPrice, futurePrices, energyHistory, settings.Settings)
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(schedules); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
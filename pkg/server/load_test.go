@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/controller"
+	"github.com/raterudder/raterudder/pkg/ess"
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingLoads(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+	water := types.FlexibleLoad{ID: "water", EnergyKWH: 6, PowerKW: 4, DeadlineHour: 6}
+	pool := types.FlexibleLoad{ID: "pool", EnergyKWH: 3, PowerKW: 1, DeadlineHour: 6}
+
+	mockS := &mockStorage{}
+	mockS.On("GetActionHistory", mock.Anything, "site1", time.Date(2026, 3, 10, 6, 0, 0, 0, time.UTC), now).Return([]types.Action{
+		{Timestamp: now.Add(-4 * time.Hour), Loads: []types.LoadResult{{ID: "water", On: true}}},
+		// still running 30 minutes later
+		{Timestamp: now.Add(-3*time.Hour - 30*time.Minute), Loads: []types.LoadResult{{ID: "water", On: true}, {ID: "pool", On: true, Error: "timeout"}}},
+		{Timestamp: now.Add(-3 * time.Hour), Loads: []types.LoadResult{{ID: "water", On: false}}},
+		{Timestamp: now.Add(-2 * time.Hour), DryRun: true, Loads: []types.LoadResult{{ID: "pool", On: true}}},
+		// no update after so it's assumed to run for at most an hour
+		{Timestamp: now.Add(-90 * time.Minute), Loads: []types.LoadResult{{ID: "pool", On: true}}},
+	}, nil)
+
+	var history []types.EnergyStats
+	for i := 6; i > 0; i-- {
+		history = append(history, types.EnergyStats{TSHourStart: now.Add(-time.Duration(i) * time.Hour), HomeKWH: 5})
+	}
+
+	srv := &Server{storage: mockS}
+	pending, withoutLoads, err := srv.pendingLoads(ctx, "site1", []types.FlexibleLoad{water, pool}, history, now)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	// an hour at 4kW
+	assert.InDelta(t, 2.0, pending[0].EnergyKWH, 0.001)
+	// an hour at 1kW
+	assert.InDelta(t, 2.0, pending[1].EnergyKWH, 0.001)
+	mockS.AssertExpectations(t)
+
+	// the energy the loads used is taken out of the home load so it isn't
+	// counted again on top of the planned runs
+	require.Len(t, withoutLoads, 6)
+	assert.InDelta(t, 5.0, withoutLoads[0].HomeKWH, 0.001)
+	assert.InDelta(t, 5.0, withoutLoads[1].HomeKWH, 0.001)
+	assert.InDelta(t, 1.0, withoutLoads[2].HomeKWH, 0.001)
+	assert.InDelta(t, 4.5, withoutLoads[4].HomeKWH, 0.001)
+	assert.InDelta(t, 4.5, withoutLoads[5].HomeKWH, 0.001)
+	assert.InDelta(t, 5.0, history[2].HomeKWH, 0.001, "the history passed in isn't changed")
+}
+
+type actuatorFunc func(ctx context.Context, on bool) error
+
+func (f actuatorFunc) SetLoad(ctx context.Context, on bool) error {
+	return f(ctx, on)
+}
+
+func TestUpdateLoads(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+
+	var mu sync.Mutex
+	commands := map[string]bool{}
+	// webhooks only go to public https urls so they're switched by a fake
+	newActuator := func(siteID string, load types.FlexibleLoad, creds types.Credentials) (ess.Actuator, error) {
+		if load.Actuator.Type != types.LoadActuatorWebhook {
+			return ess.NewActuator(siteID, load, creds)
+		}
+		return actuatorFunc(func(ctx context.Context, on bool) error {
+			mu.Lock()
+			defer mu.Unlock()
+			commands[load.ID] = on
+			return nil
+		}), nil
+	}
+
+	// the current hour is the cheapest
+	currentPrice := types.Price{TSStart: now, DollarsPerKWH: 0.02}
+	var futurePrices []types.Price
+	for i := 1; i < 24; i++ {
+		futurePrices = append(futurePrices, types.Price{TSStart: now.Add(time.Duration(i) * time.Hour), DollarsPerKWH: 0.10})
+	}
+	settings := types.Settings{
+		IgnoreHourUsageOverMultiple: 2,
+		SolarTrendRatioMax:          3,
+		FlexibleLoads: []types.FlexibleLoad{
+			{ID: "water", EnergyKWH: 4, PowerKW: 4, DeadlineHour: 6, Actuator: types.LoadActuator{Type: types.LoadActuatorWebhook, URL: "https://example.com/water"}},
+			// already done for the day
+			{ID: "pool", EnergyKWH: 0, PowerKW: 1, DeadlineHour: 6, Actuator: types.LoadActuator{Type: types.LoadActuatorWebhook, URL: "https://example.com/pool"}},
+			{ID: "spa", EnergyKWH: 1, PowerKW: 1, DeadlineHour: 6, Actuator: types.LoadActuator{Type: types.LoadActuatorMQTT, Topic: "spa"}},
+		},
+	}
+	status := types.SystemStatus{Timestamp: now, BatterySOC: 50, BatteryCapacityKWH: 10}
+	srv := &Server{controller: controller.NewController(), newActuator: newActuator}
+
+	t.Run("Dry Run", func(t *testing.T) {
+		s := settings
+		s.DryRun = true
+		var action types.Action
+		srv.updateLoads(ctx, "site1", s, types.Credentials{}, status, currentPrice, futurePrices, nil, &action)
+		assert.Equal(t, []types.LoadResult{{ID: "water", On: true}, {ID: "pool", On: false}, {ID: "spa", On: true}}, action.Loads)
+		assert.Empty(t, commands)
+	})
+
+	t.Run("Switches Loads", func(t *testing.T) {
+		var action types.Action
+		srv.updateLoads(ctx, "site1", settings, types.Credentials{}, status, currentPrice, futurePrices, nil, &action)
+		require.Len(t, action.Loads, 3)
+		assert.Equal(t, types.LoadResult{ID: "water", On: true}, action.Loads[0])
+		assert.Equal(t, types.LoadResult{ID: "pool", On: false}, action.Loads[1])
+		// there are no mqtt credentials for the site
+		assert.Contains(t, action.Loads[2].Error, "requires mqtt credentials")
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, map[string]bool{"water": true, "pool": false}, commands)
+	})
+}
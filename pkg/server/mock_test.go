@@ -97,6 +97,19 @@ func (m *mockStorage) GetLatestPriceHistoryTime(ctx context.Context, siteID stri
 	return time.Time{}, 0, nil
 }
 
+func (m *mockStorage) UpsertBillReconciliation(ctx context.Context, siteID string, report types.BillReconciliation) error {
+	args := m.Called(ctx, siteID, report)
+	return args.Error(0)
+}
+
+func (m *mockStorage) GetBillReconciliations(ctx context.Context, siteID string, start, end time.Time) ([]types.BillReconciliation, error) {
+	args := m.Called(ctx, siteID, start, end)
+	if len(args) > 0 {
+		return args.Get(0).([]types.BillReconciliation), args.Error(1)
+	}
+	return nil, nil
+}
+
 func (m *mockStorage) GetUser(ctx context.Context, email string) (types.User, error) {
 	args := m.Called(ctx, email)
 	if len(args) > 0 {
@@ -182,9 +195,18 @@ func (m *mockUtility) ApplySettings(ctx context.Context, settings types.Settings
 	args := m.Called(ctx, settings)
 	return args.Error(0)
 }
+func (m *mockUtility) AdditionalFees(ts time.Time) []types.UtilityAdditionalFeesPeriod {
+	args := m.Called(ts)
+	if len(args) > 0 {
+		return args.Get(0).([]types.UtilityAdditionalFeesPeriod)
+	}
+	return nil
+}
 
 type mockESS struct {
 	mock.Mock
+	// caps are returned by Capabilities, nil supports everything
+	caps *types.ESSCapabilities
 }
 
 func (m *mockESS) GetStatus(ctx context.Context) (types.SystemStatus, error) {
@@ -217,6 +239,33 @@ func (m *mockESS) GetEnergyHistory(ctx context.Context, start, end time.Time) ([
 	}
 	return nil, nil
 }
+func (m *mockESS) Capabilities() types.ESSCapabilities {
+	if m.caps == nil {
+		return types.AllESSCapabilities()
+	}
+	return *m.caps
+}
+
+// mockSchedulerESS is a mockESS that can follow a schedule.
+type mockSchedulerESS struct {
+	*mockESS
+}
+
+func (m *mockSchedulerESS) SetSchedule(ctx context.Context, blocks []types.ScheduleBlock) error {
+	args := m.Called(ctx, blocks)
+	return args.Error(0)
+}
+
+// mockMultiUnitESS is a mockESS made up of several units.
+type mockMultiUnitESS struct {
+	*mockESS
+}
+
+func (m *mockMultiUnitESS) UnitResults() []types.UnitResult {
+	args := m.Called()
+	return args.Get(0).([]types.UnitResult)
+}
+
 func (m *mockESS) Validate() error {
 	args := m.Called()
 	return args.Error(0)
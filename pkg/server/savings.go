@@ -50,6 +50,9 @@ func (s *Server) handleHistorySavings(w http.ResponseWriter, r *http.Request) {
 		totalSavings.GridImported += stats.GridImported
 		totalSavings.GridExported += stats.GridExported
 		totalSavings.BatteryUsed += stats.BatteryUsed
+		totalSavings.GeneratorUsed += stats.GeneratorUsed
+		totalSavings.GeneratorHours += stats.GeneratorHours
+		totalSavings.GeneratorCost += stats.GeneratorCost
 		totalSavings.Cost += stats.Cost
 		totalSavings.Credit += stats.Credit
 		totalSavings.AvoidedCost += stats.AvoidedCost
@@ -110,16 +113,26 @@ func (s *Server) getSiteSavings(ctx context.Context, siteID string, start, end t
 		gridImportPrice := hourlyImportPrices[ts]
 		gridExportPrice := hourlyExportPrices[ts]
 
+		// prefer the revenue meter over the ESS for grid usage when we have it
+		gridImportKWH := stat.GridImportKWH
+		gridExportKWH := stat.GridExportKWH
+		if stat.HasMeterData {
+			gridImportKWH = stat.MeterGridImportKWH
+			gridExportKWH = stat.MeterGridExportKWH
+		}
+
 		// Accumulate Energy Amounts even if price is missing
 		stats.HomeUsed += stat.HomeKWH
 		stats.SolarGenerated += stat.SolarKWH
-		stats.GridImported += stat.GridImportKWH
-		stats.GridExported += stat.GridExportKWH
+		stats.GridImported += gridImportKWH
+		stats.GridExported += gridExportKWH
 		stats.BatteryUsed += stat.BatteryUsedKWH
+		stats.GeneratorUsed += stat.GeneratorKWH
+		stats.GeneratorHours += stat.GeneratorHours
 
 		// Cost and Credit
-		cost := stat.GridImportKWH * gridImportPrice
-		credit := stat.GridExportKWH * gridExportPrice
+		cost := gridImportKWH * gridImportPrice
+		credit := gridExportKWH * gridExportPrice
 		stats.Cost += cost
 		stats.Credit += credit
 
@@ -131,7 +144,7 @@ func (s *Server) getSiteSavings(ctx context.Context, siteID string, start, end t
 
 		// Determine how much battery was charged from the grid and what cost we
 		// paid to charge the battery.
-		gridToBattery := math.Max(0, stat.BatteryChargedKWH-stat.SolarToBatteryKWH)
+		gridToBattery := math.Max(0, stat.BatteryChargedKWH-stat.SolarToBatteryKWH-stat.GeneratorToBatteryKWH)
 		chargingCost := gridToBattery * gridImportPrice
 		stats.ChargingCost += chargingCost
 
@@ -155,5 +168,15 @@ func (s *Server) getSiteSavings(ctx context.Context, siteID string, start, end t
 	}
 
 	stats.BatterySavings = stats.AvoidedCost - stats.ChargingCost
+
+	// the generator's fuel cost isn't part of the energy history so only look
+	// up settings for sites that ran one
+	if stats.GeneratorUsed > 0 {
+		settings, _, err := s.storage.GetSettings(ctx, siteID)
+		if err != nil {
+			return types.SavingsStats{}, err
+		}
+		stats.GeneratorCost = stats.GeneratorUsed * settings.GeneratorCostDollarsPerKWH
+	}
 	return stats, nil
 }
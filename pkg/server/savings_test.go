@@ -187,3 +187,80 @@ func TestHandleHistorySavingsAll(t *testing.T) {
 	assert.Equal(t, 30.0, savings.HomeUsed) // 10 + 20
 	assert.Empty(t, savings.HourlyDebugging)
 }
+
+func TestHandleHistorySavingsMeterData(t *testing.T) {
+	mockStore := &mockStorage{}
+	s := &Server{storage: mockStore, bypassAuth: true}
+
+	start := time.Now().Truncate(24 * time.Hour)
+	end := start.Add(24 * time.Hour)
+
+	mockStore.On("GetPriceHistory", mock.Anything, "site1", mock.Anything, mock.Anything).Return([]types.Price{
+		{TSStart: start, DollarsPerKWH: 0.10},
+	}, nil)
+	mockStore.On("GetEnergyHistory", mock.Anything, "site1", mock.Anything, mock.Anything).Return([]types.EnergyStats{
+		{
+			TSHourStart:        start,
+			HomeKWH:            10,
+			GridImportKWH:      10,
+			GridExportKWH:      1,
+			MeterGridImportKWH: 12,
+			HasMeterData:       true,
+		},
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/history/savings?siteID=site1&start="+start.Format(time.RFC3339)+"&end="+end.Format(time.RFC3339), nil)
+	req = req.WithContext(context.WithValue(req.Context(), siteIDContextKey, "site1"))
+
+	rr := httptest.NewRecorder()
+	s.handleHistorySavings(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var savings types.SavingsStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &savings))
+
+	// the meter readings take precedence over the ESS readings
+	assert.InDelta(t, 12.0, savings.GridImported, 0.0001)
+	assert.InDelta(t, 0.0, savings.GridExported, 0.0001)
+	assert.InDelta(t, 1.20, savings.Cost, 0.0001)
+}
+
+func TestHandleHistorySavingsGenerator(t *testing.T) {
+	mockStore := &mockStorage{}
+	s := &Server{storage: mockStore, bypassAuth: true}
+
+	start := time.Now().Truncate(24 * time.Hour)
+	end := start.Add(24 * time.Hour)
+
+	mockStore.On("GetSettings", mock.Anything, "site1").Return(types.Settings{GeneratorCostDollarsPerKWH: 0.40}, types.CurrentSettingsVersion, nil)
+	mockStore.On("GetPriceHistory", mock.Anything, "site1", mock.Anything, mock.Anything).Return([]types.Price{
+		{TSStart: start, DollarsPerKWH: 0.10},
+	}, nil)
+	mockStore.On("GetEnergyHistory", mock.Anything, "site1", mock.Anything, mock.Anything).Return([]types.EnergyStats{
+		{
+			TSHourStart:           start,
+			HomeKWH:               3,
+			BatteryChargedKWH:     2,
+			GeneratorKWH:          5,
+			GeneratorToHomeKWH:    3,
+			GeneratorToBatteryKWH: 2,
+			GeneratorHours:        1,
+		},
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/history/savings?siteID=site1&start="+start.Format(time.RFC3339)+"&end="+end.Format(time.RFC3339), nil)
+	req = req.WithContext(context.WithValue(req.Context(), siteIDContextKey, "site1"))
+
+	rr := httptest.NewRecorder()
+	s.handleHistorySavings(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var savings types.SavingsStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &savings))
+
+	assert.InDelta(t, 5.0, savings.GeneratorUsed, 0.0001)
+	assert.InDelta(t, 1.0, savings.GeneratorHours, 0.0001)
+	assert.InDelta(t, 2.0, savings.GeneratorCost, 0.0001)
+	// charging the battery from the generator isn't grid charging
+	assert.InDelta(t, 0.0, savings.ChargingCost, 0.0001)
+}
@@ -20,9 +20,11 @@ import (
 	"github.com/raterudder/raterudder/pkg/controller"
 	"github.com/raterudder/raterudder/pkg/ess"
 	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/ocpp"
 	"github.com/raterudder/raterudder/pkg/storage"
 	"github.com/raterudder/raterudder/pkg/types"
 	"github.com/raterudder/raterudder/pkg/utility"
+	"github.com/raterudder/raterudder/pkg/weather"
 	"github.com/raterudder/raterudder/web"
 )
 
@@ -51,6 +53,14 @@ type Server struct {
 	storage    storage.Database
 	controller *controller.Controller
 
+	// priceForecaster estimates prices the utility hasn't published
+	priceForecaster utility.PriceForecaster
+	// weather forecasts the outdoor temperature, nil to skip the forecast
+	weather weather.Forecaster
+	// newActuator returns the actuator for a flexible load, nil for
+	// ess.NewActuator
+	newActuator func(siteID string, load types.FlexibleLoad, creds types.Credentials) (ess.Actuator, error)
+
 	listenAddr string
 	devProxy   string
 	httpServer *http.Server
@@ -66,18 +76,28 @@ type Server struct {
 	serverName          string
 	webCacheDuration    time.Duration
 	showHidden          bool
+
+	// modeVerifications counts whether each ESS provider applied the modes
+	// it was sent
+	modeVerifications verificationStats
+
+	// ocpp is the central system EV chargers connect to
+	ocpp *ocpp.CentralSystem
 }
 
 // Configured initializes the Server with dependencies.
 // It uses lflag to register command-line flags for configuration.
 func Configured(u *utility.Map, e *ess.Map, s storage.Database) *Server {
 	srv := &Server{
-		utilities:  u,
-		ess:        e,
-		storage:    s,
-		controller: controller.NewController(),
-		serverName: "raterudder",
+		utilities:       u,
+		ess:             e,
+		storage:         s,
+		controller:      controller.NewController(),
+		priceForecaster: utility.NewSeasonalForecaster(),
+		weather:         weather.NewOpenMeteo(),
+		serverName:      "raterudder",
 	}
+	srv.ocpp = ocpp.NewCentralSystem(srv.authorizeChargePoint)
 	revision := os.Getenv("K_REVISION")
 	if revision != "" {
 		srv.serverName = revision
@@ -195,6 +215,7 @@ func (s *Server) setupHandler() http.Handler {
 	apiMux.HandleFunc("GET /api/history/prices", s.handleHistoryPrices)
 	apiMux.HandleFunc("GET /api/history/actions", s.handleHistoryActions)
 	apiMux.HandleFunc("GET /api/history/savings", s.handleHistorySavings)
+	apiMux.HandleFunc("GET /api/history/batteries", s.handleHistoryBatteries)
 	apiMux.HandleFunc("GET /api/settings", s.handleGetSettings)
 	apiMux.HandleFunc("POST /api/settings", s.handleUpdateSettings)
 	apiMux.HandleFunc("GET /api/auth/status", s.handleAuthStatus)
@@ -204,6 +225,12 @@ func (s *Server) setupHandler() http.Handler {
 	apiMux.HandleFunc("POST /api/join", s.handleJoin)
 	apiMux.HandleFunc("GET /api/list/utilities", s.handleListUtilities)
 	apiMux.HandleFunc("GET /api/list/ess", s.handleListESS)
+	apiMux.HandleFunc("POST /api/import/greenbutton", s.handleImportGreenButton)
+	apiMux.HandleFunc("POST /api/billing/reconcile", s.handleReconcileBill)
+	apiMux.HandleFunc("GET /api/billing/reconciliations", s.handleBillReconciliations)
+	apiMux.HandleFunc("GET /api/stats/verification", s.handleVerificationStats)
+	apiMux.HandleFunc("GET /api/ev", s.handleGetEV)
+	apiMux.HandleFunc("GET /api/loads", s.handleGetLoads)
 
 	mux := http.NewServeMux()
 	mux.Handle("/api/", s.authMiddleware(apiMux))
@@ -224,7 +251,18 @@ func (s *Server) setupHandler() http.Handler {
 		mux.Handle("/", s.webHandler(distFS, fileServer))
 	}
 	mux.HandleFunc("/healthz", s.handleHealthz)
-	return s.revisionMiddleware(gziphandler.GzipHandler(s.securityHeadersMiddleware(mux)))
+	handler := s.revisionMiddleware(gziphandler.GzipHandler(s.securityHeadersMiddleware(mux)))
+	if s.ocpp == nil {
+		return handler
+	}
+
+	// EV chargers authenticate themselves and need the raw connection for
+	// the websocket so they skip the middleware
+	root := http.NewServeMux()
+	root.Handle("GET /ocpp/{siteID}/{chargePointID}", s.ocpp)
+	root.Handle("GET /ocpp/{chargePointID}", s.ocpp)
+	root.Handle("/", handler)
+	return root
 }
 
 func (s *Server) getSiteID(r *http.Request) string {
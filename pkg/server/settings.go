@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/raterudder/raterudder/pkg/common"
 	"github.com/raterudder/raterudder/pkg/ess"
 	"github.com/raterudder/raterudder/pkg/log"
 	"github.com/raterudder/raterudder/pkg/types"
@@ -197,6 +198,52 @@ func (s *Server) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, "solar trend ratio max must be at least 1", http.StatusBadRequest)
 		return
 	}
+	if newSettings.EVMaxChargeKW < 0 {
+		writeJSONError(w, "ev max charge rate cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if newSettings.EVChargeHours < 0 || newSettings.EVChargeHours > 24 {
+		writeJSONError(w, "ev charge hours must be between 0 and 24", http.StatusBadRequest)
+		return
+	}
+	if newSettings.VerifyModesDelaySeconds < 0 || newSettings.VerifyModesDelaySeconds > 60 {
+		writeJSONError(w, "verify modes delay must be between 0 and 60 seconds", http.StatusBadRequest)
+		return
+	}
+	if newSettings.BatteryBalanceThresholdSOC < 0 || newSettings.BatteryBalanceThresholdSOC > 100 {
+		writeJSONError(w, "battery balance threshold must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+	if newSettings.BatteryMinChargeTempC < -40 || newSettings.BatteryMinChargeTempC > 40 {
+		writeJSONError(w, "battery min charge temperature must be between -40 and 40", http.StatusBadRequest)
+		return
+	}
+	if newSettings.Latitude < -90 || newSettings.Latitude > 90 || newSettings.Longitude < -180 || newSettings.Longitude > 180 {
+		writeJSONError(w, "invalid latitude or longitude", http.StatusBadRequest)
+		return
+	}
+	if newSettings.GeneratorCostDollarsPerKWH < 0 {
+		writeJSONError(w, "generator cost cannot be negative", http.StatusBadRequest)
+		return
+	}
+	loadIDs := make(map[string]bool, len(newSettings.FlexibleLoads))
+	for _, l := range newSettings.FlexibleLoads {
+		if err := l.Validate(); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if l.Actuator.Type == types.LoadActuatorWebhook {
+			if err := common.ValidatePublicURL(l.Actuator.URL); err != nil {
+				writeJSONError(w, fmt.Sprintf("load %s webhook url: %v", l.ID, err), http.StatusBadRequest)
+				return
+			}
+		}
+		if loadIDs[l.ID] {
+			writeJSONError(w, fmt.Sprintf("duplicate load id %s", l.ID), http.StatusBadRequest)
+			return
+		}
+		loadIDs[l.ID] = true
+	}
 	if newSettings.Release != s.release {
 		writeJSONError(w, "settings release mismatch", http.StatusBadRequest)
 		return
@@ -246,6 +293,72 @@ func (s *Server) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
 				}
 				existingCreds.Franklin = req.Credentials.Franklin
 			}
+		case "powerwall":
+			if req.Credentials.Powerwall != nil {
+				changedESS = true
+				if existingCreds.Powerwall == nil {
+					shouldBackfillHistory = true
+					credentialsActuallyChanged = true
+				} else if req.Credentials.Powerwall.Host != existingCreds.Powerwall.Host || req.Credentials.Powerwall.Password != existingCreds.Powerwall.Password {
+					credentialsActuallyChanged = true
+				}
+				existingCreds.Powerwall = req.Credentials.Powerwall
+			}
+		case "enphase":
+			if req.Credentials.Enphase != nil {
+				changedESS = true
+				if existingCreds.Enphase == nil {
+					shouldBackfillHistory = true
+					credentialsActuallyChanged = true
+				} else if req.Credentials.Enphase.Host != existingCreds.Enphase.Host || req.Credentials.Enphase.Token != existingCreds.Enphase.Token {
+					credentialsActuallyChanged = true
+				}
+				existingCreds.Enphase = req.Credentials.Enphase
+			}
+		case "sunspec":
+			if req.Credentials.SunSpec != nil {
+				changedESS = true
+				if existingCreds.SunSpec == nil {
+					shouldBackfillHistory = true
+					credentialsActuallyChanged = true
+				} else if *req.Credentials.SunSpec != *existingCreds.SunSpec {
+					credentialsActuallyChanged = true
+				}
+				existingCreds.SunSpec = req.Credentials.SunSpec
+			}
+		case "mqtt":
+			if req.Credentials.MQTT != nil {
+				changedESS = true
+				if existingCreds.MQTT == nil {
+					shouldBackfillHistory = true
+					credentialsActuallyChanged = true
+				} else if *req.Credentials.MQTT != *existingCreds.MQTT {
+					credentialsActuallyChanged = true
+				}
+				existingCreds.MQTT = req.Credentials.MQTT
+			}
+		case "homeassistant":
+			if req.Credentials.HomeAssistant != nil {
+				changedESS = true
+				if existingCreds.HomeAssistant == nil {
+					shouldBackfillHistory = true
+					credentialsActuallyChanged = true
+				} else if *req.Credentials.HomeAssistant != *existingCreds.HomeAssistant {
+					credentialsActuallyChanged = true
+				}
+				existingCreds.HomeAssistant = req.Credentials.HomeAssistant
+			}
+		case "aggregate":
+			if req.Credentials.Aggregate != nil {
+				changedESS = true
+				if existingCreds.Aggregate == nil {
+					shouldBackfillHistory = true
+					credentialsActuallyChanged = true
+				} else if *req.Credentials.Aggregate != *existingCreds.Aggregate {
+					credentialsActuallyChanged = true
+				}
+				existingCreds.Aggregate = req.Credentials.Aggregate
+			}
 		case "mock":
 			if req.Credentials.Mock != nil {
 				changedESS = true
@@ -256,6 +369,23 @@ func (s *Server) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// the EV charger isn't part of the ESS so it's updated regardless
+		if req.Credentials.OCPP != nil {
+			if req.Credentials.OCPP.ChargePointID == "" {
+				writeJSONError(w, "charge point ID is required", http.StatusBadRequest)
+				return
+			}
+			// keep the existing password unless a new one was sent
+			if req.Credentials.OCPP.Password == "" && existingCreds.OCPP != nil {
+				req.Credentials.OCPP.Password = existingCreds.OCPP.Password
+			}
+			if req.Credentials.OCPP.Password == "" {
+				writeJSONError(w, "charge point password is required", http.StatusBadRequest)
+				return
+			}
+			existingCreds.OCPP = req.Credentials.OCPP
+		}
+
 		// if the ess credentials changed, we need to verify them and potentially backfill history
 		if changedESS {
 			essSystem, err := s.ess.Site(ctx, siteID, newSettings)
@@ -190,6 +190,93 @@ func TestSettings(t *testing.T) {
 		srv.handleUpdateSettings(w, req)
 		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
 		assert.Contains(t, w.Body.String(), "settings release mismatch")
+
+		// Invalid value (EVChargeHours > 24)
+		s7 := base
+		s7.EVChargeHours = 25
+		b7, _ := json.Marshal(s7)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b7))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "ev charge hours must be between 0 and 24")
+
+		// Invalid flexible load (no actuator)
+		s8 := base
+		s8.FlexibleLoads = []types.FlexibleLoad{{ID: "water", EnergyKWH: 6, PowerKW: 4}}
+		b8, _ := json.Marshal(s8)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b8))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "unknown actuator type")
+
+		// Negative generator cost
+		s9 := base
+		s9.GeneratorCostDollarsPerKWH = -0.1
+		b9, _ := json.Marshal(s9)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b9))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "generator cost cannot be negative")
+
+		// Battery balance threshold out of range
+		s10 := base
+		s10.BatteryBalanceThresholdSOC = 120
+		b10, _ := json.Marshal(s10)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b10))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "battery balance threshold must be between 0 and 100")
+
+		// Battery min charge temperature out of range
+		s11 := base
+		s11.BatteryMinChargeTempC = -50
+		b11, _ := json.Marshal(s11)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b11))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "battery min charge temperature must be between -40 and 40")
+
+		// Location out of range
+		s12 := base
+		s12.Latitude = 91
+		b12, _ := json.Marshal(s12)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b12))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "invalid latitude or longitude")
+
+		// Verify delay too long
+		s13 := base
+		s13.VerifyModesDelaySeconds = 600
+		b13, _ := json.Marshal(s13)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b13))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "verify modes delay must be between 0 and 60 seconds")
+
+		s14 := base
+		s14.FlexibleLoads = []types.FlexibleLoad{{ID: "water", EnergyKWH: 4, PowerKW: 4, Actuator: types.LoadActuator{Type: types.LoadActuatorWebhook, URL: "http://192.168.1.20/water"}}}
+		b14, _ := json.Marshal(s14)
+		req = httptest.NewRequest("POST", "/api/settings", bytes.NewReader(b14))
+		req = withUser(req, "admin@example.com", true)
+		w = httptest.NewRecorder()
+		srv.handleUpdateSettings(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		assert.Contains(t, w.Body.String(), "load water webhook url")
 	})
 
 	t.Run("Update Settings - Success", func(t *testing.T) {
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/controller"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// batteryTempHistory is how much status history is used to predict the
+// battery temperature
+const batteryTempHistory = 72 * time.Hour
+
+// forecastBatteryTemps predicts the battery temperature for the next day from
+// the recent statuses and, if the site's location is set, the weather
+// forecast. Nothing is predicted unless the site enabled cold weather
+// charging. It also returns the temperature at or below which the battery
+// can't charge, raised to where the battery recently stopped charging.
+func (s *Server) forecastBatteryTemps(ctx context.Context, siteID string, status types.SystemStatus, settings types.Settings, now time.Time) ([]types.TempForecast, float64) {
+	if !settings.ColdWeatherCharging || status.BatteryTempC == nil {
+		return nil, settings.BatteryMinChargeTempC
+	}
+
+	history, err := s.storage.GetActionHistory(ctx, siteID, now.Add(-batteryTempHistory), now)
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to get action history for battery temperature", slog.Any("error", err))
+	}
+
+	var weather []types.TempForecast
+	if s.weather != nil && (settings.Latitude != 0 || settings.Longitude != 0) {
+		weather, err = s.weather.ForecastTemps(ctx, settings.Latitude, settings.Longitude, now, now.Add(24*time.Hour))
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to get weather forecast", slog.Any("error", err))
+		}
+	}
+
+	minTempC := controller.MinChargeTemp(settings.BatteryMinChargeTempC, history)
+	if minTempC != settings.BatteryMinChargeTempC {
+		log.Ctx(ctx).InfoContext(
+			ctx,
+			"battery recently stopped charging above the minimum charging temperature",
+			slog.Float64("configuredMinTempC", settings.BatteryMinChargeTempC),
+			slog.Float64("minTempC", minTempC),
+		)
+	}
+	return controller.PredictBatteryTemps(ctx, now, *status.BatteryTempC, history, weather), minTempC
+}
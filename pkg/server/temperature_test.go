@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWeather struct {
+	forecast []types.TempForecast
+	err      error
+	calls    int
+}
+
+func (f *fakeWeather) ForecastTemps(ctx context.Context, latitude, longitude float64, start, end time.Time) ([]types.TempForecast, error) {
+	f.calls++
+	return f.forecast, f.err
+}
+
+func TestForecastBatteryTemps(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 10, 15, 0, 0, 0, time.UTC)
+	tempC := 8.0
+	status := types.SystemStatus{Timestamp: now, BatteryTempC: &tempC}
+	settings := types.Settings{ColdWeatherCharging: true, BatteryMinChargeTempC: 0, Latitude: 41.9, Longitude: -87.6}
+
+	disabledTempC := 2.0
+	history := []types.Action{
+		{
+			Timestamp: now.Add(-12 * time.Hour),
+			SystemStatus: types.SystemStatus{
+				Timestamp:               now.Add(-12 * time.Hour),
+				BatteryTempC:            &disabledTempC,
+				BatteryChargingDisabled: true,
+			},
+		},
+	}
+
+	t.Run("No Temperature", func(t *testing.T) {
+		mockS := &mockStorage{}
+		w := &fakeWeather{}
+		srv := &Server{storage: mockS, weather: w}
+
+		forecast, minTempC := srv.forecastBatteryTemps(ctx, "site1", types.SystemStatus{Timestamp: now}, settings, now)
+		assert.Nil(t, forecast)
+		assert.Equal(t, 0.0, minTempC)
+		assert.Equal(t, 0, w.calls)
+		mockS.AssertNotCalled(t, "GetActionHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		mockS := &mockStorage{}
+		w := &fakeWeather{}
+		srv := &Server{storage: mockS, weather: w}
+
+		s := settings
+		s.ColdWeatherCharging = false
+		forecast, _ := srv.forecastBatteryTemps(ctx, "site1", status, s, now)
+		assert.Nil(t, forecast)
+		assert.Equal(t, 0, w.calls)
+		mockS.AssertNotCalled(t, "GetActionHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("History and Weather", func(t *testing.T) {
+		mockS := &mockStorage{}
+		mockS.On("GetActionHistory", mock.Anything, "site1", now.Add(-72*time.Hour), now).Return(history, nil)
+		var weather []types.TempForecast
+		for i := 0; i < 24; i++ {
+			weather = append(weather, types.TempForecast{TSStart: now.Add(time.Duration(i) * time.Hour), TempC: 3.0 - float64(i)})
+		}
+		w := &fakeWeather{forecast: weather}
+		srv := &Server{storage: mockS, weather: w}
+
+		forecast, minTempC := srv.forecastBatteryTemps(ctx, "site1", status, settings, now)
+		require.Len(t, forecast, 24)
+		assert.Equal(t, 1, w.calls)
+		// the battery has stopped charging at 2C
+		assert.Equal(t, 2.0, minTempC)
+		// the battery is 5C warmer than outside
+		assert.InDelta(t, 8.0, forecast[0].TempC, 0.001)
+		assert.InDelta(t, -2.0, forecast[10].TempC, 0.001)
+		mockS.AssertExpectations(t)
+	})
+
+	t.Run("No Location", func(t *testing.T) {
+		mockS := &mockStorage{}
+		mockS.On("GetActionHistory", mock.Anything, "site1", mock.Anything, mock.Anything).Return([]types.Action{}, nil)
+		w := &fakeWeather{}
+		srv := &Server{storage: mockS, weather: w}
+
+		s := settings
+		s.Latitude, s.Longitude = 0, 0
+		forecast, minTempC := srv.forecastBatteryTemps(ctx, "site1", status, s, now)
+		require.Len(t, forecast, 24)
+		assert.Equal(t, 0, w.calls)
+		assert.Equal(t, 0.0, minTempC)
+		assert.InDelta(t, 8.0, forecast[23].TempC, 0.001)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		// the prediction carries on without the history or the weather
+		mockS := &mockStorage{}
+		mockS.On("GetActionHistory", mock.Anything, "site1", mock.Anything, mock.Anything).Return([]types.Action(nil), errors.New("db down"))
+		w := &fakeWeather{err: errors.New("offline")}
+		srv := &Server{storage: mockS, weather: w}
+
+		forecast, minTempC := srv.forecastBatteryTemps(ctx, "site1", status, settings, now)
+		require.Len(t, forecast, 24)
+		assert.Equal(t, 0.0, minTempC)
+		assert.InDelta(t, 8.0, forecast[12].TempC, 0.001)
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/raterudder/raterudder/pkg/common"
 	"github.com/raterudder/raterudder/pkg/ess"
 	"github.com/raterudder/raterudder/pkg/log"
 	"github.com/raterudder/raterudder/pkg/types"
@@ -106,6 +107,9 @@ func (s *Server) performSiteUpdate(
 	settings settingsWithVersion,
 	creds types.Credentials,
 ) (*types.Action, string, error) {
+	// bound how many upstream requests a single update can make so a
+	// struggling provider can't stall the tick with retries
+	ctx = common.WithRequestBudget(ctx, updateRequestBudget)
 
 	// get ESS System
 	essSystem, err := s.getESSSystem(ctx, siteID, settings, creds)
@@ -138,6 +142,9 @@ func (s *Server) performSiteUpdate(
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to get ess status: %w", err)
 	}
+	// the controller only chooses modes the system supports
+	caps := essSystem.Capabilities()
+	status.Capabilities = &caps
 
 	log.Ctx(ctx).DebugContext(ctx, "update: ess status fetched")
 
@@ -164,8 +171,15 @@ func (s *Server) performSiteUpdate(
 		return &action, "paused", nil
 	}
 
+	// during an outage with a generator the controller records whether the
+	// battery or the generator should power the home even in emergency mode
+	outage := status.GridOutage && settings.GeneratorCostDollarsPerKWH > 0
+	if outage {
+		log.Ctx(ctx).InfoContext(ctx, "update: grid outage", slog.Float64("generatorKW", status.GeneratorKW))
+	}
+
 	// don't update if we're in emergency mode
-	if status.EmergencyMode {
+	if status.EmergencyMode && !outage {
 		log.Ctx(ctx).InfoContext(ctx, "update: emergency mode")
 		action := types.Action{
 			Timestamp:    time.Now(),
@@ -204,23 +218,9 @@ func (s *Server) performSiteUpdate(
 		// Continue with empty future prices
 	}
 
-	nowTime := time.Now()
-	if len(futurePrices) == 0 {
-		log.Ctx(ctx).WarnContext(ctx, "no future prices available, estimating using last 24 hours")
-		histStart := nowTime.Add(-24 * time.Hour)
-		histPrices, histErr := s.storage.GetPriceHistory(ctx, siteID, histStart, nowTime)
-		if histErr != nil {
-			log.Ctx(ctx).WarnContext(ctx, "failed to get historical prices", slog.Any("error", histErr))
-		} else {
-			for _, p := range histPrices {
-				p.TSStart = p.TSStart.Add(24 * time.Hour)
-				if !p.TSEnd.IsZero() {
-					p.TSEnd = p.TSEnd.Add(24 * time.Hour)
-				}
-				futurePrices = append(futurePrices, p)
-			}
-		}
-	}
+	// load deadlines are in the gateway's time zone like in updateLoads
+	nowTime := time.Now().In(status.Timestamp.Location())
+	futurePrices = s.forecastMissingPrices(ctx, siteID, futurePrices, nowTime)
 
 	hasFuture := false
 	for _, fp := range futurePrices {
@@ -241,6 +241,20 @@ func (s *Server) performSiteUpdate(
 		log.Ctx(ctx).WarnContext(ctx, "failed to get energy history from storage", slog.Any("error", err))
 	}
 
+	// the simulation includes the energy the flexible loads still need
+	if len(settings.FlexibleLoads) > 0 {
+		pending, withoutLoads, err := s.pendingLoads(ctx, siteID, settings.FlexibleLoads, energyHistory, nowTime)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to get delivered load energy", slog.Any("error", err))
+		} else {
+			settings.FlexibleLoads = pending
+			energyHistory = withoutLoads
+		}
+	}
+
+	// the battery is charged ahead of the hours it'll be too cold to charge
+	status.BatteryTempForecast, settings.BatteryMinChargeTempC = s.forecastBatteryTemps(ctx, siteID, status, settings.Settings, nowTime)
+
 	log.Ctx(ctx).DebugContext(ctx, "update: starting decision")
 
 	// decide Action
@@ -266,26 +280,76 @@ func (s *Server) performSiteUpdate(
 		slog.Float64("batterySOC", status.BatterySOC),
 	)
 
-	// execute Action
-	switch action.BatteryMode {
-	case types.BatteryModeChargeAny:
-		err = essSystem.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeAny) // Force charge
-	case types.BatteryModeLoad:
-		err = essSystem.SetModes(ctx, types.BatteryModeLoad, types.SolarModeAny) // Use battery
-	case types.BatteryModeStandby:
-		// "self_consumption" is usually safe for idle too (just don't force charge)
-		err = essSystem.SetModes(ctx, types.BatteryModeStandby, types.SolarModeAny)
+	// some systems can't handle frequent changes so hold off until the last
+	// command has had time to apply
+	var rateLimited bool
+	if action.BatteryMode != types.BatteryModeNoChange && caps.MinCommandIntervalSeconds > 0 {
+		interval := time.Duration(caps.MinCommandIntervalSeconds) * time.Second
+		lastCommand, err := s.lastCommandTime(ctx, siteID, time.Now().Add(-interval))
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to get last command time", slog.Any("error", err))
+		} else if !lastCommand.IsZero() {
+			log.Ctx(ctx).InfoContext(ctx, "update: command rate limited", slog.Time("lastCommand", lastCommand), slog.Duration("interval", interval))
+			rateLimited = true
+			action.BatteryMode = types.BatteryModeNoChange
+			action.Description += fmt.Sprintf(" (Delayed: ESS only accepts changes every %s, last at %s)", interval, lastCommand.Format(time.Kitchen))
+		}
+	}
+
+	// systems that can follow a schedule get the whole planned day, starting
+	// with this action, so they keep following the plan if updates stop. A
+	// schedule is a command too so it waits out the rate limit as well.
+	var scheduled bool
+	var schedule []types.ScheduleBlock
+	if scheduler, ok := essSystem.(ess.Scheduler); ok && settings.PushSchedule && caps.Schedule && !rateLimited {
+		schedule = s.controller.PlanSchedule(ctx, status, currentPrice, futurePrices, energyHistory, settings.Settings, action)
+		if err := scheduler.SetSchedule(ctx, schedule); err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to push schedule, setting modes instead", slog.Any("error", err))
+		} else {
+			log.Ctx(ctx).InfoContext(ctx, "update: pushed schedule", slog.Int("blocks", len(schedule)))
+			scheduled = true
+		}
+	}
+
+	// execute Action unless the schedule already includes it
+	if !scheduled {
+		switch action.BatteryMode {
+		case types.BatteryModeChargeAny:
+			err = essSystem.SetModes(ctx, types.BatteryModeChargeAny, types.SolarModeAny) // Force charge
+		case types.BatteryModeLoad:
+			err = essSystem.SetModes(ctx, types.BatteryModeLoad, types.SolarModeAny) // Use battery
+		case types.BatteryModeExport:
+			err = essSystem.SetModes(ctx, types.BatteryModeExport, types.SolarModeAny) // Sell stored energy
+		case types.BatteryModeStandby:
+			// "self_consumption" is usually safe for idle too (just don't force charge)
+			err = essSystem.SetModes(ctx, types.BatteryModeStandby, types.SolarModeAny)
+		}
+		if multi, ok := essSystem.(ess.MultiUnit); ok && action.BatteryMode != types.BatteryModeNoChange {
+			action.UnitResults = multi.UnitResults()
+		}
 	}
 	if err != nil {
 		log.Ctx(ctx).ErrorContext(ctx, "failed to set mode", slog.Any("error", err))
 		action.Description += fmt.Sprintf(" (FAILED: %v)", err)
 		action.Failed = true
 		action.Error = err.Error()
+	} else if settings.VerifyModes && !scheduled && !settings.DryRun && action.BatteryMode != types.BatteryModeNoChange {
+		// some systems accept commands without applying them so check that it did
+		var mismatch string
+		action.Verification, mismatch = s.verifyModes(ctx, essSystem, settings.Settings, action)
+		s.modeVerifications.record(settings.ESS, action.Verification)
+		log.Ctx(ctx).InfoContext(ctx, "update: verified modes", slog.String("ess", settings.ESS), slog.String("verification", string(action.Verification)))
+		if action.Verification == types.ModeVerificationUnverified {
+			action.Description += fmt.Sprintf(" (UNVERIFIED: %s)", mismatch)
+		}
 	}
 	if settings.DryRun {
 		action.DryRun = true
 	}
 
+	s.updateEVCharging(ctx, siteID, settings.Settings, creds, status, currentPrice, futurePrices, energyHistory, schedule, &action)
+	s.updateLoads(ctx, siteID, settings.Settings, creds, status, currentPrice, futurePrices, energyHistory, &action)
+
 	// log Action
 	if err := s.storage.InsertAction(ctx, siteID, action); err != nil {
 		log.Ctx(ctx).ErrorContext(ctx, "failed to insert action", slog.Any("error", err))
@@ -294,6 +358,25 @@ func (s *Server) performSiteUpdate(
 	return &action, "", nil
 }
 
+// lastCommandTime returns when the last mode change since the given time was
+// sent or zero if there wasn't one.
+func (s *Server) lastCommandTime(ctx context.Context, siteID string, since time.Time) (time.Time, error) {
+	actions, err := s.storage.GetActionHistory(ctx, siteID, since, time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	var last time.Time
+	for _, a := range actions {
+		if a.BatteryMode == types.BatteryModeNoChange || a.Failed || a.DryRun || a.Paused {
+			continue
+		}
+		if a.Timestamp.After(last) {
+			last = a.Timestamp
+		}
+	}
+	return last, nil
+}
+
 func (s *Server) updatePriceHistory(ctx context.Context, siteID string, provider utility.Utility) error {
 	lastPriceTime, lastVersion, err := s.storage.GetLatestPriceHistoryTime(ctx, siteID)
 	if err != nil {
@@ -388,3 +471,58 @@ func (s *Server) updateEnergyHistory(ctx context.Context, siteID string, essSyst
 
 	return nil
 }
+
+// updateRequestBudget is the most HTTP requests, including retries, that a
+// site update can send to the ESS and utility.
+const updateRequestBudget = 200
+
+const (
+	// priceForecastHistory is how much price history is used to forecast
+	// prices the utility hasn't published.
+	priceForecastHistory = 28 * 24 * time.Hour
+	// priceForecastHorizon is how far into the future the controller needs
+	// prices for.
+	priceForecastHorizon = 24 * time.Hour
+)
+
+// forecastMissingPrices appends forecasted prices for the hours after the
+// last published future price until the forecast horizon. If the utility
+// didn't publish any future prices then the entire horizon is forecasted.
+func (s *Server) forecastMissingPrices(ctx context.Context, siteID string, futurePrices []types.Price, now time.Time) []types.Price {
+	start := now.Truncate(time.Hour)
+	for _, p := range futurePrices {
+		end := p.TSEnd
+		if end.IsZero() {
+			end = p.TSStart.Add(time.Hour)
+		}
+		if end.After(start) {
+			start = end
+		}
+	}
+	end := now.Add(priceForecastHorizon)
+	if !start.Before(end) {
+		return futurePrices
+	}
+
+	histPrices, err := s.storage.GetPriceHistory(ctx, siteID, now.Add(-priceForecastHistory), now)
+	if err != nil {
+		log.Ctx(ctx).WarnContext(ctx, "failed to get historical prices for forecast", slog.Any("error", err))
+		return futurePrices
+	}
+
+	forecaster := s.priceForecaster
+	if forecaster == nil {
+		forecaster = utility.NewSeasonalForecaster()
+	}
+	forecast := forecaster.ForecastPrices(ctx, histPrices, start, end)
+	if len(forecast) > 0 {
+		log.Ctx(ctx).InfoContext(
+			ctx,
+			"forecasted missing future prices",
+			slog.Int("publishedCount", len(futurePrices)),
+			slog.Int("forecastCount", len(forecast)),
+			slog.Time("forecastStart", start),
+		)
+	}
+	return append(futurePrices, forecast...)
+}
@@ -3,9 +3,11 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -364,6 +366,70 @@ func TestHandleUpdate(t *testing.T) {
 		mockES.AssertNotCalled(t, "SetModes")
 	})
 
+	t.Run("Action - Emergency Mode During Outage", func(t *testing.T) {
+		mockS := &mockStorage{}
+		mockS.On("GetSettings", mock.Anything, mock.Anything).Return(types.Settings{
+			UtilityProvider:            "test",
+			MinBatterySOC:              20,
+			SolarTrendRatioMax:         3,
+			GeneratorCostDollarsPerKWH: 0.05,
+		}, types.CurrentSettingsVersion, nil)
+		mockS.On("GetLatestEnergyHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+		mockS.On("GetLatestPriceHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+		mockS.On("UpsertEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockS.On("UpsertPrice", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockS.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+		mockS.On("GetPriceHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil).Maybe()
+
+		mockES := &mockESS{}
+		mockES.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+		mockES.On("Authenticate", mock.Anything, mock.Anything).Return(types.Credentials{}, false, nil)
+		mockES.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+		mockES.On("GetStatus", mock.Anything).Return(types.SystemStatus{
+			Timestamp:          time.Now(),
+			BatterySOC:         90,
+			BatteryCapacityKWH: 10,
+			EmergencyMode:      true,
+			GridOutage:         true,
+			GeneratorKW:        5,
+		}, nil)
+
+		mockP := ess.NewMap()
+		mockP.SetSystem(types.SiteIDNone, mockES)
+
+		// the generator is cheaper than recharging the battery later
+		mockS.On("InsertAction", mock.Anything, mock.Anything, mock.MatchedBy(func(a types.Action) bool {
+			return a.Reason == types.ActionReasonOutageUseGenerator && a.BatteryMode == types.BatteryModeNoChange && !a.Fault
+		})).Return(nil)
+
+		mockU := &mockUtility{}
+		mockU.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+		mockU.On("GetCurrentPrice", mock.Anything).Return(types.Price{DollarsPerKWH: 0.10, TSStart: time.Now()}, nil)
+		mockU.On("GetFuturePrices", mock.Anything).Return([]types.Price{{DollarsPerKWH: 0.15, TSStart: time.Now().Add(time.Hour)}}, nil)
+		mockU.On("GetConfirmedPrices", mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil)
+
+		mockUMap := utility.NewMap()
+		mockUMap.SetProvider("test", mockU)
+
+		srv := &Server{
+			utilities:  mockUMap,
+			ess:        mockP,
+			storage:    mockS,
+			listenAddr: ":8080",
+			controller: controller.NewController(),
+			bypassAuth: true,
+		}
+
+		req := httptest.NewRequest("GET", "/api/update", nil)
+		req = req.WithContext(context.WithValue(req.Context(), siteIDContextKey, types.SiteIDNone))
+		w := httptest.NewRecorder()
+		srv.handleUpdate(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		mockS.AssertCalled(t, "InsertAction", mock.Anything, mock.Anything, mock.Anything)
+		mockES.AssertNotCalled(t, "SetModes")
+	})
+
 	t.Run("Action - Alarms Present", func(t *testing.T) {
 		mockS := &mockStorage{}
 		mockS.On("GetSettings", mock.Anything, mock.Anything).Return(types.Settings{UtilityProvider: "test"}, types.CurrentSettingsVersion, nil)
@@ -547,6 +613,187 @@ func TestHandleUpdate(t *testing.T) {
 	})
 }
 
+func TestUpdateCommandRateLimit(t *testing.T) {
+	newServer := func(recentActions []types.Action) (*Server, *mockStorage, *mockESS) {
+		mockU := &mockUtility{}
+		mockU.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+		mockU.On("GetCurrentPrice", mock.Anything).Return(types.Price{DollarsPerKWH: 0.15, TSStart: time.Now()}, nil)
+		mockU.On("GetFuturePrices", mock.Anything).Return([]types.Price{{DollarsPerKWH: 0.15, TSStart: time.Now().Add(time.Hour)}}, nil)
+		mockU.On("GetConfirmedPrices", mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil)
+
+		mockS := &mockStorage{}
+		mockS.On("GetSettings", mock.Anything, mock.Anything).Return(types.Settings{
+			MinBatterySOC:   5.0,
+			UtilityProvider: "test",
+		}, types.CurrentSettingsVersion, nil)
+		mockS.On("GetLatestEnergyHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+		mockS.On("GetLatestPriceHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+		mockS.On("UpsertEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockS.On("UpsertPrice", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockS.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+		mockS.On("GetPriceHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil).Maybe()
+		mockS.On("GetActionHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(recentActions, nil)
+		mockS.On("InsertAction", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+		mockES := &mockESS{caps: &types.ESSCapabilities{
+			BatteryModes:              types.AllESSCapabilities().BatteryModes,
+			MinCommandIntervalSeconds: 900,
+		}}
+		mockES.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+		mockES.On("Authenticate", mock.Anything, mock.Anything).Return(types.Credentials{}, false, nil)
+		mockES.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+		// the reserve is elevated so using the battery is a change
+		mockES.On("GetStatus", mock.Anything).Return(types.SystemStatus{
+			BatterySOC:            80,
+			BatteryCapacityKWH:    10,
+			ElevatedMinBatterySOC: true,
+		}, nil)
+		mockES.On("SetModes", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+		mockP := ess.NewMap()
+		mockP.SetSystem(types.SiteIDNone, mockES)
+		mockUMap := utility.NewMap()
+		mockUMap.SetProvider("test", mockU)
+
+		return &Server{
+			utilities:  mockUMap,
+			ess:        mockP,
+			storage:    mockS,
+			controller: controller.NewController(),
+			bypassAuth: true,
+		}, mockS, mockES
+	}
+	update := func(srv *Server) {
+		req := httptest.NewRequest("GET", "/api/update", nil)
+		req = req.WithContext(context.WithValue(req.Context(), siteIDContextKey, types.SiteIDNone))
+		w := httptest.NewRecorder()
+		srv.handleUpdate(w, req)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+
+	t.Run("Recent Command", func(t *testing.T) {
+		srv, mockS, mockES := newServer([]types.Action{
+			{Timestamp: time.Now().Add(-5 * time.Minute), BatteryMode: types.BatteryModeStandby},
+		})
+		update(srv)
+
+		mockES.AssertNotCalled(t, "SetModes", mock.Anything, mock.Anything, mock.Anything)
+		mockS.AssertCalled(t, "InsertAction", mock.Anything, mock.Anything, mock.MatchedBy(func(a types.Action) bool {
+			return a.BatteryMode == types.BatteryModeNoChange &&
+				a.TargetBatteryMode == types.BatteryModeLoad &&
+				strings.Contains(a.Description, "Delayed: ESS only accepts changes every 15m0s")
+		}))
+	})
+
+	t.Run("Failed And Unchanged Actions Ignored", func(t *testing.T) {
+		srv, _, mockES := newServer([]types.Action{
+			{Timestamp: time.Now().Add(-5 * time.Minute), BatteryMode: types.BatteryModeStandby, Failed: true},
+			{Timestamp: time.Now().Add(-1 * time.Minute), BatteryMode: types.BatteryModeNoChange},
+		})
+		update(srv)
+
+		mockES.AssertCalled(t, "SetModes", mock.Anything, types.BatteryModeLoad, types.SolarModeAny)
+	})
+}
+
+func TestUpdatePushSchedule(t *testing.T) {
+	newServer := func(pushSchedule bool, scheduleErr error, recentActions []types.Action) (*Server, *mockSchedulerESS) {
+		mockU := &mockUtility{}
+		mockU.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+		mockU.On("GetCurrentPrice", mock.Anything).Return(types.Price{DollarsPerKWH: 0.15, TSStart: time.Now()}, nil)
+		mockU.On("GetFuturePrices", mock.Anything).Return([]types.Price{{DollarsPerKWH: 0.15, TSStart: time.Now().Add(time.Hour)}}, nil)
+		mockU.On("GetConfirmedPrices", mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil)
+
+		mockS := &mockStorage{}
+		mockS.On("GetSettings", mock.Anything, mock.Anything).Return(types.Settings{
+			MinBatterySOC:   5.0,
+			UtilityProvider: "test",
+			PushSchedule:    pushSchedule,
+		}, types.CurrentSettingsVersion, nil)
+		mockS.On("GetLatestEnergyHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+		mockS.On("GetLatestPriceHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+		mockS.On("UpsertEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockS.On("UpsertPrice", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockS.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+		mockS.On("GetPriceHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil).Maybe()
+		mockS.On("InsertAction", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+		caps := types.AllESSCapabilities()
+		caps.Schedule = true
+		if recentActions != nil {
+			caps.MinCommandIntervalSeconds = 900
+			mockS.On("GetActionHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(recentActions, nil)
+		}
+		mockES := &mockSchedulerESS{mockESS: &mockESS{caps: &caps}}
+		mockES.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+		mockES.On("Authenticate", mock.Anything, mock.Anything).Return(types.Credentials{}, false, nil)
+		mockES.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+		// the reserve is elevated so using the battery is a change
+		mockES.On("GetStatus", mock.Anything).Return(types.SystemStatus{
+			BatterySOC:            80,
+			BatteryCapacityKWH:    10,
+			ElevatedMinBatterySOC: true,
+		}, nil)
+		mockES.On("SetModes", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockES.On("SetSchedule", mock.Anything, mock.Anything).Return(scheduleErr)
+
+		mockP := ess.NewMap()
+		mockP.SetSystem(types.SiteIDNone, mockES)
+		mockUMap := utility.NewMap()
+		mockUMap.SetProvider("test", mockU)
+
+		return &Server{
+			utilities:  mockUMap,
+			ess:        mockP,
+			storage:    mockS,
+			controller: controller.NewController(),
+			bypassAuth: true,
+		}, mockES
+	}
+	update := func(srv *Server) {
+		req := httptest.NewRequest("GET", "/api/update", nil)
+		req = req.WithContext(context.WithValue(req.Context(), siteIDContextKey, types.SiteIDNone))
+		w := httptest.NewRecorder()
+		srv.handleUpdate(w, req)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+
+	t.Run("Pushed", func(t *testing.T) {
+		srv, mockES := newServer(true, nil, nil)
+		update(srv)
+
+		mockES.AssertCalled(t, "SetSchedule", mock.Anything, mock.MatchedBy(func(blocks []types.ScheduleBlock) bool {
+			return len(blocks) > 0 && blocks[0].BatteryMode == types.BatteryModeLoad
+		}))
+		mockES.AssertNotCalled(t, "SetModes", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Push Failed", func(t *testing.T) {
+		srv, mockES := newServer(true, errors.New("no time of use mode"), nil)
+		update(srv)
+
+		mockES.AssertCalled(t, "SetModes", mock.Anything, types.BatteryModeLoad, types.SolarModeAny)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		srv, mockES := newServer(false, nil, nil)
+		update(srv)
+
+		mockES.AssertNotCalled(t, "SetSchedule", mock.Anything, mock.Anything)
+		mockES.AssertCalled(t, "SetModes", mock.Anything, types.BatteryModeLoad, types.SolarModeAny)
+	})
+
+	t.Run("Rate Limited", func(t *testing.T) {
+		srv, mockES := newServer(true, nil, []types.Action{
+			{Timestamp: time.Now().Add(-5 * time.Minute), BatteryMode: types.BatteryModeStandby},
+		})
+		update(srv)
+
+		mockES.AssertNotCalled(t, "SetSchedule", mock.Anything, mock.Anything)
+		mockES.AssertNotCalled(t, "SetModes", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
 func TestHandleUpdateSites(t *testing.T) {
 	mockU := &mockUtility{}
 	mockU.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
@@ -989,3 +1236,115 @@ func TestUpdateEnergyHistory(t *testing.T) {
 		mockS.AssertCalled(t, "GetPriceHistory", mock.Anything, "site1", mock.Anything, mock.Anything)
 	})
 }
+
+func TestForecastMissingPrices(t *testing.T) {
+	now := time.Now().Truncate(time.Hour).Add(30 * time.Minute)
+	var history []types.Price
+	for ts := now.Truncate(time.Hour).Add(-48 * time.Hour); ts.Before(now); ts = ts.Add(time.Hour) {
+		history = append(history, types.Price{TSStart: ts, TSEnd: ts.Add(time.Hour), DollarsPerKWH: 0.10})
+	}
+
+	t.Run("Extends Published Prices", func(t *testing.T) {
+		mockS := &mockStorage{}
+		mockS.On("GetPriceHistory", mock.Anything, "site1", now.Add(-priceForecastHistory), now).Return(history, nil)
+		srv := &Server{storage: mockS}
+
+		published := []types.Price{
+			{TSStart: now.Truncate(time.Hour).Add(time.Hour), TSEnd: now.Truncate(time.Hour).Add(2 * time.Hour), DollarsPerKWH: 0.20},
+			{TSStart: now.Truncate(time.Hour).Add(2 * time.Hour), TSEnd: now.Truncate(time.Hour).Add(3 * time.Hour), DollarsPerKWH: 0.20},
+		}
+		prices := srv.forecastMissingPrices(context.Background(), "site1", published, now)
+		require.Greater(t, len(prices), 2)
+		assert.Equal(t, published, prices[:2])
+		// forecasts pick up where the published prices end
+		assert.True(t, prices[2].TSStart.Equal(published[1].TSEnd))
+		for _, p := range prices[2:] {
+			assert.True(t, p.Estimated)
+			assert.InDelta(t, 0.10, p.DollarsPerKWH, 0.000001)
+		}
+		assert.False(t, prices[len(prices)-1].TSEnd.Before(now.Add(priceForecastHorizon)))
+	})
+
+	t.Run("No Published Prices", func(t *testing.T) {
+		mockS := &mockStorage{}
+		mockS.On("GetPriceHistory", mock.Anything, "site1", mock.Anything, mock.Anything).Return(history, nil)
+		srv := &Server{storage: mockS}
+
+		prices := srv.forecastMissingPrices(context.Background(), "site1", nil, now)
+		require.Len(t, prices, 25)
+		assert.True(t, prices[0].TSStart.Equal(now.Truncate(time.Hour)))
+		assert.True(t, prices[0].Estimated)
+	})
+
+	t.Run("Enough Published Prices", func(t *testing.T) {
+		mockS := &mockStorage{}
+		srv := &Server{storage: mockS}
+
+		published := []types.Price{{TSStart: now.Truncate(time.Hour), TSEnd: now.Add(25 * time.Hour), DollarsPerKWH: 0.20}}
+		prices := srv.forecastMissingPrices(context.Background(), "site1", published, now)
+		assert.Equal(t, published, prices)
+		mockS.AssertNotCalled(t, "GetPriceHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUpdateUnitResults(t *testing.T) {
+	mockU := &mockUtility{}
+	mockU.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+	mockU.On("GetCurrentPrice", mock.Anything).Return(types.Price{DollarsPerKWH: 0.15, TSStart: time.Now()}, nil)
+	mockU.On("GetFuturePrices", mock.Anything).Return([]types.Price{{DollarsPerKWH: 0.15, TSStart: time.Now().Add(time.Hour)}}, nil)
+	mockU.On("GetConfirmedPrices", mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil)
+
+	results := []types.UnitResult{
+		{Name: "House", BatteryMode: types.BatteryModeLoad, SolarMode: types.SolarModeAny},
+		{Name: "Garage", BatteryMode: types.BatteryModeLoad, SolarMode: types.SolarModeAny, Error: "offline"},
+	}
+
+	mockS := &mockStorage{}
+	mockS.On("GetSettings", mock.Anything, mock.Anything).Return(types.Settings{
+		MinBatterySOC:   5.0,
+		UtilityProvider: "test",
+	}, types.CurrentSettingsVersion, nil)
+	mockS.On("GetLatestEnergyHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+	mockS.On("GetLatestPriceHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+	mockS.On("UpsertEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockS.On("UpsertPrice", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockS.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+	mockS.On("GetPriceHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil).Maybe()
+	mockS.On("InsertAction", mock.Anything, mock.Anything, mock.MatchedBy(func(action types.Action) bool {
+		return action.Failed && assert.ObjectsAreEqual(results, action.UnitResults)
+	})).Return(nil)
+
+	mockES := &mockMultiUnitESS{mockESS: &mockESS{}}
+	mockES.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+	mockES.On("Authenticate", mock.Anything, mock.Anything).Return(types.Credentials{}, false, nil)
+	mockES.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+	// the reserve is elevated so using the battery is a change
+	mockES.On("GetStatus", mock.Anything).Return(types.SystemStatus{
+		BatterySOC:            80,
+		BatteryCapacityKWH:    10,
+		ElevatedMinBatterySOC: true,
+	}, nil)
+	mockES.On("SetModes", mock.Anything, types.BatteryModeLoad, types.SolarModeAny).Return(errors.New("Garage: offline"))
+	mockES.On("UnitResults").Return(results)
+
+	mockP := ess.NewMap()
+	mockP.SetSystem(types.SiteIDNone, mockES)
+	mockUMap := utility.NewMap()
+	mockUMap.SetProvider("test", mockU)
+
+	srv := &Server{
+		utilities:  mockUMap,
+		ess:        mockP,
+		storage:    mockS,
+		controller: controller.NewController(),
+		bypassAuth: true,
+	}
+	req := httptest.NewRequest("GET", "/api/update", nil)
+	req = req.WithContext(context.WithValue(req.Context(), siteIDContextKey, types.SiteIDNone))
+	w := httptest.NewRecorder()
+	srv.handleUpdate(w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	mockS.AssertExpectations(t)
+	mockES.AssertCalled(t, "UnitResults")
+}
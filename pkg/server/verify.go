@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/controller"
+	"github.com/raterudder/raterudder/pkg/ess"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// verifyModes waits for the ESS to settle and checks that it's in the battery
+// mode the action sent. If it isn't, the modes are sent once more before giving up
+// unless the ESS limits how often it can be sent commands. It returns the
+// result along with what didn't match.
+func (s *Server) verifyModes(ctx context.Context, essSystem ess.System, settings types.Settings, action types.Action) (types.ModeVerification, string) {
+	delay := time.Duration(settings.VerifyModesDelaySeconds) * time.Second
+
+	for retried := false; ; retried = true {
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return types.ModeVerificationUnverified, ctx.Err().Error()
+			case <-time.After(delay):
+			}
+		}
+
+		status, err := essSystem.GetStatus(ctx)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to get status to verify modes", slog.Any("error", err))
+			return types.ModeVerificationUnverified, fmt.Sprintf("failed to get status: %v", err)
+		}
+		// the update always sends SolarModeAny which leaves solar export up to
+		// the ESS settings so only the battery mode is checked
+		mismatches := controller.ModeMismatches(status, settings, action.TargetBatteryMode, types.SolarModeNoChange)
+		if len(mismatches) == 0 {
+			if retried {
+				return types.ModeVerificationVerifiedAfterRetry, ""
+			}
+			return types.ModeVerificationVerified, ""
+		}
+		mismatch := strings.Join(mismatches, ", ")
+		if retried {
+			return types.ModeVerificationUnverified, mismatch
+		}
+		if essSystem.Capabilities().MinCommandIntervalSeconds > 0 {
+			// another command this soon would be rejected or delay the first
+			log.Ctx(ctx).WarnContext(ctx, "ess didn't apply modes, can't retry this soon", slog.String("mismatch", mismatch))
+			return types.ModeVerificationUnverified, mismatch
+		}
+
+		log.Ctx(ctx).WarnContext(ctx, "ess didn't apply modes, retrying", slog.String("mismatch", mismatch))
+		if err := essSystem.SetModes(ctx, action.BatteryMode, types.SolarModeAny); err != nil {
+			log.Ctx(ctx).ErrorContext(ctx, "failed to retry setting modes", slog.Any("error", err))
+			return types.ModeVerificationUnverified, fmt.Sprintf("%s, retry failed: %v", mismatch, err)
+		}
+	}
+}
+
+// verificationStats counts the mode verification results of each ESS
+// provider since the server started.
+type verificationStats struct {
+	mu     sync.Mutex
+	counts map[string]map[types.ModeVerification]int
+}
+
+func (v *verificationStats) record(essID string, result types.ModeVerification) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]map[types.ModeVerification]int)
+	}
+	if v.counts[essID] == nil {
+		v.counts[essID] = make(map[types.ModeVerification]int)
+	}
+	v.counts[essID][result]++
+}
+
+// essVerificationStats is the verification summary of an ESS provider.
+type essVerificationStats struct {
+	Verified           int `json:"verified"`
+	VerifiedAfterRetry int `json:"verifiedAfterRetry"`
+	Unverified         int `json:"unverified"`
+	// MismatchRate is the fraction of checks where the ESS wasn't in the
+	// modes the first time
+	MismatchRate float64 `json:"mismatchRate"`
+}
+
+func (v *verificationStats) snapshot() map[string]essVerificationStats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	res := make(map[string]essVerificationStats, len(v.counts))
+	for essID, counts := range v.counts {
+		stats := essVerificationStats{
+			Verified:           counts[types.ModeVerificationVerified],
+			VerifiedAfterRetry: counts[types.ModeVerificationVerifiedAfterRetry],
+			Unverified:         counts[types.ModeVerificationUnverified],
+		}
+		total := stats.Verified + stats.VerifiedAfterRetry + stats.Unverified
+		if total > 0 {
+			stats.MismatchRate = float64(stats.VerifiedAfterRetry+stats.Unverified) / float64(total)
+		}
+		res[essID] = stats
+	}
+	return res
+}
+
+// handleVerificationStats returns the mode verification results of each ESS
+// provider.
+func (s *Server) handleVerificationStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := s.getUser(r)
+	if user.ID == "" {
+		writeJSONError(w, "missing authentication", http.StatusUnauthorized)
+		return
+	}
+	if !user.Admin {
+		log.Ctx(ctx).WarnContext(ctx, "unauthorized for verification stats", slog.String("userID", user.ID), slog.String("email", user.Email))
+		writeJSONError(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.modeVerifications.snapshot()); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/controller"
+	"github.com/raterudder/raterudder/pkg/ess"
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/raterudder/raterudder/pkg/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyModes(t *testing.T) {
+	ctx := context.Background()
+	settings := types.Settings{MinBatterySOC: 20}
+	action := types.Action{
+		BatteryMode:       types.BatteryModeStandby,
+		SolarMode:         types.SolarModeNoChange,
+		TargetBatteryMode: types.BatteryModeStandby,
+		TargetSolarMode:   types.SolarModeNoExport,
+	}
+	applied := types.SystemStatus{BatterySOC: 60, ElevatedMinBatterySOC: true, CanExportSolar: true}
+	notApplied := types.SystemStatus{BatterySOC: 60, CanExportSolar: true}
+
+	t.Run("Verified", func(t *testing.T) {
+		mockES := &mockESS{}
+		mockES.On("GetStatus", mock.Anything).Return(applied, nil).Once()
+
+		result, mismatch := (&Server{}).verifyModes(ctx, mockES, settings, action)
+		assert.Equal(t, types.ModeVerificationVerified, result)
+		assert.Empty(t, mismatch)
+		mockES.AssertNotCalled(t, "SetModes", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Verified After Retry", func(t *testing.T) {
+		mockES := &mockESS{}
+		mockES.On("GetStatus", mock.Anything).Return(notApplied, nil).Once()
+		mockES.On("SetModes", mock.Anything, types.BatteryModeStandby, types.SolarModeAny).Return(nil).Once()
+		mockES.On("GetStatus", mock.Anything).Return(applied, nil).Once()
+
+		result, _ := (&Server{}).verifyModes(ctx, mockES, settings, action)
+		assert.Equal(t, types.ModeVerificationVerifiedAfterRetry, result)
+		mockES.AssertExpectations(t)
+	})
+
+	t.Run("Unverified", func(t *testing.T) {
+		mockES := &mockESS{}
+		mockES.On("GetStatus", mock.Anything).Return(notApplied, nil).Twice()
+		mockES.On("SetModes", mock.Anything, types.BatteryModeStandby, types.SolarModeAny).Return(nil).Once()
+
+		result, mismatch := (&Server{}).verifyModes(ctx, mockES, settings, action)
+		assert.Equal(t, types.ModeVerificationUnverified, result)
+		assert.Equal(t, "battery reserve not elevated", mismatch)
+		mockES.AssertExpectations(t)
+	})
+
+	t.Run("Command Interval", func(t *testing.T) {
+		// the ESS won't take another command this soon so there's no retry
+		caps := types.AllESSCapabilities()
+		caps.MinCommandIntervalSeconds = 900
+		mockES := &mockESS{caps: &caps}
+		mockES.On("GetStatus", mock.Anything).Return(notApplied, nil).Once()
+
+		result, mismatch := (&Server{}).verifyModes(ctx, mockES, settings, action)
+		assert.Equal(t, types.ModeVerificationUnverified, result)
+		assert.Equal(t, "battery reserve not elevated", mismatch)
+		mockES.AssertExpectations(t)
+		mockES.AssertNotCalled(t, "SetModes", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Solar Mode Not Sent", func(t *testing.T) {
+		// the decided solar mode isn't what's sent so it isn't checked
+		mockES := &mockESS{}
+		mockES.On("GetStatus", mock.Anything).Return(applied, nil).Once()
+
+		a := action
+		a.SolarMode = types.SolarModeNoExport
+		result, mismatch := (&Server{}).verifyModes(ctx, mockES, settings, a)
+		assert.Equal(t, types.ModeVerificationVerified, result)
+		assert.Empty(t, mismatch)
+		mockES.AssertNotCalled(t, "SetModes", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Status Failed", func(t *testing.T) {
+		mockES := &mockESS{}
+		mockES.On("GetStatus", mock.Anything).Return(types.SystemStatus{}, errors.New("timeout")).Once()
+
+		result, mismatch := (&Server{}).verifyModes(ctx, mockES, settings, action)
+		assert.Equal(t, types.ModeVerificationUnverified, result)
+		assert.Contains(t, mismatch, "timeout")
+	})
+
+	t.Run("Settle Delay", func(t *testing.T) {
+		mockES := &mockESS{}
+		s := settings
+		s.VerifyModesDelaySeconds = 60
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		result, _ := (&Server{}).verifyModes(ctx, mockES, s, action)
+		assert.Equal(t, types.ModeVerificationUnverified, result)
+		mockES.AssertNotCalled(t, "GetStatus", mock.Anything)
+	})
+}
+
+func TestUpdateVerifyModes(t *testing.T) {
+	mockU := &mockUtility{}
+	mockU.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+	mockU.On("GetCurrentPrice", mock.Anything).Return(types.Price{DollarsPerKWH: 0.15, TSStart: time.Now()}, nil)
+	mockU.On("GetFuturePrices", mock.Anything).Return([]types.Price{{DollarsPerKWH: 0.15, TSStart: time.Now().Add(time.Hour)}}, nil)
+	mockU.On("GetConfirmedPrices", mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil)
+
+	mockS := &mockStorage{}
+	mockS.On("GetSettings", mock.Anything, mock.Anything).Return(types.Settings{
+		MinBatterySOC:   5.0,
+		UtilityProvider: "test",
+		ESS:             "franklin",
+		VerifyModes:     true,
+	}, types.CurrentSettingsVersion, nil)
+	mockS.On("GetLatestEnergyHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+	mockS.On("GetLatestPriceHistoryTime", mock.Anything, mock.Anything).Return(time.Time{}, 0, nil)
+	mockS.On("UpsertEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockS.On("UpsertPrice", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockS.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+	mockS.On("GetPriceHistory", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]types.Price{}, nil).Maybe()
+	mockS.On("InsertAction", mock.Anything, mock.Anything, mock.MatchedBy(func(action types.Action) bool {
+		return action.Verification == types.ModeVerificationUnverified && !action.Failed
+	})).Return(nil)
+
+	mockES := &mockESS{}
+	mockES.On("ApplySettings", mock.Anything, mock.Anything).Return(nil)
+	mockES.On("Authenticate", mock.Anything, mock.Anything).Return(types.Credentials{}, false, nil)
+	mockES.On("GetEnergyHistory", mock.Anything, mock.Anything, mock.Anything).Return([]types.EnergyStats{}, nil)
+	// the reserve stays elevated so using the battery never applies
+	mockES.On("GetStatus", mock.Anything).Return(types.SystemStatus{
+		BatterySOC:            80,
+		BatteryCapacityKWH:    10,
+		ElevatedMinBatterySOC: true,
+	}, nil)
+	mockES.On("SetModes", mock.Anything, types.BatteryModeLoad, types.SolarModeAny).Return(nil)
+
+	mockP := ess.NewMap()
+	mockP.SetSystem(types.SiteIDNone, mockES)
+	mockUMap := utility.NewMap()
+	mockUMap.SetProvider("test", mockU)
+
+	srv := &Server{
+		utilities:  mockUMap,
+		ess:        mockP,
+		storage:    mockS,
+		controller: controller.NewController(),
+		bypassAuth: true,
+		singleSite: true,
+	}
+	req := httptest.NewRequest("GET", "/api/update", nil)
+	req = req.WithContext(context.WithValue(req.Context(), siteIDContextKey, types.SiteIDNone))
+	w := httptest.NewRecorder()
+	srv.handleUpdate(w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	// the modes were sent and then retried once
+	mockES.AssertNumberOfCalls(t, "SetModes", 2)
+	mockS.AssertExpectations(t)
+
+	req = httptest.NewRequest("GET", "/api/stats/verification", nil)
+	w = httptest.NewRecorder()
+	srv.setupHandler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var stats map[string]essVerificationStats
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+	assert.Equal(t, map[string]essVerificationStats{
+		"franklin": {Unverified: 1, MismatchRate: 1},
+	}, stats)
+}
+
+func TestVerificationStats(t *testing.T) {
+	var v verificationStats
+	assert.Empty(t, v.snapshot())
+
+	v.record("franklin", types.ModeVerificationVerified)
+	v.record("franklin", types.ModeVerificationVerified)
+	v.record("franklin", types.ModeVerificationVerifiedAfterRetry)
+	v.record("franklin", types.ModeVerificationUnverified)
+	v.record("mqtt", types.ModeVerificationVerified)
+
+	assert.Equal(t, map[string]essVerificationStats{
+		"franklin": {Verified: 2, VerifiedAfterRetry: 1, Unverified: 1, MismatchRate: 0.5},
+		"mqtt":     {Verified: 1},
+	}, v.snapshot())
+}
@@ -231,20 +231,39 @@ func (f *FirestoreProvider) UpsertEnergyHistory(ctx context.Context, siteID stri
 	if stats.TSHourStart.IsZero() {
 		return fmt.Errorf("energy stats missing tsHourStart")
 	}
-	jsonBytes, err := json.Marshal(stats)
-	if err != nil {
-		return fmt.Errorf("failed to marshal energy stats: %w", err)
-	}
 
 	coll, err := f.getCollection(siteID, "energy_history")
 	if err != nil {
 		return err
 	}
-	docID := stats.TSHourStart.UTC().Format(time.RFC3339)
-	_, err = coll.Doc(docID).Set(ctx, map[string]interface{}{
-		"json":      string(jsonBytes),
-		"timestamp": stats.TSHourStart,
-		"version":   version,
+	docRef := coll.Doc(stats.TSHourStart.UTC().Format(time.RFC3339))
+	err = f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// the ESS doesn't know about the meter readings imported from the
+		// utility so keep them when the hour is synced again
+		if !stats.HasMeterData {
+			doc, err := tx.Get(docRef)
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				var existing types.EnergyStats
+				if jsonStr, ok := doc.Data()["json"].(string); ok && json.Unmarshal([]byte(jsonStr), &existing) == nil && existing.HasMeterData {
+					stats.HasMeterData = true
+					stats.MeterGridImportKWH = existing.MeterGridImportKWH
+					stats.MeterGridExportKWH = existing.MeterGridExportKWH
+				}
+			}
+		}
+
+		jsonBytes, err := json.Marshal(stats)
+		if err != nil {
+			return fmt.Errorf("failed to marshal energy stats: %w", err)
+		}
+		return tx.Set(docRef, map[string]interface{}{
+			"json":      string(jsonBytes),
+			"timestamp": stats.TSHourStart,
+			"version":   version,
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upsert energy history: %w", err)
@@ -300,6 +319,82 @@ func (f *FirestoreProvider) GetEnergyHistory(ctx context.Context, siteID string,
 	return allStats, nil
 }
 
+// UpsertBillReconciliation adds or updates a bill reconciliation report in the
+// "bill_reconciliations" collection. The document ID is the RFC3339 timestamp
+// of the bill's period start so re-entering a bill replaces the old report.
+func (f *FirestoreProvider) UpsertBillReconciliation(ctx context.Context, siteID string, report types.BillReconciliation) error {
+	if report.Bill.PeriodStart.IsZero() {
+		return fmt.Errorf("bill reconciliation missing periodStart")
+	}
+	jsonBytes, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bill reconciliation: %w", err)
+	}
+
+	coll, err := f.getCollection(siteID, "bill_reconciliations")
+	if err != nil {
+		return err
+	}
+	docID := report.Bill.PeriodStart.UTC().Format(time.RFC3339)
+	_, err = coll.Doc(docID).Set(ctx, map[string]interface{}{
+		"json":      string(jsonBytes),
+		"timestamp": report.Bill.PeriodStart,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upsert bill reconciliation: %w", err)
+	}
+	return nil
+}
+
+// GetBillReconciliations retrieves bill reconciliation reports for bills whose
+// period starts within the specified time range.
+func (f *FirestoreProvider) GetBillReconciliations(ctx context.Context, siteID string, start, end time.Time) ([]types.BillReconciliation, error) {
+	startDocID := start.UTC().Format(time.RFC3339)
+	endDocID := end.UTC().Format(time.RFC3339)
+
+	coll, err := f.getCollection(siteID, "bill_reconciliations")
+	if err != nil {
+		return nil, err
+	}
+	iter := coll.
+		Where(firestore.DocumentID, ">=", coll.Doc(startDocID)).
+		Where(firestore.DocumentID, "<", coll.Doc(endDocID)).
+		OrderBy(firestore.DocumentID, firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var reports []types.BillReconciliation
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error iterating bill reconciliations: %w", err)
+		}
+
+		val, err := doc.DataAt("json")
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "bill reconciliation doc missing json", slog.String("docID", doc.Ref.ID), slog.String("siteID", siteID), slog.Any("err", err))
+			return nil, fmt.Errorf("bill reconciliation doc %s missing 'json' field: %w", doc.Ref.ID, err)
+		}
+
+		jsonStr, ok := val.(string)
+		if !ok {
+			log.Ctx(ctx).WarnContext(ctx, "bill reconciliation doc json not string", slog.String("docID", doc.Ref.ID), slog.String("siteID", siteID))
+			return nil, fmt.Errorf("bill reconciliation doc %s 'json' field is not string", doc.Ref.ID)
+		}
+
+		var r types.BillReconciliation
+		if err := json.Unmarshal([]byte(jsonStr), &r); err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to unmarshal bill reconciliation", slog.String("docID", doc.Ref.ID), slog.String("siteID", siteID), slog.Any("err", err))
+			return nil, fmt.Errorf("failed to unmarshal bill reconciliation (id=%s): %w", doc.Ref.ID, err)
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
 // GetLatestEnergyHistoryTime retrieves the timestamp of the last stored energy history record.
 func (f *FirestoreProvider) GetLatestEnergyHistoryTime(ctx context.Context, siteID string) (time.Time, int, error) {
 	coll, err := f.getCollection(siteID, "energy_history")
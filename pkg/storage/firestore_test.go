@@ -220,6 +220,61 @@ func TestFirestoreProvider(t *testing.T) {
 			assert.Equal(t, future, latestTime, "latest time should match the future timestamp we just inserted")
 			assert.Equal(t, 0, version, "version should be 0 because we didn't set it explicitly")
 		})
+
+		t.Run("KeepsMeterData", func(t *testing.T) {
+			hour := now.Add(-48 * time.Hour)
+			imported := types.EnergyStats{
+				TSHourStart:        hour,
+				GridImportKWH:      1.0,
+				MeterGridImportKWH: 1.2,
+				MeterGridExportKWH: 0.3,
+				HasMeterData:       true,
+			}
+			require.NoError(t, f.UpsertEnergyHistory(ctx, "test-site", imported, 2))
+
+			// syncing the hour from the ESS again doesn't lose the meter data
+			resynced := types.EnergyStats{
+				TSHourStart:   hour,
+				GridImportKWH: 1.1,
+			}
+			require.NoError(t, f.UpsertEnergyHistory(ctx, "test-site", resynced, 2))
+
+			energyHistory, err := f.GetEnergyHistory(ctx, "test-site", hour, hour.Add(time.Hour))
+			require.NoError(t, err)
+			require.Len(t, energyHistory, 1)
+			assert.Equal(t, 1.1, energyHistory[0].GridImportKWH)
+			assert.True(t, energyHistory[0].HasMeterData)
+			assert.Equal(t, 1.2, energyHistory[0].MeterGridImportKWH)
+			assert.Equal(t, 0.3, energyHistory[0].MeterGridExportKWH)
+		})
+	})
+
+	t.Run("BillReconciliations", func(t *testing.T) {
+		periodStart := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		report := types.BillReconciliation{
+			Bill: types.Bill{
+				PeriodStart:  periodStart,
+				PeriodEnd:    periodStart.AddDate(0, 1, 0),
+				TotalDollars: 100,
+			},
+			EstimatedTotalDollars: 90,
+			DifferenceDollars:     10,
+		}
+		require.NoError(t, f.UpsertBillReconciliation(ctx, "test-site", report))
+
+		// re-entering the same bill replaces the report
+		report.EstimatedTotalDollars = 95
+		report.DifferenceDollars = 5
+		require.NoError(t, f.UpsertBillReconciliation(ctx, "test-site", report))
+
+		reports, err := f.GetBillReconciliations(ctx, "test-site", periodStart.Add(-time.Hour), periodStart.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, 95.0, reports[0].EstimatedTotalDollars)
+		assert.Equal(t, 5.0, reports[0].DifferenceDollars)
+
+		err = f.UpsertBillReconciliation(ctx, "test-site", types.BillReconciliation{})
+		assert.ErrorContains(t, err, "missing periodStart")
 	})
 
 	t.Run("Sites", func(t *testing.T) {
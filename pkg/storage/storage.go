@@ -25,6 +25,8 @@ type Database interface {
 	// UpsertPrice adds or updates a price record.
 	UpsertPrice(ctx context.Context, siteID string, price types.Price, version int) error
 	InsertAction(ctx context.Context, siteID string, action types.Action) error
+	// UpsertEnergyHistory adds or updates an hour of energy stats. Meter data
+	// already stored for the hour is kept unless stats has its own.
 	UpsertEnergyHistory(ctx context.Context, siteID string, stats types.EnergyStats, version int) error
 	UpdateESSMockState(ctx context.Context, siteID string, state types.ESSMockState) error
 	GetESSMockState(ctx context.Context, siteID string) (types.ESSMockState, error)
@@ -36,6 +38,10 @@ type Database interface {
 	GetLatestEnergyHistoryTime(ctx context.Context, siteID string) (time.Time, int, error)
 	GetLatestPriceHistoryTime(ctx context.Context, siteID string) (time.Time, int, error)
 
+	// Billing
+	UpsertBillReconciliation(ctx context.Context, siteID string, report types.BillReconciliation) error
+	GetBillReconciliations(ctx context.Context, siteID string, start, end time.Time) ([]types.BillReconciliation, error)
+
 	// Sites & Users
 	GetSite(ctx context.Context, siteID string) (types.Site, error)
 	ListSites(ctx context.Context) ([]types.Site, error)
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"slices"
+	"time"
+)
+
+// BatteryModules is the state of each battery module at a point in time.
+type BatteryModules struct {
+	Timestamp      time.Time `json:"timestamp"`
+	EachBatterySOC []float64 `json:"eachBatterySOC"`
+	EachBatteryKW  []float64 `json:"eachBatteryKW,omitempty"`
+	// ImbalanceSOC is the spread (in percentage points) between the fullest
+	// and emptiest modules
+	ImbalanceSOC float64 `json:"imbalanceSOC"`
+}
+
+// BatteryImbalance returns the spread (in percentage points) between the
+// fullest and emptiest battery modules or 0 if there's only one module.
+func (s SystemStatus) BatteryImbalance() float64 {
+	if len(s.EachBatterySOC) < 2 {
+		return 0
+	}
+	return slices.Max(s.EachBatterySOC) - slices.Min(s.EachBatterySOC)
+}
+
+// UsableBattery returns the capacity and current energy (in kWh) of the
+// battery as limited by its weakest modules. Modules are assumed to be the
+// same size and in the same unit so they charge and discharge together so discharging stops when
+// the emptiest module reaches the reserve and charging stops when the
+// fullest module is full. Without per-module data it's the whole battery.
+func (s SystemStatus) UsableBattery() (capacityKWH, energyKWH float64) {
+	if len(s.EachBatterySOC) < 2 {
+		return s.BatteryCapacityKWH, s.BatteryCapacityKWH * (s.BatterySOC / 100.0)
+	}
+	capacityKWH = s.BatteryCapacityKWH * (1.0 - s.BatteryImbalance()/100.0)
+	energyKWH = s.BatteryCapacityKWH * (slices.Min(s.EachBatterySOC) / 100.0)
+	return capacityKWH, energyKWH
+}
+
+// Modules returns the per-module state of the status.
+func (s SystemStatus) Modules() BatteryModules {
+	return BatteryModules{
+		Timestamp:      s.Timestamp,
+		EachBatterySOC: s.EachBatterySOC,
+		EachBatteryKW:  s.EachBatteryKW,
+		ImbalanceSOC:   s.BatteryImbalance(),
+	}
+}
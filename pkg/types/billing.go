@@ -0,0 +1,57 @@
+package types
+
+import "time"
+
+const (
+	// BillLineItemEnergy is the description used for the base energy (supply)
+	// cost of the grid imports, excluding any additional fees.
+	BillLineItemEnergy = "Energy"
+	// BillLineItemExportCredit is the description used for the credit earned
+	// from grid exports.
+	BillLineItemExportCredit = "Export Credit"
+)
+
+// BillLineItem is a single line item on a utility bill.
+type BillLineItem struct {
+	Description string  `json:"description"`
+	KWH         float64 `json:"kwh"`
+	Dollars     float64 `json:"dollars"`
+}
+
+// Bill is the utility bill for a single billing period as entered by the user.
+type Bill struct {
+	PeriodStart  time.Time      `json:"periodStart"`
+	PeriodEnd    time.Time      `json:"periodEnd"`
+	TotalKWH     float64        `json:"totalKWH"`
+	TotalDollars float64        `json:"totalDollars"`
+	LineItems    []BillLineItem `json:"lineItems"`
+}
+
+// BillLineItemDiscrepancy compares a single line item on the bill with the
+// estimated line item of the same description.
+type BillLineItemDiscrepancy struct {
+	Description       string  `json:"description"`
+	BillKWH           float64 `json:"billKWH"`
+	EstimatedKWH      float64 `json:"estimatedKWH"`
+	BillDollars       float64 `json:"billDollars"`
+	EstimatedDollars  float64 `json:"estimatedDollars"`
+	DifferenceDollars float64 `json:"differenceDollars"` // Bill - Estimated
+	MissingFromBill   bool    `json:"missingFromBill,omitempty"`
+	MissingEstimate   bool    `json:"missingEstimate,omitempty"`
+}
+
+// BillReconciliation is the report comparing a utility bill with the costs
+// computed by RateRudder over the same billing period.
+type BillReconciliation struct {
+	Timestamp               time.Time                 `json:"timestamp"`
+	Bill                    Bill                      `json:"bill"`
+	EstimatedTotalKWH       float64                   `json:"estimatedTotalKWH"`
+	EstimatedTotalDollars   float64                   `json:"estimatedTotalDollars"`
+	EstimatedLineItems      []BillLineItem            `json:"estimatedLineItems"`
+	Discrepancies           []BillLineItemDiscrepancy `json:"discrepancies"`
+	DifferenceKWH           float64                   `json:"differenceKWH"`     // Bill - Estimated
+	DifferenceDollars       float64                   `json:"differenceDollars"` // Bill - Estimated
+	MissingEnergyHours      int                       `json:"missingEnergyHours"`
+	MissingPriceHours       int                       `json:"missingPriceHours"`
+	UsedMeterDataPercentage float64                   `json:"usedMeterDataPercentage"` // 0-100
+}
@@ -8,6 +8,72 @@ type ESSProviderInfo struct {
 	Name        string          `json:"name"`
 	Credentials []ESSCredential `json:"credentials"`
 	Hidden      bool            `json:"hidden,omitempty"`
+	// Capabilities are what the provider supports in general, individual
+	// systems may support less depending on their configuration.
+	Capabilities ESSCapabilities `json:"capabilities"`
+}
+
+// ESSCapabilities describes which commands an ESS supports so the controller
+// only chooses actions the hardware can carry out.
+type ESSCapabilities struct {
+	BatteryModes []BatteryMode `json:"batteryModes"`
+	SolarModes   []SolarMode   `json:"solarModes"`
+	// GridCharging is true if the battery can be charged from the grid
+	GridCharging bool `json:"gridCharging"`
+	// BatteryExport is true if the battery can discharge to the grid
+	BatteryExport bool `json:"batteryExport"`
+	// MinCommandIntervalSeconds is the minimum time between commands that
+	// change the modes, 0 if there's no limit.
+	MinCommandIntervalSeconds int `json:"minCommandIntervalSeconds,omitempty"`
+	// Schedule is true if the ESS can follow a pushed schedule on its own
+	Schedule bool `json:"schedule,omitempty"`
+}
+
+// ScheduleBlock is a period of a schedule pushed to an ESS.
+type ScheduleBlock struct {
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"`
+	BatteryMode BatteryMode `json:"batteryMode"`
+	SolarMode   SolarMode   `json:"solarMode"`
+}
+
+// AllESSCapabilities returns capabilities supporting every mode without any
+// command limits.
+func AllESSCapabilities() ESSCapabilities {
+	return ESSCapabilities{
+		BatteryModes:  []BatteryMode{BatteryModeChargeAny, BatteryModeChargeSolar, BatteryModeStandby, BatteryModeLoad, BatteryModeExport},
+		SolarModes:    []SolarMode{SolarModeNoExport, SolarModeAny},
+		GridCharging:  true,
+		BatteryExport: true,
+	}
+}
+
+// SupportsBatteryMode returns true if the battery mode is supported. A nil
+// ESSCapabilities supports everything.
+func (c *ESSCapabilities) SupportsBatteryMode(mode BatteryMode) bool {
+	if c == nil || mode == BatteryModeNoChange {
+		return true
+	}
+	for _, m := range c.BatteryModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsSolarMode returns true if the solar mode is supported. A nil
+// ESSCapabilities supports everything.
+func (c *ESSCapabilities) SupportsSolarMode(mode SolarMode) bool {
+	if c == nil || mode == SolarModeNoChange {
+		return true
+	}
+	for _, m := range c.SolarModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
 }
 
 // ESSCredential defines a single configuration/credential option for an ESS.
@@ -27,3 +93,11 @@ type ESSMockState struct {
 	SolarMode    SolarMode              `json:"solarMode"`
 	DailyHistory map[string]EnergyStats `json:"dailyHistory"`
 }
+
+// EVChargeBlock is a period of an EV charging plan and the most the charger
+// can draw during it.
+type EVChargeBlock struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	LimitKW float64   `json:"limitKW"`
+}
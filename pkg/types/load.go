@@ -0,0 +1,121 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Actuator types for flexible loads
+const (
+	// LoadActuatorWebhook POSTs the load's state as JSON to a URL
+	LoadActuatorWebhook = "webhook"
+	// LoadActuatorMQTT publishes the load's state to a topic using the
+	// site's MQTT credentials
+	LoadActuatorMQTT = "mqtt"
+	// LoadActuatorHomeAssistant turns an entity on and off using the site's
+	// Home Assistant credentials
+	LoadActuatorHomeAssistant = "homeassistant"
+)
+
+// FlexibleLoad is a deferrable load, like a water heater or pool pump, that
+// needs EnergyKWH delivered at PowerKW every day before DeadlineHour.
+type FlexibleLoad struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	EnergyKWH float64 `json:"energyKWH"`
+	PowerKW   float64 `json:"powerKW"`
+	// DeadlineHour is the hour of the day (0-23) the energy must be delivered
+	// by
+	DeadlineHour int          `json:"deadlineHour"`
+	Actuator     LoadActuator `json:"actuator"`
+}
+
+// Validate returns an error if the load can't be scheduled or driven.
+func (l FlexibleLoad) Validate() error {
+	if l.ID == "" {
+		return errors.New("load id is required")
+	}
+	if l.EnergyKWH <= 0 {
+		return fmt.Errorf("load %s energy must be positive", l.ID)
+	}
+	if l.PowerKW <= 0 {
+		return fmt.Errorf("load %s power must be positive", l.ID)
+	}
+	if l.DeadlineHour < 0 || l.DeadlineHour > 23 {
+		return fmt.Errorf("load %s deadline hour must be between 0 and 23", l.ID)
+	}
+	switch l.Actuator.Type {
+	case LoadActuatorWebhook:
+		if l.Actuator.URL == "" {
+			return fmt.Errorf("load %s webhook url is required", l.ID)
+		}
+	case LoadActuatorMQTT:
+		if l.Actuator.Topic == "" {
+			return fmt.Errorf("load %s mqtt topic is required", l.ID)
+		}
+	case LoadActuatorHomeAssistant:
+		if l.Actuator.EntityID == "" {
+			return fmt.Errorf("load %s home assistant entity is required", l.ID)
+		}
+	default:
+		return fmt.Errorf("load %s has unknown actuator type %q", l.ID, l.Actuator.Type)
+	}
+	return nil
+}
+
+// NextDeadline returns the first deadline after t in t's location.
+func (l FlexibleLoad) NextDeadline(t time.Time) time.Time {
+	deadline := time.Date(t.Year(), t.Month(), t.Day(), l.DeadlineHour, 0, 0, 0, t.Location())
+	if !deadline.After(t) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
+	return deadline
+}
+
+// LoadActuator is how a flexible load is switched on and off. Only the field
+// for the type is used.
+type LoadActuator struct {
+	Type string `json:"type"`
+	// URL is POSTed {"id": ..., "on": ..., "timestamp": ...} and must be a
+	// public https URL
+	URL string `json:"url,omitempty"`
+	// Topic is published the same payload as a webhook, retained
+	Topic string `json:"topic,omitempty"`
+	// EntityID is turned on and off with homeassistant.turn_on/turn_off
+	EntityID string `json:"entityID,omitempty"`
+}
+
+// LoadRun is a period a flexible load is scheduled to run.
+type LoadRun struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	EnergyKWH float64   `json:"energyKWH"`
+}
+
+// LoadSchedule is when a flexible load will run before its next deadline.
+type LoadSchedule struct {
+	LoadID   string    `json:"loadID"`
+	Name     string    `json:"name"`
+	Deadline time.Time `json:"deadline"`
+	Runs     []LoadRun `json:"runs"`
+	// UnmetKWH is the energy that couldn't be scheduled before the deadline
+	UnmetKWH float64 `json:"unmetKWH,omitempty"`
+}
+
+// RunningAt returns whether the load is scheduled to run at t.
+func (s LoadSchedule) RunningAt(t time.Time) bool {
+	for _, r := range s.Runs {
+		if !t.Before(r.Start) && t.Before(r.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadResult is the state a flexible load was switched to during an update.
+type LoadResult struct {
+	ID    string `json:"id"`
+	On    bool   `json:"on"`
+	Error string `json:"error,omitempty"`
+}
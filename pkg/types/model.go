@@ -3,7 +3,7 @@ package types
 import "time"
 
 const (
-	CurrentEnergyStatsVersion  = 1
+	CurrentEnergyStatsVersion  = 2
 	CurrentPriceHistoryVersion = 1
 
 	SiteIDNone = "none"
@@ -54,6 +54,11 @@ const (
 	ActionReasonWaitingToCharge            ActionReason = "waitingToCharge"
 	ActionReasonChargeSurvivePeak          ActionReason = "chargeSurvivePeak"
 	ActionReasonPreventSolarCurtailment    ActionReason = "preventSolarCurtailment"
+	ActionReasonArbitrageExportNow         ActionReason = "arbitrageExport"
+	ActionReasonOutageUseBattery           ActionReason = "outageUseBattery"
+	ActionReasonOutageUseGenerator         ActionReason = "outageUseGenerator"
+	ActionReasonBalanceCharge              ActionReason = "balanceCharge"
+	ActionReasonColdChargeNow              ActionReason = "coldCharge"
 )
 
 // Action represents a control decision made by the system.
@@ -75,6 +80,35 @@ type Action struct {
 	Failed            bool         `json:"failed,omitempty"`
 	Paused            bool         `json:"paused,omitempty"`
 	Error             string       `json:"error,omitempty"`
+	// UnitResults are the results of each unit when the ESS is made up of
+	// several systems
+	UnitResults []UnitResult `json:"unitResults,omitempty"`
+	// Verification is whether the ESS was confirmed to be in the modes after
+	// they were sent, empty if it wasn't checked
+	Verification ModeVerification `json:"verification,omitempty"`
+	// EVChargeLimitKW is the limit the EV charger was told to charge at now,
+	// nil if there's no charger being controlled
+	EVChargeLimitKW *float64 `json:"evChargeLimitKW,omitempty"`
+	// Loads are the states the flexible loads were switched to
+	Loads []LoadResult `json:"loads,omitempty"`
+}
+
+// ModeVerification is the result of checking that an ESS applied the modes it
+// was sent.
+type ModeVerification string
+
+const (
+	ModeVerificationVerified           ModeVerification = "verified"
+	ModeVerificationVerifiedAfterRetry ModeVerification = "verifiedAfterRetry"
+	ModeVerificationUnverified         ModeVerification = "unverified"
+)
+
+// UnitResult is the result of sending modes to one unit of an ESS.
+type UnitResult struct {
+	Name        string      `json:"name"`
+	BatteryMode BatteryMode `json:"batteryMode"`
+	SolarMode   SolarMode   `json:"solarMode"`
+	Error       string      `json:"error,omitempty"`
 }
 
 // EnergyStats represents aggregated energy statistics for an hourly period.
@@ -100,6 +134,22 @@ type EnergyStats struct {
 	SolarToGridKWH    float64 `json:"solarToGridKWH"`
 	BatteryToGridKWH  float64 `json:"batteryToGridKWH"`
 
+	// Standby generator output, only reported by systems with a generator
+	// input. GeneratorToHomeKWH is included in HomeKWH and
+	// GeneratorToBatteryKWH in BatteryChargedKWH.
+	GeneratorKWH          float64 `json:"generatorKWH,omitempty"`
+	GeneratorToHomeKWH    float64 `json:"generatorToHomeKWH,omitempty"`
+	GeneratorToBatteryKWH float64 `json:"generatorToBatteryKWH,omitempty"`
+	// How long the generator was running during the hour
+	GeneratorHours float64 `json:"generatorHours,omitempty"`
+
+	// Revenue meter readings imported from the utility (e.g. Green Button).
+	// These take precedence over the ESS-reported grid values when
+	// HasMeterData is true.
+	MeterGridImportKWH float64 `json:"meterGridImportKWH,omitempty"`
+	MeterGridExportKWH float64 `json:"meterGridExportKWH,omitempty"`
+	HasMeterData       bool    `json:"hasMeterData,omitempty"`
+
 	// Miscellaneous
 	Alarms []SystemAlarm `json:"alarms,omitempty"`
 }
@@ -119,6 +169,12 @@ type Storm struct {
 	TSEnd       time.Time `json:"tsEnd"`
 }
 
+// TempForecast is the forecasted temperature for an hour.
+type TempForecast struct {
+	TSStart time.Time `json:"tsStart"`
+	TempC   float64   `json:"tempC"`
+}
+
 // SystemStatus represents the current system status.
 type SystemStatus struct {
 	Timestamp               time.Time     `json:"timestamp"`
@@ -129,18 +185,30 @@ type SystemStatus struct {
 	BatteryCapacityKWH      float64       `json:"batteryCapacityKWH"`    // Total capacity of the battery (kWh)
 	MaxBatteryChargeKW      float64       `json:"maxBatteryChargeKW"`    // Maximum charge rate of the battery (kW)
 	MaxBatteryDischargeKW   float64       `json:"maxBatteryDischargeKW"` // Maximum discharge rate of the battery (kW)
+	MaxGridImportKW         float64       `json:"maxGridImportKW"`       // Grid import limit (kW), 0 if unlimited
+	MaxGridExportKW         float64       `json:"maxGridExportKW"`       // Grid export limit (kW), 0 if unlimited
 	SolarKW                 float64       `json:"solarKW"`               // Solar generation (kW)
 	GridKW                  float64       `json:"gridKW"`                // Grid import/export (kW, + import, - export)
 	HomeKW                  float64       `json:"homeKW"`                // Home consumption (kW)
+	GeneratorKW             float64       `json:"generatorKW,omitempty"` // Standby generator output (kW)
+	GridOutage              bool          `json:"gridOutage,omitempty"`  // True if the grid is down and the system is off-grid
 	CanExportSolar          bool          `json:"canExportSolar"`        // True if solar exporting is enabled
 	CanExportBattery        bool          `json:"canExportBattery"`      // True if battery exporting is enabled
 	CanImportBattery        bool          `json:"canImportBattery"`      // True if battery importing is enabled
 	ElevatedMinBatterySOC   bool          `json:"elevatedMinBatterySOC"` // True if the minimum SOC is elevated to force standby
 	BatteryAboveMinSOC      bool          `json:"batteryAboveMinSOC"`    // True if the battery SOC is above the minimum SOC
 	EmergencyMode           bool          `json:"emergencyMode"`
-	BatteryChargingDisabled bool          `json:"batteryChargingDisabled"` // True if battery charging is disabled due to alarms
+	BatteryChargingDisabled bool          `json:"batteryChargingDisabled"`     // True if battery charging is disabled due to alarms
+	BatteryTempC            *float64      `json:"batteryTempC,omitempty"`      // Temperature of the coldest battery (°C), nil if unknown
+	ExportLimitLifted       bool          `json:"exportLimitLifted,omitempty"` // True if the grid export limit was lifted to export the battery and still needs to be put back
 	Alarms                  []SystemAlarm `json:"alarms"`
 	Storms                  []Storm       `json:"storms"`
+	// BatteryTempForecast is the predicted battery temperature for the coming
+	// hours, used to plan around hours the battery is too cold to charge
+	BatteryTempForecast []TempForecast `json:"batteryTempForecast,omitempty"`
+	// Capabilities are the ESS's capabilities, nil if unknown in which case
+	// every mode is assumed to be supported
+	Capabilities *ESSCapabilities `json:"capabilities,omitempty"`
 }
 
 // BatteryMode represents the mode of the battery.
@@ -152,6 +220,9 @@ const (
 	BatteryModeChargeAny   BatteryMode = 2
 	BatteryModeChargeSolar BatteryMode = 3
 	BatteryModeLoad        BatteryMode = -1
+	// BatteryModeExport discharges the battery as fast as possible and sends
+	// whatever the home doesn't use to the grid.
+	BatteryModeExport BatteryMode = -2
 )
 
 // SolarMode represents the mode of the solar panels.
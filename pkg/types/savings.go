@@ -28,5 +28,8 @@ type SavingsStats struct {
 	GridExported    float64                       `json:"gridExported"`   // Total grid exported
 	HomeUsed        float64                       `json:"homeUsed"`       // Total home usage
 	BatteryUsed     float64                       `json:"batteryUsed"`    // Total battery discharged
+	GeneratorUsed   float64                       `json:"generatorUsed"`  // Total standby generator output
+	GeneratorHours  float64                       `json:"generatorHours"` // Total standby generator runtime
+	GeneratorCost   float64                       `json:"generatorCost"`  // Fuel cost of the generator output
 	HourlyDebugging []HourlySavingsStatsDebugging `json:"hourlyDebugging"`
 }
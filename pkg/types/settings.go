@@ -43,6 +43,20 @@ type Settings struct {
 
 	// The minimum battery SOC should be charged to at all times.
 	MinBatterySOC float64 `json:"minBatterySOC"`
+	// Fully charge the battery in the next cheap window when the SOC of the
+	// fullest and emptiest battery modules differ by more than this many
+	// percentage points so they can balance. 0 disables balancing.
+	BatteryBalanceThresholdSOC float64 `json:"batteryBalanceThresholdSOC"`
+	// Predict the battery temperature, if the ESS reports it, and charge the
+	// battery ahead of the hours it's predicted to be too cold to charge.
+	ColdWeatherCharging bool `json:"coldWeatherCharging"`
+	// Battery charging is disabled at or below this temperature (in °C).
+	BatteryMinChargeTempC float64 `json:"batteryMinChargeTempC"`
+
+	// Location of the site, used to get the weather forecast. Both 0 disables
+	// the weather forecast.
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 
 	// Grid Settings
 	// Maximum Grid Use (in kW) (not supported yet since we don't change limits)
@@ -56,6 +70,32 @@ type Settings struct {
 	// Can export batteries to grid
 	GridExportBatteries bool `json:"gridExportBatteries"`
 
+	// Push the planned day to the ESS as its own schedule so it keeps
+	// following the plan if updates stop. Only used if the ESS supports it.
+	PushSchedule bool `json:"pushSchedule"`
+
+	// Re-read the status after sending modes to make sure the ESS applied
+	// them, waiting VerifyModesDelaySeconds (at most 60) for it to settle
+	// first.
+	VerifyModes             bool `json:"verifyModes"`
+	VerifyModesDelaySeconds int  `json:"verifyModesDelaySeconds"`
+
+	// EV Charger Settings
+	// Maximum rate (in kW) an OCPP charger is allowed to charge at. 0
+	// disables controlling the charger.
+	EVMaxChargeKW float64 `json:"evMaxChargeKW"`
+	// How many of the cheapest hours in the next day the EV should charge
+	EVChargeHours int `json:"evChargeHours"`
+
+	// Generator Settings
+	// Fuel cost (in $/kWh) of running the standby generator. During an outage
+	// the battery is saved and the home runs on the generator if it's cheaper
+	// than replacing the battery's energy later. 0 means there's no generator.
+	GeneratorCostDollarsPerKWH float64 `json:"generatorCostDollarsPerKWH"`
+
+	// Deferrable loads to run in the cheapest hours before their deadlines
+	FlexibleLoads []FlexibleLoad `json:"flexibleLoads,omitempty"`
+
 	// Solar Settings
 	// Maximum ratio for solar trend adjustment (caps recentSolar/modelSolar).
 	// Higher values allow more aggressive upward solar predictions.
@@ -85,8 +125,15 @@ type ESSAuthStatus struct {
 
 // Credentials for external systems
 type Credentials struct {
-	Franklin *FranklinCredentials `json:"franklin,omitempty"`
-	Mock     *MockCredentials     `json:"mock,omitempty"`
+	Franklin      *FranklinCredentials      `json:"franklin,omitempty"`
+	Mock          *MockCredentials          `json:"mock,omitempty"`
+	Powerwall     *PowerwallCredentials     `json:"powerwall,omitempty"`
+	Enphase       *EnphaseCredentials       `json:"enphase,omitempty"`
+	SunSpec       *SunSpecCredentials       `json:"sunspec,omitempty"`
+	MQTT          *MQTTCredentials          `json:"mqtt,omitempty"`
+	HomeAssistant *HomeAssistantCredentials `json:"homeassistant,omitempty"`
+	Aggregate     *AggregateCredentials     `json:"aggregate,omitempty"`
+	OCPP          *OCPPCredentials          `json:"ocpp,omitempty"`
 	// when a new field is added we need to make sure that handleGetSettings and
 	// handleUpdateSettings are updated to handle the new field
 }
@@ -94,15 +141,34 @@ type Credentials struct {
 // Has returns a map of credentials that are set
 func (c *Credentials) Has() map[string]bool {
 	return map[string]bool{
-		"franklin": c.Franklin != nil,
-		"mock":     c.Mock != nil,
+		"franklin":      c.Franklin != nil,
+		"mock":          c.Mock != nil,
+		"powerwall":     c.Powerwall != nil,
+		"enphase":       c.Enphase != nil,
+		"sunspec":       c.SunSpec != nil,
+		"mqtt":          c.MQTT != nil,
+		"homeassistant": c.HomeAssistant != nil,
+		"aggregate":     c.Aggregate != nil,
+		"ocpp":          c.OCPP != nil,
 	}
 }
 
-// MockCredentials for simulated ESS
+// MockCredentials for simulated ESS. Everything except the strategy is
+// optional and defaults to a 10 kWh battery with 5 kW limits.
 type MockCredentials struct {
-	Strategy string `json:"strategy"`
-	Location string `json:"location"`
+	Strategy            string `json:"strategy"`
+	Location            string `json:"location"`
+	CapacityKWH         string `json:"capacityKWH,omitempty"`
+	MaxChargeKW         string `json:"maxChargeKW,omitempty"`
+	MaxDischargeKW      string `json:"maxDischargeKW,omitempty"`
+	RoundTripEfficiency string `json:"roundTripEfficiency,omitempty"`
+	SolarPeakKW         string `json:"solarPeakKW,omitempty"`
+	HomeLoadKW          string `json:"homeLoadKW,omitempty"`
+	// Profile is the hourly CSV used by the profile strategy, see ess.MockESS
+	Profile          string `json:"profile,omitempty"`
+	Seed             string `json:"seed,omitempty"`
+	NoisePercent     string `json:"noisePercent,omitempty"`
+	CloudyDayPercent string `json:"cloudyDayPercent,omitempty"`
 }
 
 // Credentials for Franklin
@@ -115,6 +181,100 @@ type FranklinCredentials struct {
 	// the other credentials so we can skip login on every update cycle and only
 	// re-login when the token has expired (backend returns 401).
 	Token string `json:"token,omitempty"`
+	// ExportGridFeedMax is the user's grid feed limit from before it was
+	// lifted to export the battery so it can be put back after a restart.
+	ExportGridFeedMax *float64 `json:"exportGridFeedMax,omitempty"`
+}
+
+// Credentials for a Tesla Powerwall gateway on the local network
+type PowerwallCredentials struct {
+	Host     string `json:"host"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password"`
+	// Token is the cached gateway session token so we can skip login on every
+	// update cycle.
+	Token string `json:"token,omitempty"`
+}
+
+// Credentials for an Enphase IQ Gateway (Envoy) on the local network
+type EnphaseCredentials struct {
+	Host string `json:"host"`
+	// Token is the owner token issued by Enphase for the gateway. The gateway
+	// only accepts tokens so there's no password to log in with.
+	Token string `json:"token"`
+}
+
+// Credentials for a SunSpec compatible inverter reachable over Modbus TCP.
+// Modbus has no authentication so these are only the connection details.
+type SunSpecCredentials struct {
+	// Host is the inverter's address with an optional port
+	Host        string `json:"host"`
+	UnitID      string `json:"unitID,omitempty"`
+	CapacityKWH string `json:"capacityKWH,omitempty"`
+	Location    string `json:"location,omitempty"`
+}
+
+// Credentials for an MQTT broker used to bridge to a battery we don't
+// natively support
+type MQTTCredentials struct {
+	Broker   string `json:"broker"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Mapping is the JSON topic and payload mapping, see ess.MQTTBridge
+	Mapping  string `json:"mapping"`
+	Location string `json:"location,omitempty"`
+}
+
+// Credentials for a Home Assistant instance that has the battery integrated.
+// The entities and services are declared per field since every integration
+// exposes the battery differently.
+type HomeAssistantCredentials struct {
+	URL         string `json:"url"`
+	Token       string `json:"token"`
+	CapacityKWH string `json:"capacityKWH"`
+	MaxPowerKW  string `json:"maxPowerKW,omitempty"`
+
+	// Entities are sensor entity IDs, prefixed with - to negate the value
+	SOCEntity          string `json:"socEntity"`
+	BatteryPowerEntity string `json:"batteryPowerEntity"`
+	GridPowerEntity    string `json:"gridPowerEntity"`
+	SolarPowerEntity   string `json:"solarPowerEntity,omitempty"`
+	HomePowerEntity    string `json:"homePowerEntity,omitempty"`
+
+	HomeEnergyEntity              string `json:"homeEnergyEntity,omitempty"`
+	SolarEnergyEntity             string `json:"solarEnergyEntity,omitempty"`
+	GridImportEnergyEntity        string `json:"gridImportEnergyEntity,omitempty"`
+	GridExportEnergyEntity        string `json:"gridExportEnergyEntity,omitempty"`
+	BatteryChargedEnergyEntity    string `json:"batteryChargedEnergyEntity,omitempty"`
+	BatteryDischargedEnergyEntity string `json:"batteryDischargedEnergyEntity,omitempty"`
+
+	// Services are JSON service calls, see ess.HomeAssistant
+	ChargeAnyService     string `json:"chargeAnyService"`
+	ChargeSolarService   string `json:"chargeSolarService,omitempty"`
+	StandbyService       string `json:"standbyService"`
+	LoadService          string `json:"loadService"`
+	SolarExportService   string `json:"solarExportService,omitempty"`
+	SolarNoExportService string `json:"solarNoExportService,omitempty"`
+}
+
+// Credentials for several systems that are controlled together as one
+type AggregateCredentials struct {
+	// Units is the JSON list of AggregateUnit
+	Units string `json:"units"`
+}
+
+// AggregateUnit is one of the systems in an aggregate
+type AggregateUnit struct {
+	Name        string      `json:"name"`
+	ESS         string      `json:"ess"`
+	Credentials Credentials `json:"credentials"`
+}
+
+// OCPPCredentials are what an EV charger uses to connect to the server's
+// OCPP central system using HTTP basic auth.
+type OCPPCredentials struct {
+	ChargePointID string `json:"chargePointID"`
+	Password      string `json:"password,omitempty"`
 }
 
 // MigrateSettings migrates the settings to the current version.
@@ -58,9 +58,46 @@ type Price struct {
 	// the base price when using the grid.
 	GridUseDollarsPerKWH float64 `json:"gridUseDollarsPerKWH"`
 
+	// Estimated is true when the price was forecasted from price history rather
+	// than published by the utility.
+	Estimated bool `json:"estimated,omitempty"`
+
+	// Projected is true when DollarsPerKWH is a projection of the full
+	// interval's price because only part of the interval has been observed.
+	Projected bool `json:"projected,omitempty"`
+
+	// ObservedDollarsPerKWH is the base cost of electricity averaged over only
+	// the observed part of the interval. It's only set when Projected is true.
+	ObservedDollarsPerKWH float64 `json:"observedDollarsPerKWH,omitempty"`
+
+	// Confidence is how confident (0-1) we are that a projected DollarsPerKWH
+	// matches the final price. It's only set when Projected is true.
+	Confidence float64 `json:"confidence,omitempty"`
+
+	// Fees are the additional fees that were included in DollarsPerKWH and
+	// GridUseDollarsPerKWH. Prices stored before fees were recorded don't
+	// have them.
+	Fees []PriceFee `json:"fees,omitempty"`
+
 	SampleCount int `json:"-"`
 }
 
+// PriceFee is an additional fee included in a price.
+type PriceFee struct {
+	Description    string  `json:"description"`
+	DollarsPerKWH  float64 `json:"dollarsPerKWH"`
+	GridAdditional bool    `json:"gridAdditional,omitempty"`
+}
+
+// PriceConfidence returns how confident (0-1) we are in DollarsPerKWH. Prices
+// that aren't projections are always fully confident.
+func (p Price) PriceConfidence() float64 {
+	if !p.Projected {
+		return 1
+	}
+	return p.Confidence
+}
+
 // UtilityRateOptions represents the options for the utility rate.
 type UtilityRateOptions struct {
 	RateClass            string `json:"rateClass"`
@@ -68,12 +105,18 @@ type UtilityRateOptions struct {
 	NetMeteringCredits   bool   `json:"netMeteringCredits"`
 }
 
-// UtilityPeriod defines a particular schedule for some utility rate or fee
+// UtilityPeriod defines a particular schedule for some utility rate or fee.
+// The daily window starts at HourStart:MinuteStart (inclusive) and ends at
+// HourEnd:MinuteEnd (exclusive). If the end is before the start then the window
+// wraps past midnight (e.g. 21:30 - 06:30) and DaysOfTheWeek applies to the day
+// the window started on.
 type UtilityPeriod struct {
 	Start         time.Time      `json:"start"`
 	End           time.Time      `json:"end"`
 	HourStart     int            `json:"hourStart"`
+	MinuteStart   int            `json:"minuteStart,omitempty"`
 	HourEnd       int            `json:"hourEnd"`
+	MinuteEnd     int            `json:"minuteEnd,omitempty"`
 	DaysOfTheWeek []time.Weekday `json:"daysOfTheWeek"`
 	Location      string         `json:"location"`
 	LocationPtr   *time.Location `json:"-"`
@@ -96,12 +139,35 @@ func (p *UtilityPeriod) Contains(t time.Time) (bool, error) {
 	if !p.End.IsZero() && t.After(p.End) {
 		return false, nil
 	}
-	if h := t.Hour(); h < p.HourStart || h >= p.HourEnd {
-		return false, nil
+	return p.ContainsTimeOfDay(t), nil
+}
+
+// ContainsTimeOfDay checks if the time of day (and day of the week) of t is
+// within the period's daily window. It ignores Start, End, and Location so t
+// must already be in the period's location.
+func (p *UtilityPeriod) ContainsTimeOfDay(t time.Time) bool {
+	start := p.HourStart*60 + p.MinuteStart
+	end := p.HourEnd*60 + p.MinuteEnd
+	m := t.Hour()*60 + t.Minute()
+
+	dow := t.Weekday()
+	switch {
+	case start <= end:
+		if m < start || m >= end {
+			return false
+		}
+	case m >= start:
+		// in the portion of a wrapping window before midnight
+	case m < end:
+		// in the portion of a wrapping window after midnight so the window
+		// started on the previous day
+		dow = (dow + 6) % 7
+	default:
+		return false
 	}
+
 	if len(p.DaysOfTheWeek) > 0 {
 		var found bool
-		dow := t.Weekday()
 		for _, d := range p.DaysOfTheWeek {
 			if d == dow {
 				found = true
@@ -109,10 +175,10 @@ func (p *UtilityPeriod) Contains(t time.Time) (bool, error) {
 			}
 		}
 		if !found {
-			return false, nil
+			return false
 		}
 	}
-	return true, nil
+	return true
 }
 
 // UtilityAdditionalFeesPeriod represents a period of time with an additional fee.
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -167,6 +168,94 @@ func TestUtilityPeriodContains(t *testing.T) {
 		assert.False(t, contained)
 	})
 
+	t.Run("minute range", func(t *testing.T) {
+		p := &UtilityPeriod{
+			HourStart:   9,
+			MinuteStart: 30,
+			HourEnd:     17,
+			MinuteEnd:   15,
+		}
+
+		// 9:29 AM (before Start)
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 9, 29, 0, 0, time.UTC)))
+		// 9:30 AM (at Start)
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)))
+		// 5:14 PM (within range)
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 17, 14, 59, 0, time.UTC)))
+		// 5:15 PM (at End - exclusive)
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 17, 15, 0, 0, time.UTC)))
+	})
+
+	t.Run("overnight wrap", func(t *testing.T) {
+		p := &UtilityPeriod{
+			HourStart:   21,
+			MinuteStart: 30,
+			HourEnd:     6,
+			MinuteEnd:   30,
+		}
+
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 21, 29, 0, 0, time.UTC)))
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 21, 30, 0, 0, time.UTC)))
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 23, 59, 59, 0, time.UTC)))
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)))
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 2, 6, 29, 0, 0, time.UTC)))
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 2, 6, 30, 0, 0, time.UTC)))
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("overnight wrap days of the week", func(t *testing.T) {
+		// Friday night through Saturday morning only
+		p := &UtilityPeriod{
+			HourStart:     22,
+			HourEnd:       6,
+			DaysOfTheWeek: []time.Weekday{time.Friday},
+		}
+
+		// Friday Jan 5, 2024 11:00 PM
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 5, 23, 0, 0, 0, time.UTC)))
+		// Saturday Jan 6, 2024 2:00 AM belongs to Friday's window
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC)))
+		// Saturday Jan 6, 2024 11:00 PM
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 6, 23, 0, 0, 0, time.UTC)))
+		// Friday Jan 5, 2024 2:00 AM belongs to Thursday's window
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 5, 2, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("overnight wrap with location", func(t *testing.T) {
+		p := &UtilityPeriod{
+			Location:  "America/Chicago",
+			HourStart: 21,
+			HourEnd:   6,
+		}
+
+		// 11:00 PM Central is 05:00 UTC the next day
+		contained, err := p.Contains(time.Date(2024, 1, 2, 5, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.True(t, contained)
+
+		// 7:00 AM Central is 13:00 UTC
+		contained, err = p.Contains(time.Date(2024, 1, 2, 13, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.False(t, contained)
+	})
+
+	t.Run("json backwards compatibility", func(t *testing.T) {
+		var p UtilityPeriod
+		err := json.Unmarshal([]byte(`{"hourStart":9,"hourEnd":17}`), &p)
+		require.NoError(t, err)
+		assert.Equal(t, 9, p.HourStart)
+		assert.Equal(t, 0, p.MinuteStart)
+		assert.Equal(t, 17, p.HourEnd)
+		assert.Equal(t, 0, p.MinuteEnd)
+		assert.True(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)))
+		assert.False(t, p.ContainsTimeOfDay(time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC)))
+
+		b, err := json.Marshal(UtilityPeriod{HourStart: 9, HourEnd: 17})
+		require.NoError(t, err)
+		assert.NotContains(t, string(b), "minuteStart")
+		assert.NotContains(t, string(b), "minuteEnd")
+	})
+
 	t.Run("empty days of week", func(t *testing.T) {
 		p := &UtilityPeriod{
 			DaysOfTheWeek: []time.Weekday{},
@@ -181,7 +181,7 @@ func (c *BaseAmerenSmart) fetchMISODayAhead(ctx context.Context, date time.Time,
 
 	if resp.StatusCode != http.StatusOK {
 		// if today fails, try yesterday + 1 da? For now just return error
-		return nil, fmt.Errorf("miso da api status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("miso da api error: %w", common.NewStatusError(resp))
 	}
 
 	reader := csv.NewReader(resp.Body)
@@ -210,7 +210,7 @@ func (c *BaseComEdHourly) GetConfirmedPrices(ctx context.Context, start, end tim
 
 		// require full 12 5-minute periods to ensure complete data but somehow we
 		// don't have enough samples even though we have 59+minutes of data
-		if p.SampleCount != 12 {
+		if p.SampleCount != comEdSamplesPerHour {
 			log.Ctx(ctx).ErrorContext(
 				ctx,
 				"incomplete price data for hour",
@@ -279,7 +279,7 @@ func (c *BaseComEdHourly) fetchPricesRange(ctx context.Context, start, end time.
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("comed api returned status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("comed api error: %w", common.NewStatusError(resp))
 	}
 
 	var data []comedPriceEntry
@@ -367,15 +367,64 @@ func (c *BaseComEdHourly) GetCurrentPrice(ctx context.Context) (types.Price, err
 
 	// Return the latest available price (even if incomplete)
 	latest := prices[len(prices)-1]
+	if latest.SampleCount < comEdSamplesPerHour {
+		// the hour isn't complete so project the rest of the hour using the
+		// day-ahead price if we have one
+		var dayAhead *types.Price
+		future, err := c.GetFuturePrices(ctx)
+		if err != nil {
+			log.Ctx(ctx).WarnContext(ctx, "failed to get day-ahead prices for projection", slog.Any("error", err))
+		}
+		for i := range future {
+			if future[i].TSStart.Equal(latest.TSStart) {
+				dayAhead = &future[i]
+				break
+			}
+		}
+		latest = projectComEdHourPrice(latest, dayAhead)
+	}
 	log.Ctx(ctx).DebugContext(
 		ctx,
 		"got current price",
 		slog.Float64("price", latest.DollarsPerKWH),
 		slog.Time("ts", latest.TSStart),
+		slog.Int("sampleCount", latest.SampleCount),
+		slog.Bool("projected", latest.Projected),
+		slog.Float64("confidence", latest.PriceConfidence()),
 	)
 	return latest, nil
 }
 
+const (
+	// comEdSamplesPerHour is the number of 5-minute prices that are averaged
+	// into the hourly price.
+	comEdSamplesPerHour = 12
+
+	// comEdDayAheadConfidence is how much we trust the day-ahead price to
+	// predict the unobserved part of the hour. Real-time prices regularly
+	// diverge from the day-ahead prices so this is intentionally low.
+	comEdDayAheadConfidence = 0.25
+)
+
+// projectComEdHourPrice projects the full hour's price from a partial hour of
+// 5-minute samples. ComEd bills the average of all 12 samples so the
+// unobserved samples are assumed to match the day-ahead price, if we have one,
+// otherwise the observed average.
+func projectComEdHourPrice(partial types.Price, dayAhead *types.Price) types.Price {
+	observed := min(partial.SampleCount, comEdSamplesPerHour)
+	observedFraction := float64(observed) / comEdSamplesPerHour
+
+	p := partial
+	p.Projected = true
+	p.ObservedDollarsPerKWH = partial.DollarsPerKWH
+	p.Confidence = observedFraction
+	if dayAhead != nil {
+		p.DollarsPerKWH = observedFraction*partial.DollarsPerKWH + (1-observedFraction)*dayAhead.DollarsPerKWH
+		p.Confidence += (1 - observedFraction) * comEdDayAheadConfidence
+	}
+	return p
+}
+
 // GetFuturePrices returns predicted or day-ahead prices.
 // Prefers PJM API if configured, otherwise returns nothing
 func (c *BaseComEdHourly) GetFuturePrices(ctx context.Context) ([]types.Price, error) {
@@ -455,7 +504,7 @@ func (c *BaseComEdHourly) fetchPJMDayAhead(ctx context.Context, pnodeID string)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("pjm api status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("pjm api error: %w", common.NewStatusError(resp))
 	}
 
 	var res []pjmItem
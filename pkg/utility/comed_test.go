@@ -50,6 +50,64 @@ func TestComEd(t *testing.T) {
 		assert.Equal(t, expectedTime, price.TSStart)
 	})
 
+	t.Run("GetCurrentPrice_Projected", func(t *testing.T) {
+		hourStart := time.Now().In(etLocation).Truncate(time.Hour)
+		comed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// three samples in the current hour averaging 6 cents
+			_, _ = fmt.Fprintf(w, `[
+				{"millisUTC":"%d","price":"4.0"},
+				{"millisUTC":"%d","price":"6.0"},
+				{"millisUTC":"%d","price":"8.0"}
+			]`,
+				hourStart.Add(5*time.Minute).UnixMilli(),
+				hourStart.Add(10*time.Minute).UnixMilli(),
+				hourStart.Add(15*time.Minute).UnixMilli(),
+			)
+		}))
+		defer comed.Close()
+		pjm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, `[{"datetime_beginning_ept": "%s", "total_lmp_da": 20.0}]`, hourStart.Format("2006-01-02T15:04:05"))
+		}))
+		defer pjm.Close()
+
+		c := &BaseComEdHourly{
+			apiURL:           comed.URL,
+			pjmAPIKey:        "test-key",
+			pjmAPIURL:        pjm.URL,
+			client:           http.DefaultClient,
+			historicalPrices: make(map[int64]types.Price),
+		}
+
+		price, err := c.GetCurrentPrice(context.Background())
+		require.NoError(t, err)
+		assert.True(t, price.TSStart.Equal(hourStart))
+		assert.True(t, price.Projected)
+		assert.Equal(t, 3, price.SampleCount)
+		assert.InDelta(t, 0.06, price.ObservedDollarsPerKWH, 0.000001)
+
+		dayAhead := (20.0 / 1000) * 1.0124 * 1.0002 * (1.0 + .047)
+		assert.InDelta(t, 0.25*0.06+0.75*dayAhead, price.DollarsPerKWH, 0.000001)
+		assert.InDelta(t, 0.25+0.75*comEdDayAheadConfidence, price.Confidence, 0.000001)
+	})
+
+	t.Run("ProjectComEdHourPrice", func(t *testing.T) {
+		partial := types.Price{DollarsPerKWH: 0.10, SampleCount: 6}
+
+		// without a day-ahead price the observed average is used
+		p := projectComEdHourPrice(partial, nil)
+		assert.True(t, p.Projected)
+		assert.InDelta(t, 0.10, p.DollarsPerKWH, 0.000001)
+		assert.InDelta(t, 0.10, p.ObservedDollarsPerKWH, 0.000001)
+		assert.InDelta(t, 0.5, p.Confidence, 0.000001)
+
+		// the day-ahead price fills in the unobserved half of the hour
+		p = projectComEdHourPrice(partial, &types.Price{DollarsPerKWH: 0.02})
+		assert.InDelta(t, 0.06, p.DollarsPerKWH, 0.000001)
+		assert.InDelta(t, 0.10, p.ObservedDollarsPerKWH, 0.000001)
+		assert.InDelta(t, 0.5+0.5*comEdDayAheadConfidence, p.Confidence, 0.000001)
+		assert.Less(t, p.Confidence, 1.0)
+	})
+
 	t.Run("Caching", func(t *testing.T) {
 		requests := 0
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
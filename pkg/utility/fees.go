@@ -54,33 +54,48 @@ func (s *SiteFees) ApplySettings(ctx context.Context, settings types.Settings) e
 	return nil
 }
 
-func (s *SiteFees) applyFees(p types.Price) (types.Price, error) {
+// AdditionalFees implements the Utility interface
+func (s *SiteFees) AdditionalFees(ts time.Time) []types.UtilityAdditionalFeesPeriod {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var fees []types.UtilityAdditionalFeesPeriod
 	for _, period := range s.periods {
-		// Calculate time-of-day in minutes for easier comparison if needed, or just use hour
 		// Check date range
-		if !period.Start.IsZero() && p.TSStart.Before(period.Start) {
+		if !period.Start.IsZero() && ts.Before(period.Start) {
 			continue
 		}
 		// period.End is exclusive: skip if the price starts at or after End
-		if !period.End.IsZero() && !p.TSStart.Before(period.End) {
+		if !period.End.IsZero() && !ts.Before(period.End) {
 			continue
 		}
 
-		// Check hour range (inclusive start, exclusive end)
-		// Assuming p.TSStart is the start of the hour
-		h := p.TSStart.In(ctLocation).Hour()
-		if h < period.HourStart || h >= period.HourEnd {
+		// Check the daily window (inclusive start, exclusive end) against the
+		// start of the price interval
+		if !period.ContainsTimeOfDay(ts.In(ctLocation)) {
 			continue
 		}
+		fees = append(fees, period)
+	}
+	return fees
+}
 
-		// Apply fee
+func (s *SiteFees) applyFees(p types.Price) (types.Price, error) {
+	for _, period := range s.AdditionalFees(p.TSStart) {
+		// keep the fees with the price so it can be broken down later even if
+		// the fees change
+		p.Fees = append(p.Fees, types.PriceFee{
+			Description:    period.Description,
+			DollarsPerKWH:  period.DollarsPerKWH,
+			GridAdditional: period.GridAdditional,
+		})
 		if period.GridAdditional {
 			p.GridUseDollarsPerKWH += period.DollarsPerKWH
 		} else {
 			p.DollarsPerKWH += period.DollarsPerKWH
+			if p.Projected {
+				p.ObservedDollarsPerKWH += period.DollarsPerKWH
+			}
 		}
 	}
 	return p, nil
@@ -130,6 +130,11 @@ func TestSiteFees(t *testing.T) {
 			assert.InDelta(t, 0.25, result.DollarsPerKWH, 0.0001)
 			// Grid Fee (0.02)
 			assert.InDelta(t, 0.02, result.GridUseDollarsPerKWH, 0.0001)
+			assert.Equal(t, []types.PriceFee{
+				{Description: "Peak Fee", DollarsPerKWH: 0.10},
+				{Description: "Summer Fee", DollarsPerKWH: 0.05},
+				{Description: "Grid Fee", DollarsPerKWH: 0.02, GridAdditional: true},
+			}, result.Fees)
 		})
 
 		t.Run("off-peak in winter", func(t *testing.T) {
@@ -161,6 +166,47 @@ func TestSiteFees(t *testing.T) {
 			assert.InDelta(t, 0.10, p2.DollarsPerKWH, 0.0001)
 		})
 
+		t.Run("overnight wrap", func(t *testing.T) {
+			overnightS := &SiteFees{
+				periods: []types.UtilityAdditionalFeesPeriod{
+					{
+						UtilityPeriod: types.UtilityPeriod{
+							HourStart:   21,
+							MinuteStart: 30,
+							HourEnd:     6,
+							MinuteEnd:   30,
+						},
+						DollarsPerKWH:  -0.02,
+						GridAdditional: true,
+						Description:    "Overnight Credit",
+					},
+				},
+			}
+
+			for _, tc := range []struct {
+				hour    int
+				applied bool
+			}{
+				{hour: 21, applied: false},
+				{hour: 22, applied: true},
+				{hour: 0, applied: true},
+				{hour: 6, applied: true},
+				{hour: 7, applied: false},
+				{hour: 12, applied: false},
+			} {
+				p, err := overnightS.applyFees(types.Price{
+					TSStart:       time.Date(2026, 1, 1, tc.hour, 0, 0, 0, ctLocation),
+					DollarsPerKWH: 0.10,
+				})
+				require.NoError(t, err)
+				if tc.applied {
+					assert.InDelta(t, -0.02, p.GridUseDollarsPerKWH, 0.0001, "hour %d", tc.hour)
+				} else {
+					assert.InDelta(t, 0.0, p.GridUseDollarsPerKWH, 0.0001, "hour %d", tc.hour)
+				}
+			}
+		})
+
 		t.Run("End is exclusive", func(t *testing.T) {
 			// Use a dedicated SiteFees with a single date-bounded period to isolate period.End behavior.
 			endTime := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
@@ -201,6 +247,33 @@ func TestSiteFees(t *testing.T) {
 		})
 	})
 
+	t.Run("AdditionalFees", func(t *testing.T) {
+		s := &SiteFees{
+			periods: []types.UtilityAdditionalFeesPeriod{
+				{
+					UtilityPeriod: types.UtilityPeriod{HourStart: 14, HourEnd: 18},
+					DollarsPerKWH: 0.10,
+					Description:   "Peak Fee",
+				},
+				{
+					UtilityPeriod:  types.UtilityPeriod{HourStart: 0, HourEnd: 24},
+					DollarsPerKWH:  0.02,
+					GridAdditional: true,
+					Description:    "Grid Fee",
+				},
+			},
+		}
+
+		fees := s.AdditionalFees(time.Date(2026, 1, 1, 15, 0, 0, 0, ctLocation))
+		require.Len(t, fees, 2)
+		assert.Equal(t, "Peak Fee", fees[0].Description)
+		assert.Equal(t, "Grid Fee", fees[1].Description)
+
+		fees = s.AdditionalFees(time.Date(2026, 1, 1, 10, 0, 0, 0, ctLocation))
+		require.Len(t, fees, 1)
+		assert.Equal(t, "Grid Fee", fees[0].Description)
+	})
+
 	t.Run("GetConfirmedPrices", func(t *testing.T) {
 		m := new(mockUtilityPrices)
 		s := &SiteFees{
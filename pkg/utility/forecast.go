@@ -0,0 +1,154 @@
+package utility
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// PriceForecaster estimates future prices from a site's price history. It is
+// used when the utility doesn't publish future prices or to extend the
+// published prices further into the future.
+type PriceForecaster interface {
+	// ForecastPrices returns an estimated hourly price for every hour in
+	// [start, end) using the given price history. The returned prices must
+	// have Estimated set.
+	ForecastPrices(ctx context.Context, history []types.Price, start, end time.Time) []types.Price
+}
+
+// SeasonalForecaster forecasts prices using the average price for the same
+// weekday and hour in the history, adjusted by how far the most recent prices
+// have drifted from that average.
+type SeasonalForecaster struct {
+	// Location is used to determine the weekday and hour of each price.
+	Location *time.Location
+	// RecentWindow is how much of the most recent history is used to compute
+	// the level adjustment.
+	RecentWindow time.Duration
+	// AdjustmentHalfLife is how quickly the level adjustment decays the further
+	// into the future we forecast.
+	AdjustmentHalfLife time.Duration
+	// MinWeekdaySamples is the number of samples required for a weekday/hour
+	// before it's used instead of the average for the hour across all days.
+	MinWeekdaySamples int
+}
+
+// NewSeasonalForecaster returns a SeasonalForecaster with the default
+// parameters.
+func NewSeasonalForecaster() *SeasonalForecaster {
+	return &SeasonalForecaster{
+		Location:           ctLocation,
+		RecentWindow:       6 * time.Hour,
+		AdjustmentHalfLife: 12 * time.Hour,
+		MinWeekdaySamples:  2,
+	}
+}
+
+// priceStat accumulates the average of a set of prices.
+type priceStat struct {
+	dollars float64
+	gridUse float64
+	count   int
+}
+
+func (s *priceStat) add(p types.Price) {
+	s.dollars += p.DollarsPerKWH
+	s.gridUse += p.GridUseDollarsPerKWH
+	s.count++
+}
+
+func (s priceStat) avg() (float64, float64) {
+	return s.dollars / float64(s.count), s.gridUse / float64(s.count)
+}
+
+// ForecastPrices implements PriceForecaster.
+func (f *SeasonalForecaster) ForecastPrices(ctx context.Context, history []types.Price, start, end time.Time) []types.Price {
+	loc := f.Location
+	if loc == nil {
+		loc = ctLocation
+	}
+
+	var weekdayHours [7][24]priceStat
+	var hours [24]priceStat
+	var overall priceStat
+	var latest time.Time
+	var provider string
+	for _, p := range history {
+		// don't forecast from other forecasts
+		if p.Estimated {
+			continue
+		}
+		ts := p.TSStart.In(loc)
+		weekdayHours[ts.Weekday()][ts.Hour()].add(p)
+		hours[ts.Hour()].add(p)
+		overall.add(p)
+		if p.TSStart.After(latest) {
+			latest = p.TSStart
+			provider = p.Provider
+		}
+	}
+	if overall.count == 0 {
+		log.Ctx(ctx).DebugContext(ctx, "no price history to forecast from")
+		return nil
+	}
+
+	seasonal := func(t time.Time) (float64, float64) {
+		t = t.In(loc)
+		if s := weekdayHours[t.Weekday()][t.Hour()]; s.count >= f.MinWeekdaySamples && s.count > 0 {
+			return s.avg()
+		}
+		if s := hours[t.Hour()]; s.count > 0 {
+			return s.avg()
+		}
+		return overall.avg()
+	}
+
+	// the level adjustment is how much the recent prices differ from what the
+	// seasonal average would have predicted for them
+	var adjustment float64
+	var recentCount int
+	for _, p := range history {
+		if p.Estimated || p.TSStart.Before(latest.Add(-f.RecentWindow)) {
+			continue
+		}
+		expected, _ := seasonal(p.TSStart)
+		adjustment += p.DollarsPerKWH - expected
+		recentCount++
+	}
+	if recentCount > 0 {
+		adjustment /= float64(recentCount)
+	}
+
+	start = start.Truncate(time.Hour)
+	var prices []types.Price
+	for ts := start; ts.Before(end); ts = ts.Add(time.Hour) {
+		dollars, gridUse := seasonal(ts)
+		if f.AdjustmentHalfLife > 0 {
+			ahead := max(ts.Sub(latest), 0)
+			dollars += adjustment * math.Pow(0.5, ahead.Hours()/f.AdjustmentHalfLife.Hours())
+		}
+		prices = append(prices, types.Price{
+			Provider:             provider,
+			TSStart:              ts,
+			TSEnd:                ts.Add(time.Hour),
+			DollarsPerKWH:        dollars,
+			GridUseDollarsPerKWH: gridUse,
+			Estimated:            true,
+		})
+	}
+
+	log.Ctx(ctx).DebugContext(
+		ctx,
+		"forecasted prices",
+		slog.Int("historyCount", overall.count),
+		slog.Float64("adjustment", adjustment),
+		slog.Int("count", len(prices)),
+		slog.Time("start", start),
+		slog.Time("end", end),
+	)
+	return prices
+}
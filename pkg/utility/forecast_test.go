@@ -0,0 +1,94 @@
+package utility
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeasonalForecaster(t *testing.T) {
+	ctx := context.Background()
+	// Monday
+	start := time.Date(2026, time.January, 5, 0, 0, 0, 0, ctLocation)
+
+	// four weeks of history where the price is the hour of day in cents except
+	// on Mondays at 5pm when it spikes
+	var history []types.Price
+	for ts := start; ts.Before(start.AddDate(0, 0, 28)); ts = ts.Add(time.Hour) {
+		p := types.Price{
+			Provider:             "test",
+			TSStart:              ts,
+			TSEnd:                ts.Add(time.Hour),
+			DollarsPerKWH:        float64(ts.Hour()) / 100,
+			GridUseDollarsPerKWH: 0.05,
+		}
+		if ts.Weekday() == time.Monday && ts.Hour() == 17 {
+			p.DollarsPerKWH = 0.50
+		}
+		history = append(history, p)
+	}
+	forecastStart := start.AddDate(0, 0, 28)
+
+	t.Run("Seasonality", func(t *testing.T) {
+		f := NewSeasonalForecaster()
+		prices := f.ForecastPrices(ctx, history, forecastStart, forecastStart.Add(48*time.Hour))
+		require.Len(t, prices, 48)
+		for i, p := range prices {
+			assert.True(t, p.Estimated)
+			assert.Equal(t, "test", p.Provider)
+			assert.True(t, p.TSStart.Equal(forecastStart.Add(time.Duration(i)*time.Hour)))
+			assert.InDelta(t, 0.05, p.GridUseDollarsPerKWH, 0.000001)
+		}
+		// forecastStart is a Monday so the spike is expected
+		assert.InDelta(t, 0.50, prices[17].DollarsPerKWH, 0.000001)
+		// but not on Tuesday
+		assert.InDelta(t, 0.17, prices[24+17].DollarsPerKWH, 0.000001)
+		assert.InDelta(t, 0.03, prices[3].DollarsPerKWH, 0.000001)
+	})
+
+	t.Run("Level Adjustment", func(t *testing.T) {
+		// the last 6 hours have been 10 cents higher than usual
+		adjusted := make([]types.Price, len(history))
+		copy(adjusted, history)
+		for i := len(adjusted) - 6; i < len(adjusted); i++ {
+			adjusted[i].DollarsPerKWH += 0.10
+		}
+
+		f := NewSeasonalForecaster()
+		prices := f.ForecastPrices(ctx, adjusted, forecastStart, forecastStart.Add(48*time.Hour))
+		require.Len(t, prices, 48)
+
+		// the adjustment is large right away and decays over time
+		nearAdjustment := prices[0].DollarsPerKWH - 0.00
+		farAdjustment := prices[47].DollarsPerKWH - 0.23
+		assert.Greater(t, nearAdjustment, 0.05)
+		assert.Less(t, nearAdjustment, 0.10)
+		assert.Greater(t, farAdjustment, 0.0)
+		assert.Less(t, farAdjustment, nearAdjustment/4)
+	})
+
+	t.Run("Hourly Fallback", func(t *testing.T) {
+		// only a single day of history so there's not enough samples for each
+		// weekday and the hour of day average is used
+		f := NewSeasonalForecaster()
+		prices := f.ForecastPrices(ctx, history[:24], forecastStart.Add(24*time.Hour), forecastStart.Add(26*time.Hour))
+		require.Len(t, prices, 2)
+		assert.InDelta(t, 0.00, prices[0].DollarsPerKWH, 0.000001)
+		assert.InDelta(t, 0.01, prices[1].DollarsPerKWH, 0.000001)
+	})
+
+	t.Run("Ignores Estimates", func(t *testing.T) {
+		f := NewSeasonalForecaster()
+		prices := f.ForecastPrices(ctx, []types.Price{{TSStart: start, DollarsPerKWH: 1, Estimated: true}}, forecastStart, forecastStart.Add(time.Hour))
+		assert.Empty(t, prices)
+	})
+
+	t.Run("No History", func(t *testing.T) {
+		f := NewSeasonalForecaster()
+		assert.Empty(t, f.ForecastPrices(ctx, nil, forecastStart, forecastStart.Add(time.Hour)))
+	})
+}
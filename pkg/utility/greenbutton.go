@@ -0,0 +1,185 @@
+package utility
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// Green Button (ESPI) reading type constants.
+// See the NAESB REQ.21 ESPI schema for the full list of values.
+const (
+	espiFlowDirectionForward = 1  // delivered to the customer (grid import)
+	espiFlowDirectionReverse = 19 // received from the customer (grid export)
+	espiUOMWattHours         = 72
+)
+
+// espiFeed is the Atom feed wrapping Green Button resources.
+type espiFeed struct {
+	Entries []espiEntry `xml:"entry"`
+}
+
+type espiEntry struct {
+	Links   []espiLink  `xml:"link"`
+	Content espiContent `xml:"content"`
+}
+
+type espiLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type espiContent struct {
+	ReadingType    *espiReadingType    `xml:"ReadingType"`
+	MeterReading   *struct{}           `xml:"MeterReading"`
+	IntervalBlocks []espiIntervalBlock `xml:"IntervalBlock"`
+}
+
+type espiReadingType struct {
+	FlowDirection        int `xml:"flowDirection"`
+	PowerOfTenMultiplier int `xml:"powerOfTenMultiplier"`
+	UOM                  int `xml:"uom"`
+}
+
+type espiIntervalBlock struct {
+	Readings []espiIntervalReading `xml:"IntervalReading"`
+}
+
+type espiIntervalReading struct {
+	TimePeriod struct {
+		Duration int64 `xml:"duration"`
+		Start    int64 `xml:"start"`
+	} `xml:"timePeriod"`
+	Value int64 `xml:"value"`
+}
+
+func (e espiEntry) link(rel string) string {
+	for _, l := range e.Links {
+		if l.Rel == rel {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// ParseGreenButton parses a Green Button (ESPI Atom/XML) document and returns
+// hourly meter readings. Each returned EnergyStats only has TSHourStart,
+// MeterGridImportKWH, MeterGridExportKWH, and HasMeterData set. Intervals
+// shorter than an hour are summed into their hour.
+func ParseGreenButton(r io.Reader) ([]types.EnergyStats, error) {
+	var feed espiFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to decode green button xml: %w", err)
+	}
+
+	// ReadingTypes are keyed by their self link and MeterReadings point to
+	// their ReadingType through a related link
+	readingTypes := make(map[string]espiReadingType)
+	meterReadingTypes := make(map[string]string)
+	var lastReadingType *espiReadingType
+	for _, e := range feed.Entries {
+		switch {
+		case e.Content.ReadingType != nil:
+			readingTypes[e.link("self")] = *e.Content.ReadingType
+			lastReadingType = e.Content.ReadingType
+		case e.Content.MeterReading != nil:
+			for _, l := range e.Links {
+				if l.Rel == "related" && strings.Contains(l.Href, "/ReadingType/") {
+					meterReadingTypes[e.link("self")] = l.Href
+				}
+			}
+		}
+	}
+
+	hours := make(map[int64]*types.EnergyStats)
+	for _, e := range feed.Entries {
+		if len(e.Content.IntervalBlocks) == 0 {
+			continue
+		}
+
+		// IntervalBlocks have an up link of <MeterReading>/IntervalBlock
+		rt, ok := readingTypes[meterReadingTypes[strings.TrimSuffix(e.link("up"), "/IntervalBlock")]]
+		if !ok {
+			// if there's only one reading type in the document then it must be
+			// the one that applies
+			if len(readingTypes) != 1 {
+				return nil, fmt.Errorf("unable to determine reading type for interval block (up=%s)", e.link("up"))
+			}
+			rt = *lastReadingType
+		}
+		if rt.UOM != espiUOMWattHours {
+			return nil, fmt.Errorf("unsupported green button unit of measure: %d", rt.UOM)
+		}
+		if rt.FlowDirection != espiFlowDirectionForward && rt.FlowDirection != espiFlowDirectionReverse {
+			return nil, fmt.Errorf("unsupported green button flow direction: %d", rt.FlowDirection)
+		}
+		multiplier := math.Pow10(rt.PowerOfTenMultiplier)
+
+		for _, block := range e.Content.IntervalBlocks {
+			for _, reading := range block.Readings {
+				if reading.TimePeriod.Duration <= 0 || reading.TimePeriod.Duration > 3600 {
+					return nil, fmt.Errorf("unsupported green button interval duration: %ds", reading.TimePeriod.Duration)
+				}
+				tsHourStart := time.Unix(reading.TimePeriod.Start, 0).Truncate(time.Hour)
+				stats, ok := hours[tsHourStart.Unix()]
+				if !ok {
+					stats = &types.EnergyStats{
+						TSHourStart:  tsHourStart,
+						HasMeterData: true,
+					}
+					hours[tsHourStart.Unix()] = stats
+				}
+				kwh := float64(reading.Value) * multiplier / 1000
+				if rt.FlowDirection == espiFlowDirectionForward {
+					stats.MeterGridImportKWH += kwh
+				} else {
+					stats.MeterGridExportKWH += kwh
+				}
+			}
+		}
+	}
+
+	history := make([]types.EnergyStats, 0, len(hours))
+	for _, stats := range hours {
+		history = append(history, *stats)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].TSHourStart.Before(history[j].TSHourStart)
+	})
+	return history, nil
+}
+
+// MergeMeterHistory reconciles meter readings with the ESS-reported history.
+// The meter readings are added to the matching ESS hour, leaving the ESS
+// values untouched. Hours without ESS data are returned with the grid values
+// taken from the meter so savings can still be computed. Only the hours that
+// have meter data are returned.
+func MergeMeterHistory(essHistory, meterHistory []types.EnergyStats) []types.EnergyStats {
+	byHour := make(map[int64]types.EnergyStats, len(essHistory))
+	for _, h := range essHistory {
+		byHour[h.TSHourStart.Truncate(time.Hour).Unix()] = h
+	}
+
+	merged := make([]types.EnergyStats, 0, len(meterHistory))
+	for _, m := range meterHistory {
+		h, ok := byHour[m.TSHourStart.Truncate(time.Hour).Unix()]
+		if !ok {
+			h = types.EnergyStats{
+				TSHourStart:   m.TSHourStart,
+				GridImportKWH: m.MeterGridImportKWH,
+				GridExportKWH: m.MeterGridExportKWH,
+			}
+		}
+		h.MeterGridImportKWH = m.MeterGridImportKWH
+		h.MeterGridExportKWH = m.MeterGridExportKWH
+		h.HasMeterData = true
+		merged = append(merged, h)
+	}
+	return merged
+}
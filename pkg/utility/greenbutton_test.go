@@ -0,0 +1,156 @@
+package utility
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 2024-01-01T00:00:00Z
+const greenButtonStart = 1704067200
+
+const greenButtonFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:espi="http://naesb.org/espi">
+  <entry>
+    <link rel="self" href="/espi/1_1/resource/Subscription/1/UsagePoint/1/MeterReading/1"/>
+    <link rel="related" href="/espi/1_1/resource/Subscription/1/UsagePoint/1/MeterReading/1/IntervalBlock"/>
+    <link rel="related" href="/espi/1_1/resource/ReadingType/1"/>
+    <content><espi:MeterReading/></content>
+  </entry>
+  <entry>
+    <link rel="self" href="/espi/1_1/resource/Subscription/1/UsagePoint/1/MeterReading/2"/>
+    <link rel="related" href="/espi/1_1/resource/ReadingType/2"/>
+    <content><espi:MeterReading/></content>
+  </entry>
+  <entry>
+    <link rel="self" href="/espi/1_1/resource/ReadingType/1"/>
+    <content>
+      <espi:ReadingType>
+        <espi:flowDirection>1</espi:flowDirection>
+        <espi:powerOfTenMultiplier>0</espi:powerOfTenMultiplier>
+        <espi:uom>72</espi:uom>
+      </espi:ReadingType>
+    </content>
+  </entry>
+  <entry>
+    <link rel="self" href="/espi/1_1/resource/ReadingType/2"/>
+    <content>
+      <espi:ReadingType>
+        <espi:flowDirection>19</espi:flowDirection>
+        <espi:powerOfTenMultiplier>-3</espi:powerOfTenMultiplier>
+        <espi:uom>72</espi:uom>
+      </espi:ReadingType>
+    </content>
+  </entry>
+  <entry>
+    <link rel="self" href="/espi/1_1/resource/Subscription/1/UsagePoint/1/MeterReading/1/IntervalBlock/1"/>
+    <link rel="up" href="/espi/1_1/resource/Subscription/1/UsagePoint/1/MeterReading/1/IntervalBlock"/>
+    <content>
+      <espi:IntervalBlock>
+        <espi:interval><espi:duration>7200</espi:duration><espi:start>1704067200</espi:start></espi:interval>
+        <espi:IntervalReading><espi:timePeriod><espi:duration>1800</espi:duration><espi:start>1704067200</espi:start></espi:timePeriod><espi:value>500</espi:value></espi:IntervalReading>
+        <espi:IntervalReading><espi:timePeriod><espi:duration>1800</espi:duration><espi:start>1704069000</espi:start></espi:timePeriod><espi:value>750</espi:value></espi:IntervalReading>
+        <espi:IntervalReading><espi:timePeriod><espi:duration>3600</espi:duration><espi:start>1704070800</espi:start></espi:timePeriod><espi:value>2000</espi:value></espi:IntervalReading>
+      </espi:IntervalBlock>
+    </content>
+  </entry>
+  <entry>
+    <link rel="self" href="/espi/1_1/resource/Subscription/1/UsagePoint/1/MeterReading/2/IntervalBlock/1"/>
+    <link rel="up" href="/espi/1_1/resource/Subscription/1/UsagePoint/1/MeterReading/2/IntervalBlock"/>
+    <content>
+      <espi:IntervalBlock>
+        <espi:IntervalReading><espi:timePeriod><espi:duration>3600</espi:duration><espi:start>1704070800</espi:start></espi:timePeriod><espi:value>300000</espi:value></espi:IntervalReading>
+      </espi:IntervalBlock>
+    </content>
+  </entry>
+</feed>`
+
+func TestParseGreenButton(t *testing.T) {
+	t.Run("import and export", func(t *testing.T) {
+		history, err := ParseGreenButton(strings.NewReader(greenButtonFeed))
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+
+		assert.True(t, history[0].TSHourStart.Equal(time.Unix(greenButtonStart, 0)))
+		assert.True(t, history[0].HasMeterData)
+		// two half hour readings summed: 500Wh + 750Wh
+		assert.InDelta(t, 1.25, history[0].MeterGridImportKWH, 0.0001)
+		assert.InDelta(t, 0.0, history[0].MeterGridExportKWH, 0.0001)
+
+		assert.True(t, history[1].TSHourStart.Equal(time.Unix(greenButtonStart+3600, 0)))
+		assert.InDelta(t, 2.0, history[1].MeterGridImportKWH, 0.0001)
+		// 300000 mWh
+		assert.InDelta(t, 0.3, history[1].MeterGridExportKWH, 0.0001)
+	})
+
+	t.Run("single reading type", func(t *testing.T) {
+		feed := `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry><content><ReadingType xmlns="http://naesb.org/espi"><flowDirection>1</flowDirection><powerOfTenMultiplier>3</powerOfTenMultiplier><uom>72</uom></ReadingType></content></entry>
+  <entry><content><IntervalBlock xmlns="http://naesb.org/espi"><IntervalReading><timePeriod><duration>900</duration><start>1704067200</start></timePeriod><value>2</value></IntervalReading></IntervalBlock></content></entry>
+</feed>`
+		history, err := ParseGreenButton(strings.NewReader(feed))
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		// 2 kWh
+		assert.InDelta(t, 2.0, history[0].MeterGridImportKWH, 0.0001)
+	})
+
+	t.Run("unsupported unit", func(t *testing.T) {
+		feed := `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry><content><ReadingType xmlns="http://naesb.org/espi"><flowDirection>1</flowDirection><uom>169</uom></ReadingType></content></entry>
+  <entry><content><IntervalBlock xmlns="http://naesb.org/espi"><IntervalReading><timePeriod><duration>3600</duration><start>1704067200</start></timePeriod><value>2</value></IntervalReading></IntervalBlock></content></entry>
+</feed>`
+		_, err := ParseGreenButton(strings.NewReader(feed))
+		assert.ErrorContains(t, err, "unit of measure")
+	})
+
+	t.Run("daily intervals", func(t *testing.T) {
+		feed := `<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry><content><ReadingType xmlns="http://naesb.org/espi"><flowDirection>1</flowDirection><uom>72</uom></ReadingType></content></entry>
+  <entry><content><IntervalBlock xmlns="http://naesb.org/espi"><IntervalReading><timePeriod><duration>86400</duration><start>1704067200</start></timePeriod><value>2</value></IntervalReading></IntervalBlock></content></entry>
+</feed>`
+		_, err := ParseGreenButton(strings.NewReader(feed))
+		assert.ErrorContains(t, err, "interval duration")
+	})
+
+	t.Run("invalid xml", func(t *testing.T) {
+		_, err := ParseGreenButton(strings.NewReader("not xml"))
+		assert.Error(t, err)
+	})
+}
+
+func TestMergeMeterHistory(t *testing.T) {
+	start := time.Unix(greenButtonStart, 0)
+	essHistory := []types.EnergyStats{
+		{
+			TSHourStart:   start,
+			HomeKWH:       2,
+			SolarKWH:      1,
+			GridImportKWH: 1.1,
+		},
+	}
+	meterHistory := []types.EnergyStats{
+		{TSHourStart: start, MeterGridImportKWH: 1.25, HasMeterData: true},
+		{TSHourStart: start.Add(time.Hour), MeterGridImportKWH: 2, MeterGridExportKWH: 0.3, HasMeterData: true},
+	}
+
+	merged := MergeMeterHistory(essHistory, meterHistory)
+	require.Len(t, merged, 2)
+
+	// ESS values are untouched and meter values are added
+	assert.Equal(t, 2.0, merged[0].HomeKWH)
+	assert.Equal(t, 1.1, merged[0].GridImportKWH)
+	assert.Equal(t, 1.25, merged[0].MeterGridImportKWH)
+	assert.True(t, merged[0].HasMeterData)
+
+	// hours without ESS data take the grid values from the meter
+	assert.True(t, merged[1].TSHourStart.Equal(start.Add(time.Hour)))
+	assert.Equal(t, 2.0, merged[1].GridImportKWH)
+	assert.Equal(t, 0.3, merged[1].GridExportKWH)
+	assert.Equal(t, 2.0, merged[1].MeterGridImportKWH)
+	assert.True(t, merged[1].HasMeterData)
+}
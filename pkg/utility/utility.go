@@ -28,6 +28,10 @@ type Utility interface {
 
 	// ApplySettings updates the system using the provided global settings.
 	ApplySettings(ctx context.Context, settings types.Settings) error
+
+	// AdditionalFees returns the additional fees that apply to a price
+	// interval starting at ts.
+	AdditionalFees(ts time.Time) []types.UtilityAdditionalFeesPeriod
 }
 
 // Configured sets up the utility providers and returns a Map.
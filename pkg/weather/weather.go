@@ -0,0 +1,154 @@
+// Package weather gets weather forecasts for a site.
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/raterudder/raterudder/pkg/common"
+	"github.com/raterudder/raterudder/pkg/log"
+	"github.com/raterudder/raterudder/pkg/types"
+)
+
+// Forecaster gets the hourly outdoor temperature forecast for a location.
+type Forecaster interface {
+	// ForecastTemps returns the forecasted temperature for each hour that
+	// starts between start and end.
+	ForecastTemps(ctx context.Context, latitude, longitude float64, start, end time.Time) ([]types.TempForecast, error)
+}
+
+// forecastCacheDuration is how long a location's forecast is reused. The
+// hourly forecast is only updated every hour or so.
+const forecastCacheDuration = time.Hour
+
+// OpenMeteo gets forecasts from Open-Meteo which doesn't need an API key.
+// Forecasts are cached by location, rounded to about a kilometer, so sites
+// close together share them.
+type OpenMeteo struct {
+	client  *http.Client
+	baseURL string
+
+	mu    sync.Mutex
+	cache map[forecastKey]cachedForecast
+}
+
+type forecastKey struct {
+	latitude  float64
+	longitude float64
+	days      int
+}
+
+type cachedForecast struct {
+	hourly  []types.TempForecast
+	expires time.Time
+}
+
+// NewOpenMeteo returns an OpenMeteo using the public API.
+func NewOpenMeteo() *OpenMeteo {
+	return &OpenMeteo{
+		client:  common.HTTPClient(time.Minute),
+		baseURL: "https://api.open-meteo.com",
+	}
+}
+
+type openMeteoForecast struct {
+	Hourly struct {
+		Time          []int64   `json:"time"`
+		Temperature2M []float64 `json:"temperature_2m"`
+	} `json:"hourly"`
+}
+
+// ForecastTemps implements Forecaster.
+func (o *OpenMeteo) ForecastTemps(ctx context.Context, latitude, longitude float64, start, end time.Time) ([]types.TempForecast, error) {
+	// the forecast starts at midnight UTC today
+	days := int(end.Sub(start).Hours()/24) + 2
+	key := forecastKey{
+		latitude:  math.Round(latitude*100) / 100,
+		longitude: math.Round(longitude*100) / 100,
+		days:      min(max(days, 1), 16),
+	}
+	hourly, err := o.hourlyForecast(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var forecast []types.TempForecast
+	for _, f := range hourly {
+		if f.TSStart.Before(start.Truncate(time.Hour)) || !f.TSStart.Before(end) {
+			continue
+		}
+		forecast = append(forecast, f)
+	}
+	return forecast, nil
+}
+
+// hourlyForecast returns the whole hourly forecast for the location from the
+// cache or Open-Meteo.
+func (o *OpenMeteo) hourlyForecast(ctx context.Context, key forecastKey) ([]types.TempForecast, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	if cached, ok := o.cache[key]; ok && now.Before(cached.expires) {
+		return cached.hourly, nil
+	}
+
+	params := url.Values{
+		"latitude":      {strconv.FormatFloat(key.latitude, 'f', 2, 64)},
+		"longitude":     {strconv.FormatFloat(key.longitude, 'f', 2, 64)},
+		"hourly":        {"temperature_2m"},
+		"timeformat":    {"unixtime"},
+		"timezone":      {"UTC"},
+		"forecast_days": {strconv.Itoa(key.days)},
+	}
+	u := o.baseURL + "/v1/forecast?" + params.Encode()
+	log.Ctx(ctx).DebugContext(ctx, "fetching weather forecast", slog.String("url", u))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := common.CheckResponse(resp); err != nil {
+		return nil, fmt.Errorf("open-meteo forecast failed: %w", err)
+	}
+
+	var res openMeteoForecast
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to decode open-meteo forecast: %w", err)
+	}
+	if len(res.Hourly.Time) != len(res.Hourly.Temperature2M) {
+		return nil, fmt.Errorf("open-meteo returned %d times and %d temperatures", len(res.Hourly.Time), len(res.Hourly.Temperature2M))
+	}
+
+	hourly := make([]types.TempForecast, len(res.Hourly.Time))
+	for i, ts := range res.Hourly.Time {
+		hourly[i] = types.TempForecast{
+			TSStart: time.Unix(ts, 0),
+			TempC:   res.Hourly.Temperature2M[i],
+		}
+	}
+
+	if o.cache == nil {
+		o.cache = make(map[forecastKey]cachedForecast)
+	}
+	for k, cached := range o.cache {
+		if !now.Before(cached.expires) {
+			delete(o.cache, k)
+		}
+	}
+	o.cache[key] = cachedForecast{hourly: hourly, expires: now.Add(forecastCacheDuration)}
+	return hourly, nil
+}
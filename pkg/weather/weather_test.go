@@ -0,0 +1,81 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMeteo(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 1, 10, 22, 30, 0, 0, time.UTC)
+
+	newTestOpenMeteo := func(t *testing.T, handler http.HandlerFunc) *OpenMeteo {
+		ts := httptest.NewServer(handler)
+		t.Cleanup(ts.Close)
+		return &OpenMeteo{client: ts.Client(), baseURL: ts.URL}
+	}
+
+	t.Run("ForecastTemps", func(t *testing.T) {
+		var requests int
+		o := newTestOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			assert.Equal(t, "/v1/forecast", r.URL.Path)
+			assert.Equal(t, "41.88", r.URL.Query().Get("latitude"))
+			assert.Equal(t, "-87.63", r.URL.Query().Get("longitude"))
+			assert.Equal(t, "temperature_2m", r.URL.Query().Get("hourly"))
+			assert.Equal(t, "unixtime", r.URL.Query().Get("timeformat"))
+			assert.Equal(t, "2", r.URL.Query().Get("forecast_days"))
+
+			var times []int64
+			var temps []float64
+			day := start.Truncate(24 * time.Hour)
+			for i := 0; i < 48; i++ {
+				times = append(times, day.Add(time.Duration(i)*time.Hour).Unix())
+				temps = append(temps, float64(-i))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"hourly": map[string]interface{}{
+					"time":           times,
+					"temperature_2m": temps,
+				},
+			})
+		})
+
+		forecast, err := o.ForecastTemps(ctx, 41.8781, -87.6298, start, start.Add(3*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, forecast, 4)
+		assert.True(t, forecast[0].TSStart.Equal(time.Date(2026, 1, 10, 22, 0, 0, 0, time.UTC)))
+		assert.Equal(t, -22.0, forecast[0].TempC)
+		assert.Equal(t, -24.0, forecast[2].TempC)
+
+		// a nearby site an hour later uses the cached forecast
+		forecast, err = o.ForecastTemps(ctx, 41.8779, -87.6301, start.Add(time.Hour), start.Add(4*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, forecast, 4)
+		assert.Equal(t, -23.0, forecast[0].TempC)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("Mismatched Lengths", func(t *testing.T) {
+		o := newTestOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"hourly":{"time":[1768082400,1768086000],"temperature_2m":[1.5]}}`))
+		})
+		_, err := o.ForecastTemps(ctx, 1, 1, start, start.Add(time.Hour))
+		assert.ErrorContains(t, err, "2 times and 1 temperatures")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		o := newTestOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+		})
+		_, err := o.ForecastTemps(ctx, 1, 1, start, start.Add(time.Hour))
+		assert.ErrorContains(t, err, "open-meteo forecast failed")
+	})
+}
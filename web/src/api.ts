@@ -32,6 +32,11 @@ export const ActionReason = {
     WaitingToCharge: 'waitingToCharge',
     ChargeSurvivePeak: 'chargeSurvivePeak',
     PreventSolarCurtailment: 'preventSolarCurtailment',
+    ArbitrageExport: 'arbitrageExport',
+    OutageUseBattery: 'outageUseBattery',
+    OutageUseGenerator: 'outageUseGenerator',
+    BalanceCharge: 'balanceCharge',
+    ColdCharge: 'coldCharge',
     // deprecated
     DeficitSave: 'deficitSave',
 } as const;
@@ -61,6 +66,23 @@ export interface Action {
     dryRun?: boolean;
     fault?: boolean;
     paused?: boolean;
+    unitResults?: UnitResult[];
+    verification?: 'verified' | 'verifiedAfterRetry' | 'unverified';
+    evChargeLimitKW?: number;
+    loads?: LoadResult[];
+}
+
+export interface LoadResult {
+    id: string;
+    on: boolean;
+    error?: string;
+}
+
+export interface UnitResult {
+    name: string;
+    batteryMode: number;
+    solarMode: number;
+    error?: string;
 }
 
 export const BatteryMode = {
@@ -69,6 +91,7 @@ export const BatteryMode = {
     ChargeAny: 2,
     ChargeSolar: 3,
     Load: -1,
+    Export: -2,
 } as const;
 
 export type BatteryMode = typeof BatteryMode[keyof typeof BatteryMode];
@@ -120,6 +143,28 @@ export const fetchActions = async (start: Date, end: Date, siteID?: string): Pro
     return response.json();
 };
 
+export interface BatteryModules {
+    timestamp: string;
+    eachBatterySOC: number[];
+    eachBatteryKW?: number[];
+    imbalanceSOC: number;
+}
+
+export const fetchBatteryHistory = async (start: Date, end: Date, siteID?: string): Promise<BatteryModules[]> => {
+    const query = new URLSearchParams({
+        start: start.toISOString(),
+        end: end.toISOString(),
+    });
+    if (siteID) {
+        query.append('siteID', siteID);
+    }
+    const response = await fetch(`/api/history/batteries?${query.toString()}`);
+    if (!response.ok) {
+        throw new Error(await extractError(response, 'Failed to fetch battery history'));
+    }
+    return response.json();
+};
+
 export interface SavingsStats {
     timestamp: string;
     cost: number;
@@ -133,6 +178,9 @@ export interface SavingsStats {
     gridExported: number;
     homeUsed: number;
     batteryUsed: number;
+    generatorUsed?: number;
+    generatorHours?: number;
+    generatorCost?: number;
 }
 
 export const fetchSavings = async (start: Date, end: Date, siteID?: string): Promise<SavingsStats|null> => {
@@ -193,11 +241,21 @@ export interface ESSCredential {
   description?: string;
 }
 
+export interface ESSCapabilities {
+  batteryModes: number[] | null;
+  solarModes: number[] | null;
+  gridCharging: boolean;
+  batteryExport: boolean;
+  minCommandIntervalSeconds?: number;
+  schedule?: boolean;
+}
+
 export interface ESSProviderInfo {
   id: string;
   name: string;
   credentials: ESSCredential[];
   hidden?: boolean;
+  capabilities?: ESSCapabilities;
 }
 
 export interface Settings {
@@ -208,10 +266,22 @@ export interface Settings {
     minArbitrageDifferenceDollarsPerKWH: number;
     minDeficitPriceDifferenceDollarsPerKWH: number;
     minBatterySOC: number;
+    batteryBalanceThresholdSOC: number;
+    coldWeatherCharging: boolean;
+    batteryMinChargeTempC: number;
+    latitude: number;
+    longitude: number;
     ignoreHourUsageOverMultiple: number;
     gridChargeBatteries: boolean;
     gridExportSolar: boolean;
     gridExportBatteries: boolean;
+    pushSchedule: boolean;
+    verifyModes: boolean;
+    verifyModesDelaySeconds: number;
+    evMaxChargeKW: number;
+    evChargeHours: number;
+    generatorCostDollarsPerKWH: number;
+    flexibleLoads?: FlexibleLoad[];
     solarTrendRatioMax: number;
     solarBellCurveMultiplier: number;
     solarFullyChargeHeadroomBatterySOC: number;
@@ -225,6 +295,20 @@ export interface Settings {
     };
 }
 
+export interface FlexibleLoad {
+    id: string;
+    name: string;
+    energyKWH: number;
+    powerKW: number;
+    deadlineHour: number;
+    actuator: {
+        type: 'webhook' | 'mqtt' | 'homeassistant';
+        url?: string;
+        topic?: string;
+        entityID?: string;
+    };
+}
+
 export interface FranklinCredentials {
     username: string;
     md5Password: string;
@@ -325,6 +409,7 @@ export interface ModelingHour {
     gridChargeDollarsPerKWH: number;
     solarOppDollarsPerKWH: number;
     avgHomeLoadKWH: number;
+    flexibleLoadKWH?: number;
     predictedSolarKWH: number;
     batteryKWH: number;
     batteryKWHIfStandby: number;
@@ -345,6 +430,30 @@ export const fetchModeling = async (siteID?: string): Promise<ModelingHour[]> =>
     return response.json();
 };
 
+export interface LoadSchedule {
+    loadID: string;
+    name: string;
+    deadline: string;
+    runs: {
+        start: string;
+        end: string;
+        energyKWH: number;
+    }[];
+    unmetKWH?: number;
+}
+
+export const fetchLoadSchedules = async (siteID?: string): Promise<LoadSchedule[]> => {
+    const query = new URLSearchParams();
+    if (siteID) {
+        query.append('siteID', siteID);
+    }
+    const response = await fetch(`/api/loads?${query.toString()}`);
+    if (!response.ok) {
+        throw new Error(await extractError(response, 'Failed to fetch load schedules'));
+    }
+    return response.json();
+};
+
 export const joinSite = async (joinSiteID: string, inviteCode: string, name: string): Promise<void> => {
     const response = await fetch('/api/join', {
         method: 'POST',
@@ -49,7 +49,7 @@ const CurrentStatus: React.FC<CurrentStatusProps> = ({ action }) => {
     const kw = action.systemStatus?.batteryKW || 0;
 
     let state: 'charging' | 'discharging' | 'standby' = 'standby';
-    if (mode === BatteryMode.Load || kw > 0.1) state = 'discharging';
+    if (mode === BatteryMode.Load || mode === BatteryMode.Export || kw > 0.1) state = 'discharging';
     else if (mode === BatteryMode.ChargeAny || mode === BatteryMode.ChargeSolar || kw < -0.1) state = 'charging';
 
     return (
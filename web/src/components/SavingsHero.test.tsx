@@ -40,6 +40,16 @@ describe('SavingsHero', () => {
         expect(screen.getByText('40.0')).toBeInTheDocument();
     });
 
+    it('renders generator usage only when the generator ran', () => {
+        const { rerender } = render(<SavingsHero savings={defaultSavings} />);
+        expect(screen.queryByText(/Generator/)).not.toBeInTheDocument();
+
+        rerender(<SavingsHero savings={{ ...defaultSavings, generatorUsed: 7.5, generatorHours: 2, generatorCost: 3 }} />);
+        expect(screen.getByText('Generator (2.0 h)')).toBeInTheDocument();
+        expect(screen.getByText('7.5')).toBeInTheDocument();
+        expect(screen.getByText('$ 3.00 fuel')).toBeInTheDocument();
+    });
+
     it('returns null if savings is null', () => {
         const { container } = render(<SavingsHero savings={null} />);
         expect(container.firstChild).toBeNull();
@@ -62,6 +62,13 @@ const SavingsHero: React.FC<SavingsHeroProps> = ({ savings }) => {
                             <span className="stat-label">Battery Use</span>
                             <span className="stat-value">{savings.batteryUsed.toFixed(1)} <small>kWh</small></span>
                         </div>
+                        {!!savings.generatorUsed && (
+                            <div className="stat-card">
+                                <span className="stat-label">Generator ({(savings.generatorHours ?? 0).toFixed(1)} h)</span>
+                                <span className="stat-value">{savings.generatorUsed.toFixed(1)} <small>kWh</small></span>
+                                <small>$ {(savings.generatorCost ?? 0).toFixed(2)} fuel</small>
+                            </div>
+                        )}
                     </div>
                     <div className="stats-row grid-metrics">
                         <div className="stat-card">
@@ -509,6 +509,23 @@ const Settings = ({ siteID }: { siteID?: string }) => {
                             <Field.Label htmlFor="gridExportBatteries">Export Battery to Grid</Field.Label>
                         </div>
                     </Field.Root>
+
+                    {essProviders.find(p => p.id === settings.ess)?.capabilities?.schedule && (
+                        <Field.Root className="form-group switch-group compact">
+                            <div className="switch-row">
+                                <Switch.Root
+                                    id="pushSchedule"
+                                    checked={settings.pushSchedule}
+                                    onCheckedChange={(checked) => handleChange('pushSchedule', checked)}
+                                    className="switch-root"
+                                >
+                                    <Switch.Thumb className="switch-thumb" />
+                                </Switch.Root>
+                                <Field.Label htmlFor="pushSchedule">Push Daily Schedule</Field.Label>
+                            </div>
+                            <Field.Description>Keep following the plan if the gateway loses its connection.</Field.Description>
+                        </Field.Root>
+                    )}
                 </div>
 
 
@@ -530,6 +547,152 @@ const Settings = ({ siteID }: { siteID?: string }) => {
                             <Field.Description>Simulate actions without executing them (useful for testing).</Field.Description>
                         </Field.Root>
 
+                        <Field.Root className="form-group switch-group">
+                            <div className="switch-row">
+                                <Switch.Root
+                                    checked={settings.verifyModes}
+                                    onCheckedChange={(checked) => handleChange('verifyModes', checked)}
+                                    className="switch-root"
+                                >
+                                    <Switch.Thumb className="switch-thumb" />
+                                </Switch.Root>
+                                <Field.Label>Verify Modes</Field.Label>
+                            </div>
+                            <Field.Description>Check the system applied the modes it was sent and retry once if it didn't.</Field.Description>
+                        </Field.Root>
+                        {settings.verifyModes && (
+                            <Field.Root className="form-group">
+                                <Field.Label>Verify Delay (seconds)</Field.Label>
+                                <Input
+                                    id="verifyModesDelaySeconds"
+                                    type="number"
+                                    step="1"
+                                    min="0"
+                                    max="60"
+                                    value={settings.verifyModesDelaySeconds}
+                                    onChange={(e) => handleChange('verifyModesDelaySeconds', parseInt(e.target.value, 10))}
+                                />
+                                <Field.Description>How long to wait for the system to settle before checking.</Field.Description>
+                            </Field.Root>
+                        )}
+
+                        <div className="section-header">
+                            <h3>EV Charger</h3>
+                        </div>
+                        <Field.Root className="form-group">
+                            <Field.Label>EV Max Charge Rate (kW)</Field.Label>
+                            <Input
+                                id="evMaxChargeKW"
+                                type="number"
+                                step="0.1"
+                                min="0"
+                                value={settings.evMaxChargeKW}
+                                onChange={(e) => handleChange('evMaxChargeKW', parseFloat(e.target.value))}
+                            />
+                            <Field.Description>Fastest an OCPP charger is allowed to charge. 0 leaves the charger alone.</Field.Description>
+                        </Field.Root>
+                        <Field.Root className="form-group">
+                            <Field.Label>EV Charge Hours</Field.Label>
+                            <Input
+                                id="evChargeHours"
+                                type="number"
+                                step="1"
+                                min="0"
+                                max="24"
+                                value={settings.evChargeHours}
+                                onChange={(e) => handleChange('evChargeHours', parseInt(e.target.value, 10))}
+                            />
+                            <Field.Description>How many of the cheapest hours in the next day the EV charges.</Field.Description>
+                        </Field.Root>
+
+                        <div className="section-header">
+                            <h3>Generator</h3>
+                        </div>
+                        <Field.Root className="form-group">
+                            <Field.Label>Generator Cost ($/kWh)</Field.Label>
+                            <Input
+                                id="generatorCostDollarsPerKWH"
+                                type="number"
+                                step="0.01"
+                                min="0"
+                                value={settings.generatorCostDollarsPerKWH}
+                                onChange={(e) => handleChange('generatorCostDollarsPerKWH', parseFloat(e.target.value))}
+                            />
+                            <Field.Description>Fuel cost of running the standby generator. During an outage the home runs on the generator instead of the battery when it's cheaper than recharging the battery later. 0 means there's no generator.</Field.Description>
+                        </Field.Root>
+
+                        <div className="section-header">
+                            <h3>Battery Modules</h3>
+                        </div>
+                        <Field.Root className="form-group">
+                            <Field.Label>Balance Threshold (%)</Field.Label>
+                            <Input
+                                id="batteryBalanceThresholdSOC"
+                                type="number"
+                                step="1"
+                                min="0"
+                                max="100"
+                                value={settings.batteryBalanceThresholdSOC}
+                                onChange={(e) => handleChange('batteryBalanceThresholdSOC', parseFloat(e.target.value))}
+                            />
+                            <Field.Description>Fully charge the battery in the next cheap window when its modules are this far apart so they can balance. 0 disables balancing.</Field.Description>
+                        </Field.Root>
+
+                        <div className="section-header">
+                            <h3>Cold Weather</h3>
+                        </div>
+                        <Field.Root className="form-group switch-group">
+                            <div className="switch-row">
+                                <Switch.Root
+                                    checked={settings.coldWeatherCharging}
+                                    onCheckedChange={(checked) => handleChange('coldWeatherCharging', checked)}
+                                    className="switch-root"
+                                >
+                                    <Switch.Thumb className="switch-thumb" />
+                                </Switch.Root>
+                                <Field.Label>Cold Weather Charging</Field.Label>
+                            </div>
+                            <Field.Description>If your system reports the battery temperature, charge the battery ahead of the hours it's predicted to be too cold to charge.</Field.Description>
+                        </Field.Root>
+                        <Field.Root className="form-group">
+                            <Field.Label>Minimum Charging Temperature (°C)</Field.Label>
+                            <Input
+                                id="batteryMinChargeTempC"
+                                type="number"
+                                step="1"
+                                min="-40"
+                                max="40"
+                                value={settings.batteryMinChargeTempC}
+                                onChange={(e) => handleChange('batteryMinChargeTempC', parseFloat(e.target.value))}
+                            />
+                            <Field.Description>The battery can't charge at or below this temperature.</Field.Description>
+                        </Field.Root>
+                        <Field.Root className="form-group">
+                            <Field.Label>Latitude</Field.Label>
+                            <Input
+                                id="latitude"
+                                type="number"
+                                step="0.0001"
+                                min="-90"
+                                max="90"
+                                value={settings.latitude}
+                                onChange={(e) => handleChange('latitude', parseFloat(e.target.value))}
+                            />
+                        </Field.Root>
+                        <Field.Root className="form-group">
+                            <Field.Label>Longitude</Field.Label>
+                            <Input
+                                id="longitude"
+                                type="number"
+                                step="0.0001"
+                                min="-180"
+                                max="180"
+                                value={settings.longitude}
+                                onChange={(e) => handleChange('longitude', parseFloat(e.target.value))}
+                            />
+                            <Field.Description>Location of the battery, used to get the weather forecast for predicting its temperature. Leave both at 0 to only use the battery's recent temperatures.</Field.Description>
+                        </Field.Root>
+
 
 
                         <div className="section-header">
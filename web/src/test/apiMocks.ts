@@ -25,8 +25,19 @@ export const defaultSettings = {
     pause: false,
     release: 'production',
     minBatterySOC: 10,
+    batteryBalanceThresholdSOC: 0,
+    coldWeatherCharging: false,
+    batteryMinChargeTempC: 0,
+    latitude: 0,
+    longitude: 0,
     gridExportSolar: false,
     gridExportBatteries: false,
+    pushSchedule: false,
+    verifyModes: false,
+    verifyModesDelaySeconds: 0,
+    evMaxChargeKW: 0,
+    evChargeHours: 0,
+    generatorCostDollarsPerKWH: 0,
     gridChargeBatteries: true,
     solarTrendRatioMax: 3.0,
     solarBellCurveMultiplier: 1.0,
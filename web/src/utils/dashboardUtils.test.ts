@@ -13,6 +13,9 @@ describe('dashboardUtils', () => {
         it('returns correct label for standby', () => {
             expect(getBatteryModeLabel(BatteryMode.Standby)).toBe('Hold Battery');
         });
+        it('returns correct label for export', () => {
+            expect(getBatteryModeLabel(BatteryMode.Export)).toBe('Sell Battery To Grid');
+        });
         it('returns Unknown for invalid mode', () => {
             expect(getBatteryModeLabel(999)).toBe('Unknown');
         });
@@ -80,6 +83,20 @@ describe('dashboardUtils', () => {
             expect(getReasonText(action)).toContain('exceed battery capacity');
         });
 
+        it('handles ArbitrageExport', () => {
+            const action = {
+                ...baseAction,
+                batteryMode: BatteryMode.Export,
+                reason: ActionReason.ArbitrageExport,
+                currentPrice: { dollarsPerKWH: 0.5, gridUseDollarsPerKWH: 0.05, tsStart: '', tsEnd: '' },
+                futurePrice: { dollarsPerKWH: 0.05, gridUseDollarsPerKWH: 0.05, tsStart: '', tsEnd: '' }
+            };
+            const text = getReasonText(action);
+            expect(text).toContain('Selling stored battery energy');
+            expect(text).toContain('$ 0.500');
+            expect(text).toContain('$ 0.100');
+        });
+
         it('appends NoExport suffix for arbitrage', () => {
             const action = {
                 ...baseAction,
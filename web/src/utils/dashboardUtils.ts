@@ -6,6 +6,7 @@ export const getBatteryModeLabel = (mode: number) => {
         case BatteryMode.ChargeAny: return 'Charge From Solar+Grid';
         case BatteryMode.ChargeSolar: return 'Charge From Solar';
         case BatteryMode.Load: return 'Use Battery';
+        case BatteryMode.Export: return 'Sell Battery To Grid';
         case BatteryMode.NoChange: return 'No Change';
         default: return 'Unknown';
     }
@@ -17,6 +18,7 @@ export const getBatteryModeClass = (mode: number) => {
         case BatteryMode.ChargeAny: return 'charge_any';
         case BatteryMode.ChargeSolar: return 'charge_solar';
         case BatteryMode.Load: return 'load';
+        case BatteryMode.Export: return 'export';
         case BatteryMode.NoChange: return 'no_change';
         default: return 'unknown';
     }
@@ -158,6 +160,14 @@ export const getReasonText = (action: Action): string => {
             ];
             return parts.concat(suffixParts).join(' ');
         }
+        case ActionReason.ArbitrageExport: {
+            const sellStr = currentPrice ? formatPrice(currentPrice.dollarsPerKWH) : '';
+            const parts = [
+                `Electricity prices are at their peak${sellStr ? ` (${sellStr})` : ''}.`,
+                `Selling stored battery energy to the grid since it can be replaced later for less${futureCostStr ? ` (${futureCostStr})` : ''}.`,
+            ];
+            return parts.concat(suffixParts).join(' ');
+        }
         case ActionReason.SufficientBattery: {
             const parts = [
                 'The battery has enough stored energy to meet predicted demand. Using the battery normally to reduce grid usage.'